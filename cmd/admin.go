@@ -0,0 +1,773 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administer the homeserver",
+	Long: `Administer the homeserver as the admin user, through the Synapse
+Admin API.
+
+muxbee logs in as the admin once and keeps that session in the data
+directory, so its actions all share one device.`,
+}
+
+var adminVersionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the Synapse version",
+	RunE:  runAdminVersion,
+}
+
+var adminDevicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "Manage the admin user's devices",
+}
+
+var adminDevicesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the admin user's devices",
+	RunE:  runAdminDevicesList,
+}
+
+var adminDevicesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete devices muxbee left behind",
+	Long: `Delete the admin devices muxbee created before it kept its session: one
+for each bridge toggled, health check and alert. They are the devices named
+"muxbee" or without a name; muxbee's current one is kept.
+
+With --all, every other device is deleted too, which logs the admin out of
+Element and other clients. --inactive keeps devices used recently.`,
+	Example: `  muxbee admin devices prune --dry-run
+  muxbee admin devices prune --inactive 720h
+  muxbee admin devices prune --all --yes`,
+	RunE: runAdminDevicesPrune,
+}
+
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage the homeserver's users",
+	Long: `Manage the homeserver's users. Users are given by name ("alice") or
+full Matrix ID ("@alice:example.com").`,
+}
+
+var adminUsersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users",
+	RunE:  runAdminUsersList,
+}
+
+var adminUsersCreateCmd = &cobra.Command{
+	Use:   "create <user>",
+	Short: "Create a user",
+	Long: `Create a user. Without --password, a random password is generated
+and printed.`,
+	Example: `  muxbee admin users create alice
+  muxbee admin users create bob --display-name "Bob" --admin`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdminUsersCreate,
+}
+
+var adminUsersDeactivateCmd = &cobra.Command{
+	Use:   "deactivate <user>",
+	Short: "Deactivate a user",
+	Long: `Log a user out everywhere and lock their account. This can't be undone,
+and the name can't be used again.
+
+With --erase, their messages are hidden from people who join rooms later.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdminUsersDeactivate,
+}
+
+var adminUsersResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password <user>",
+	Short: "Set a user's password",
+	Long: `Set a user's password and log out their devices. Without --password,
+a random password is generated and printed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdminUsersResetPassword,
+}
+
+var adminRoomsCmd = &cobra.Command{
+	Use:   "rooms",
+	Short: "Manage the homeserver's rooms",
+	Long: `Manage the homeserver's rooms. Rooms are given by ID ("!abc:example.com")
+or alias ("#room:example.com").`,
+}
+
+var adminRoomsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List rooms, biggest first",
+	RunE:  runAdminRoomsList,
+}
+
+var adminRoomsDeleteCmd = &cobra.Command{
+	Use:   "delete <room>",
+	Short: "Delete a room",
+	Long: `Remove every local member from a room and delete it from the database.
+With --block, no one can join it again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdminRoomsDelete,
+}
+
+var adminRoomsPurgeCmd = &cobra.Command{
+	Use:   "purge <room>",
+	Short: "Delete a room's old history",
+	Long: `Delete a room's events older than --before to free up space. Messages
+sent by local users are kept unless --local is given.`,
+	Example: `  muxbee admin rooms purge '!abc:example.com' --before 2160h`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runAdminRoomsPurge,
+}
+
+var adminMediaCmd = &cobra.Command{
+	Use:   "media",
+	Short: "Manage uploaded and cached media",
+}
+
+var adminMediaQuarantineCmd = &cobra.Command{
+	Use:   "quarantine [mxc://server/media-id]",
+	Short: "Stop media from being served",
+	Long:  `Quarantine one piece of media, or everything sent to a room or uploaded by a user.`,
+	Example: `  muxbee admin media quarantine mxc://example.com/AbCdEf
+  muxbee admin media quarantine --room '!abc:example.com'
+  muxbee admin media quarantine --user spammer`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runAdminMediaQuarantine,
+}
+
+var adminMediaDeleteCmd = &cobra.Command{
+	Use:   "delete <mxc://server/media-id>",
+	Short: "Delete media uploaded to this homeserver",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAdminMediaDelete,
+}
+
+var adminMediaPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete media not used for a while",
+	Long: `Delete the cached copies of media from other homeservers last used before
+--before. They are fetched again if someone asks for them.
+
+With --local, media uploaded to this homeserver is deleted instead, for
+good. Avatars are kept.`,
+	Example: `  muxbee admin media purge --before 720h
+  muxbee admin media purge --before 8760h --local`,
+	RunE: runAdminMediaPurge,
+}
+
+var adminTokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Manage registration tokens",
+	Long: `Manage registration tokens, which let people sign up while registration
+requires one.`,
+}
+
+var adminTokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registration tokens",
+	RunE:  runAdminTokensList,
+}
+
+var adminTokensCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a registration token",
+	Example: `  muxbee admin tokens create --uses 1 --expires 168h
+  muxbee admin tokens create --token family-2026`,
+	RunE: runAdminTokensCreate,
+}
+
+var adminTokensDeleteCmd = &cobra.Command{
+	Use:   "delete <token>",
+	Short: "Delete a registration token",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAdminTokensDelete,
+}
+
+var (
+	adminOutput        string
+	adminYes           bool
+	adminPruneAll      bool
+	adminPruneInactive time.Duration
+	adminPruneDryRun   bool
+
+	adminUsersName        string
+	adminUsersGuests      bool
+	adminUsersDeactivated bool
+	adminUserPassword     string
+	adminUserDisplayName  string
+	adminUserAdmin        bool
+	adminUserErase        bool
+	adminUserKeepDevices  bool
+
+	adminRoomsSearch string
+	adminRoomBlock   bool
+	adminRoomMessage string
+	adminBefore      time.Duration
+	adminLocal       bool
+
+	adminMediaRoom string
+	adminMediaUser string
+
+	adminTokenValue   string
+	adminTokenUses    int
+	adminTokenExpires time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminVersionCmd)
+	adminCmd.AddCommand(adminDevicesCmd)
+	adminDevicesCmd.AddCommand(adminDevicesListCmd)
+	adminDevicesCmd.AddCommand(adminDevicesPruneCmd)
+	adminCmd.AddCommand(adminUsersCmd)
+	adminUsersCmd.AddCommand(adminUsersListCmd)
+	adminUsersCmd.AddCommand(adminUsersCreateCmd)
+	adminUsersCmd.AddCommand(adminUsersDeactivateCmd)
+	adminUsersCmd.AddCommand(adminUsersResetPasswordCmd)
+	adminCmd.AddCommand(adminRoomsCmd)
+	adminRoomsCmd.AddCommand(adminRoomsListCmd)
+	adminRoomsCmd.AddCommand(adminRoomsDeleteCmd)
+	adminRoomsCmd.AddCommand(adminRoomsPurgeCmd)
+	adminCmd.AddCommand(adminMediaCmd)
+	adminMediaCmd.AddCommand(adminMediaQuarantineCmd)
+	adminMediaCmd.AddCommand(adminMediaDeleteCmd)
+	adminMediaCmd.AddCommand(adminMediaPurgeCmd)
+	adminCmd.AddCommand(adminTokensCmd)
+	adminTokensCmd.AddCommand(adminTokensListCmd)
+	adminTokensCmd.AddCommand(adminTokensCreateCmd)
+	adminTokensCmd.AddCommand(adminTokensDeleteCmd)
+
+	addOutputFlag(adminDevicesListCmd, &adminOutput)
+	adminDevicesPruneCmd.Flags().BoolVar(&adminPruneAll, "all", false, "Delete every device except muxbee's current one")
+	adminDevicesPruneCmd.Flags().DurationVar(&adminPruneInactive, "inactive", 0, "Only delete devices not used for this long")
+	adminDevicesPruneCmd.Flags().BoolVar(&adminPruneDryRun, "dry-run", false, "List the devices without deleting them")
+
+	addOutputFlag(adminUsersListCmd, &adminOutput)
+	adminUsersListCmd.Flags().StringVar(&adminUsersName, "name", "", "Only users whose ID or display name contains this")
+	adminUsersListCmd.Flags().BoolVar(&adminUsersGuests, "guests", false, "Include guest users")
+	adminUsersListCmd.Flags().BoolVar(&adminUsersDeactivated, "deactivated", false, "Include deactivated users")
+	for _, c := range []*cobra.Command{adminUsersCreateCmd, adminUsersResetPasswordCmd} {
+		c.Flags().StringVar(&adminUserPassword, "password", "", "Password (generated if not given)")
+	}
+	adminUsersCreateCmd.Flags().StringVar(&adminUserDisplayName, "display-name", "", "Display name")
+	adminUsersCreateCmd.Flags().BoolVar(&adminUserAdmin, "admin", false, "Make the user a server admin")
+	adminUsersDeactivateCmd.Flags().BoolVar(&adminUserErase, "erase", false, "Hide the user's messages from people who join later")
+	adminUsersResetPasswordCmd.Flags().BoolVar(&adminUserKeepDevices, "keep-devices", false, "Don't log out the user's devices")
+
+	addOutputFlag(adminRoomsListCmd, &adminOutput)
+	adminRoomsListCmd.Flags().StringVar(&adminRoomsSearch, "search", "", "Only rooms whose name, alias or ID contains this")
+	adminRoomsDeleteCmd.Flags().BoolVar(&adminRoomBlock, "block", false, "Stop anyone from joining the room again")
+	adminRoomsDeleteCmd.Flags().StringVar(&adminRoomMessage, "message", "", "Message shown to the members as they are removed")
+	adminRoomsPurgeCmd.Flags().DurationVar(&adminBefore, "before", 0, "Delete events older than this, like 2160h for 90 days")
+	adminRoomsPurgeCmd.Flags().BoolVar(&adminLocal, "local", false, "Also delete messages sent by local users")
+	adminRoomsPurgeCmd.MarkFlagRequired("before")
+
+	adminMediaQuarantineCmd.Flags().StringVar(&adminMediaRoom, "room", "", "Quarantine all media sent to this room")
+	adminMediaQuarantineCmd.Flags().StringVar(&adminMediaUser, "user", "", "Quarantine all media this user uploaded")
+	adminMediaQuarantineCmd.MarkFlagsMutuallyExclusive("room", "user")
+	adminMediaPurgeCmd.Flags().DurationVar(&adminBefore, "before", 0, "Delete media last used longer ago than this, like 720h")
+	adminMediaPurgeCmd.Flags().BoolVar(&adminLocal, "local", false, "Delete media uploaded to this homeserver instead of cached remote media")
+	adminMediaPurgeCmd.MarkFlagRequired("before")
+
+	addOutputFlag(adminTokensListCmd, &adminOutput)
+	addOutputFlag(adminTokensCreateCmd, &adminOutput)
+	adminTokensCreateCmd.Flags().StringVar(&adminTokenValue, "token", "", "The token itself (random if not given)")
+	adminTokensCreateCmd.Flags().IntVar(&adminTokenUses, "uses", 0, "How many sign-ups it allows (0 for unlimited)")
+	adminTokensCreateCmd.Flags().DurationVar(&adminTokenExpires, "expires", 0, "How long until it expires (never if not given)")
+
+	for _, c := range []*cobra.Command{adminDevicesPruneCmd, adminUsersDeactivateCmd, adminRoomsDeleteCmd, adminRoomsPurgeCmd, adminMediaDeleteCmd, adminMediaPurgeCmd} {
+		c.Flags().BoolVarP(&adminYes, "yes", "y", false, "Skip confirmation")
+	}
+}
+
+// confirm asks a yes/no question, unless yes is already set by --yes
+func confirm(question string, yes bool) bool {
+	if yes {
+		return true
+	}
+	fmt.Printf("%s [y/N] ", question)
+	reader := bufio.NewReader(os.Stdin)
+	response, _ := reader.ReadString('\n')
+	response = strings.TrimSpace(strings.ToLower(response))
+	if response != "y" && response != "yes" {
+		fmt.Println("Aborted.")
+		return false
+	}
+	return true
+}
+
+// adminClient loads the config and returns a client logged in as the admin
+func adminClient() (*config.Config, *matrix.Client, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+	client, err := matrix.AdminClient(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to login as admin: %w\nIs muxbee running? Start it with 'muxbee up'", err)
+	}
+	return cfg, client, nil
+}
+
+func runAdminDevicesList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(adminOutput); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	devices, err := client.Devices()
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+	if adminOutput != outputText {
+		return printStructured(adminOutput, devices)
+	}
+
+	printDevices(devices, client.DeviceID())
+	return nil
+}
+
+func runAdminDevicesPrune(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	devices, err := client.Devices()
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	var seenAfter time.Time
+	if adminPruneInactive > 0 {
+		seenAfter = time.Now().Add(-adminPruneInactive)
+	}
+	stale := matrix.StaleDevices(devices, client.DeviceID(), adminPruneAll, seenAfter)
+	if len(stale) == 0 {
+		fmt.Println("No devices to delete.")
+		return nil
+	}
+
+	fmt.Printf("%d of %d devices to delete:\n\n", len(stale), len(devices))
+	printDevices(stale, "")
+	fmt.Println()
+
+	if adminPruneDryRun {
+		return nil
+	}
+	if !confirm(fmt.Sprintf("Delete %d devices?", len(stale)), adminYes) {
+		return nil
+	}
+
+	ids := make([]string, len(stale))
+	for i, d := range stale {
+		ids[i] = d.DeviceID
+	}
+	if err := client.DeleteDevices(ids, cfg.Admin.Password); err != nil {
+		return fmt.Errorf("failed to delete devices: %w", err)
+	}
+	fmt.Printf("Deleted %d devices.\n", len(stale))
+	return nil
+}
+
+// printDevices prints devices as a table, marking the current one
+func printDevices(devices []matrix.Device, currentID string) {
+	fmt.Printf("%-14s %-18s %s\n", "DEVICE", "LAST SEEN", "NAME")
+	for _, d := range devices {
+		seen := "never"
+		if !d.LastSeen().IsZero() {
+			seen = d.LastSeen().Format("2006-01-02 15:04")
+		}
+		name := d.DisplayName
+		if name == "" {
+			name = "-"
+		}
+		if d.DeviceID == currentID {
+			name += " (current)"
+		}
+		fmt.Printf("%-14s %-18s %s\n", d.DeviceID, seen, name)
+	}
+}
+
+func runAdminVersion(cmd *cobra.Command, args []string) error {
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	version, err := client.ServerVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get server version: %w", err)
+	}
+	fmt.Printf("Synapse %s\n", version)
+	return nil
+}
+
+func runAdminUsersList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(adminOutput); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	users, err := client.Users(matrix.UserFilter{
+		Name:        adminUsersName,
+		Guests:      adminUsersGuests,
+		Deactivated: adminUsersDeactivated,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	if adminOutput != outputText {
+		return printStructured(adminOutput, users)
+	}
+
+	fmt.Printf("%-32s %-6s %s\n", "USER", "ADMIN", "DISPLAY NAME")
+	for _, u := range users {
+		admin := ""
+		if u.Admin {
+			admin = "yes"
+		}
+		name := valueOr(u.DisplayName, "-")
+		if u.Deactivated {
+			name += " (deactivated)"
+		}
+		fmt.Printf("%-32s %-6s %s\n", u.Name, admin, name)
+	}
+	return nil
+}
+
+func runAdminUsersCreate(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	password, generated, err := newPassword(adminUserPassword)
+	if err != nil {
+		return err
+	}
+
+	userID := cfg.UserID(args[0])
+	err = client.CreateUser(userID, matrix.NewUser{
+		Password:    password,
+		DisplayName: adminUserDisplayName,
+		Admin:       adminUserAdmin,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	fmt.Printf("Created %s\n", userID)
+	if generated {
+		fmt.Printf("  Password: %s\n", password)
+	}
+	return nil
+}
+
+func runAdminUsersDeactivate(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	userID := cfg.UserID(args[0])
+	if userID == cfg.UserID(cfg.Admin.Username) {
+		return fmt.Errorf("refusing to deactivate muxbee's admin user %s", userID)
+	}
+	if !confirm(fmt.Sprintf("Deactivate %s? This can't be undone.", userID), adminYes) {
+		return nil
+	}
+	if err := client.DeactivateUser(userID, adminUserErase); err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	fmt.Printf("Deactivated %s\n", userID)
+	return nil
+}
+
+func runAdminUsersResetPassword(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	userID := cfg.UserID(args[0])
+	if userID == cfg.UserID(cfg.Admin.Username) {
+		return fmt.Errorf("refusing to reset the password of muxbee's admin user %s\nmuxbee logs in with the password in settings.yaml", userID)
+	}
+	password, generated, err := newPassword(adminUserPassword)
+	if err != nil {
+		return err
+	}
+	if err := client.ResetPassword(userID, password, adminUserKeepDevices); err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	fmt.Printf("Reset the password of %s\n", userID)
+	if generated {
+		fmt.Printf("  Password: %s\n", password)
+	}
+	return nil
+}
+
+// newPassword returns the given password, or a new random one
+func newPassword(given string) (password string, generated bool, err error) {
+	if given != "" {
+		return given, false, nil
+	}
+	password, err = config.GeneratePassword(16)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to generate password: %w", err)
+	}
+	return password, true, nil
+}
+
+func runAdminRoomsList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(adminOutput); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	rooms, err := client.Rooms(adminRoomsSearch)
+	if err != nil {
+		return fmt.Errorf("failed to list rooms: %w", err)
+	}
+	if adminOutput != outputText {
+		return printStructured(adminOutput, rooms)
+	}
+
+	fmt.Printf("%-44s %-8s %s\n", "ROOM", "MEMBERS", "NAME")
+	for _, r := range rooms {
+		name := valueOr(r.Name, valueOr(r.CanonicalAlias, "-"))
+		fmt.Printf("%-44s %-8d %s\n", r.RoomID, r.JoinedMembers, name)
+	}
+	return nil
+}
+
+func runAdminRoomsDelete(cmd *cobra.Command, args []string) error {
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	roomID, err := client.ResolveRoom(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to find room: %w", err)
+	}
+	if !confirm(fmt.Sprintf("Delete %s and all its history?", roomID), adminYes) {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Deleting %s...\n", roomID)
+	result, err := client.DeleteRoom(ctx, roomID, matrix.DeleteRoomOptions{
+		Block:   adminRoomBlock,
+		Message: adminRoomMessage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+	fmt.Printf("Deleted %s (removed %d members)\n", roomID, len(result.KickedUsers))
+	for _, u := range result.FailedToKickUsers {
+		fmt.Printf("  Could not remove %s\n", u)
+	}
+	return nil
+}
+
+func runAdminRoomsPurge(cmd *cobra.Command, args []string) error {
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	roomID, err := client.ResolveRoom(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to find room: %w", err)
+	}
+
+	before := time.Now().Add(-adminBefore)
+	if !confirm(fmt.Sprintf("Delete the history of %s from before %s?", roomID, before.Format("2006-01-02 15:04")), adminYes) {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Purging %s...\n", roomID)
+	if err := client.PurgeHistory(ctx, roomID, before, adminLocal); err != nil {
+		return fmt.Errorf("failed to purge history: %w", err)
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+func runAdminMediaQuarantine(cmd *cobra.Command, args []string) error {
+	if (len(args) == 1) == (adminMediaRoom != "" || adminMediaUser != "") {
+		return fmt.Errorf("give either an mxc:// URL, --room or --user")
+	}
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	var count int
+	switch {
+	case adminMediaRoom != "":
+		var roomID string
+		if roomID, err = client.ResolveRoom(adminMediaRoom); err != nil {
+			return fmt.Errorf("failed to find room: %w", err)
+		}
+		count, err = client.QuarantineRoomMedia(roomID)
+	case adminMediaUser != "":
+		count, err = client.QuarantineUserMedia(cfg.UserID(adminMediaUser))
+	default:
+		count, err = 1, client.QuarantineMedia(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to quarantine media: %w", err)
+	}
+	fmt.Printf("Quarantined %d media.\n", count)
+	return nil
+}
+
+func runAdminMediaDelete(cmd *cobra.Command, args []string) error {
+	if _, _, err := matrix.ParseMXC(args[0]); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	if !confirm(fmt.Sprintf("Delete %s?", args[0]), adminYes) {
+		return nil
+	}
+	if err := client.DeleteMedia(args[0]); err != nil {
+		return fmt.Errorf("failed to delete media: %w", err)
+	}
+	fmt.Printf("Deleted %s\n", args[0])
+	return nil
+}
+
+func runAdminMediaPurge(cmd *cobra.Command, args []string) error {
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	before := time.Now().Add(-adminBefore)
+	which := "cached remote media"
+	if adminLocal {
+		which = "uploaded media"
+	}
+	if !confirm(fmt.Sprintf("Delete %s last used before %s?", which, before.Format("2006-01-02 15:04")), adminYes) {
+		return nil
+	}
+
+	var count int
+	if adminLocal {
+		count, err = client.PurgeLocalMedia(before)
+	} else {
+		count, err = client.PurgeRemoteMedia(before)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to purge media: %w", err)
+	}
+	fmt.Printf("Deleted %d media.\n", count)
+	return nil
+}
+
+func runAdminTokensList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(adminOutput); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := client.RegistrationTokens()
+	if err != nil {
+		return fmt.Errorf("failed to list registration tokens: %w", err)
+	}
+	if adminOutput != outputText {
+		return printStructured(adminOutput, tokens)
+	}
+
+	fmt.Printf("%-24s %-10s %s\n", "TOKEN", "USED", "EXPIRES")
+	for _, t := range tokens {
+		printToken(t)
+	}
+	return nil
+}
+
+func runAdminTokensCreate(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(adminOutput); err != nil {
+		return err
+	}
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+
+	opts := matrix.NewRegistrationToken{Token: adminTokenValue, UsesAllowed: adminTokenUses}
+	if adminTokenExpires > 0 {
+		opts.Expires = time.Now().Add(adminTokenExpires)
+	}
+	token, err := client.CreateRegistrationToken(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create registration token: %w", err)
+	}
+	if adminOutput != outputText {
+		return printStructured(adminOutput, token)
+	}
+
+	fmt.Printf("%-24s %-10s %s\n", "TOKEN", "USED", "EXPIRES")
+	printToken(*token)
+	return nil
+}
+
+func runAdminTokensDelete(cmd *cobra.Command, args []string) error {
+	_, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteRegistrationToken(args[0]); err != nil {
+		return fmt.Errorf("failed to delete registration token: %w", err)
+	}
+	fmt.Printf("Deleted %s\n", args[0])
+	return nil
+}
+
+// printToken prints a registration token as a table row
+func printToken(t matrix.RegistrationToken) {
+	uses := "unlimited"
+	if t.UsesAllowed != nil {
+		uses = strconv.Itoa(*t.UsesAllowed)
+	}
+	expires := "never"
+	if !t.Expires().IsZero() {
+		expires = t.Expires().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("%-24s %-10s %s\n", t.Token, fmt.Sprintf("%d/%s", t.Completed, uses), expires)
+}
@@ -1,16 +1,21 @@
 package cmd
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
+	"filippo.io/age"
+	"github.com/charmbracelet/x/term"
 	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/backup"
 	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
 )
 
 var backupCmd = &cobra.Command{
@@ -20,92 +25,442 @@ var backupCmd = &cobra.Command{
 
 The backup includes:
   - Configuration files (synapse, element, bridges)
-  - Data directories (database, media, bridge state)
-  - Settings file`,
+  - Data directories (media, bridge state)
+  - Settings file
+  - A pg_dump of the Synapse database
+  - Bridge SQLite databases, copied with the SQLite backup API
+
+Databases are dumped rather than copied, so it is safe to back up while
+services are running. If PostgreSQL is stopped, it is started briefly
+for the dump.
+
+Backups contain every password, appservice token and bridge session, so
+use --encrypt before copying them anywhere shared. Encrypted backups use
+the age format and can also be opened with the age CLI:
+
+  muxbee backup --encrypt                        # Prompt for a passphrase
+  muxbee backup --recipients-file keys.txt       # Encrypt to age public keys
+
+For unattended runs, the passphrase can be set in MUXBEE_BACKUP_PASSPHRASE.
+See 'muxbee backup schedule' for recurring backups with retention.`,
 	RunE: runBackup,
 }
 
-var backupOutput string
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Take recurring backups with retention",
+	Long: `Take timestamped backups into a directory at a fixed interval and delete
+old ones according to a retention policy.
+
+A full backup is taken when there is none newer than --full-every. Every
+other run is incremental: it only stores files whose SHA-256 differs from
+the latest full backup (Synapse media rarely changes, so this saves a lot
+of space), and refers to that full backup for the rest. Restoring an
+incremental backup needs its full backup in the same directory; retention
+never deletes a full backup that a kept incremental depends on.
+
+Runs until interrupted. Use --once to take a single backup and prune, for
+example from cron or a systemd timer.
+
+Examples:
+  muxbee backup schedule --dir /srv/backups
+  muxbee backup schedule --dir /srv/backups --interval 6h --keep-daily 14
+  MUXBEE_BACKUP_PASSPHRASE=... muxbee backup schedule --dir /mnt/nas --encrypt --once`,
+	RunE: runBackupSchedule,
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify <backup-file>",
+	Short: "Check a backup's integrity",
+	Long: `Read a backup end to end and check every file against the SHA-256
+recorded in its manifest. For incremental backups, the files inherited from
+the full backup are checked too. Nothing is extracted.
+
+Exits with a non-zero status if any problem is found.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupVerify,
+}
+
+var backupInspectCmd = &cobra.Command{
+	Use:   "inspect <backup-file>",
+	Short: "Show what a backup contains",
+	Long: `Show a backup's manifest (muxbee version, server name, bridges, image
+versions) and what a restore would bring back, without touching the live
+config and data directories.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupInspect,
+}
+
+var (
+	backupOutput         string
+	backupEncrypt        bool
+	backupRecipientsFile string
+	backupIdentity       string
+
+	scheduleDir        string
+	scheduleInterval   time.Duration
+	scheduleFullEvery  time.Duration
+	scheduleKeepDaily  int
+	scheduleKeepWeekly int
+	scheduleOnce       bool
+)
+
+// passphraseEnv lets scripts supply the backup passphrase without a prompt
+const passphraseEnv = "MUXBEE_BACKUP_PASSPHRASE"
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupScheduleCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
+	backupCmd.AddCommand(backupInspectCmd)
+
 	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", "", "Output file path (default: muxbee-backup-TIMESTAMP.tar.gz)")
+	// Only the commands that write backups encrypt them
+	for _, c := range []*cobra.Command{backupCmd, backupScheduleCmd} {
+		c.Flags().BoolVarP(&backupEncrypt, "encrypt", "e", false, "Encrypt the backup with a passphrase")
+		c.Flags().StringVarP(&backupRecipientsFile, "recipients-file", "R", "", "Encrypt to the age public keys in this file")
+		c.MarkFlagsMutuallyExclusive("encrypt", "recipients-file")
+	}
+	backupCmd.PersistentFlags().StringVarP(&backupIdentity, "identity", "i", "", "age identity file for reading recipient-encrypted backups")
+
+	backupScheduleCmd.Flags().StringVarP(&scheduleDir, "dir", "d", "", "Directory to write backups to (required)")
+	backupScheduleCmd.Flags().DurationVar(&scheduleInterval, "interval", 24*time.Hour, "Time between backups")
+	backupScheduleCmd.Flags().DurationVar(&scheduleFullEvery, "full-every", 7*24*time.Hour, "Take a full backup when the latest is older than this")
+	backupScheduleCmd.Flags().IntVar(&scheduleKeepDaily, "keep-daily", 7, "Number of daily backups to keep")
+	backupScheduleCmd.Flags().IntVar(&scheduleKeepWeekly, "keep-weekly", 4, "Number of weekly backups to keep")
+	backupScheduleCmd.Flags().BoolVar(&scheduleOnce, "once", false, "Take one backup, prune, and exit")
+	backupScheduleCmd.MarkFlagRequired("dir")
 }
 
 func runBackup(cmd *cobra.Command, args []string) error {
-	if !config.Exists() {
-		return fmt.Errorf("no configuration found\nRun 'muxbee init' first")
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	recipients, _, err := backupRecipients()
+	if err != nil {
+		return err
 	}
 
 	if backupOutput == "" {
 		timestamp := time.Now().Format("20060102-150405")
 		backupOutput = fmt.Sprintf("muxbee-backup-%s.tar.gz", timestamp)
+		if len(recipients) > 0 {
+			backupOutput += backup.EncryptedExt
+		}
 	}
 
-	configDir := config.ConfigDir()
-	dataDir := config.DataDir()
-
 	fmt.Printf("Creating backup...\n")
-	fmt.Printf("  Config: %s\n", configDir)
-	fmt.Printf("  Data:   %s\n", dataDir)
+	fmt.Printf("  Config: %s\n", config.ConfigDir())
+	fmt.Printf("  Data:   %s\n", config.DataDir())
 
-	f, err := os.Create(backupOutput)
+	f, err := os.OpenFile(backupOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create backup file: %w", err)
 	}
 	defer f.Close()
 
-	gw := gzip.NewWriter(f)
-	defer gw.Close()
+	manifest, err := writeBackup(f, cfg, recipients)
+	if err != nil {
+		f.Close()
+		os.Remove(backupOutput)
+		return err
+	}
+
+	fmt.Printf("\nBackup created: %s\n", backupOutput)
+	fmt.Printf("  PostgreSQL dump and %d bridge database(s) included\n", len(manifest.SQLiteDatabases))
+	if len(recipients) > 0 {
+		fmt.Println("  Encrypted (age)")
+	}
+	return nil
+}
+
+// writeBackup creates the archive, encrypting it if recipients are given
+func writeBackup(w io.Writer, cfg *config.Config, recipients []age.Recipient) (*backup.Manifest, error) {
+	progress := func(step string) {
+		fmt.Printf("  %s...\n", step)
+	}
+
+	opts := backup.CreateOptions{Progress: progress, MuxbeeVersion: Version}
 
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	if len(recipients) == 0 {
+		return backup.Create(w, cfg, opts)
+	}
+
+	ew, err := backup.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+	manifest, err := backup.Create(ew, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ew.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	return manifest, nil
+}
 
-	if err := addDirToTar(tw, configDir, "config"); err != nil {
-		return fmt.Errorf("failed to backup config: %w", err)
+// backupRecipients returns the recipients selected by --encrypt or
+// --recipients-file, plus the passphrase if one was used
+func backupRecipients() ([]age.Recipient, string, error) {
+	if backupRecipientsFile != "" {
+		recipients, err := backup.LoadRecipients(backupRecipientsFile)
+		return recipients, "", err
 	}
 
-	if err := addDirToTar(tw, dataDir, "data"); err != nil {
-		return fmt.Errorf("failed to backup data: %w", err)
+	if !backupEncrypt {
+		return nil, "", nil
 	}
 
-	fmt.Printf("\nBackup created: %s\n", backupOutput)
-	return nil
+	passphrase, err := readPassphrase(true)
+	if err != nil {
+		return nil, "", err
+	}
+	recipient, err := backup.PassphraseRecipient(passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	return []age.Recipient{recipient}, passphrase, nil
 }
 
-func addDirToTar(tw *tar.Writer, srcDir, prefix string) error {
-	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+func runBackupSchedule(cmd *cobra.Command, args []string) error {
+	if scheduleInterval <= 0 {
+		return fmt.Errorf("--interval must be positive, got %s", scheduleInterval)
+	}
+	if _, err := config.Load(); err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	recipients, passphrase, err := backupRecipients()
+	if err != nil {
+		return err
+	}
+
+	schedule := &backup.Schedule{
+		Dir: scheduleDir,
+		Policy: backup.Policy{
+			KeepDaily:  scheduleKeepDaily,
+			KeepWeekly: scheduleKeepWeekly,
+		},
+		FullEvery:  scheduleFullEvery,
+		Recipients: recipients,
+		Keys: backup.Keys{
+			IdentityFile: backupIdentity,
+			Passphrase:   func() (string, error) { return passphrase, nil },
+		},
+		Progress: func(step string) {
+			fmt.Printf("  %s...\n", step)
+		},
+		MuxbeeVersion: Version,
+	}
+
+	if scheduleOnce {
+		return runScheduledBackup(schedule)
+	}
+
+	fmt.Printf("Backing up to %s every %s (keeping %d daily, %d weekly)\n",
+		scheduleDir, scheduleInterval, scheduleKeepDaily, scheduleKeepWeekly)
+	fmt.Println("Press Ctrl+C to stop.")
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		// A failed run is reported and retried at the next interval
+		if err := runScheduledBackup(schedule); err != nil {
+			fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
 		}
+		fmt.Printf("Next backup at %s\n", time.Now().Add(scheduleInterval).Format("2006-01-02 15:04"))
 
-		relPath, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
+		select {
+		case <-ticker.C:
+		case <-sigChan:
+			fmt.Println("\nStopping backup schedule.")
+			return nil
 		}
+	}
+}
 
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+// runScheduledBackup runs one scheduled backup and prints what happened
+func runScheduledBackup(schedule *backup.Schedule) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	fmt.Printf("\n[%s] Creating backup...\n", time.Now().Format("2006-01-02 15:04:05"))
+	result, err := schedule.Run(cfg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if result.Note != "" {
+		fmt.Printf("  Note: %s\n", result.Note)
+	}
+	if result.Manifest.IsIncremental() {
+		fmt.Printf("Backup created: %s (incremental, %d of %d files unchanged since %s)\n",
+			result.Path, len(result.Manifest.Inherited), len(result.Manifest.Files), result.Manifest.Parent)
+	} else {
+		fmt.Printf("Backup created: %s (full)\n", result.Path)
+	}
+	for _, name := range result.Pruned {
+		fmt.Printf("  Removed old backup %s\n", name)
+	}
+	return nil
+}
+
+func runBackupVerify(cmd *cobra.Command, args []string) error {
+	fmt.Printf("Verifying %s...\n", args[0])
+
+	report, err := backup.VerifyFile(args[0], backupKeys(backupIdentity))
+	if err != nil {
+		return err
+	}
+
+	if report.Manifest == nil {
+		fmt.Printf("Readable: %d files, %s\n", report.Files, formatSize(report.Size))
+		fmt.Println("Legacy backup without a manifest: no checksums to verify against.")
+		return nil
+	}
+
+	if !report.OK() {
+		fmt.Println()
+		for _, p := range report.Problems {
+			fmt.Printf("  ✗ %s\n", p)
 		}
-		header.Name = filepath.Join(prefix, relPath)
+		fmt.Println()
+		return fmt.Errorf("backup failed verification: %d problem(s)", len(report.Problems))
+	}
 
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+	verified := len(report.Manifest.Files)
+	fmt.Printf("OK: %d files verified", verified)
+	if report.Manifest.IsIncremental() {
+		fmt.Printf(" (%d from %s)", len(report.Manifest.Inherited), report.Manifest.Parent)
+	}
+	fmt.Println()
+	return nil
+}
+
+func runBackupInspect(cmd *cobra.Command, args []string) error {
+	report, err := backup.VerifyFile(args[0], backupKeys(backupIdentity))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup: %s\n", args[0])
+	if report.Encrypted {
+		fmt.Println("  Encrypted:       yes (age)")
+	}
+
+	m := report.Manifest
+	if m == nil {
+		fmt.Println("  Format:          legacy (no manifest, raw database copy)")
+	} else {
+		fmt.Printf("  Created:         %s\n", m.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		fmt.Printf("  muxbee version:  %s\n", valueOr(m.MuxbeeVersion, "unknown"))
+		fmt.Printf("  Format:          %d (settings schema %d)\n", m.FormatVersion, m.SchemaVersion)
+		fmt.Printf("  Server name:     %s\n", valueOr(m.ServerName, "unknown"))
+		if len(m.EnabledBridges) > 0 {
+			fmt.Printf("  Bridges:         %s\n", strings.Join(m.EnabledBridges, ", "))
+		} else {
+			fmt.Println("  Bridges:         none")
+		}
+		if m.IsIncremental() {
+			fmt.Printf("  Incremental:     %d of %d files come from %s\n", len(m.Inherited), len(m.Files), m.Parent)
 		}
 
-		if !info.IsDir() {
-			f, err := os.Open(path)
-			if err != nil {
-				return err
+		if len(m.Images) > 0 {
+			fmt.Println()
+			fmt.Println("Images:")
+			services := make([]string, 0, len(m.Images))
+			for service := range m.Images {
+				services = append(services, service)
 			}
-			defer f.Close()
-
-			if _, err := io.Copy(tw, f); err != nil {
-				return err
+			sort.Strings(services)
+			for _, service := range services {
+				info := m.Images[service]
+				digest := ""
+				if info.Digest != "" {
+					digest = " " + docker.ShortVersion(info.Digest)
+				}
+				fmt.Printf("  %-20s %s%s\n", service, info.Image, digest)
 			}
 		}
+	}
 
-		return nil
-	})
+	fmt.Println()
+	fmt.Println("A restore would bring back:")
+	for _, group := range report.GroupNames() {
+		fmt.Printf("  %-28s %s\n", group, formatSize(report.Groups[group]))
+	}
+	if m != nil && m.PostgresDump != "" {
+		fmt.Println("  (the PostgreSQL dump is loaded into a fresh database)")
+	}
+
+	fmt.Println()
+	if report.OK() {
+		fmt.Printf("Integrity: OK (%d files, %s stored)\n", report.Files, formatSize(report.Size))
+	} else {
+		fmt.Printf("Integrity: %d problem(s), run 'muxbee backup verify' for details\n", len(report.Problems))
+	}
+	return nil
+}
+
+// formatSize formats a byte count for display
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// readPassphrase reads a backup passphrase from MUXBEE_BACKUP_PASSPHRASE or
+// the terminal, asking twice when confirm is set
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("no terminal to prompt for a passphrase\nSet %s instead", passphraseEnv)
+	}
+
+	fmt.Print("Backup passphrase: ")
+	passphrase, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+
+	if confirm {
+		fmt.Print("Confirm passphrase: ")
+		again, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if string(again) != string(passphrase) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+
+	return string(passphrase), nil
 }
@@ -2,16 +2,24 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/charmbracelet/x/term"
 	"github.com/spf13/cobra"
 	"github.com/tobocop2/muxbee/internal/bridges"
 	"github.com/tobocop2/muxbee/internal/config"
 	"github.com/tobocop2/muxbee/internal/docker"
 	"github.com/tobocop2/muxbee/internal/generator"
+	"github.com/tobocop2/muxbee/internal/matrix"
+	"github.com/tobocop2/muxbee/internal/qrcode"
 )
 
 var bridgeCmd = &cobra.Command{
@@ -47,21 +55,68 @@ var bridgeDisableCmd = &cobra.Command{
 
 var bridgeLoginCmd = &cobra.Command{
 	Use:   "login <bridge>",
-	Short: "Show login instructions for a bridge",
-	Long:  `Display login instructions for a specific bridge.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runBridgeLogin,
+	Short: "Log in to a bridge",
+	Long: `Log in to a bridge by talking to its bot for you, as the admin user.
+
+muxbee sends the bot its login command, shows the QR codes it sends right
+in the terminal and asks for whatever else the bot wants, such as a phone
+number, a verification code or a 2FA password. Press Ctrl-C to cancel the
+login.
+
+Bridges that need cookies or tokens extracted from a browser only print
+their login instructions; so does --instructions. Use --command to start
+the login with a different command, such as 'login phone' for WhatsApp's
+pairing code.`,
+	Example: `  muxbee bridge login whatsapp
+  muxbee bridge login whatsapp --command "login phone"
+  muxbee bridge login meta --instructions`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBridgeLogin,
+}
+
+// defaultBridgeLoginTimeout is how long 'muxbee bridge login' waits for the
+// bot to answer
+const defaultBridgeLoginTimeout = 5 * time.Minute
+
+var (
+	bridgeListOutput        string
+	bridgeLoginCommand      string
+	bridgeLoginInstructions bool
+	bridgeLoginTimeout      time.Duration
+)
+
+// bridgeListEntry is one bridge in 'muxbee bridge list --output json|yaml'
+type bridgeListEntry struct {
+	Name                   string `json:"name" yaml:"name"`
+	Description            string `json:"description" yaml:"description"`
+	Enabled                bool   `json:"enabled" yaml:"enabled"`
+	Custom                 bool   `json:"custom" yaml:"custom"`
+	Service                string `json:"service" yaml:"service"`
+	Image                  string `json:"image" yaml:"image"`
+	Port                   int    `json:"port" yaml:"port"`
+	BotUsername            string `json:"bot_username" yaml:"bot_username"`
+	RequiresAPICredentials bool   `json:"requires_api_credentials" yaml:"requires_api_credentials"`
+	Note                   string `json:"note,omitempty" yaml:"note,omitempty"`
 }
 
 func init() {
 	rootCmd.AddCommand(bridgeCmd)
 	bridgeCmd.AddCommand(bridgeListCmd)
+	addOutputFlag(bridgeListCmd, &bridgeListOutput)
 	bridgeCmd.AddCommand(bridgeEnableCmd)
 	bridgeCmd.AddCommand(bridgeDisableCmd)
 	bridgeCmd.AddCommand(bridgeLoginCmd)
+
+	bridgeLoginCmd.Flags().StringVar(&bridgeLoginCommand, "command", "", "Send this command to the bot to start the login")
+	bridgeLoginCmd.Flags().BoolVar(&bridgeLoginInstructions, "instructions", false, "Only print the login instructions")
+	bridgeLoginCmd.Flags().DurationVar(&bridgeLoginTimeout, "timeout", defaultBridgeLoginTimeout, "How long to wait for the bot to answer")
 }
 
 func runBridgeList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(bridgeListOutput); err != nil {
+		return err
+	}
+
 	var enabledBridges []string
 	if cfg, err := config.Load(); err == nil {
 		enabledBridges = cfg.EnabledBridges
@@ -76,13 +131,35 @@ func runBridgeList(cmd *cobra.Command, args []string) error {
 		return false
 	}
 
+	if bridgeListOutput != outputText {
+		entries := []bridgeListEntry{}
+		for _, b := range bridges.List() {
+			entries = append(entries, bridgeListEntry{
+				Name:                   b.Name,
+				Description:            b.Description,
+				Enabled:                isEnabled(b.Name),
+				Custom:                 b.Custom,
+				Service:                b.ServiceName(),
+				Image:                  b.Image(),
+				Port:                   b.Port,
+				BotUsername:            b.BotUsername(),
+				RequiresAPICredentials: b.RequiresAPICredentials,
+				Note:                   b.Note,
+			})
+		}
+		return printStructured(bridgeListOutput, entries)
+	}
+
 	fmt.Println("Available Bridges:")
 	fmt.Println()
 
 	for _, b := range bridges.List() {
 		status := ""
+		if b.Custom {
+			status += " [custom]"
+		}
 		if isEnabled(b.Name) {
-			status = " [enabled]"
+			status += " [enabled]"
 		}
 		fmt.Printf("  %-12s %s%s\n", b.Name, b.Description, status)
 	}
@@ -129,6 +206,10 @@ func runBridgeEnable(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Bridge '%s' enabled.\n", bridgeName)
 
 	compose := docker.New(cfg)
+	if err := compose.WriteComposeFile(); err != nil {
+		return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+	}
+
 	if compose.IsServiceRunning("synapse") {
 		fmt.Println("Services are running. Applying changes...")
 
@@ -242,6 +323,11 @@ func runBridgeDisable(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to generate configs: %w", err)
 	}
 
+	compose := docker.New(cfg)
+	if err := compose.WriteComposeFile(); err != nil {
+		return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+	}
+
 	fmt.Printf("Bridge '%s' disabled.\n", bridgeName)
 	fmt.Println("Run 'muxbee up' to apply changes.")
 
@@ -265,11 +351,148 @@ func runBridgeLogin(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("bridge '%s' is not enabled\nRun 'muxbee bridge enable %s' first", bridgeName, bridgeName)
 	}
 
-	fmt.Printf("Login instructions for %s:\n", bridge.Description)
-	fmt.Println()
+	command := bridgeLoginCommand
+	if command == "" {
+		command = bridge.LoginCommand
+	}
+	if bridgeLoginInstructions || command == "" {
+		fmt.Printf("Login instructions for %s:\n", bridge.Description)
+		fmt.Println()
 
-	instructions := strings.ReplaceAll(bridge.LoginInstructions, "SERVER", cfg.ServerName)
-	fmt.Println(instructions)
+		instructions := strings.ReplaceAll(bridge.LoginInstructions, "SERVER", cfg.ServerName)
+		fmt.Println(instructions)
 
-	return nil
+		return nil
+	}
+
+	return loginToBridge(cfg, bridge, command)
+}
+
+// loginToBridge walks through a bridge's login with its bot: it shows what
+// the bot sends and relays the user's answers until the bot reports how
+// the login went
+func loginToBridge(cfg *config.Config, bridge *bridges.BridgeInfo, command string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	login, err := matrix.StartBridgeLogin(ctx, cfg, bridge, command)
+	if err != nil {
+		return fmt.Errorf("failed to start login: %w\nIs muxbee running? Start it with 'muxbee up'", err)
+	}
+	fmt.Printf("Sent '%s' to %s. Press Ctrl-C to cancel.\n\n", command, login.BotUserID())
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	// cancel tells the bot to drop the login, so the next one starts clean
+	cancel := func() {
+		login.Send("cancel")
+	}
+
+	for {
+		waitCtx, done := context.WithTimeout(ctx, bridgeLoginTimeout)
+		messages, err := login.Next(waitCtx)
+		done()
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				cancel()
+				return fmt.Errorf("login cancelled")
+			case errors.Is(err, context.DeadlineExceeded):
+				cancel()
+				return fmt.Errorf("%s did not answer within %s", login.BotUserID(), bridgeLoginTimeout)
+			}
+			return fmt.Errorf("failed to read the bot's reply: %w", err)
+		}
+
+		for _, msg := range messages {
+			printBotMessage(msg)
+			switch msg.Result() {
+			case matrix.LoginSucceeded:
+				fmt.Printf("\n✓ Logged in to %s\n", bridge.Description)
+				return nil
+			case matrix.LoginFailed:
+				return fmt.Errorf("%s login failed", bridge.Name)
+			}
+		}
+
+		last := messages[len(messages)-1]
+		if !last.IsPrompt() {
+			continue
+		}
+		answer, err := readBotAnswer(ctx, stdin, last.IsSecret())
+		if err != nil {
+			cancel()
+			return err
+		}
+		if err := login.Send(answer); err != nil {
+			return fmt.Errorf("failed to send answer: %w", err)
+		}
+	}
+}
+
+// printBotMessage prints a bot's message, drawing images as QR codes
+func printBotMessage(msg matrix.BotMessage) {
+	if msg.Image == nil {
+		fmt.Println(msg.Body)
+		return
+	}
+
+	code, err := qrcode.RenderImage(msg.Image)
+	if err != nil {
+		fmt.Printf("The bot sent an image muxbee can't show (%v). Open the chat in Element to see it.\n", err)
+		return
+	}
+	if msg.Replaces != "" {
+		fmt.Println("\nNew QR code:")
+	}
+	fmt.Print(code)
+}
+
+// readBotAnswer reads the user's answer to a bot's prompt, without echo for
+// secrets. It returns early when ctx is cancelled.
+func readBotAnswer(ctx context.Context, stdin *bufio.Reader, secret bool) (string, error) {
+	fd := os.Stdin.Fd()
+	if secret && !term.IsTerminal(fd) {
+		secret = false
+	}
+
+	var state *term.State
+	if secret {
+		var err error
+		if state, err = term.GetState(fd); err != nil {
+			return "", fmt.Errorf("failed to read terminal state: %w", err)
+		}
+	}
+
+	type answer struct {
+		text string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		if secret {
+			text, err := term.ReadPassword(fd)
+			fmt.Println()
+			answers <- answer{string(text), err}
+			return
+		}
+		text, err := stdin.ReadString('\n')
+		answers <- answer{text, err}
+	}()
+
+	fmt.Print("> ")
+	select {
+	case a := <-answers:
+		if a.err != nil && (a.err != io.EOF || a.text == "") {
+			return "", fmt.Errorf("failed to read answer: %w", a.err)
+		}
+		return strings.TrimSpace(a.text), nil
+	case <-ctx.Done():
+		// ReadPassword turned echo off and won't get to restore it
+		if state != nil {
+			term.Restore(fd, state)
+		}
+		fmt.Println()
+		return "", fmt.Errorf("login cancelled")
+	}
 }
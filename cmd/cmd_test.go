@@ -2,8 +2,13 @@ package cmd
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
 )
 
 // Test command structure
@@ -37,7 +42,7 @@ func TestRootHasSubcommands(t *testing.T) {
 	}
 
 	// Check expected subcommands exist
-	expected := []string{"init", "up", "down", "status", "bridge", "logs", "backup", "restore", "nuke", "config", "health", "open", "setup-bots", "tui", "update"}
+	expected := []string{"init", "up", "down", "status", "bridge", "logs", "backup", "restore", "nuke", "config", "health", "open", "setup-bots", "tui", "update", "pin", "unpin", "doctor", "watch", "daemon", "service", "metrics", "admin", "user"}
 	cmdNames := make(map[string]bool)
 	for _, cmd := range subcommands {
 		cmdNames[cmd.Name()] = true
@@ -277,6 +282,45 @@ func TestBackupCommand_HasOutputFlag(t *testing.T) {
 	}
 }
 
+func TestBackupCommand_EncryptFlags(t *testing.T) {
+	for _, c := range []*cobra.Command{backupCmd, backupScheduleCmd} {
+		if c.Flags().Lookup("encrypt") == nil || c.Flags().Lookup("recipients-file") == nil {
+			t.Errorf("expected %s to have the encryption flags", c.CommandPath())
+		}
+	}
+	for _, c := range []*cobra.Command{backupVerifyCmd, backupInspectCmd} {
+		if c.Flags().Lookup("encrypt") != nil || c.Flags().Lookup("recipients-file") != nil {
+			t.Errorf("expected %s not to have the encryption flags", c.CommandPath())
+		}
+	}
+}
+
+func TestBackupCommand_EncryptionFlagsExclusive(t *testing.T) {
+	for _, c := range []*cobra.Command{backupCmd, backupScheduleCmd} {
+		c.Flags().Set("encrypt", "true")
+		c.Flags().Set("recipients-file", "keys.txt")
+		err := c.ValidateFlagGroups()
+		if err == nil || !strings.Contains(err.Error(), "none of the others can be") {
+			t.Errorf("expected %s to reject --encrypt with --recipients-file, got %v", c.CommandPath(), err)
+		}
+		c.Flags().Set("encrypt", "false")
+		c.Flags().Lookup("encrypt").Changed = false
+		c.Flags().Set("recipients-file", "")
+		c.Flags().Lookup("recipients-file").Changed = false
+	}
+}
+
+func TestBackupSchedule_RejectsBadInterval(t *testing.T) {
+	defer func() { scheduleInterval = 24 * time.Hour }()
+	for _, interval := range []time.Duration{0, -time.Hour} {
+		scheduleInterval = interval
+		err := runBackupSchedule(backupScheduleCmd, nil)
+		if err == nil || !strings.Contains(err.Error(), "--interval") {
+			t.Errorf("expected %s to be rejected, got %v", interval, err)
+		}
+	}
+}
+
 func TestLogsCommand_HasFollowFlag(t *testing.T) {
 	flag := logsCmd.Flags().Lookup("follow")
 	if flag == nil {
@@ -297,6 +341,99 @@ func TestLogsCommand_HasTailFlag(t *testing.T) {
 	}
 }
 
+func TestBridgeLoginCommand_HasFlags(t *testing.T) {
+	for _, name := range []string{"command", "instructions", "timeout"} {
+		if bridgeLoginCmd.Flags().Lookup(name) == nil {
+			t.Errorf("expected bridge login command to have --%s flag", name)
+		}
+	}
+}
+
+func TestAdminCommand_HasSubcommands(t *testing.T) {
+	expected := map[*cobra.Command][]string{
+		adminCmd:       {"version", "devices", "users", "rooms", "media", "tokens"},
+		adminUsersCmd:  {"list", "create", "deactivate", "reset-password"},
+		adminRoomsCmd:  {"list", "delete", "purge"},
+		adminMediaCmd:  {"quarantine", "delete", "purge"},
+		adminTokensCmd: {"list", "create", "delete"},
+	}
+	for parent, subcommands := range expected {
+		names := make(map[string]bool)
+		for _, c := range parent.Commands() {
+			names[c.Name()] = true
+		}
+		for _, name := range subcommands {
+			if !names[name] {
+				t.Errorf("expected %s subcommand '%s' to exist", parent.CommandPath(), name)
+			}
+		}
+	}
+}
+
+func TestUserCommand_HasSubcommands(t *testing.T) {
+	names := make(map[string]bool)
+	for _, c := range userCmd.Commands() {
+		names[c.Name()] = true
+	}
+	for _, name := range []string{"add", "remove", "list", "passwd", "invite", "signup"} {
+		if !names[name] {
+			t.Errorf("expected user subcommand '%s' to exist", name)
+		}
+	}
+}
+
+func TestUserName(t *testing.T) {
+	cfg := &config.Config{ServerName: "example.com"}
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"alice", "alice", false},
+		{"@alice:example.com", "alice", false},
+		{"@alice:other.org", "", true},
+		{"Alice", "", true},
+		{"", "", true},
+		{"a.l_i=c-e/1+x", "a.l_i=c-e/1+x", false},
+		{"alice smith", "", true},
+		{"alice#1", "", true},
+		{"élise", "", true},
+		{"@alice:example.com:8448", "", true},
+	}
+	for _, tt := range tests {
+		got, err := userName(cfg, tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("userName(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUserNameArg(t *testing.T) {
+	for _, c := range []*cobra.Command{userAddCmd, userRemoveCmd, userPasswdCmd} {
+		if err := c.Args(c, []string{"Bad Name"}); err == nil {
+			t.Errorf("expected %s to reject an invalid name", c.CommandPath())
+		}
+		if err := c.Args(c, []string{"@alice:example.com"}); err != nil {
+			t.Errorf("expected %s to accept a user ID, got %v", c.CommandPath(), err)
+		}
+	}
+}
+
+func TestAdminDevicesCommand_HasSubcommands(t *testing.T) {
+	names := make(map[string]bool)
+	for _, c := range adminDevicesCmd.Commands() {
+		names[c.Name()] = true
+	}
+	for _, name := range []string{"list", "prune"} {
+		if !names[name] {
+			t.Errorf("expected admin devices subcommand '%s' to exist", name)
+		}
+	}
+	if adminDevicesPruneCmd.Flags().Lookup("dry-run") == nil {
+		t.Error("expected admin devices prune to have --dry-run flag")
+	}
+}
+
 func TestNukeCommand_HasYesFlag(t *testing.T) {
 	flag := nukeCmd.Flags().Lookup("yes")
 	if flag == nil {
@@ -339,3 +476,57 @@ func TestUpdateCommand(t *testing.T) {
 		t.Error("expected updateCmd to have RunE function")
 	}
 }
+
+func TestPinCommand(t *testing.T) {
+	if pinCmd.Args == nil {
+		t.Error("expected pinCmd to have Args validator")
+	}
+	if err := pinCmd.Args(pinCmd, []string{"a", "b", "c"}); err == nil {
+		t.Error("expected pinCmd to reject more than two args")
+	}
+	if err := unpinCmd.Args(unpinCmd, []string{}); err == nil {
+		t.Error("expected unpinCmd to require a service")
+	}
+}
+
+func TestStructuredOutputFlags(t *testing.T) {
+	for _, c := range []*cobra.Command{statusCmd, healthCmd, bridgeListCmd} {
+		flag := c.Flags().Lookup("output")
+		if flag == nil {
+			t.Errorf("expected %s command to have --output flag", c.Name())
+			continue
+		}
+		if flag.DefValue != "text" {
+			t.Errorf("expected --output to default to 'text' on %s, got '%s'", c.Name(), flag.DefValue)
+		}
+	}
+
+	if err := checkOutputFormat("xml"); err == nil {
+		t.Error("expected an error for unknown output format")
+	}
+}
+
+func TestHealthReportStatus(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     string
+		code     int
+	}{
+		{[]string{healthOK, healthOK}, healthOK, 0},
+		{[]string{healthOK, healthDegraded, healthOK}, healthDegraded, 1},
+		{[]string{healthDegraded, healthDown, healthOK}, healthDown, 2},
+	}
+
+	for _, tt := range tests {
+		report := &healthReport{}
+		for i, s := range tt.statuses {
+			report.add(fmt.Sprintf("check %d", i), s, "")
+		}
+		if report.Status != tt.want {
+			t.Errorf("statuses %v: expected %s, got %s", tt.statuses, tt.want, report.Status)
+		}
+		if code := report.exitCode(); code != tt.code {
+			t.Errorf("statuses %v: expected exit code %d, got %d", tt.statuses, tt.code, code)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/backup"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/daemon"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Supervise services in the background",
+	Long: `Run muxbee as a long-running supervisor. The daemon:
+
+  - starts the services, and starts them again when the container runtime
+    comes back after a restart
+  - checks every service and bridge each --health-interval, starting
+    stopped services and restarting bridges whose appservice listener
+    stops answering, waiting twice as long after each restart (30s up to
+    30m) so a broken bridge isn't restarted nonstop
+  - sends bridge alerts like 'muxbee watch' (turn off with --no-alerts)
+  - serves Prometheus metrics on --metrics-listen, like 'muxbee metrics
+    serve'
+  - takes scheduled backups into --backup-dir, like 'muxbee backup
+    schedule'; set MUXBEE_BACKUP_PASSPHRASE or --backup-recipients-file to
+    encrypt them
+
+While it runs, 'muxbee up', 'muxbee down', 'muxbee status' and the TUI go
+through the daemon's control socket. Services stopped with 'muxbee down'
+stay down, also when the daemon restarts, until 'muxbee up'.
+
+The daemon runs in the foreground until interrupted.`,
+	RunE: runDaemon,
+}
+
+var (
+	daemonHealthInterval time.Duration
+	daemonNoAlerts       bool
+	daemonMetricsListen  string
+
+	daemonBackupDir        string
+	daemonBackupInterval   time.Duration
+	daemonBackupFullEvery  time.Duration
+	daemonBackupKeepDaily  int
+	daemonBackupKeepWeekly int
+	daemonBackupRecipients string
+	daemonBackupIdentity   string
+)
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().DurationVar(&daemonHealthInterval, "health-interval", daemon.DefaultHealthInterval, "Time between health checks")
+	daemonCmd.Flags().BoolVar(&daemonNoAlerts, "no-alerts", false, "Don't send bridge alerts")
+	daemonCmd.Flags().StringVar(&daemonMetricsListen, "metrics-listen", "", "Serve Prometheus metrics on this address, e.g. 127.0.0.1:9480")
+	daemonCmd.Flags().StringVar(&daemonBackupDir, "backup-dir", "", "Take scheduled backups into this directory")
+	daemonCmd.Flags().DurationVar(&daemonBackupInterval, "backup-interval", daemon.DefaultBackupInterval, "Time between backups")
+	daemonCmd.Flags().DurationVar(&daemonBackupFullEvery, "backup-full-every", 7*24*time.Hour, "Take a full backup when the latest is older than this")
+	daemonCmd.Flags().IntVar(&daemonBackupKeepDaily, "backup-keep-daily", 7, "Number of daily backups to keep")
+	daemonCmd.Flags().IntVar(&daemonBackupKeepWeekly, "backup-keep-weekly", 4, "Number of weekly backups to keep")
+	daemonCmd.Flags().StringVar(&daemonBackupRecipients, "backup-recipients-file", "", "Encrypt backups to the age public keys in this file")
+	daemonCmd.Flags().StringVar(&daemonBackupIdentity, "backup-identity", "", "age identity file for reading recipient-encrypted backups")
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	logf := func(format string, args ...any) {
+		fmt.Printf("[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+	}
+
+	opts := daemon.Options{
+		HealthInterval: daemonHealthInterval,
+		BackupInterval: daemonBackupInterval,
+		Alerts:         !daemonNoAlerts,
+		MetricsAddr:    daemonMetricsListen,
+		Logf:           logf,
+	}
+	if daemonBackupDir != "" {
+		schedule, err := daemonBackupSchedule()
+		if err != nil {
+			return err
+		}
+		opts.Backup = schedule
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logf("muxbee daemon started, control socket %s", config.DaemonSocketPath())
+	if err := daemon.New(cfg, opts).Run(ctx); err != nil {
+		return err
+	}
+	logf("muxbee daemon stopped")
+	return nil
+}
+
+// daemonBackupSchedule builds the backup schedule from the --backup flags
+func daemonBackupSchedule() (*backup.Schedule, error) {
+	schedule := &backup.Schedule{
+		Dir: daemonBackupDir,
+		Policy: backup.Policy{
+			KeepDaily:  daemonBackupKeepDaily,
+			KeepWeekly: daemonBackupKeepWeekly,
+		},
+		FullEvery:     daemonBackupFullEvery,
+		Keys:          backup.Keys{IdentityFile: daemonBackupIdentity},
+		MuxbeeVersion: Version,
+	}
+
+	switch passphrase := os.Getenv(passphraseEnv); {
+	case daemonBackupRecipients != "":
+		recipients, err := backup.LoadRecipients(daemonBackupRecipients)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Recipients = recipients
+	case passphrase != "":
+		recipient, err := backup.PassphraseRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Recipients = []age.Recipient{recipient}
+		schedule.Keys.Passphrase = func() (string, error) { return passphrase, nil }
+	}
+	return schedule, nil
+}
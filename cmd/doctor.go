@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/doctor"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common setup problems",
+	Long: `Check the install for the usual reasons muxbee doesn't work:
+
+  - Docker or Podman missing or not running, or its compose tool missing
+    or too old
+  - Low disk space for the data directory
+  - Missing config or data directories, or missing generated configs
+  - Appservice tokens in registration.yaml or bridge configs that don't
+    match settings.yaml
+  - Synapse loading the registration of a disabled bridge, or missing one
+    for an enabled bridge
+  - Telegram enabled without API credentials
+  - Ports muxbee publishes held by another program
+  - A server name that doesn't resolve
+
+With --fix, muxbee repairs what it can on its own: it recreates missing
+directories and regenerates configs from settings.yaml, issuing new tokens
+where settings.yaml has none. Everything else comes with instructions.
+
+Exits with status 1 if any check fails.`,
+	RunE: runDoctor,
+}
+
+var (
+	doctorFix    bool
+	doctorOutput string
+)
+
+// doctorReport is what 'muxbee doctor --output json|yaml' prints
+type doctorReport struct {
+	Checks []doctor.Check `json:"checks" yaml:"checks"`
+	Fixed  []string       `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	addOutputFlag(doctorCmd, &doctorOutput)
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "Repair the problems muxbee can fix itself")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(doctorOutput); err != nil {
+		return err
+	}
+	text := doctorOutput == outputText
+
+	report := doctor.Run()
+	if text {
+		fmt.Println("muxbee doctor")
+		fmt.Println("=============")
+		fmt.Println()
+		printDoctor(report, !doctorFix)
+	}
+
+	var fixed []string
+	if doctorFix && len(report.Fixes()) > 0 {
+		regenerated := slices.Contains(report.Fixes(), doctor.FixRegenerate)
+
+		var err error
+		fixed, err = doctor.Repair(report)
+		if text {
+			fmt.Println()
+			for _, f := range fixed {
+				fmt.Printf("Fixed: %s\n", f)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if regenerated {
+			if err := reloadServices(text); err != nil {
+				return err
+			}
+		}
+
+		report = doctor.Run()
+		if text {
+			fmt.Println()
+			fmt.Println("Checking again...")
+			fmt.Println()
+			printDoctor(report, false)
+		}
+	}
+
+	if !text {
+		if err := printStructured(doctorOutput, doctorReport{Checks: report.Checks, Fixed: fixed}); err != nil {
+			return err
+		}
+	}
+
+	if report.Failed() {
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// reloadServices recreates running containers so they pick up regenerated
+// configs
+func reloadServices(verbose bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	compose := docker.New(cfg)
+	if !compose.IsServiceRunning("synapse") {
+		return nil
+	}
+
+	if verbose {
+		fmt.Println("Services are running. Recreating them to load the new configs...")
+	}
+	if err := compose.UpForceRecreateQuiet(docker.GetProfiles(cfg)); err != nil {
+		return fmt.Errorf("failed to restart services: %w", err)
+	}
+	return nil
+}
+
+// printDoctor prints each check, with instructions under the problems.
+// suggestFix mentions --fix when it would help.
+func printDoctor(report *doctor.Report, suggestFix bool) {
+	const indent = "                        "
+
+	for _, c := range report.Checks {
+		label := c.Name + ":"
+		switch {
+		case c.Status == doctor.StatusOK && c.Detail != "":
+			fmt.Printf("%-24sOK (%s)\n", label, c.Detail)
+		case c.Status == doctor.StatusOK:
+			fmt.Printf("%-24sOK\n", label)
+		case c.Status == doctor.StatusSkip:
+			fmt.Printf("%-24sSKIPPED (%s)\n", label, c.Detail)
+		case c.Status == doctor.StatusWarn:
+			fmt.Printf("%-24sWARN - %s\n", label, c.Detail)
+		default:
+			fmt.Printf("%-24sFAIL - %s\n", label, c.Detail)
+		}
+
+		if c.Status != doctor.StatusFail && c.Status != doctor.StatusWarn {
+			continue
+		}
+		if c.Hint != "" {
+			fmt.Printf("%s%s\n", indent, c.Hint)
+		}
+		if c.Fix != "" {
+			fmt.Printf("%sFixable with 'muxbee doctor --fix'\n", indent)
+		}
+	}
+
+	fmt.Println()
+
+	problems := report.Problems()
+	switch {
+	case len(problems) == 0:
+		fmt.Println("No problems found.")
+	case len(problems) == 1:
+		fmt.Println("1 problem found.")
+	default:
+		fmt.Printf("%d problems found.\n", len(problems))
+	}
+	if suggestFix && len(report.Fixes()) > 0 {
+		fmt.Println("Run 'muxbee doctor --fix' to repair the ones marked fixable.")
+	}
+}
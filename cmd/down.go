@@ -5,14 +5,18 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/daemon"
 	"github.com/tobocop2/muxbee/internal/docker"
 )
 
 var downCmd = &cobra.Command{
 	Use:   "down",
 	Short: "Stop muxbee services",
-	Long:  `Stop all running muxbee services.`,
-	RunE:  runDown,
+	Long: `Stop all running muxbee services.
+
+If 'muxbee daemon' is running, it stops them and leaves them down until
+'muxbee up'.`,
+	RunE: runDown,
 }
 
 func init() {
@@ -25,6 +29,19 @@ func runDown(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
 	}
 
+	if client, err := daemon.Dial(); err == nil {
+		if err := client.Down(); err != nil {
+			return fmt.Errorf("failed to stop services: %w", err)
+		}
+		fmt.Println("Services stopped. muxbee daemon leaves them down until 'muxbee up'.")
+		return nil
+	}
+
+	// So a daemon started later leaves the stack down
+	if err := daemon.SetStoppedOnPurpose(true); err != nil {
+		return fmt.Errorf("failed to record the stack as stopped: %w", err)
+	}
+
 	compose := docker.New(cfg)
 	profiles := docker.GetProfiles(cfg)
 
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/tobocop2/muxbee/internal/config"
 	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
 )
 
 var healthCmd = &cobra.Command{
@@ -16,56 +17,110 @@ var healthCmd = &cobra.Command{
 	Long: `Perform health checks on all muxbee services.
 
 This checks:
-  - Docker availability
+  - Docker or Podman availability
   - Container status
   - Synapse API health
-  - Element Web availability`,
+  - Element Web availability
+  - Each enabled bridge: container running, appservice listener reachable
+    from Synapse and, with --check-login, logged in (the bridge bot is sent
+    "ping" in your existing chat with it, at most every five minutes)
+
+Exit status:
+  0  healthy
+  1  degraded (some services or bridges are down, but Synapse is serving)
+  2  down (the container runtime, the config or Synapse is unavailable)`,
 	RunE: runHealth,
 }
 
+var (
+	healthOutput     string
+	healthCheckLogin bool
+)
+
+// Health states, for single checks and for the overall result
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// healthCheck is the result of one health check
+type healthCheck struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// healthReport is what 'muxbee health --output json|yaml' prints
+type healthReport struct {
+	Status  string          `json:"status" yaml:"status"`
+	Checks  []healthCheck   `json:"checks" yaml:"checks"`
+	Bridges []health.Bridge `json:"bridges,omitempty" yaml:"bridges,omitempty"`
+}
+
 func init() {
 	rootCmd.AddCommand(healthCmd)
+	addOutputFlag(healthCmd, &healthOutput)
+	healthCmd.Flags().BoolVar(&healthCheckLogin, "check-login", false, "Ping bridge bots for their login state")
 }
 
 func runHealth(cmd *cobra.Command, args []string) error {
-	fmt.Println("Mautrix Chat Health Check")
-	fmt.Println("====================")
-	fmt.Println()
+	if err := checkOutputFormat(healthOutput); err != nil {
+		return err
+	}
 
-	allHealthy := true
+	report := collectHealth(health.Options{Login: healthCheckLogin})
 
-	fmt.Print("Docker:       ")
-	if err := docker.DockerAvailable(); err != nil {
-		fmt.Println("FAIL - Docker not available")
-		allHealthy = false
+	if healthOutput != outputText {
+		if err := printStructured(healthOutput, report); err != nil {
+			return err
+		}
 	} else {
-		fmt.Println("OK")
+		printHealth(report)
 	}
 
-	fmt.Print("Config:       ")
-	if !config.Exists() {
-		fmt.Println("FAIL - No configuration found")
-		allHealthy = false
-		return nil
+	if code := report.exitCode(); code != 0 {
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return &exitCodeError{code: code}
 	}
-	fmt.Println("OK")
+	return nil
+}
 
-	cfg, err := config.Load()
-	if err != nil {
-		fmt.Printf("Config Load:  FAIL - %v\n", err)
-		return nil
+// collectHealth runs every health check
+func collectHealth(opts health.Options) *healthReport {
+	report := &healthReport{}
+
+	var cfg *config.Config
+	var cfgErr error
+	if config.Exists() {
+		cfg, cfgErr = config.Load()
+	}
+
+	rt := docker.RuntimeFor(cfg)
+	if err := rt.Available(); err != nil {
+		report.add(rt.Title(), healthDown, rt.Title()+" not available")
+	} else {
+		report.add(rt.Title(), healthOK, "")
+	}
+
+	switch {
+	case cfgErr != nil:
+		report.add("Config", healthDown, cfgErr.Error())
+		return report
+	case cfg == nil:
+		report.add("Config", healthDown, "No configuration found")
+		return report
 	}
+	report.add("Config", healthOK, "")
 
 	compose := docker.New(cfg)
 
-	fmt.Print("Services:     ")
 	statuses, err := compose.Status()
 	if err != nil {
-		fmt.Printf("FAIL - %v\n", err)
-		allHealthy = false
+		report.add("Services", healthDown, err.Error())
 	} else if len(statuses) == 0 {
-		fmt.Println("NOT RUNNING")
-		allHealthy = false
+		report.add("Services", healthDown, "not running")
 	} else {
 		runningCount := 0
 		for _, s := range statuses {
@@ -73,42 +128,109 @@ func runHealth(cmd *cobra.Command, args []string) error {
 				runningCount++
 			}
 		}
+		detail := fmt.Sprintf("%d/%d running", runningCount, len(statuses))
 		if runningCount == len(statuses) {
-			fmt.Printf("OK (%d/%d running)\n", runningCount, len(statuses))
+			report.add("Services", healthOK, detail)
 		} else {
-			fmt.Printf("DEGRADED (%d/%d running)\n", runningCount, len(statuses))
-			allHealthy = false
+			report.add("Services", healthDegraded, detail)
 		}
 	}
 
-	fmt.Print("Synapse API:  ")
 	synapseHealthURL := fmt.Sprintf("http://localhost:%d/health", cfg.SynapsePort())
-	if checkHTTP(synapseHealthURL, 5*time.Second) {
-		fmt.Println("OK")
+	synapseUp := checkHTTP(synapseHealthURL, 5*time.Second)
+	if synapseUp {
+		report.add("Synapse API", healthOK, "")
 	} else {
-		fmt.Println("FAIL")
-		allHealthy = false
+		report.add("Synapse API", healthDown, "not responding")
 	}
 
 	if cfg.IsElementEnabled() {
-		fmt.Print("Element Web:  ")
 		if checkHTTP(cfg.ElementURL(), 5*time.Second) {
-			fmt.Println("OK")
+			report.add("Element Web", healthOK, "")
 		} else {
-			fmt.Println("FAIL")
-			allHealthy = false
+			report.add("Element Web", healthDegraded, "not responding")
 		}
 	}
 
+	// Bots can't answer without Synapse
+	opts.Login = opts.Login && synapseUp
+	report.Bridges = health.CheckBridges(cfg, compose, statuses, opts)
+	for _, b := range report.Bridges {
+		report.addBridge(b)
+	}
+
+	return report
+}
+
+// addBridge records a bridge probe as a check. A broken bridge degrades
+// the install but doesn't take it down.
+func (r *healthReport) addBridge(b health.Bridge) {
+	detail := string(b.State)
+	if b.Detail != "" {
+		detail += ", " + b.Detail
+	}
+
+	status := healthDegraded
+	if b.Healthy() {
+		status = healthOK
+	}
+	r.add("Bridge "+b.Name, status, detail)
+}
+
+// add records a check and folds it into the overall status
+func (r *healthReport) add(name, status, detail string) {
+	r.Checks = append(r.Checks, healthCheck{Name: name, Status: status, Detail: detail})
+
+	switch {
+	case status == healthDown || r.Status == healthDown:
+		r.Status = healthDown
+	case status == healthDegraded || r.Status == healthDegraded:
+		r.Status = healthDegraded
+	default:
+		r.Status = healthOK
+	}
+}
+
+// exitCode maps the overall status to the exit status of 'muxbee health'
+func (r *healthReport) exitCode() int {
+	switch r.Status {
+	case healthDown:
+		return 2
+	case healthDegraded:
+		return 1
+	}
+	return 0
+}
+
+func printHealth(report *healthReport) {
+	fmt.Println("Mautrix Chat Health Check")
+	fmt.Println("====================")
 	fmt.Println()
 
-	if allHealthy {
+	for _, c := range report.Checks {
+		label := c.Name + ":"
+		switch {
+		case c.Status == healthOK && c.Detail != "":
+			fmt.Printf("%-20sOK (%s)\n", label, c.Detail)
+		case c.Status == healthOK:
+			fmt.Printf("%-20sOK\n", label)
+		case c.Status == healthDegraded:
+			fmt.Printf("%-20sDEGRADED (%s)\n", label, c.Detail)
+		default:
+			fmt.Printf("%-20sFAIL - %s\n", label, c.Detail)
+		}
+	}
+
+	fmt.Println()
+
+	switch report.Status {
+	case healthOK:
 		fmt.Println("All systems operational.")
-	} else {
+	case healthDegraded:
+		fmt.Println("Some services are degraded. Run 'muxbee status' for details.")
+	default:
 		fmt.Println("Some checks failed. Run 'muxbee status' for details.")
 	}
-
-	return nil
 }
 
 func checkHTTP(url string, timeout time.Duration) bool {
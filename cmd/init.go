@@ -30,6 +30,7 @@ var (
 	initEmail      string
 	initForce      bool
 	initNoElement  bool
+	initRuntime    string
 )
 
 func init() {
@@ -41,6 +42,7 @@ func init() {
 	initCmd.Flags().StringVar(&initEmail, "email", "", "Email for Let's Encrypt certificates")
 	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite existing configuration")
 	initCmd.Flags().BoolVar(&initNoElement, "no-element", false, "Don't run Element Web (use your own Matrix client)")
+	initCmd.Flags().StringVar(&initRuntime, "runtime", "", "Container runtime: docker or podman (default: detect)")
 }
 
 func runInit(cmd *cobra.Command, args []string) error {
@@ -48,8 +50,12 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("configuration already exists, use --force to overwrite")
 	}
 
-	if err := docker.DockerAvailable(); err != nil {
-		return fmt.Errorf("Docker is not available: %w\nPlease install Docker and ensure it is running", err)
+	if initRuntime != "" && !docker.IsRuntime(initRuntime) {
+		return fmt.Errorf("invalid runtime %q (use docker or podman)", initRuntime)
+	}
+	rt := docker.DetectRuntime(initRuntime)
+	if err := rt.Available(); err != nil {
+		return fmt.Errorf("%s is not available: %w\nPlease install %s and ensure it is running", rt.Title(), err, rt.Title())
 	}
 
 	connectivityMode := "local"
@@ -73,6 +79,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 
 	cfg.ServerName = initServerName
 	cfg.ConnectivityMode = connectivityMode
+	cfg.Runtime = initRuntime
 	cfg.HTTPS.Enabled = initHTTPS
 	cfg.HTTPS.Domain = initDomain
 	cfg.HTTPS.Email = initEmail
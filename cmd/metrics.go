@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/metrics"
+)
+
+// defaultMetricsListen is the address 'muxbee metrics serve' listens on
+const defaultMetricsListen = "127.0.0.1:9480"
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Export Prometheus metrics",
+	Long: `Export the state of this install as Prometheus metrics:
+
+  muxbee_runtime_up                       Container runtime answers
+  muxbee_service_up{service}              Container running
+  muxbee_service_healthy{service}         Healthcheck passing
+  muxbee_service_restarts_total{service}  Restarts by the restart policy
+  muxbee_service_exit_code{service}       Exit code of the last run
+  muxbee_container_cpu_percent{service}   CPU use, percent of one CPU
+  muxbee_container_memory_bytes{service}  Memory use without page cache
+  muxbee_bridge_state{bridge,state}       1 for the bridge's state
+  muxbee_bridge_logged_in{bridge}         Bridge reports an active login
+  muxbee_database_size_bytes{database}    Postgres database sizes
+  muxbee_media_store_size_bytes           Synapse media store size
+  muxbee_synapse_health_up                Synapse /health answers OK
+  muxbee_synapse_health_duration_seconds  Synapse /health latency
+  muxbee_backup_age_seconds               Age of the newest backup
+
+Services and Synapse are measured every 30 seconds; bridge logins and
+storage sizes every 5 minutes. Backup metrics need --backup-dir.
+
+For Synapse's own metrics, set this in settings.yaml and run 'muxbee up'
+again:
+
+  metrics:
+    synapse: true      # Synapse metrics on 127.0.0.1:9000
+    synapse_port: 9000`,
+}
+
+var metricsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve Prometheus metrics over HTTP",
+	Long: `Serve Prometheus metrics on /metrics until interrupted. 'muxbee daemon
+--metrics-listen' serves the same metrics from the daemon.`,
+	RunE: runMetricsServe,
+}
+
+var (
+	metricsListen    string
+	metricsBackupDir string
+)
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.AddCommand(metricsServeCmd)
+
+	metricsServeCmd.Flags().StringVar(&metricsListen, "listen", defaultMetricsListen, "Address to serve metrics on")
+	metricsServeCmd.Flags().StringVar(&metricsBackupDir, "backup-dir", "", "Report the age of the newest backup in this directory")
+}
+
+func runMetricsServe(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+	compose := docker.New(cfg)
+
+	ln, err := net.Listen("tcp", metricsListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	exporter := metrics.NewExporter(func() (*config.Config, *docker.Compose) { return cfg, compose })
+	exporter.BackupDir = metricsBackupDir
+	exporter.Logf = func(format string, args ...any) {
+		fmt.Printf("[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Serving metrics on http://%s/metrics\n", ln.Addr())
+	return exporter.Serve(ctx, ln)
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+var pinCmd = &cobra.Command{
+	Use:   "pin [service] [tag|sha256:digest]",
+	Short: "Pin a service to an exact image version",
+	Long: `Pin a service to an exact image tag or sha256 digest.
+
+Pinned versions are stored in the versions section of settings.yaml and
+written into the generated docker-compose.yml, so 'muxbee update' no longer
+moves them. Services can be named by compose service (synapse,
+mautrix-whatsapp) or by bridge name (whatsapp).
+
+Without a version, the digest of the currently running image is pinned.
+Without arguments, all pinned versions are listed.
+
+Examples:
+  muxbee pin                              # List pinned versions
+  muxbee pin synapse v1.120.0             # Pin Synapse to a tag
+  muxbee pin whatsapp sha256:4f2a...      # Pin a bridge to a digest
+  muxbee pin whatsapp                     # Pin to the running digest`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: runPin,
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <service>",
+	Short: "Remove a pinned image version",
+	Long:  `Remove a pinned version so the service follows its default image tag again.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUnpin,
+}
+
+func init() {
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
+}
+
+func runPin(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	if len(args) == 0 {
+		printPinnedVersions(cfg)
+		return nil
+	}
+
+	service, ok := docker.ResolveService(args[0])
+	if !ok {
+		return fmt.Errorf("unknown service: %s", args[0])
+	}
+
+	compose := docker.New(cfg)
+
+	var version string
+	if len(args) == 2 {
+		version = args[1]
+	} else {
+		version, err = compose.RunningImageDigest(service)
+		if err != nil {
+			return fmt.Errorf("failed to detect running version: %w", err)
+		}
+	}
+	if err := docker.ValidatePin(version); err != nil {
+		return err
+	}
+
+	cfg.PinVersion(service, version)
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	if err := compose.WriteComposeFile(); err != nil {
+		return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+	}
+
+	fmt.Printf("Pinned %s to %s\n", service, docker.ServiceImage(cfg, service))
+	fmt.Println("Run 'muxbee up' to apply.")
+	return nil
+}
+
+func runUnpin(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	service, ok := docker.ResolveService(args[0])
+	if !ok {
+		return fmt.Errorf("unknown service: %s", args[0])
+	}
+
+	if !cfg.UnpinVersion(service) {
+		fmt.Printf("Service '%s' is not pinned.\n", service)
+		return nil
+	}
+
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	compose := docker.New(cfg)
+	if err := compose.WriteComposeFile(); err != nil {
+		return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+	}
+
+	fmt.Printf("Unpinned %s, now using %s\n", service, docker.ServiceImage(cfg, service))
+	fmt.Println("Run 'muxbee up' to apply.")
+	return nil
+}
+
+func printPinnedVersions(cfg *config.Config) {
+	if len(cfg.Versions) == 0 {
+		fmt.Println("No pinned versions.")
+		return
+	}
+
+	services := make([]string, 0, len(cfg.Versions))
+	for service := range cfg.Versions {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	fmt.Println("Pinned versions:")
+	fmt.Println()
+	for _, service := range services {
+		fmt.Printf("  %-20s %s\n", service, cfg.Versions[service])
+	}
+}
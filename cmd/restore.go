@@ -1,15 +1,13 @@
 package cmd
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/backup"
+	"github.com/tobocop2/muxbee/internal/bridges"
 	"github.com/tobocop2/muxbee/internal/config"
 )
 
@@ -18,17 +16,50 @@ var restoreCmd = &cobra.Command{
 	Short: "Restore muxbee from a backup",
 	Long: `Restore muxbee data and configuration from a backup file.
 
-This will overwrite existing configuration and data.
-Services should be stopped before restoring.`,
+This replaces the existing configuration and data. The backup is extracted
+and verified into a staging directory first, so a damaged or tampered
+archive leaves the live files untouched. Running services are then stopped,
+the restored directories are swapped in, and the previous ones are kept
+next to them with a .pre-restore-<time> suffix. Configs are regenerated from the
+restored settings.yaml. If anything fails after the swap, the previous
+directories are put back.
+
+The backup format is detected automatically. Backups containing a
+PostgreSQL dump are loaded into a freshly initialized postgres container;
+older backups with a raw copy of the database directory are extracted as-is.
+
+Encrypted backups are decrypted transparently: you are prompted for the
+passphrase (or it is read from MUXBEE_BACKUP_PASSPHRASE), and backups
+encrypted to age recipients need --identity with the matching key file.
+
+Incremental backups from 'muxbee backup schedule' are restored together
+with the full backup they build on, which must be in the same directory.
+
+Parts of a backup can be restored on their own:
+  --bridge NAME    only data/bridges/NAME/; just that bridge is stopped and
+                   started again afterwards
+  --config-only    only the config directory; configs are regenerated
+  --data-only      only the data directory and the database dump`,
 	Args: cobra.ExactArgs(1),
 	RunE: runRestore,
 }
 
-var restoreForce bool
+var (
+	restoreForce      bool
+	restoreIdentity   string
+	restoreBridge     string
+	restoreConfigOnly bool
+	restoreDataOnly   bool
+)
 
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.Flags().BoolVarP(&restoreForce, "force", "f", false, "Overwrite existing data without confirmation")
+	restoreCmd.Flags().StringVarP(&restoreIdentity, "identity", "i", "", "age identity file for backups encrypted to a recipient")
+	restoreCmd.Flags().StringVar(&restoreBridge, "bridge", "", "Only restore this bridge's data")
+	restoreCmd.Flags().BoolVar(&restoreConfigOnly, "config-only", false, "Only restore the config directory")
+	restoreCmd.Flags().BoolVar(&restoreDataOnly, "data-only", false, "Only restore the data directory and database")
+	restoreCmd.MarkFlagsMutuallyExclusive("bridge", "config-only", "data-only")
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
@@ -38,89 +69,93 @@ func runRestore(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("backup file not found: %s", backupFile)
 	}
 
-	configDir := config.ConfigDir()
-	dataDir := config.DataDir()
+	if restoreBridge != "" && !bridges.Exists(restoreBridge) {
+		return fmt.Errorf("unknown bridge: %s\nRun 'muxbee bridge list' to see available bridges", restoreBridge)
+	}
 
-	configExists := dirHasContents(configDir)
-	dataExists := dirHasContents(dataDir)
+	var targets []string
+	switch {
+	case restoreBridge != "":
+		targets = []string{filepath.Join(config.DataDir(), "bridges", restoreBridge)}
+	case restoreConfigOnly:
+		targets = []string{config.ConfigDir()}
+	case restoreDataOnly:
+		targets = []string{config.DataDir()}
+	default:
+		targets = []string{config.ConfigDir(), config.DataDir()}
+	}
+
+	exists := false
+	for _, dir := range targets {
+		exists = exists || dirHasContents(dir)
+	}
 
-	if (configExists || dataExists) && !restoreForce {
+	if exists && !restoreForce {
 		fmt.Println("Warning: Existing data will be overwritten!")
-		fmt.Printf("  Config: %s\n", configDir)
-		fmt.Printf("  Data:   %s\n", dataDir)
+		for _, dir := range targets {
+			fmt.Printf("  %s\n", dir)
+		}
 		fmt.Println()
-		fmt.Println("Use --force to proceed, or run 'muxbee down' first.")
+		if restoreBridge != "" {
+			fmt.Printf("Use --force to proceed. Only %s is stopped, and the current\n", bridges.ServiceNameFor(restoreBridge))
+		} else {
+			fmt.Println("Use --force to proceed. Running services are stopped, and the current")
+		}
+		fmt.Println("files are kept with a .pre-restore-<time> suffix.")
 		return nil
 	}
 
 	fmt.Printf("Restoring from %s...\n", backupFile)
 
-	f, err := os.Open(backupFile)
+	result, err := backup.RestoreFile(backupFile, backup.RestoreOptions{
+		Keys: backupKeys(restoreIdentity),
+		Progress: func(step string) {
+			fmt.Printf("  %s...\n", step)
+		},
+		Bridge:     restoreBridge,
+		ConfigOnly: restoreConfigOnly,
+		DataOnly:   restoreDataOnly,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
+		return err
 	}
-	defer f.Close()
 
-	gr, err := gzip.NewReader(f)
-	if err != nil {
-		return fmt.Errorf("failed to read gzip: %w", err)
+	if result.Manifest == nil {
+		fmt.Println("  Legacy backup (raw data copy, no database dump)")
 	}
-	defer gr.Close()
 
-	tr := tar.NewReader(gr)
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read tar: %w", err)
-		}
-
-		var destDir string
-		var relPath string
-
-		if strings.HasPrefix(header.Name, "config/") {
-			destDir = configDir
-			relPath = strings.TrimPrefix(header.Name, "config/")
-		} else if strings.HasPrefix(header.Name, "data/") {
-			destDir = dataDir
-			relPath = strings.TrimPrefix(header.Name, "data/")
-		} else {
-			continue
+	fmt.Println("Restore complete!")
+	fmt.Println()
+	if len(result.Previous) > 0 {
+		fmt.Println("The previous files were kept in:")
+		for _, dir := range result.Previous {
+			fmt.Printf("  %s\n", dir)
 		}
+		fmt.Println("Delete them once the restored install works.")
+		fmt.Println()
+	}
+	if restoreBridge == "" {
+		fmt.Println("Run 'muxbee up' to start services.")
+	}
 
-		destPath := filepath.Join(destDir, relPath)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(destPath, os.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory: %w", err)
-			}
-
-			outFile, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return fmt.Errorf("failed to create file: %w", err)
-			}
+	return nil
+}
 
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
-				return fmt.Errorf("failed to write file: %w", err)
+// backupKeys returns the keys for opening an encrypted backup, prompting
+// for a passphrase only when one is needed, and only once
+func backupKeys(identityFile string) backup.Keys {
+	var passphrase string
+	return backup.Keys{
+		IdentityFile: identityFile,
+		Passphrase: func() (string, error) {
+			if passphrase != "" {
+				return passphrase, nil
 			}
-			outFile.Close()
-		}
+			var err error
+			passphrase, err = readPassphrase(false)
+			return passphrase, err
+		},
 	}
-
-	fmt.Println("Restore complete!")
-	fmt.Println()
-	fmt.Println("Run 'muxbee up' to start services.")
-
-	return nil
 }
 
 func dirHasContents(dir string) bool {
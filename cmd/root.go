@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
 	"github.com/tobocop2/muxbee/internal/tui"
 )
 
@@ -17,7 +20,8 @@ var rootCmd = &cobra.Command{
 	Long: `muxbee is a self-hosted Matrix server with messaging bridges.
 
 Run without arguments to launch the TUI, or use subcommands for CLI access.`,
-	Version: Version,
+	Version:           Version,
+	PersistentPreRunE: loadUserBridges,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return tui.Run()
 	},
@@ -26,6 +30,10 @@ Run without arguments to launch the TUI, or use subcommands for CLI access.`,
 // Execute runs the root command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -34,3 +42,13 @@ func Execute() {
 func init() {
 	rootCmd.SetVersionTemplate("muxbee version {{.Version}}\n")
 }
+
+// loadUserBridges merges bridges from ~/.config/muxbee/bridges.d into the
+// registry before any command runs
+func loadUserBridges(cmd *cobra.Command, args []string) error {
+	dir := config.UserBridgesDir()
+	if err := bridges.LoadUserBridges(dir); err != nil {
+		return fmt.Errorf("failed to load user bridges from %s:\n%w\nFix or remove the files listed above", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/systemd"
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Start muxbee at boot with systemd",
+	Long: `Install muxbee as a systemd service, so the stack comes back after a
+reboot the way 'muxbee up' starts it: with the admin user and bot rooms set
+up, not just the containers the runtime restarts on its own.
+
+By default muxbee installs a user unit in ~/.config/systemd/user and turns
+on lingering, so it starts at boot without anyone logging in. Use --system
+to install a system unit instead, with sudo; it runs as the user who ran
+sudo, against their muxbee install.`,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install and enable the systemd service",
+	Long: `Write the muxbee unit, enable it and start it. The unit runs 'muxbee up'
+when it starts and 'muxbee down' when it stops, with this install's
+XDG_CONFIG_HOME and XDG_DATA_HOME.
+
+With --daemon it runs 'muxbee daemon' instead, which also restarts
+services that stop and sends bridge alerts.
+
+Run it again after moving the muxbee binary or changing options.`,
+	RunE: runServiceInstall,
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the systemd service",
+	Long: `Stop and disable the muxbee unit and remove it. Stopping it runs
+'muxbee down'. Lingering is left on, since other services may need it.`,
+	RunE: runServiceUninstall,
+}
+
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the systemd service is installed and running",
+	RunE:  runServiceStatus,
+}
+
+var (
+	serviceSystem  bool
+	serviceDaemon  bool
+	serviceNoStart bool
+	serviceOutput  string
+)
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	serviceCmd.AddCommand(serviceStatusCmd)
+
+	serviceCmd.PersistentFlags().BoolVar(&serviceSystem, "system", false, "Use a system unit rather than a user unit (needs root)")
+	serviceInstallCmd.Flags().BoolVar(&serviceDaemon, "daemon", false, "Run 'muxbee daemon' rather than 'muxbee up'")
+	serviceInstallCmd.Flags().BoolVar(&serviceNoStart, "no-start", false, "Enable the service without starting it now")
+	addOutputFlag(serviceStatusCmd, &serviceOutput)
+}
+
+// serviceUnit describes the unit for this install. Changing a system unit
+// needs root.
+func serviceUnit(daemon, change bool) (systemd.Unit, error) {
+	if runtime.GOOS != "linux" {
+		return systemd.Unit{}, fmt.Errorf("systemd services are only supported on Linux")
+	}
+	if change && serviceSystem && os.Geteuid() != 0 {
+		return systemd.Unit{}, fmt.Errorf("a system unit needs root: run it with sudo")
+	}
+
+	unit, err := systemd.NewUnit(serviceSystem, daemon)
+	if err != nil {
+		return systemd.Unit{}, err
+	}
+
+	// Read the settings of the install the unit will run, which under sudo
+	// are the user's rather than root's
+	os.Setenv("XDG_CONFIG_HOME", unit.ConfigHome)
+	os.Setenv("XDG_DATA_HOME", unit.DataHome)
+	var cfg *config.Config
+	if config.Exists() {
+		cfg, _ = config.Load()
+	}
+	unit.Runtime = docker.RuntimeFor(cfg).Name
+	return unit, nil
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	unit, err := serviceUnit(serviceDaemon, true)
+	if err != nil {
+		return err
+	}
+	if !config.Exists() {
+		return fmt.Errorf("no muxbee install in %s\nRun 'muxbee init' first", config.ConfigDir())
+	}
+
+	if err := systemd.Install(unit, !serviceNoStart); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s\n", unit.Path())
+
+	if err := systemd.EnableLinger(unit); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		fmt.Println("Without lingering, muxbee only starts once you log in.")
+	}
+
+	scope := "--user "
+	if unit.System {
+		scope = ""
+	}
+	if serviceNoStart {
+		fmt.Println("muxbee will start at boot.")
+	} else {
+		fmt.Println("muxbee is running and will start at boot.")
+	}
+	fmt.Printf("Follow its logs with: journalctl %s-u %s -f\n", scope, systemd.UnitName)
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	unit, err := serviceUnit(false, true)
+	if err != nil {
+		return err
+	}
+
+	if err := systemd.Uninstall(unit); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", unit.Path())
+	return nil
+}
+
+func runServiceStatus(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(serviceOutput); err != nil {
+		return err
+	}
+	unit, err := serviceUnit(false, false)
+	if err != nil {
+		return err
+	}
+
+	status := systemd.GetStatus(unit)
+	if serviceOutput != outputText {
+		return printStructured(serviceOutput, status)
+	}
+
+	if !status.Installed {
+		fmt.Println("Not installed. Run 'muxbee service install' to start muxbee at boot.")
+		return nil
+	}
+	fmt.Printf("Unit:    %s\n", status.Path)
+	fmt.Printf("Enabled: %s\n", status.Enabled)
+	fmt.Printf("Active:  %s\n", status.Active)
+	if status.Linger != nil {
+		if *status.Linger {
+			fmt.Printf("Linger:  yes\n")
+		} else {
+			fmt.Printf("Linger:  no (muxbee only starts once %s logs in)\n", unit.User)
+		}
+	}
+	return nil
+}
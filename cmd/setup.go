@@ -13,7 +13,8 @@ var setupBotsCmd = &cobra.Command{
 	Use:   "setup-bots",
 	Short: "Create DM rooms with all enabled bridge bots",
 	Long: `Creates direct message rooms with all your enabled bridge bots
-and sends welcome messages with login instructions.
+and sends welcome messages with login instructions. Users added with
+'muxbee user add' get their own rooms too.
 
 Run this after enabling bridges to get started quickly without
 having to manually find and message each bot.`,
@@ -42,8 +43,13 @@ func runSetupBots(cmd *cobra.Command, args []string) error {
 	// Give a moment for any recently started services
 	time.Sleep(2 * time.Second)
 
-	if err := matrix.SetupBotsForUser(cfg); err != nil {
-		return fmt.Errorf("failed to setup bots: %w", err)
+	for _, username := range cfg.Usernames() {
+		if len(cfg.Users) > 0 {
+			fmt.Printf("%s:\n", username)
+		}
+		if err := matrix.SetupBotsForUser(cfg, username); err != nil {
+			return fmt.Errorf("failed to setup bots for %s: %w", username, err)
+		}
 	}
 
 	fmt.Println()
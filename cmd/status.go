@@ -5,32 +5,84 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/daemon"
 	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show status of muxbee services",
-	Long:  `Display the current status of all muxbee services.`,
-	RunE:  runStatus,
+	Long: `Display the current status of all muxbee services.
+
+Enabled bridges are listed with how far they work: stopped, running (the
+appservice listener doesn't answer), reachable, logged in or logged out.
+With --check-login, the login state is read by sending "ping" to each
+bridge bot in your existing chat with it. Bots are pinged at most every
+five minutes; more recent answers are reused.`,
+	RunE: runStatus,
+}
+
+var (
+	statusOutput     string
+	statusCheckLogin bool
+)
+
+// statusReport is what 'muxbee status --output json|yaml' prints
+type statusReport struct {
+	Services   []docker.ServiceStatus `json:"services" yaml:"services"`
+	Bridges    []health.Bridge        `json:"bridges" yaml:"bridges"`
+	Pinned     map[string]string      `json:"pinned,omitempty" yaml:"pinned,omitempty"`
+	MatrixURL  string                 `json:"matrix_url" yaml:"matrix_url"`
+	ElementURL string                 `json:"element_url,omitempty" yaml:"element_url,omitempty"`
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	addOutputFlag(statusCmd, &statusOutput)
+	statusCmd.Flags().BoolVar(&statusCheckLogin, "check-login", false, "Ping bridge bots for their login state")
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(statusOutput); err != nil {
+		return err
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
 	}
 
 	compose := docker.New(cfg)
-	statuses, err := compose.Status()
+	statuses, err := serviceStatuses(compose)
 	if err != nil {
 		return fmt.Errorf("failed to get status: %w", err)
 	}
 
+	var bridgeHealth []health.Bridge
+	if len(statuses) > 0 {
+		bridgeHealth = health.CheckBridges(cfg, compose, statuses, health.Options{Login: statusCheckLogin})
+	}
+
+	if statusOutput != outputText {
+		report := statusReport{
+			Services:  statuses,
+			Bridges:   bridgeHealth,
+			Pinned:    cfg.Versions,
+			MatrixURL: cfg.PublicBaseURL(),
+		}
+		if report.Services == nil {
+			report.Services = []docker.ServiceStatus{}
+		}
+		if report.Bridges == nil {
+			report.Bridges = []health.Bridge{}
+		}
+		if cfg.IsElementEnabled() {
+			report.ElementURL = cfg.ElementURL()
+		}
+		return printStructured(statusOutput, report)
+	}
+
 	if len(statuses) == 0 {
 		fmt.Println("No services running.")
 		fmt.Println("Run 'muxbee up' to start services.")
@@ -54,11 +106,28 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		if s.Version != "" {
 			version = fmt.Sprintf(" %s", s.Version)
 		}
-		fmt.Printf("  %-20s%-10s %s\n", serviceName, version, status)
+		pinned := ""
+		if pin := cfg.PinnedVersion(serviceName); pin != "" {
+			pinned = fmt.Sprintf("  [pinned %s]", docker.ShortVersion(pin))
+		}
+		fmt.Printf("  %-20s%-10s %s%s\n", serviceName, version, status, pinned)
 	}
 
 	fmt.Println()
 
+	if len(bridgeHealth) > 0 {
+		fmt.Println("Bridges:")
+		fmt.Println()
+		for _, b := range bridgeHealth {
+			detail := ""
+			if b.Detail != "" {
+				detail = fmt.Sprintf("  (%s)", b.Detail)
+			}
+			fmt.Printf("  %-20s%s%s\n", b.Name, b.State, detail)
+		}
+		fmt.Println()
+	}
+
 	if compose.IsRunning() {
 		if cfg.IsElementEnabled() {
 			fmt.Printf("Element Web: %s\n", cfg.ElementURL())
@@ -68,3 +137,16 @@ func runStatus(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// serviceStatuses asks the daemon for the service list if it runs, and the
+// container runtime otherwise
+func serviceStatuses(compose *docker.Compose) ([]docker.ServiceStatus, error) {
+	if client, err := daemon.Dial(); err == nil {
+		status, err := client.Status()
+		if err != nil {
+			return nil, err
+		}
+		return status.Services, nil
+	}
+	return compose.Status()
+}
@@ -1,15 +1,16 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/daemon"
 	"github.com/tobocop2/muxbee/internal/docker"
 	"github.com/tobocop2/muxbee/internal/generator"
 	"github.com/tobocop2/muxbee/internal/matrix"
@@ -54,8 +55,19 @@ func runUp(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if err := compose.Up(profiles); err != nil {
-		return fmt.Errorf("failed to start services: %w", err)
+	if client, err := daemon.Dial(); err == nil {
+		fmt.Println("Starting services through muxbee daemon...")
+		if err := client.Up(); err != nil {
+			return fmt.Errorf("failed to start services: %w", err)
+		}
+	} else {
+		// So a daemon started later keeps the stack up
+		if err := daemon.SetStoppedOnPurpose(false); err != nil {
+			return fmt.Errorf("failed to clear the stopped marker: %w", err)
+		}
+		if err := compose.Up(profiles); err != nil {
+			return fmt.Errorf("failed to start services: %w", err)
+		}
 	}
 	fmt.Println()
 
@@ -68,7 +80,7 @@ func runUp(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Println()
 
-	if err := setupAdminUser(cfg); err != nil {
+	if err := setupAdminUser(cfg, compose); err != nil {
 		fmt.Printf("Note: Could not create admin user: %v\n", err)
 	}
 
@@ -77,8 +89,13 @@ func runUp(cmd *cobra.Command, args []string) error {
 		compose.WaitForBridges(cfg.EnabledBridges, 30)
 
 		fmt.Println("Setting up bridge bot conversations...")
-		if err := matrix.SetupBotsForUser(cfg); err != nil {
-			fmt.Printf("Note: Bot setup incomplete: %v\n", err)
+		for _, username := range cfg.Usernames() {
+			if len(cfg.Users) > 0 {
+				fmt.Printf("  %s:\n", username)
+			}
+			if err := matrix.SetupBotsForUser(cfg, username); err != nil {
+				fmt.Printf("Note: Bot setup incomplete for %s: %v\n", username, err)
+			}
 		}
 		fmt.Println()
 	}
@@ -89,7 +106,7 @@ func runUp(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func setupAdminUser(cfg *config.Config) error {
+func setupAdminUser(cfg *config.Config, compose *docker.Compose) error {
 	markerFile := filepath.Join(config.DataDir(), ".admin_setup_done")
 	if fileExists(markerFile) {
 		return nil
@@ -98,7 +115,9 @@ func setupAdminUser(cfg *config.Config) error {
 	fmt.Println("Setting up admin user...")
 	time.Sleep(3 * time.Second) // Wait for Synapse to be ready
 
-	cmd := exec.Command("docker", "exec", "muxbee-synapse-1",
+	// register_new_matrix_user reports some errors on stdout
+	var out bytes.Buffer
+	err := compose.Exec("synapse", nil, &out,
 		"register_new_matrix_user",
 		"-u", cfg.Admin.Username,
 		"-p", cfg.Admin.Password,
@@ -106,11 +125,10 @@ func setupAdminUser(cfg *config.Config) error {
 		"-c", "/data/homeserver.yaml",
 		"http://localhost:8008",
 	)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		outStr := string(output)
-		if !strings.Contains(outStr, "already taken") && !strings.Contains(outStr, "already exists") {
-			return fmt.Errorf("failed to create admin user: %s", outStr)
+		msg := out.String() + err.Error()
+		if !strings.Contains(msg, "already taken") && !strings.Contains(msg, "already exists") {
+			return fmt.Errorf("failed to create admin user: %w", err)
 		}
 	}
 
@@ -13,7 +13,12 @@ var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update all services to latest versions",
 	Long: `Pull the latest Docker images for all services and restart them.
-This is useful when new bridge versions are released.`,
+This is useful when new bridge versions are released.
+
+After restarting, every service is checked along with Synapse's /health
+endpoint. Services that fail to come back on the new image are rolled
+back to the image they were running before, and a per-service report
+shows what was upgraded, kept, or rolled back.`,
 	RunE: runUpdate,
 }
 
@@ -31,41 +36,62 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	profiles := docker.GetProfiles(cfg)
 
 	// Show what will be updated
-	services := []string{"synapse", "postgres"}
-	if cfg.IsElementEnabled() {
-		services = append(services, "element")
-	}
-	if cfg.HTTPS.Enabled {
-		services = append(services, "caddy")
-	}
-	for _, bridge := range cfg.EnabledBridges {
-		services = append(services, "mautrix-"+bridge)
-	}
-
+	services := docker.ConfiguredServices(cfg)
 	fmt.Println("Updating services:", strings.Join(services, ", "))
 	fmt.Println()
 
-	// Pull images
-	fmt.Println("==> Pulling latest images...")
-	if err := compose.Pull(profiles); err != nil {
-		return fmt.Errorf("failed to pull images: %w", err)
+	report, err := compose.Update(profiles, docker.UpdateOptions{
+		Progress: func(step string) {
+			fmt.Printf("==> %s...\n", step)
+		},
+	})
+	if err != nil {
+		return err
 	}
 	fmt.Println()
 
-	// Stop services
-	fmt.Println("==> Stopping services...")
-	if err := compose.Down(profiles); err != nil {
-		return fmt.Errorf("failed to stop services: %w", err)
+	printUpdateReport(report)
+
+	if n := report.Count(docker.UpdateFailed); n > 0 {
+		return fmt.Errorf("%d service(s) failed to come back after update\nCheck logs with: muxbee logs <service>", n)
 	}
-	fmt.Println()
+	fmt.Println("Update complete!")
+	return nil
+}
 
-	// Start with force recreate to ensure new images are used
-	fmt.Println("==> Starting services with updated images...")
-	if err := compose.UpForceRecreate(profiles); err != nil {
-		return fmt.Errorf("failed to start services: %w", err)
+// printUpdateReport prints what happened to each service
+func printUpdateReport(report *docker.UpdateReport) {
+	fmt.Println("Update report:")
+	fmt.Println()
+	for _, svc := range report.Services {
+		line := fmt.Sprintf("  %-24s %-12s", svc.Service, svc.Outcome)
+		switch svc.Outcome {
+		case docker.UpdateUpgraded:
+			line += fmt.Sprintf(" %s -> %s", shortImageID(svc.PreviousImage), shortImageID(svc.CurrentImage))
+		case docker.UpdateRolledBack, docker.UpdateFailed:
+			line += " " + svc.Detail
+		}
+		fmt.Println(strings.TrimRight(line, " "))
 	}
+	fmt.Println("  " + report.Summary())
 	fmt.Println()
 
-	fmt.Println("Update complete!")
-	return nil
+	if report.Count(docker.UpdateRolledBack) > 0 {
+		fmt.Println("Rolled-back services are running their previous image. The next")
+		fmt.Println("'muxbee update' will try the new image again unless you pin them:")
+		fmt.Println("  muxbee pin <service>")
+		fmt.Println()
+	}
+}
+
+// shortImageID trims an image ID to 12 hex characters like 'docker images'
+func shortImageID(id string) string {
+	if id == "" {
+		return "none"
+	}
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
@@ -0,0 +1,456 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/generator"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage the people using muxbee",
+	Long: `Manage the people sharing this muxbee besides the admin. Each user
+gets their own chats with the bridge bots and links their own accounts.`,
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a user",
+	Long: `Create an account and start chats between it and every enabled bridge
+bot. Without --password, a random password is generated and printed.
+
+If the account already exists, such as after someone signed up with an
+invite, it is added to muxbee as it is.`,
+	Example: `  muxbee user add alice
+  muxbee user add bob --display-name "Bob" --bridge-admin`,
+	Args: userNameArg,
+	RunE: runUserAdd,
+}
+
+var userRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a user",
+	Long: `Remove a user from muxbee and deactivate their account, which can't be
+undone. With --keep-account, the account is left alone.`,
+	Args: userNameArg,
+	RunE: runUserRemove,
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users",
+	RunE:  runUserList,
+}
+
+var userPasswdCmd = &cobra.Command{
+	Use:   "passwd <name>",
+	Short: "Set a user's password",
+	Long: `Set a user's password and log out their devices. Without --password,
+a random password is generated and printed.
+
+For the admin, the new password is saved to settings.yaml first, and the
+old one put back if Synapse doesn't take it.`,
+	Args: userNameArg,
+	RunE: runUserPasswd,
+}
+
+var userInviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Create an invite to sign up",
+	Long: `Create a registration token someone can sign up with in Element. Turn
+on sign-ups first with 'muxbee user signup on'.
+
+Run 'muxbee user add <name>' once they have signed up to start their
+bridge bot chats.`,
+	Example: `  muxbee user invite
+  muxbee user invite --uses 3 --expires 72h`,
+	RunE: runUserInvite,
+}
+
+var userSignupCmd = &cobra.Command{
+	Use:       "signup <on|off>",
+	Short:     "Let people sign up with an invite",
+	Long:      `Turn on or off signing up in Element with an invite from 'muxbee user invite'.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"on", "off"},
+	RunE:      runUserSignup,
+}
+
+var (
+	userOutput        string
+	userPassword      string
+	userDisplayName   string
+	userBridgeAdmin   bool
+	userKeepAccount   bool
+	userKeepDevices   bool
+	userYes           bool
+	userInviteUses    int
+	userInviteExpires time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userAddCmd)
+	userCmd.AddCommand(userRemoveCmd)
+	userCmd.AddCommand(userListCmd)
+	userCmd.AddCommand(userPasswdCmd)
+	userCmd.AddCommand(userInviteCmd)
+	userCmd.AddCommand(userSignupCmd)
+
+	for _, c := range []*cobra.Command{userAddCmd, userPasswdCmd} {
+		c.Flags().StringVar(&userPassword, "password", "", "Password (generated if not given)")
+	}
+	userAddCmd.Flags().StringVar(&userDisplayName, "display-name", "", "Display name")
+	userAddCmd.Flags().BoolVar(&userBridgeAdmin, "bridge-admin", false, "Let the user manage the bridges, like the admin")
+	userRemoveCmd.Flags().BoolVar(&userKeepAccount, "keep-account", false, "Don't deactivate the account")
+	userRemoveCmd.Flags().BoolVarP(&userYes, "yes", "y", false, "Skip confirmation")
+	addOutputFlag(userListCmd, &userOutput)
+	userPasswdCmd.Flags().BoolVar(&userKeepDevices, "keep-devices", false, "Don't log out the user's devices")
+	userInviteCmd.Flags().IntVar(&userInviteUses, "uses", 1, "How many people can sign up with it (0 for unlimited)")
+	userInviteCmd.Flags().DurationVar(&userInviteExpires, "expires", 7*24*time.Hour, "How long until it expires (0 for never)")
+}
+
+// localpartPattern is the character set Synapse accepts for new user IDs
+var localpartPattern = regexp.MustCompile(`^[a-z0-9._=/+-]+$`)
+
+// userNameArg rejects a <name> that can't be a Matrix user before anything
+// is loaded or logged in to
+func userNameArg(cmd *cobra.Command, args []string) error {
+	if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+		return err
+	}
+	_, _, err := splitUserName(args[0])
+	return err
+}
+
+// userName checks a name given to 'muxbee user' and returns its localpart
+func userName(cfg *config.Config, name string) (string, error) {
+	localpart, server, err := splitUserName(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(name, "@") && server != cfg.ServerName {
+		return "", fmt.Errorf("%s is not a user of %s", name, cfg.ServerName)
+	}
+	return localpart, nil
+}
+
+// splitUserName splits a name or Matrix user ID into its localpart and
+// server, checking the localpart
+func splitUserName(name string) (localpart, server string, err error) {
+	localpart = name
+	if strings.HasPrefix(name, "@") {
+		localpart, server, _ = strings.Cut(strings.TrimPrefix(name, "@"), ":")
+	}
+	if !localpartPattern.MatchString(localpart) {
+		return "", "", fmt.Errorf("invalid user name %q: use lowercase letters, digits and ._=-/+", localpart)
+	}
+	return localpart, server, nil
+}
+
+func runUserAdd(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	username, err := userName(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	if username == cfg.Admin.Username {
+		return fmt.Errorf("%s is muxbee's admin user", username)
+	}
+
+	password, generated, err := newPassword(userPassword)
+	if err != nil {
+		return err
+	}
+	userID := cfg.UserID(username)
+	err = client.CreateUser(userID, matrix.NewUser{Password: password, DisplayName: userDisplayName})
+	existed := errors.Is(err, matrix.ErrUserExists)
+	if err != nil && !existed {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	cfg.AddUser(config.UserConfig{Username: username, BridgeAdmin: userBridgeAdmin})
+	if err := applyUserChanges(cfg, false); err != nil {
+		return err
+	}
+
+	if existed {
+		fmt.Printf("Added existing user %s\n", userID)
+	} else {
+		fmt.Printf("Created %s\n", userID)
+		if generated {
+			fmt.Printf("  Password: %s\n", password)
+		}
+	}
+
+	if len(cfg.EnabledBridges) > 0 {
+		fmt.Println()
+		fmt.Println("Setting up bridge bot conversations...")
+		if err := matrix.SetupBotsForUser(cfg, username); err != nil {
+			fmt.Printf("Note: Bot setup incomplete: %v\n", err)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("They can sign in at %s\n", cfg.ElementURL())
+	return nil
+}
+
+func runUserRemove(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+	username, err := userName(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	if username == cfg.Admin.Username {
+		return fmt.Errorf("%s is muxbee's admin user and can't be removed", username)
+	}
+	userID := cfg.UserID(username)
+
+	if !userKeepAccount {
+		if !confirm(fmt.Sprintf("Deactivate %s? This can't be undone.", userID), userYes) {
+			return nil
+		}
+		_, client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		if err := client.DeactivateUser(userID, false); err != nil && !errors.Is(err, matrix.ErrNotFound) {
+			return fmt.Errorf("failed to deactivate user: %w", err)
+		}
+	}
+
+	if cfg.RemoveUser(username) {
+		if err := applyUserChanges(cfg, false); err != nil {
+			return err
+		}
+	}
+
+	if userKeepAccount {
+		fmt.Printf("Removed %s from muxbee; the account is kept.\n", userID)
+	} else {
+		fmt.Printf("Removed and deactivated %s\n", userID)
+	}
+	return nil
+}
+
+// userListEntry is one row of 'muxbee user list'
+type userListEntry struct {
+	User        string `json:"user" yaml:"user"`
+	Role        string `json:"role" yaml:"role"`
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	Status      string `json:"status" yaml:"status"`
+}
+
+func runUserList(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(userOutput); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	// The homeserver fills in names and status if it's up
+	client, clientErr := matrix.AdminClient(cfg)
+
+	var entries []userListEntry
+	for _, username := range cfg.Usernames() {
+		entry := userListEntry{User: cfg.UserID(username), Role: "user", Status: "unknown"}
+		if username == cfg.Admin.Username {
+			entry.Role = "admin"
+		} else if cfg.GetUser(username).BridgeAdmin {
+			entry.Role = "bridge admin"
+		}
+
+		if clientErr == nil {
+			user, err := client.User(entry.User)
+			switch {
+			case errors.Is(err, matrix.ErrNotFound):
+				entry.Status = "missing"
+			case err != nil:
+			case user.Deactivated:
+				entry.Status = "deactivated"
+			default:
+				entry.Status = "active"
+				entry.DisplayName = user.DisplayName
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if userOutput != outputText {
+		return printStructured(userOutput, entries)
+	}
+
+	fmt.Printf("%-32s %-13s %-12s %s\n", "USER", "ROLE", "STATUS", "DISPLAY NAME")
+	for _, e := range entries {
+		fmt.Printf("%-32s %-13s %-12s %s\n", e.User, e.Role, e.Status, valueOr(e.DisplayName, "-"))
+	}
+	if clientErr != nil {
+		fmt.Println()
+		fmt.Println("Start muxbee with 'muxbee up' to see each user's status.")
+	}
+	return nil
+}
+
+func runUserPasswd(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	username, err := userName(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	isAdmin := username == cfg.Admin.Username
+	if !isAdmin && cfg.GetUser(username) == nil {
+		return fmt.Errorf("%s is not a muxbee user\nUse 'muxbee admin users reset-password' for other accounts", username)
+	}
+
+	password, generated, err := newPassword(userPassword)
+	if err != nil {
+		return err
+	}
+	userID := cfg.UserID(username)
+
+	// muxbee logs in with the admin password when its session expires,
+	// which resetting it can cause, so the new one is saved first
+	previous := cfg.Admin.Password
+	if isAdmin {
+		cfg.Admin.Password = password
+		if err := cfg.Save(); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+	}
+	if err := client.ResetPassword(userID, password, userKeepDevices); err != nil {
+		if isAdmin {
+			cfg.Admin.Password = previous
+			if saveErr := cfg.Save(); saveErr != nil {
+				return fmt.Errorf("failed to reset password: %w\nPutting the old password back in settings.yaml also failed: %v", err, saveErr)
+			}
+		}
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	fmt.Printf("Reset the password of %s\n", userID)
+	if generated {
+		fmt.Printf("  Password: %s\n", password)
+	}
+	return nil
+}
+
+func runUserInvite(cmd *cobra.Command, args []string) error {
+	cfg, client, err := adminClient()
+	if err != nil {
+		return err
+	}
+	if !cfg.Registration.Enabled {
+		return fmt.Errorf("sign-ups are off\nTurn them on with 'muxbee user signup on'")
+	}
+
+	opts := matrix.NewRegistrationToken{UsesAllowed: userInviteUses}
+	if userInviteExpires > 0 {
+		opts.Expires = time.Now().Add(userInviteExpires)
+	}
+	token, err := client.CreateRegistrationToken(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	fmt.Printf("Sign up at:  %s/#/register\n", cfg.ElementURL())
+	fmt.Printf("Homeserver:  %s\n", cfg.PublicBaseURL())
+	fmt.Printf("Token:       %s\n", token.Token)
+	if !token.Expires().IsZero() {
+		fmt.Printf("Expires:     %s\n", token.Expires().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func runUserSignup(cmd *cobra.Command, args []string) error {
+	var enabled bool
+	switch args[0] {
+	case "on":
+		enabled = true
+	case "off":
+	default:
+		return fmt.Errorf("expected on or off, got %s", args[0])
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+	if cfg.Registration.Enabled == enabled {
+		fmt.Printf("Sign-ups are already %s.\n", args[0])
+		return nil
+	}
+
+	cfg.Registration.Enabled = enabled
+	if err := applyUserChanges(cfg, true); err != nil {
+		return err
+	}
+
+	if enabled {
+		fmt.Println("Sign-ups are on. People need an invite from 'muxbee user invite'.")
+	} else {
+		fmt.Println("Sign-ups are off.")
+	}
+	return nil
+}
+
+// applyUserChanges saves the config and regenerates the files that list
+// users. If muxbee is running, it restarts the bridges to load their new
+// permissions, and Synapse too if restartSynapse is set.
+func applyUserChanges(cfg *config.Config, restartSynapse bool) error {
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	if err := generator.New().GenerateAll(cfg); err != nil {
+		return fmt.Errorf("failed to generate configs: %w", err)
+	}
+
+	compose := docker.New(cfg)
+	if !compose.IsServiceRunning("synapse") {
+		return nil
+	}
+
+	if restartSynapse {
+		fmt.Println("Restarting Synapse...")
+		if err := compose.RestartQuiet("synapse"); err != nil {
+			return fmt.Errorf("failed to restart Synapse: %w", err)
+		}
+		time.Sleep(3 * time.Second) // Wait for Synapse to be ready
+	}
+
+	if len(cfg.EnabledBridges) == 0 {
+		return nil
+	}
+	fmt.Println("Restarting bridges...")
+	for _, name := range cfg.EnabledBridges {
+		bridge := bridges.Get(name)
+		if bridge == nil {
+			continue
+		}
+		if err := compose.RestartQuiet(bridge.ServiceName()); err != nil {
+			return fmt.Errorf("failed to restart %s bridge: %w", name, err)
+		}
+	}
+	compose.WaitForBridges(cfg.EnabledBridges, 30)
+	return nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tobocop2/muxbee/internal/alerts"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch bridges and send alerts when they break",
+	Long: `Watch the enabled bridges and send an alert when one of them:
+
+  - crash loops: its container restarts 3 times within 10 minutes
+  - is logged out: its bot replies to "ping" without an active login
+
+and again when it recovers. Bridge bots are pinged every 5 minutes.
+
+Alerts are posted by the admin user into a "muxbee alerts" room, created
+on the first alert, and sent to a webhook if one is set in settings.yaml:
+
+  alerts:
+    webhook: https://hooks.example.com/muxbee  # POSTed the alert as JSON
+    matrix_enabled: false                      # Webhook only
+
+The room belongs to the admin, so Element shows alerts there without a
+notification; set the room to notify for every message to be pinged.
+
+Use --test to send a test alert and exit.`,
+	RunE: runWatch,
+}
+
+var watchTest bool
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().BoolVar(&watchTest, "test", false, "Send a test alert and exit")
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+
+	notifiers := alerts.Notifiers(cfg)
+	if len(notifiers) == 0 {
+		return fmt.Errorf("alerts are turned off: set alerts.webhook or remove alerts.matrix_enabled in settings.yaml")
+	}
+
+	if watchTest {
+		err := alerts.Send(notifiers, alerts.Alert{
+			Kind:    alerts.KindTest,
+			Message: "Test alert from muxbee watch.",
+			Time:    time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Test alert sent.")
+		return nil
+	}
+
+	watcher := alerts.NewWatcher(cfg, docker.New(cfg), notifiers)
+	watcher.Logf = func(format string, args ...any) {
+		fmt.Printf("[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Watching %d bridge(s). Press Ctrl+C to stop.\n", len(cfg.EnabledBridges))
+	return watcher.Run(ctx)
+}
@@ -0,0 +1,178 @@
+// Package alerts notices bridges that crash loop or lose their login and
+// tells the admin, in a Matrix room and optionally through a webhook.
+package alerts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+)
+
+// A bridge restarted CrashLoopRestarts times within CrashLoopWindow is
+// crash looping. It has recovered once a window passes without a restart.
+const (
+	CrashLoopRestarts = 3
+	CrashLoopWindow   = 10 * time.Minute
+)
+
+// Kind is what an alert is about
+type Kind string
+
+const (
+	KindCrashLoop Kind = "crash_loop"
+	KindLoggedOut Kind = "logged_out"
+	KindRecovered Kind = "recovered"
+	KindTest      Kind = "test"
+)
+
+// Alert is a change in a bridge's health worth telling the admin about
+type Alert struct {
+	Kind         Kind      `json:"kind"`
+	Bridge       string    `json:"bridge,omitempty"`
+	Service      string    `json:"service,omitempty"`
+	Message      string    `json:"message"`
+	RestartCount int       `json:"restart_count,omitempty"`
+	ExitCode     int       `json:"exit_code,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Text is the alert as a chat message
+func (a Alert) Text() string {
+	if a.Kind == KindRecovered {
+		return "✅ " + a.Message
+	}
+	return "⚠️ " + a.Message
+}
+
+// serviceState is what the detector remembers about a bridge container
+type serviceState struct {
+	restartCount int
+	restarts     []time.Time // Within the last CrashLoopWindow
+	looping      bool
+}
+
+// Detector turns container statuses and bridge probes into alerts. It
+// remembers what it has reported, so each problem is reported once, and
+// again when it clears.
+type Detector struct {
+	bridges   map[string]string // Compose service -> bridge name
+	services  map[string]*serviceState
+	loggedOut map[string]bool
+	now       func() time.Time
+}
+
+// NewDetector creates a detector for the bridges enabled in cfg
+func NewDetector(cfg *config.Config) *Detector {
+	d := &Detector{
+		bridges:   make(map[string]string),
+		services:  make(map[string]*serviceState),
+		loggedOut: make(map[string]bool),
+		now:       time.Now,
+	}
+	for _, name := range cfg.EnabledBridges {
+		d.bridges[bridges.ServiceNameFor(name)] = name
+	}
+	return d
+}
+
+// IsBridge reports whether a compose service runs an enabled bridge
+func (d *Detector) IsBridge(service string) bool {
+	_, ok := d.bridges[service]
+	return ok
+}
+
+// ObserveService records a container's status, from DetailedStatus or
+// InspectService. It returns an alert when a bridge starts or stops crash
+// looping, and nil otherwise.
+func (d *Detector) ObserveService(s docker.ServiceStatus) *Alert {
+	bridge, ok := d.bridges[s.Service]
+	if !ok {
+		return nil
+	}
+	now := d.now()
+
+	st, seen := d.services[s.Name]
+	if !seen {
+		// Restarts from before muxbee was watching have no time to go by
+		d.services[s.Name] = &serviceState{restartCount: s.RestartCount}
+		return nil
+	}
+
+	if s.RestartCount < st.restartCount {
+		// The container was recreated, which starts its count over
+		st.restartCount = 0
+	}
+	for ; st.restartCount < s.RestartCount; st.restartCount++ {
+		st.restarts = append(st.restarts, now)
+	}
+	for len(st.restarts) > 0 && now.Sub(st.restarts[0]) >= CrashLoopWindow {
+		st.restarts = st.restarts[1:]
+	}
+
+	switch {
+	case !st.looping && len(st.restarts) >= CrashLoopRestarts:
+		st.looping = true
+		msg := fmt.Sprintf("%s bridge is crash looping: restarted %d times in the last %s", bridge, len(st.restarts), formatWindow(CrashLoopWindow))
+		if s.ExitCode != 0 {
+			msg += fmt.Sprintf(", last exit code %d", s.ExitCode)
+		}
+		return &Alert{
+			Kind:         KindCrashLoop,
+			Bridge:       bridge,
+			Service:      s.Service,
+			Message:      msg + ". Check 'muxbee logs " + s.Service + "'.",
+			RestartCount: s.RestartCount,
+			ExitCode:     s.ExitCode,
+			Time:         now,
+		}
+	case st.looping && len(st.restarts) == 0 && s.Running:
+		st.looping = false
+		return &Alert{
+			Kind:         KindRecovered,
+			Bridge:       bridge,
+			Service:      s.Service,
+			Message:      fmt.Sprintf("%s bridge has stopped crash looping: no restarts in the last %s.", bridge, formatWindow(CrashLoopWindow)),
+			RestartCount: s.RestartCount,
+			Time:         now,
+		}
+	}
+	return nil
+}
+
+// ObserveBridge records a bridge probe. It returns an alert when the
+// bridge reports a lost login, or logs in again after one.
+func (d *Detector) ObserveBridge(b health.Bridge) *Alert {
+	switch {
+	case b.State == health.StateLoggedOut && !d.loggedOut[b.Name]:
+		d.loggedOut[b.Name] = true
+		return &Alert{
+			Kind:    KindLoggedOut,
+			Bridge:  b.Name,
+			Service: b.Service,
+			Message: fmt.Sprintf("%s bridge is logged out. Message the bridge bot to log in again.", b.Name),
+			Time:    d.now(),
+		}
+	case b.State == health.StateLoggedIn && d.loggedOut[b.Name]:
+		delete(d.loggedOut, b.Name)
+		return &Alert{
+			Kind:    KindRecovered,
+			Bridge:  b.Name,
+			Service: b.Service,
+			Message: fmt.Sprintf("%s bridge is logged in again.", b.Name),
+			Time:    d.now(),
+		}
+	}
+	return nil
+}
+
+// formatWindow formats a duration of whole minutes, e.g. "10 minutes"
+func formatWindow(d time.Duration) string {
+	if m := int(d.Minutes()); m != 1 {
+		return fmt.Sprintf("%d minutes", m)
+	}
+	return "minute"
+}
@@ -0,0 +1,121 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+)
+
+// clock is a settable time for the detector
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestDetector() (*Detector, *clock) {
+	c := &clock{t: time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)}
+	d := NewDetector(&config.Config{EnabledBridges: []string{"whatsapp"}})
+	d.now = c.now
+	return d, c
+}
+
+func whatsapp(restarts, exitCode int) docker.ServiceStatus {
+	return docker.ServiceStatus{
+		Name:         "muxbee-mautrix-whatsapp-1",
+		Service:      "mautrix-whatsapp",
+		Running:      true,
+		RestartCount: restarts,
+		ExitCode:     exitCode,
+	}
+}
+
+func TestDetector_CrashLoop(t *testing.T) {
+	d, c := newTestDetector()
+
+	// Restarts from before the first observation don't count
+	assert.Nil(t, d.ObserveService(whatsapp(5, 0)))
+
+	c.t = c.t.Add(time.Minute)
+	assert.Nil(t, d.ObserveService(whatsapp(7, 1)))
+
+	c.t = c.t.Add(time.Minute)
+	a := d.ObserveService(whatsapp(8, 1))
+	require.NotNil(t, a)
+	assert.Equal(t, KindCrashLoop, a.Kind)
+	assert.Equal(t, "whatsapp", a.Bridge)
+	assert.Equal(t, 1, a.ExitCode)
+	assert.Contains(t, a.Message, "restarted 3 times in the last 10 minutes, last exit code 1")
+
+	// Reported once
+	c.t = c.t.Add(time.Minute)
+	assert.Nil(t, d.ObserveService(whatsapp(9, 1)))
+
+	// Recovered once a whole window passes without a restart
+	c.t = c.t.Add(9 * time.Minute)
+	assert.Nil(t, d.ObserveService(whatsapp(9, 1)))
+	c.t = c.t.Add(time.Minute)
+	a = d.ObserveService(whatsapp(9, 1))
+	require.NotNil(t, a)
+	assert.Equal(t, KindRecovered, a.Kind)
+}
+
+func TestDetector_SlowRestarts(t *testing.T) {
+	d, c := newTestDetector()
+	d.ObserveService(whatsapp(0, 0))
+
+	for i := 1; i <= 5; i++ {
+		c.t = c.t.Add(6 * time.Minute)
+		assert.Nil(t, d.ObserveService(whatsapp(i, 1)), "restart %d", i)
+	}
+}
+
+func TestDetector_Recreated(t *testing.T) {
+	d, c := newTestDetector()
+	d.ObserveService(whatsapp(4, 0))
+
+	// A new container starts counting at zero
+	c.t = c.t.Add(time.Minute)
+	assert.Nil(t, d.ObserveService(whatsapp(0, 0)))
+	c.t = c.t.Add(time.Minute)
+	assert.Nil(t, d.ObserveService(whatsapp(2, 0)))
+	a := d.ObserveService(whatsapp(3, 2))
+	require.NotNil(t, a)
+	assert.Equal(t, KindCrashLoop, a.Kind)
+}
+
+func TestDetector_IgnoresOtherServices(t *testing.T) {
+	d, _ := newTestDetector()
+	assert.False(t, d.IsBridge("synapse"))
+	assert.True(t, d.IsBridge("mautrix-whatsapp"))
+
+	d.ObserveService(docker.ServiceStatus{Name: "muxbee-synapse-1", Service: "synapse"})
+	assert.Nil(t, d.ObserveService(docker.ServiceStatus{Name: "muxbee-synapse-1", Service: "synapse", RestartCount: 10}))
+}
+
+func TestDetector_LoggedOut(t *testing.T) {
+	d, _ := newTestDetector()
+	bridge := health.Bridge{Name: "whatsapp", Service: "mautrix-whatsapp"}
+
+	bridge.State = health.StateLoggedIn
+	assert.Nil(t, d.ObserveBridge(bridge))
+
+	bridge.State = health.StateLoggedOut
+	a := d.ObserveBridge(bridge)
+	require.NotNil(t, a)
+	assert.Equal(t, KindLoggedOut, a.Kind)
+	assert.Nil(t, d.ObserveBridge(bridge), "reported once")
+
+	// An inconclusive probe doesn't clear it
+	bridge.State = health.StateReachable
+	assert.Nil(t, d.ObserveBridge(bridge))
+
+	bridge.State = health.StateLoggedIn
+	a = d.ObserveBridge(bridge)
+	require.NotNil(t, a)
+	assert.Equal(t, KindRecovered, a.Kind)
+	assert.Equal(t, "✅ whatsapp bridge is logged in again.", a.Text())
+}
@@ -0,0 +1,155 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+// Name and topic of the room alerts are posted to
+const (
+	RoomName  = "muxbee alerts"
+	RoomTopic = "Bridge crash loops and lost logins, posted by muxbee"
+)
+
+// Notifier delivers alerts somewhere the admin will see them
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// Notifiers returns the notifiers settings.yaml turns on
+func Notifiers(cfg *config.Config) []Notifier {
+	var notifiers []Notifier
+	if cfg.Alerts.IsMatrixEnabled() {
+		notifiers = append(notifiers, NewMatrixNotifier(cfg))
+	}
+	if cfg.Alerts.Webhook != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(cfg.Alerts.Webhook, cfg.ServerName))
+	}
+	return notifiers
+}
+
+// Send delivers an alert through every notifier, trying all of them even
+// if some fail
+func Send(notifiers []Notifier, a Alert) error {
+	var errs []error
+	for _, n := range notifiers {
+		if err := n.Notify(a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MatrixNotifier posts alerts as the admin user into a room of their own
+type MatrixNotifier struct {
+	cfg           *config.Config
+	homeserverURL string
+	client        *matrix.Client // Logged in, or nil until the first alert
+}
+
+// NewMatrixNotifier creates a notifier that posts to the local homeserver
+func NewMatrixNotifier(cfg *config.Config) *MatrixNotifier {
+	return &MatrixNotifier{
+		cfg:           cfg,
+		homeserverURL: fmt.Sprintf("http://localhost:%d", cfg.SynapsePort()),
+	}
+}
+
+// Notify posts the alert, logging in and creating the room first if needed.
+// A new room is saved to settings.yaml so later alerts go to the same one.
+func (n *MatrixNotifier) Notify(a Alert) error {
+	if n.client == nil {
+		client, err := matrix.AdminClientAt(n.homeserverURL, n.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to login as admin: %w", err)
+		}
+		n.client = client
+	}
+
+	if n.cfg.Alerts.MatrixRoom == "" {
+		roomID, err := n.client.CreateRoom(RoomName, RoomTopic)
+		if err != nil {
+			return fmt.Errorf("failed to create alerts room: %w", err)
+		}
+		n.cfg.Alerts.MatrixRoom = roomID
+		if err := saveRoom(roomID); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+	}
+
+	if err := n.client.SendMessage(n.cfg.Alerts.MatrixRoom, a.Text()); err != nil {
+		// The token may have expired; check it again next time
+		n.client = nil
+		return fmt.Errorf("failed to post alert: %w", err)
+	}
+	return nil
+}
+
+// saveRoom records the alerts room in settings.yaml. It reloads the file
+// rather than saving the config muxbee started with, which may be stale.
+func saveRoom(roomID string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.Alerts.MatrixRoom = roomID
+	return cfg.Save()
+}
+
+// WebhookNotifier POSTs alerts as JSON to a URL
+type WebhookNotifier struct {
+	url        string
+	server     string
+	httpClient *http.Client
+}
+
+// webhookPayload is the body of a webhook request
+type webhookPayload struct {
+	Alert
+	Server string `json:"server"`
+	Text   string `json:"text"` // For chat webhooks that post "text", like Slack's or Mattermost's
+}
+
+// NewWebhookNotifier creates a notifier for a webhook URL. server is the
+// Matrix server name, to tell installs apart.
+func NewWebhookNotifier(url, server string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		server: server,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Notify sends the alert
+func (n *WebhookNotifier) Notify(a Alert) error {
+	body, err := json.Marshal(webhookPayload{
+		Alert:  a,
+		Server: n.server,
+		Text:   fmt.Sprintf("[muxbee %s] %s", n.server, a.Message),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.httpClient.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook failed: %s: %s", resp.Status, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL, "chat.example.com")
+	err := n.Notify(Alert{Kind: KindLoggedOut, Bridge: "signal", Message: "signal bridge is logged out.", Time: time.Now()})
+	require.NoError(t, err)
+
+	assert.Equal(t, "logged_out", got["kind"])
+	assert.Equal(t, "signal", got["bridge"])
+	assert.Equal(t, "chat.example.com", got["server"])
+	assert.Equal(t, "[muxbee chat.example.com] signal bridge is logged out.", got["text"])
+}
+
+func TestWebhookNotifier_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL, "localhost").Notify(Alert{Kind: KindTest})
+	assert.ErrorContains(t, err, "404 Not Found: no such hook")
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	cfg := &config.Config{Admin: config.AdminConfig{Username: "admin", Password: "secret"}}
+	require.NoError(t, cfg.Save())
+
+	var logins, rooms int
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/login":
+			logins++
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+		case "/_matrix/client/v3/createRoom":
+			rooms++
+			json.NewEncoder(w).Encode(map[string]string{"room_id": "!alerts:localhost"})
+		default:
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			sent = append(sent, r.URL.Path+" "+body["body"])
+			json.NewEncoder(w).Encode(map[string]string{"event_id": "$1"})
+		}
+	}))
+	defer server.Close()
+
+	n := NewMatrixNotifier(cfg)
+	n.homeserverURL = server.URL
+	require.NoError(t, n.Notify(Alert{Kind: KindCrashLoop, Message: "signal bridge is crash looping."}))
+	require.NoError(t, n.Notify(Alert{Kind: KindRecovered, Message: "signal bridge has stopped crash looping."}))
+
+	assert.Equal(t, 1, logins)
+	assert.Equal(t, 1, rooms)
+	require.Len(t, sent, 2)
+	assert.Contains(t, sent[0], "/rooms/!alerts:localhost/send/m.room.message/")
+	assert.Contains(t, sent[0], "⚠️ signal bridge is crash looping.")
+	assert.Contains(t, sent[1], "✅ signal bridge has stopped crash looping.")
+
+	saved, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "!alerts:localhost", saved.Alerts.MatrixRoom)
+}
+
+// failingNotifier always fails
+type failingNotifier struct{ calls int }
+
+func (f *failingNotifier) Notify(a Alert) error {
+	f.calls++
+	return errors.New("unreachable")
+}
+
+func TestSend_TriesEveryNotifier(t *testing.T) {
+	first, second := &failingNotifier{}, &failingNotifier{}
+	err := Send([]Notifier{first, second}, Alert{Kind: KindTest})
+	assert.EqualError(t, err, "unreachable\nunreachable")
+	assert.Equal(t, 1, first.calls)
+	assert.Equal(t, 1, second.calls)
+}
+
+func TestNotifiers(t *testing.T) {
+	cfg := &config.Config{}
+	assert.Len(t, Notifiers(cfg), 1)
+
+	disabled := false
+	cfg.Alerts = config.AlertsConfig{MatrixEnabled: &disabled, Webhook: "https://hooks.example.com/x"}
+	notifiers := Notifiers(cfg)
+	require.Len(t, notifiers, 1)
+	assert.IsType(t, &WebhookNotifier{}, notifiers[0])
+}
@@ -0,0 +1,143 @@
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+)
+
+// Default intervals for Watcher
+const (
+	DefaultResyncInterval = time.Minute
+	DefaultProbeInterval  = 5 * time.Minute
+	eventsRetryInterval   = 5 * time.Second
+)
+
+// Watcher follows container events and probes bridges, sending an alert
+// whenever the detector finds something new
+type Watcher struct {
+	cfg       *config.Config
+	compose   *docker.Compose
+	detector  *Detector
+	notifiers []Notifier
+
+	// ResyncInterval is how often every container is inspected, to catch
+	// restarts the event stream missed
+	ResyncInterval time.Duration
+	// ProbeInterval is how often bridge bots are pinged for their login state
+	ProbeInterval time.Duration
+	// Logf reports alerts sent and errors along the way
+	Logf func(format string, args ...any)
+}
+
+// NewWatcher creates a watcher for the bridges enabled in cfg
+func NewWatcher(cfg *config.Config, compose *docker.Compose, notifiers []Notifier) *Watcher {
+	return &Watcher{
+		cfg:            cfg,
+		compose:        compose,
+		detector:       NewDetector(cfg),
+		notifiers:      notifiers,
+		ResyncInterval: DefaultResyncInterval,
+		ProbeInterval:  DefaultProbeInterval,
+		Logf:           func(string, ...any) {},
+	}
+}
+
+// Run watches until ctx is done. Errors from the runtime or a notifier are
+// logged and retried rather than ending the watch.
+func (w *Watcher) Run(ctx context.Context) error {
+	statuses := w.resync()
+	w.probe(statuses)
+
+	resync := time.NewTicker(w.ResyncInterval)
+	defer resync.Stop()
+	probe := time.NewTicker(w.ProbeInterval)
+	defer probe.Stop()
+
+	events, errs := w.compose.Events(ctx)
+	var retry <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-events:
+			if !ok {
+				if err := <-errs; err != nil {
+					w.Logf("Container events stopped: %v", err)
+				}
+				events, errs = nil, nil
+				retry = time.After(eventsRetryInterval)
+				continue
+			}
+			w.handleEvent(ev)
+
+		case <-retry:
+			retry = nil
+			events, errs = w.compose.Events(ctx)
+
+		case <-resync.C:
+			statuses = w.resync()
+
+		case <-probe.C:
+			w.probe(statuses)
+		}
+	}
+}
+
+// handleEvent inspects a bridge container that just started or died. Its
+// restart count tells a restart by its restart policy from one by hand.
+func (w *Watcher) handleEvent(ev docker.Event) {
+	if !w.detector.IsBridge(ev.Service) {
+		return
+	}
+	switch ev.Action {
+	case "start", "die", "died":
+	default:
+		return
+	}
+
+	status, err := w.compose.InspectService(ev.ID)
+	if err != nil {
+		// Removed already; the next resync catches up
+		return
+	}
+	w.send(w.detector.ObserveService(status))
+}
+
+// resync observes every container and returns their statuses
+func (w *Watcher) resync() []docker.ServiceStatus {
+	statuses, err := w.compose.DetailedStatus()
+	if err != nil {
+		w.Logf("Failed to get service status: %v", err)
+		return nil
+	}
+	for _, s := range statuses {
+		w.send(w.detector.ObserveService(s))
+	}
+	return statuses
+}
+
+// probe pings the bridge bots for their login state
+func (w *Watcher) probe(statuses []docker.ServiceStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	for _, b := range health.CheckBridges(w.cfg, w.compose, statuses, health.Options{Login: true}) {
+		w.send(w.detector.ObserveBridge(b))
+	}
+}
+
+func (w *Watcher) send(a *Alert) {
+	if a == nil {
+		return
+	}
+	w.Logf("%s", a.Message)
+	if err := Send(w.notifiers, *a); err != nil {
+		w.Logf("Failed to send alert: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// Writer writes a gzip-compressed tar archive
+type Writer struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewWriter creates a new archive writer on top of w
+func NewWriter(w io.Writer) *Writer {
+	gw := gzip.NewWriter(w)
+	return &Writer{gw: gw, tw: tar.NewWriter(gw)}
+}
+
+// Close flushes the tar and gzip streams. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gw.Close()
+}
+
+// AddDir adds srcDir recursively under prefix. If skip returns true for a
+// path (relative to srcDir), that file or directory is left out.
+func (w *Writer) AddDir(srcDir, prefix string, skip func(rel string, info os.FileInfo) bool) error {
+	entries, err := collectEntries(srcDir, prefix, skip)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := w.addPath(e.path, e.name, e.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFile adds a single file from disk under the given archive name
+func (w *Writer) AddFile(name, src string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	return w.addPath(src, name, info)
+}
+
+// AddBytes adds an in-memory file under the given archive name
+func (w *Writer) AddBytes(name string, data []byte) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+func (w *Writer) addPath(path, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w.tw, f)
+	return err
+}
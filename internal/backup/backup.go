@@ -0,0 +1,273 @@
+// Package backup creates and restores muxbee backup archives.
+//
+// Archives are gzip-compressed tars with a manifest.json first, followed by
+// config/ and data/. Databases are never copied while live: PostgreSQL is
+// stored as a pg_dump under dumps/, and bridge SQLite databases are replaced
+// by copies taken with the SQLite backup API.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+// postgresDumpName is the archive path of the Synapse database dump
+const postgresDumpName = "dumps/synapse.pgdump"
+
+// CreateOptions controls Create
+type CreateOptions struct {
+	Progress      func(step string)
+	MuxbeeVersion string    // Recorded in the manifest
+	Parent        *Manifest // Full backup to build an incremental on, nil for a full backup
+	ParentName    string    // File name of the parent archive, recorded in the manifest
+}
+
+// archiveEntry is a file or directory to be written to the archive
+type archiveEntry struct {
+	name string // Path inside the archive
+	path string // Path on disk
+	info os.FileInfo
+}
+
+// Create writes a backup of the config and data directories to w. With a
+// parent, files whose content matches the parent are left out and recorded
+// as inherited, producing an incremental backup.
+func Create(w io.Writer, cfg *config.Config, opts CreateOptions) (*Manifest, error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "muxbee-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := config.DataDir()
+	manifest := NewManifest()
+	manifest.MuxbeeVersion = opts.MuxbeeVersion
+	manifest.SchemaVersion = cfg.SchemaVersion
+	manifest.ServerName = cfg.ServerName
+	manifest.EnabledBridges = cfg.EnabledBridges
+	files := make(map[string]string) // archive path -> local file
+
+	progress("Recording image versions")
+	manifest.Images = serviceImages(cfg)
+
+	progress("Dumping PostgreSQL database")
+	dumpPath := filepath.Join(tmpDir, "synapse.pgdump")
+	if err := dumpPostgres(cfg, dumpPath); err != nil {
+		return nil, fmt.Errorf("failed to dump PostgreSQL: %w", err)
+	}
+	manifest.PostgresDump = postgresDumpName
+	files[postgresDumpName] = dumpPath
+
+	progress("Backing up bridge databases")
+	dbs, err := FindSQLiteDatabases(filepath.Join(dataDir, "bridges"))
+	if err != nil {
+		return nil, err
+	}
+	for _, db := range dbs {
+		rel, err := filepath.Rel(dataDir, db)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(tmpDir, "sqlite", rel)
+		if err := BackupSQLite(db, dst, docker.RuntimeFor(cfg), docker.ServiceImage(cfg, "synapse")); err != nil {
+			return nil, err
+		}
+		name := "data/" + filepath.ToSlash(rel)
+		manifest.SQLiteDatabases = append(manifest.SQLiteDatabases, name)
+		files[name] = dst
+	}
+
+	if opts.Parent != nil {
+		manifest.Parent = opts.ParentName
+		progress("Comparing with " + opts.ParentName)
+	} else {
+		progress("Hashing files")
+	}
+
+	if err := writeArchive(w, config.ConfigDir(), dataDir, manifest, files, opts.Parent); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// serviceImages records the image and, for running services, the digest
+// of every configured service
+func serviceImages(cfg *config.Config) map[string]ImageInfo {
+	compose := docker.New(cfg)
+	images := make(map[string]ImageInfo)
+
+	for _, service := range docker.ConfiguredServices(cfg) {
+		info := ImageInfo{Image: docker.ServiceImage(cfg, service)}
+		if digest, err := compose.RunningImageDigest(service); err == nil {
+			info.Digest = digest
+		}
+		images[service] = info
+	}
+
+	return images
+}
+
+// dumpPostgres runs pg_dump in the postgres container, starting it
+// temporarily if the stack is down
+func dumpPostgres(cfg *config.Config, dst string) error {
+	compose := docker.New(cfg)
+
+	if !compose.IsServiceRunning("postgres") {
+		if err := compose.StartServiceWait("postgres"); err != nil {
+			return err
+		}
+		defer compose.StopService("postgres")
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return compose.DumpPostgres(f)
+}
+
+// writeArchive writes the manifest, config and data directories, and the
+// given extra files. The live postgres directory and any SQLite databases
+// (plus their WAL files) that have a backup copy in files are left out.
+// Every regular file is hashed into the manifest first; files matching the
+// parent's hash are recorded as inherited instead of being written.
+func writeArchive(w io.Writer, configDir, dataDir string, manifest *Manifest, files map[string]string, parent *Manifest) error {
+	skipData := func(rel string, info os.FileInfo) bool {
+		rel = filepath.ToSlash(rel)
+		if rel == "postgres" && info.IsDir() {
+			return true
+		}
+		for _, db := range manifest.SQLiteDatabases {
+			dbRel := db[len("data/"):]
+			if rel == dbRel || isSQLiteSidecar(rel, dbRel) {
+				return true
+			}
+		}
+		return false
+	}
+
+	configEntries, err := collectEntries(configDir, "config", nil)
+	if err != nil {
+		return fmt.Errorf("failed to backup config: %w", err)
+	}
+	dataEntries, err := collectEntries(dataDir, "data", skipData)
+	if err != nil {
+		return fmt.Errorf("failed to backup data: %w", err)
+	}
+	entries := append(configEntries, dataEntries...)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info, err := os.Lstat(files[name])
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", name, err)
+		}
+		entries = append(entries, archiveEntry{name: name, path: files[name], info: info})
+	}
+
+	manifest.Files = make(map[string]string)
+	inherited := make(map[string]bool)
+	for _, e := range entries {
+		if !e.info.Mode().IsRegular() {
+			continue
+		}
+		sum, err := hashFile(e.path)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", e.name, err)
+		}
+		manifest.Files[e.name] = sum
+		if parent != nil && parent.Files[e.name] == sum {
+			inherited[e.name] = true
+			manifest.Inherited = append(manifest.Inherited, e.name)
+		}
+	}
+
+	aw := NewWriter(w)
+
+	data, err := manifest.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := aw.AddBytes(ManifestName, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	for _, e := range entries {
+		if inherited[e.name] {
+			continue
+		}
+		if err := aw.addPath(e.path, e.name, e.info); err != nil {
+			return fmt.Errorf("failed to add %s: %w", e.name, err)
+		}
+	}
+
+	return aw.Close()
+}
+
+// collectEntries walks srcDir and returns its entries named under prefix.
+// If skip returns true for a path (relative to srcDir), it is left out.
+func collectEntries(srcDir, prefix string, skip func(rel string, info os.FileInfo) bool) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		if skip != nil && relPath != "." && skip(relPath, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entries = append(entries, archiveEntry{
+			name: filepath.ToSlash(filepath.Join(prefix, relPath)),
+			path: path,
+			info: info,
+		})
+		return nil
+	})
+
+	return entries, err
+}
+
+// hashFile returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
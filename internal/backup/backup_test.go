@@ -0,0 +1,242 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestWriteArchiveAndExtract(t *testing.T) {
+	src := t.TempDir()
+	configDir := filepath.Join(src, "config")
+	dataDir := filepath.Join(src, "data")
+
+	writeTestFile(t, filepath.Join(configDir, "settings.yaml"), "server_name: test")
+	writeTestFile(t, filepath.Join(dataDir, "postgres", "PG_VERSION"), "17")
+	writeTestFile(t, filepath.Join(dataDir, "synapse", "media_store", "abc"), "media")
+	writeTestFile(t, filepath.Join(dataDir, "bridges", "signal", "mautrix-signal.db"), "live")
+	writeTestFile(t, filepath.Join(dataDir, "bridges", "signal", "mautrix-signal.db-wal"), "wal")
+	writeTestFile(t, filepath.Join(dataDir, "bridges", "signal", "config.yaml"), "bridge config")
+
+	dbCopy := filepath.Join(src, "copy.db")
+	dumpFile := filepath.Join(src, "dump")
+	writeTestFile(t, dbCopy, "consistent")
+	writeTestFile(t, dumpFile, "pgdump")
+
+	manifest := NewManifest()
+	manifest.PostgresDump = postgresDumpName
+	manifest.SQLiteDatabases = []string{"data/bridges/signal/mautrix-signal.db"}
+	files := map[string]string{
+		postgresDumpName:                        dumpFile,
+		"data/bridges/signal/mautrix-signal.db": dbCopy,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeArchive(&buf, configDir, dataDir, manifest, files, nil))
+
+	dst := t.TempDir()
+	outConfig := filepath.Join(dst, "config")
+	outData := filepath.Join(dst, "data")
+	outDumps := filepath.Join(dst, "dumps")
+
+	// A stale WAL from the previous install must not survive the restore
+	writeTestFile(t, filepath.Join(outData, "bridges", "signal", "mautrix-signal.db-wal"), "stale")
+
+	got, err := Extract(&buf, outConfig, outData, outDumps)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, FormatVersion, got.FormatVersion)
+	assert.Equal(t, postgresDumpName, got.PostgresDump)
+
+	content, err := os.ReadFile(filepath.Join(outConfig, "settings.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "server_name: test", string(content))
+
+	content, err = os.ReadFile(filepath.Join(outData, "bridges", "signal", "mautrix-signal.db"))
+	require.NoError(t, err)
+	assert.Equal(t, "consistent", string(content), "database should come from the backup copy")
+
+	content, err = os.ReadFile(filepath.Join(outDumps, postgresDumpName))
+	require.NoError(t, err)
+	assert.Equal(t, "pgdump", string(content))
+
+	assert.FileExists(t, filepath.Join(outData, "synapse", "media_store", "abc"))
+	assert.FileExists(t, filepath.Join(outData, "bridges", "signal", "config.yaml"))
+	assert.NoDirExists(t, filepath.Join(outData, "postgres"), "raw postgres directory should not be archived")
+	assert.NoFileExists(t, filepath.Join(outData, "bridges", "signal", "mautrix-signal.db-wal"))
+}
+
+func TestExtract_LegacyArchive(t *testing.T) {
+	src := t.TempDir()
+	configDir := filepath.Join(src, "config")
+	dataDir := filepath.Join(src, "data")
+	writeTestFile(t, filepath.Join(configDir, "settings.yaml"), "server_name: test")
+	writeTestFile(t, filepath.Join(dataDir, "postgres", "PG_VERSION"), "17")
+
+	// Legacy archives are plain config/ and data/ trees without a manifest
+	var buf bytes.Buffer
+	aw := NewWriter(&buf)
+	require.NoError(t, aw.AddDir(configDir, "config", nil))
+	require.NoError(t, aw.AddDir(dataDir, "data", nil))
+	require.NoError(t, aw.Close())
+
+	dst := t.TempDir()
+	manifest, err := Extract(&buf, filepath.Join(dst, "config"), filepath.Join(dst, "data"), filepath.Join(dst, "dumps"))
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+	assert.FileExists(t, filepath.Join(dst, "data", "postgres", "PG_VERSION"))
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := NewManifest()
+	m.PostgresDump = postgresDumpName
+	m.SQLiteDatabases = []string{"data/bridges/whatsapp/mautrix-whatsapp.db"}
+
+	data, err := m.Marshal()
+	require.NoError(t, err)
+
+	parsed, err := ParseManifest(data)
+	require.NoError(t, err)
+	assert.Equal(t, m.FormatVersion, parsed.FormatVersion)
+	assert.Equal(t, m.PostgresDump, parsed.PostgresDump)
+	assert.True(t, parsed.IsSQLiteDatabase("data/bridges/whatsapp/mautrix-whatsapp.db"))
+	assert.False(t, parsed.IsSQLiteDatabase("data/bridges/whatsapp/config.yaml"))
+}
+
+func TestBackupSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bridge.db")
+	out, err := exec.Command("sqlite3", src,
+		"PRAGMA journal_mode=WAL; CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('hello');").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	dst := filepath.Join(dir, "backup", "bridge.db")
+	require.NoError(t, BackupSQLite(src, dst, docker.DetectRuntime(docker.RuntimeDocker), docker.DefaultImage("synapse")))
+
+	out, err = exec.Command("sqlite3", dst, "SELECT v FROM t").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+}
+
+func TestFindSQLiteDatabases(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "signal", "mautrix-signal.db"), "")
+	writeTestFile(t, filepath.Join(dir, "signal", "mautrix-signal.db-wal"), "")
+	writeTestFile(t, filepath.Join(dir, "telegram", "mautrix-telegram.db"), "")
+	writeTestFile(t, filepath.Join(dir, "telegram", "config.yaml"), "")
+
+	dbs, err := FindSQLiteDatabases(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "signal", "mautrix-signal.db"),
+		filepath.Join(dir, "telegram", "mautrix-telegram.db"),
+	}, dbs)
+}
+
+func TestIncrementalRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	configDir := filepath.Join(src, "config")
+	dataDir := filepath.Join(src, "data")
+	backupDir := filepath.Join(src, "backups")
+	require.NoError(t, os.MkdirAll(backupDir, 0755))
+
+	writeTestFile(t, filepath.Join(configDir, "settings.yaml"), "server_name: test")
+	writeTestFile(t, filepath.Join(dataDir, "synapse", "media_store", "big"), "unchanged media")
+	writeTestFile(t, filepath.Join(dataDir, "synapse", "homeserver.signing.key"), "key v1")
+
+	writeBackupFile := func(name string, parent *Manifest, parentName string) *Manifest {
+		manifest := NewManifest()
+		manifest.Parent = parentName
+		f, err := os.Create(filepath.Join(backupDir, name))
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, writeArchive(f, configDir, dataDir, manifest, nil, parent))
+		return manifest
+	}
+
+	full := writeBackupFile("full.tar.gz", nil, "")
+	assert.False(t, full.IsIncremental())
+	assert.Len(t, full.Files, 3)
+
+	writeTestFile(t, filepath.Join(dataDir, "synapse", "homeserver.signing.key"), "key v2")
+	incr := writeBackupFile("incr.tar.gz", full, "full.tar.gz")
+	assert.True(t, incr.IsIncremental())
+	assert.ElementsMatch(t, []string{"config/settings.yaml", "data/synapse/media_store/big"}, incr.Inherited)
+
+	// The incremental archive itself must not contain the unchanged media
+	f, err := os.Open(filepath.Join(backupDir, "incr.tar.gz"))
+	require.NoError(t, err)
+	onlyIncr := t.TempDir()
+	_, err = Extract(f, filepath.Join(onlyIncr, "config"), filepath.Join(onlyIncr, "data"), filepath.Join(onlyIncr, "dumps"))
+	f.Close()
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(onlyIncr, "data", "synapse", "media_store", "big"))
+
+	dst := t.TempDir()
+	manifest, err := extractWithParent(filepath.Join(backupDir, "incr.tar.gz"), Keys{},
+		filepath.Join(dst, "config"), filepath.Join(dst, "data"), filepath.Join(dst, "dumps"), nil, func(string) {})
+	require.NoError(t, err)
+	assert.Equal(t, "full.tar.gz", manifest.Parent)
+
+	content, err := os.ReadFile(filepath.Join(dst, "data", "synapse", "media_store", "big"))
+	require.NoError(t, err)
+	assert.Equal(t, "unchanged media", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dst, "data", "synapse", "homeserver.signing.key"))
+	require.NoError(t, err)
+	assert.Equal(t, "key v2", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dst, "config", "settings.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "server_name: test", string(content))
+}
+
+func TestExtract_ChecksumMismatch(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "config", "settings.yaml"), "server_name: test")
+
+	manifest := NewManifest()
+	manifest.Files = map[string]string{"config/settings.yaml": "0000"}
+	data, err := manifest.Marshal()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	aw := NewWriter(&buf)
+	require.NoError(t, aw.AddBytes(ManifestName, data))
+	require.NoError(t, aw.AddDir(filepath.Join(src, "config"), "config", nil))
+	require.NoError(t, aw.Close())
+
+	dst := t.TempDir()
+	_, err = Extract(&buf, filepath.Join(dst, "config"), filepath.Join(dst, "data"), filepath.Join(dst, "dumps"))
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestReadManifest(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "config", "settings.yaml"), "server_name: test")
+	writeTestFile(t, filepath.Join(src, "data", "synapse", "media_store", "abc"), "media")
+
+	var buf bytes.Buffer
+	require.NoError(t, writeArchive(&buf, filepath.Join(src, "config"), filepath.Join(src, "data"), NewManifest(), nil, nil))
+
+	got, err := ReadManifest(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Contains(t, got.Files, "config/settings.yaml")
+}
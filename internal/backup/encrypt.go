@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// ageHeader is the first line of every age-encrypted file
+const ageHeader = "age-encryption.org/v1\n"
+
+// EncryptedExt is appended to the file name of encrypted backups
+const EncryptedExt = ".age"
+
+// Keys supplies the secrets needed to open an encrypted backup
+type Keys struct {
+	IdentityFile string                 // age identity file (X25519 private keys)
+	Passphrase   func() (string, error) // Called only for passphrase-encrypted backups
+}
+
+// PassphraseRecipient returns a recipient that encrypts with a passphrase (age scrypt)
+func PassphraseRecipient(passphrase string) (age.Recipient, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	return age.NewScryptRecipient(passphrase)
+}
+
+// LoadRecipients reads age recipients (age1... public keys, one per line) from a file
+func LoadRecipients(path string) ([]age.Recipient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	recipients, err := age.ParseRecipients(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recipients file %s: %w", path, err)
+	}
+	return recipients, nil
+}
+
+// Encrypt wraps w so everything written is encrypted to the recipients.
+// The returned writer must be closed to flush the final chunk.
+func Encrypt(w io.Writer, recipients ...age.Recipient) (io.WriteCloser, error) {
+	return age.Encrypt(w, recipients...)
+}
+
+// Open returns a reader for the backup in r, decrypting it if it is
+// age-encrypted. Plain archives are passed through unchanged.
+func Open(r io.Reader, keys Keys) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+
+	header, _ := br.Peek(len(ageHeader))
+	if string(header) != ageHeader {
+		return br, false, nil
+	}
+
+	identities, err := keys.identities(br)
+	if err != nil {
+		return nil, true, err
+	}
+
+	plain, err := age.Decrypt(br, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, true, errors.New("backup is encrypted, but no key matched (wrong passphrase or identity file?)")
+		}
+		return nil, true, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	return plain, true, nil
+}
+
+// identities picks identities for an encrypted stream: the identity file if
+// given, otherwise a passphrase for scrypt-encrypted backups
+func (k Keys) identities(br *bufio.Reader) ([]age.Identity, error) {
+	if k.IdentityFile != "" {
+		f, err := os.Open(k.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity file %s: %w", k.IdentityFile, err)
+		}
+		return identities, nil
+	}
+
+	if !isPassphraseEncrypted(br) {
+		return nil, errors.New("backup is encrypted to age recipients\nUse --identity to pass the matching identity file")
+	}
+	if k.Passphrase == nil {
+		return nil, errors.New("backup is encrypted with a passphrase")
+	}
+
+	passphrase, err := k.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return []age.Identity{identity}, nil
+}
+
+// isPassphraseEncrypted reports whether the age header has a scrypt stanza.
+// Passphrase-encrypted files have exactly one, right after the version line.
+func isPassphraseEncrypted(br *bufio.Reader) bool {
+	header, _ := br.Peek(len(ageHeader) + 64)
+	return bytes.HasPrefix(header[len(ageHeader):], []byte("-> scrypt "))
+}
@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptBytes(t *testing.T, data []byte, recipients ...age.Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := Encrypt(&buf, recipients...)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestOpen_Plain(t *testing.T) {
+	r, encrypted, err := Open(bytes.NewReader([]byte("plain archive")), Keys{})
+	require.NoError(t, err)
+	assert.False(t, encrypted)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "plain archive", string(data))
+}
+
+func TestOpen_Passphrase(t *testing.T) {
+	recipient, err := PassphraseRecipient("correct horse")
+	require.NoError(t, err)
+	encrypted := encryptBytes(t, []byte("secret archive"), recipient)
+
+	r, isEncrypted, err := Open(bytes.NewReader(encrypted), Keys{
+		Passphrase: func() (string, error) { return "correct horse", nil },
+	})
+	require.NoError(t, err)
+	assert.True(t, isEncrypted)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "secret archive", string(data))
+
+	_, _, err = Open(bytes.NewReader(encrypted), Keys{
+		Passphrase: func() (string, error) { return "wrong", nil },
+	})
+	assert.Error(t, err)
+}
+
+func TestOpen_Recipient(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	identityFile := filepath.Join(dir, "key.txt")
+	require.NoError(t, os.WriteFile(recipientsFile, []byte(identity.Recipient().String()+"\n"), 0644))
+	require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+
+	recipients, err := LoadRecipients(recipientsFile)
+	require.NoError(t, err)
+	encrypted := encryptBytes(t, []byte("secret archive"), recipients...)
+
+	// Without an identity file there is nothing to prompt for
+	prompted := false
+	_, _, err = Open(bytes.NewReader(encrypted), Keys{
+		Passphrase: func() (string, error) { prompted = true; return "", nil },
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--identity")
+	assert.False(t, prompted)
+
+	r, _, err := Open(bytes.NewReader(encrypted), Keys{IdentityFile: identityFile})
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "secret archive", string(data))
+}
+
+func TestPassphraseRecipient_Empty(t *testing.T) {
+	_, err := PassphraseRecipient("")
+	assert.Error(t, err)
+}
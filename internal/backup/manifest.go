@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ManifestName is the archive path of the manifest. It is always the first entry.
+const ManifestName = "manifest.json"
+
+// maxManifestSize guards against reading a huge bogus manifest into memory
+const maxManifestSize = 64 << 20
+
+// FormatVersion is the archive layout written by this version of muxbee.
+// Version 1 archives have no manifest and contain a raw copy of data/postgres.
+const FormatVersion = 2
+
+// Manifest describes the contents of a backup archive
+type Manifest struct {
+	FormatVersion  int       `json:"format_version"`
+	CreatedAt      time.Time `json:"created_at"`
+	MuxbeeVersion  string    `json:"muxbee_version,omitempty"`
+	SchemaVersion  int       `json:"settings_schema_version,omitempty"` // Of the backed up settings.yaml
+	ServerName     string    `json:"server_name,omitempty"`
+	EnabledBridges []string  `json:"enabled_bridges,omitempty"`
+
+	// Images records the image each configured service ran at backup time
+	Images map[string]ImageInfo `json:"images,omitempty"`
+
+	PostgresDump    string   `json:"postgres_dump,omitempty"`    // Archive path of the pg_dump output
+	SQLiteDatabases []string `json:"sqlite_databases,omitempty"` // Archive paths of SQLite backups
+
+	// Files maps every regular file the backup restores to its SHA-256,
+	// including files inherited from the parent
+	Files map[string]string `json:"files,omitempty"`
+
+	// Parent is the file name of the full backup an incremental builds on.
+	// Inherited files are restored from the parent instead of this archive.
+	Parent    string   `json:"parent,omitempty"`
+	Inherited []string `json:"inherited,omitempty"`
+}
+
+// ImageInfo identifies the image a service was running
+type ImageInfo struct {
+	Image  string `json:"image"`            // Reference from the compose file
+	Digest string `json:"digest,omitempty"` // Repository digest, if the service was running
+}
+
+// NewManifest creates a manifest for a backup taken now
+func NewManifest() *Manifest {
+	return &Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+// Marshal encodes the manifest as indented JSON
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// ParseManifest decodes a manifest
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// IsIncremental reports whether the backup needs its parent to be restored
+func (m *Manifest) IsIncremental() bool {
+	return m.Parent != ""
+}
+
+// ReadManifest reads the manifest from the start of a (decrypted) archive.
+// Returns nil without error for archives made before manifests existed.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tar: %w", err)
+	}
+	if header.Name != ManifestName {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// IsSQLiteDatabase reports whether an archive path is one of the SQLite backups
+func (m *Manifest) IsSQLiteDatabase(name string) bool {
+	for _, db := range m.SQLiteDatabases {
+		if db == name {
+			return true
+		}
+	}
+	return false
+}
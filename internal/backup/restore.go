@@ -0,0 +1,701 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/generator"
+)
+
+// rollbackSuffix is appended to a live directory when a restore moves it
+// aside, followed by the time of the restore
+const rollbackSuffix = ".pre-restore"
+
+// RestoreOptions controls RestoreFile. By default the whole install is
+// restored; Bridge, ConfigOnly and DataOnly narrow it down.
+type RestoreOptions struct {
+	Keys     Keys
+	Progress func(step string)
+
+	Bridge     string // Only restore data/bridges/<Bridge>/, restarting just that bridge
+	ConfigOnly bool   // Only restore config/
+	DataOnly   bool   // Only restore data/ and the database dump
+}
+
+// RestoreResult describes a completed restore
+type RestoreResult struct {
+	Manifest *Manifest // nil for archives made before manifests existed
+	Previous []string  // Where the replaced directories were kept
+}
+
+// target is a part of the install that a restore replaces
+type target struct {
+	prefix string // Archive path prefix, e.g. "config/" or "data/bridges/signal/"
+	live   string // Directory it replaces
+	stage  string // Staging root the archive is extracted into
+}
+
+// staged returns where the target ends up inside its staging root
+func (t *target) staged() string {
+	return filepath.Join(t.stage, filepath.FromSlash(strings.TrimSuffix(t.prefix, "/")))
+}
+
+// targets returns what the options restore
+func (o RestoreOptions) targets() ([]*target, error) {
+	scopes := 0
+	for _, set := range []bool{o.Bridge != "", o.ConfigOnly, o.DataOnly} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes > 1 {
+		return nil, fmt.Errorf("--bridge, --config-only and --data-only cannot be combined")
+	}
+
+	configTarget := &target{prefix: "config/", live: config.ConfigDir()}
+	dataTarget := &target{prefix: "data/", live: config.DataDir()}
+
+	switch {
+	case o.Bridge != "":
+		if !filepath.IsLocal(o.Bridge) || strings.ContainsAny(o.Bridge, `/\`) {
+			return nil, fmt.Errorf("invalid bridge name %q", o.Bridge)
+		}
+		return []*target{{
+			prefix: "data/bridges/" + o.Bridge + "/",
+			live:   filepath.Join(config.DataDir(), "bridges", o.Bridge),
+		}}, nil
+	case o.ConfigOnly:
+		return []*target{configTarget}, nil
+	case o.DataOnly:
+		return []*target{dataTarget}, nil
+	}
+	return []*target{configTarget, dataTarget}, nil
+}
+
+// RestoreFile restores the backup at path, decrypting it if needed.
+// Incremental backups also restore their inherited files from the parent,
+// which must be in the same directory.
+//
+// Everything is extracted and verified into staging directories first, so a
+// bad archive never touches the live state. Only then are the affected
+// services stopped and the staged directories swapped in; the old ones are
+// kept next to them with a .pre-restore-<time> suffix. Configs are then
+// regenerated from the restored settings.yaml and, for archives with a
+// PostgreSQL dump, the dump is loaded into a fresh database. If any step
+// after the swap fails, the previous directories are put back.
+func RestoreFile(path string, opts RestoreOptions) (*RestoreResult, error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	targets, err := opts.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	var configStage, dataStage string
+	for _, t := range targets {
+		if t.stage, err = newStagingDir(t.live); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(t.stage)
+
+		if t.prefix == "config/" {
+			configStage = filepath.Join(t.stage, "config")
+		} else {
+			dataStage = filepath.Join(t.stage, "data")
+		}
+	}
+
+	dumpDir, err := os.MkdirTemp("", "muxbee-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dumpDir)
+
+	restoresDump := opts.Bridge == "" && !opts.ConfigOnly
+	include := func(name string) bool {
+		if strings.HasPrefix(name, "dumps/") {
+			return restoresDump
+		}
+		for _, t := range targets {
+			if strings.HasPrefix(name, t.prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	manifest, err := extractWithParent(path, opts.Keys, configStage, dataStage, dumpDir, include, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range targets {
+		if err := prepareStaged(t); err != nil {
+			return nil, err
+		}
+	}
+	if configStage != "" {
+		if _, err := os.Stat(filepath.Join(configStage, "settings.yaml")); err != nil {
+			return nil, fmt.Errorf("backup has no settings.yaml, refusing to restore it")
+		}
+		if err := checkSchema(manifest, filepath.Join(configStage, "settings.yaml")); err != nil {
+			return nil, err
+		}
+	}
+	if !restoresDump {
+		manifest = withoutDump(manifest)
+	}
+
+	if opts.Bridge != "" {
+		return restoreBridge(opts.Bridge, targets[0], manifest, progress)
+	}
+
+	progress("Stopping services")
+	if err := stopServices(); err != nil {
+		return nil, fmt.Errorf("failed to stop services: %w", err)
+	}
+
+	progress("Swapping in restored files")
+	swaps := make([]*swap, len(targets))
+	for i, t := range targets {
+		swaps[i] = &swap{live: t.live, staged: t.staged()}
+	}
+	if err := swapDirs(swaps); err != nil {
+		return nil, err
+	}
+
+	if err := finishRestore(manifest, dumpDir, configStage != "", progress); err != nil {
+		return nil, rollBack(swaps, err)
+	}
+
+	return &RestoreResult{Manifest: manifest, Previous: previousDirs(swaps)}, nil
+}
+
+// checkSchema refuses restored settings this muxbee can't read, and
+// settings that don't match the schema the manifest recorded
+func checkSchema(manifest *Manifest, settings string) error {
+	if manifest != nil && manifest.SchemaVersion > config.SchemaVersion {
+		return fmt.Errorf("backup has settings schema %d, but this muxbee only reads up to %d\nUpgrade muxbee to restore it", manifest.SchemaVersion, config.SchemaVersion)
+	}
+	cfg, err := config.LoadFile(settings)
+	if err != nil {
+		return fmt.Errorf("failed to read the backup's settings.yaml: %w", err)
+	}
+	// Backups from before the version was recorded leave it out
+	if manifest != nil && manifest.SchemaVersion != 0 && manifest.SchemaVersion != cfg.SchemaVersion {
+		return fmt.Errorf("backup's settings.yaml has schema %d, but its manifest records %d", cfg.SchemaVersion, manifest.SchemaVersion)
+	}
+	return nil
+}
+
+// restoreBridge swaps in one bridge's data directory, stopping only that
+// bridge and starting it again afterwards if it was running
+func restoreBridge(name string, t *target, manifest *Manifest, progress func(string)) (*RestoreResult, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w\nRun 'muxbee init' first", err)
+	}
+	if !cfg.IsBridgeEnabled(name) {
+		return nil, fmt.Errorf("bridge '%s' is not enabled\nRun 'muxbee bridge enable %s' first", name, name)
+	}
+
+	compose := docker.New(cfg)
+	service := bridges.ServiceNameFor(name)
+	wasRunning := compose.IsServiceRunning(service)
+
+	if wasRunning {
+		progress("Stopping " + service)
+		if err := compose.StopService(service); err != nil {
+			return nil, fmt.Errorf("failed to stop %s: %w", service, err)
+		}
+	}
+
+	progress("Swapping in restored files")
+	swaps := []*swap{{live: t.live, staged: t.staged()}}
+	if err := swapDirs(swaps); err != nil {
+		return nil, err
+	}
+
+	if wasRunning {
+		progress("Starting " + service)
+		if err := compose.StartServiceWait(service); err != nil {
+			err = rollBack(swaps, err)
+			compose.StartServiceWait(service)
+			return nil, err
+		}
+	}
+
+	return &RestoreResult{Manifest: manifest, Previous: previousDirs(swaps)}, nil
+}
+
+// rollBack undoes the swaps after a failed restore and explains where
+// things stand
+func rollBack(swaps []*swap, err error) error {
+	if undoErr := undoSwaps(swaps); undoErr != nil {
+		return fmt.Errorf("%w\nRolling back also failed: %v\nThe previous files are in %s", err, undoErr, strings.Join(previousDirs(swaps), ", "))
+	}
+	return fmt.Errorf("%w\nThe previous files were put back", err)
+}
+
+// finishRestore regenerates configs from the settings and loads the
+// database dump, if the archive has one. Configs are only regenerated when
+// config/ was restored; otherwise the live ones already match.
+func finishRestore(manifest *Manifest, dumpDir string, regenerate bool, progress func(string)) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if regenerate {
+		progress("Regenerating configs")
+		gen := generator.New()
+		if err := gen.GenerateAll(cfg); err != nil {
+			return fmt.Errorf("failed to generate configs: %w", err)
+		}
+		if err := docker.New(cfg).WriteComposeFile(); err != nil {
+			return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+		}
+	}
+
+	if manifest == nil || manifest.PostgresDump == "" {
+		return nil
+	}
+
+	progress("Loading PostgreSQL dump")
+	if err := config.EnsureDirs(); err != nil {
+		return err
+	}
+	if err := loadPostgresDump(cfg, filepath.Join(dumpDir, manifest.PostgresDump)); err != nil {
+		return fmt.Errorf("failed to restore PostgreSQL: %w", err)
+	}
+	return nil
+}
+
+// withoutDump returns a copy of the manifest with no database dump, for
+// restores that leave the database alone
+func withoutDump(m *Manifest) *Manifest {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.PostgresDump = ""
+	return &c
+}
+
+// stopServices stops the stack described by the live config. Without a
+// live config and compose file nothing can be running.
+func stopServices() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(config.DockerComposePath()); err != nil {
+		return nil
+	}
+	return docker.New(cfg).Down(docker.GetProfiles(cfg))
+}
+
+// newStagingDir creates an empty directory next to live, so what is
+// extracted into it can later be renamed over live atomically
+func newStagingDir(live string) (string, error) {
+	parent := filepath.Dir(live)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(parent, "."+filepath.Base(live)+"-restore-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	return dir, nil
+}
+
+// prepareStaged makes sure the staged directory exists and has the live
+// directory's permissions. A bridge missing from the archive is an error.
+func prepareStaged(t *target) error {
+	staged := t.staged()
+	if _, err := os.Stat(staged); os.IsNotExist(err) {
+		if strings.HasPrefix(t.prefix, "data/bridges/") {
+			return fmt.Errorf("backup has no data for bridge '%s'", filepath.Base(t.live))
+		}
+		if err := os.MkdirAll(staged, 0755); err != nil {
+			return err
+		}
+	}
+
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(t.live); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return os.Chmod(staged, mode)
+}
+
+// swap is a staged directory that replaces a live one
+type swap struct {
+	live     string
+	staged   string
+	previous string // Where the live directory was moved, empty if there was none
+	done     bool
+}
+
+// swapDirs moves each live directory aside and its staged replacement into
+// place. If one fails, the swaps already made are undone.
+func swapDirs(swaps []*swap) error {
+	for _, s := range swaps {
+		if err := s.apply(); err != nil {
+			if undoErr := undoSwaps(swaps); undoErr != nil {
+				return fmt.Errorf("%w (rolling back also failed: %v)", err, undoErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// undoSwaps reverts every applied swap, moving the restored directory
+// back to its staging path so it is cleaned up with it
+func undoSwaps(swaps []*swap) error {
+	for i := len(swaps) - 1; i >= 0; i-- {
+		if err := swaps[i].undo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *swap) apply() error {
+	if _, err := os.Lstat(s.live); err == nil {
+		previous, err := rollbackPath(s.live)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(s.live, previous); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", s.live, err)
+		}
+		s.previous = previous
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(s.staged, s.live); err != nil {
+		if s.previous != "" {
+			os.Rename(s.previous, s.live)
+			s.previous = ""
+		}
+		return fmt.Errorf("failed to move restored files into %s: %w", s.live, err)
+	}
+
+	s.done = true
+	return nil
+}
+
+// rollbackPath returns an unused name to move a live directory aside to.
+// Each restore gets its own, so an earlier rollback copy never has to be
+// deleted: its postgres files belong to the container user, and the host
+// user may not be allowed to remove them.
+func rollbackPath(live string) (string, error) {
+	base := live + rollbackSuffix + "-" + time.Now().Format("20060102-150405")
+	path := base
+	for i := 2; ; i++ {
+		_, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		path = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func (s *swap) undo() error {
+	if !s.done {
+		return nil
+	}
+	if err := os.Rename(s.live, s.staged); err != nil {
+		return err
+	}
+	if s.previous != "" {
+		if err := os.Rename(s.previous, s.live); err != nil {
+			return err
+		}
+		s.previous = ""
+	}
+	s.done = false
+	return nil
+}
+
+// previousDirs lists where the replaced live directories were kept
+func previousDirs(swaps []*swap) []string {
+	var dirs []string
+	for _, s := range swaps {
+		if s.previous != "" {
+			dirs = append(dirs, s.previous)
+		}
+	}
+	return dirs
+}
+
+// extractWithParent extracts a backup file and, for incrementals, the
+// inherited files from its parent in the same directory
+func extractWithParent(path string, keys Keys, configDir, dataDir, dumpDir string, include func(string) bool, progress func(string)) (*Manifest, error) {
+	progress("Extracting files")
+	manifest, err := extractFile(path, keys, configDir, dataDir, dumpDir, extractOptions{include: include})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil || !manifest.IsIncremental() {
+		return manifest, nil
+	}
+
+	progress("Extracting unchanged files from " + manifest.Parent)
+	inherited := make(map[string]bool)
+	for _, name := range manifest.Inherited {
+		inherited[name] = true
+	}
+	parentPath := filepath.Join(filepath.Dir(path), manifest.Parent)
+	_, err = extractFile(parentPath, keys, configDir, dataDir, dumpDir, extractOptions{
+		include: func(name string) bool { return inherited[name] && (include == nil || include(name)) },
+		expect:  manifest.Files,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore from parent backup %s: %w", manifest.Parent, err)
+	}
+
+	return manifest, nil
+}
+
+// extractFile opens, decrypts and extracts a backup file
+func extractFile(path string, keys Keys, configDir, dataDir, dumpDir string, opts extractOptions) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer f.Close()
+
+	r, _, err := Open(f, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return extract(r, configDir, dataDir, dumpDir, opts)
+}
+
+// loadPostgresDump starts postgres on the freshly created (empty) database
+// directory and loads the dump into it
+func loadPostgresDump(cfg *config.Config, dumpPath string) error {
+	compose := docker.New(cfg)
+
+	if err := compose.StartServiceWait("postgres"); err != nil {
+		return err
+	}
+	defer compose.StopService("postgres")
+
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return compose.RestorePostgres(f)
+}
+
+// extractOptions narrows what extract writes
+type extractOptions struct {
+	include func(name string) bool // Only extract matching entries (nil = all)
+	expect  map[string]string      // SHA-256 to check files against (nil = the archive's manifest)
+}
+
+// Extract unpacks config/ into configDir, data/ into dataDir and dumps/ into
+// dumpDir (keeping the dumps/ prefix). Returns the manifest if present.
+func Extract(r io.Reader, configDir, dataDir, dumpDir string) (*Manifest, error) {
+	return extract(r, configDir, dataDir, dumpDir, extractOptions{})
+}
+
+func extract(r io.Reader, configDir, dataDir, dumpDir string, opts extractOptions) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	var manifest *Manifest
+	expect := opts.expect
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		if header.Name == ManifestName {
+			data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest: %w", err)
+			}
+			if manifest, err = ParseManifest(data); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			if expect == nil {
+				expect = manifest.Files
+			}
+			continue
+		}
+
+		if opts.include != nil && !opts.include(header.Name) {
+			continue
+		}
+
+		root, rel, ok := entryRoot(header.Name, configDir, dataDir, dumpDir)
+		if !ok {
+			continue
+		}
+		destPath, err := safeJoin(root, rel)
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(destPath, os.FileMode(header.Mode)); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if manifest != nil && manifest.IsSQLiteDatabase(header.Name) {
+				if err := removeSQLiteSidecars(destPath); err != nil {
+					return nil, fmt.Errorf("failed to remove stale WAL files: %w", err)
+				}
+			}
+
+			h := sha256.New()
+			if err := writeFile(destPath, io.TeeReader(tr, h), os.FileMode(header.Mode)); err != nil {
+				return nil, err
+			}
+			if err := checkHash(header.Name, expect, h); err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			if err := writeSymlink(root, destPath, header.Linkname); err != nil {
+				return nil, fmt.Errorf("unsafe entry %s in backup: %w", header.Name, err)
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// entryRoot maps an archive path to the directory it is extracted into and
+// its path below that directory. Entries outside config/, data/ and dumps/
+// are ignored.
+func entryRoot(name, configDir, dataDir, dumpDir string) (root, rel string, ok bool) {
+	switch {
+	case strings.HasPrefix(name, "config/"):
+		root, rel = configDir, strings.TrimPrefix(name, "config/")
+	case strings.HasPrefix(name, "data/"):
+		root, rel = dataDir, strings.TrimPrefix(name, "data/")
+	case strings.HasPrefix(name, "dumps/"):
+		root, rel = dumpDir, name
+	}
+	return root, rel, root != "" && strings.TrimSuffix(rel, "/") != ""
+}
+
+// safeJoin joins an archive path onto root. Absolute paths, ".." components
+// and paths through a symlink are rejected, so nothing is written outside root.
+func safeJoin(root, rel string) (string, error) {
+	rel = filepath.FromSlash(strings.TrimSuffix(rel, "/"))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("unsafe path %q in backup", rel)
+	}
+
+	dir := root
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("unsafe path %q in backup: it passes through a symlink", rel)
+		}
+	}
+
+	return filepath.Join(root, rel), nil
+}
+
+// writeSymlink creates a symlink whose target must stay inside root
+func writeSymlink(root, destPath, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink to absolute path %s", target)
+	}
+	resolved, err := filepath.Rel(root, filepath.Join(filepath.Dir(destPath), target))
+	if err != nil || (resolved != "." && !filepath.IsLocal(resolved)) {
+		return fmt.Errorf("symlink to %s points outside its directory", target)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, destPath)
+}
+
+// checkHash compares a file's hash with the expected one, if known
+func checkHash(name string, expect map[string]string, h hash.Hash) error {
+	want, ok := expect[name]
+	if !ok {
+		return nil
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: backup is corrupt", name)
+	}
+	return nil
+}
+
+func writeFile(destPath string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if info, err := os.Lstat(destPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(destPath); err != nil {
+			return err
+		}
+	}
+
+	outFile, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, r); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
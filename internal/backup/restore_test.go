@@ -0,0 +1,383 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// rawArchive builds an archive from hand-written tar headers
+func rawArchive(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len("payload"))
+		}
+		require.NoError(t, tw.WriteHeader(h))
+		if h.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("payload"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return &buf
+}
+
+func TestExtract_RejectsUnsafeEntries(t *testing.T) {
+	tests := map[string][]*tar.Header{
+		"parent traversal": {
+			{Name: "config/../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"absolute path": {
+			{Name: "data//etc/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"symlink out of the directory": {
+			{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+		},
+		"absolute symlink": {
+			{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		},
+		"write through a symlink": {
+			{Name: "data/dir", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "data/dir/x", Typeflag: tar.TypeSymlink, Linkname: "../escaped"},
+		},
+	}
+
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "a", "b")
+			_, err := Extract(rawArchive(t, headers...), filepath.Join(dst, "config"), filepath.Join(dst, "data"), filepath.Join(dst, "dumps"))
+			assert.ErrorContains(t, err, "unsafe")
+			assert.NoFileExists(t, filepath.Join(root, "a", "evil"))
+			assert.NoFileExists(t, filepath.Join(root, "a", "b", "escaped"))
+		})
+	}
+}
+
+func TestExtract_SymlinkInside(t *testing.T) {
+	dst := t.TempDir()
+	buf := rawArchive(t,
+		&tar.Header{Name: "data/synapse/real", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "data/synapse/link", Typeflag: tar.TypeSymlink, Linkname: "real"},
+	)
+
+	_, err := Extract(buf, filepath.Join(dst, "config"), filepath.Join(dst, "data"), filepath.Join(dst, "dumps"))
+	require.NoError(t, err)
+
+	target, err := os.Readlink(filepath.Join(dst, "data", "synapse", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "real", target)
+}
+
+func TestSwapDirs(t *testing.T) {
+	root := t.TempDir()
+	configLive := filepath.Join(root, "config")
+	configStaged := filepath.Join(root, ".config-restore")
+	dataLive := filepath.Join(root, "data")
+	dataStaged := filepath.Join(root, ".data-restore")
+
+	writeTestFile(t, filepath.Join(configLive, "settings.yaml"), "old")
+	writeTestFile(t, filepath.Join(configStaged, "settings.yaml"), "new")
+	writeTestFile(t, filepath.Join(dataStaged, "file"), "new data")
+
+	swaps := []*swap{
+		{live: configLive, staged: configStaged},
+		{live: dataLive, staged: dataStaged},
+	}
+	require.NoError(t, swapDirs(swaps))
+
+	content, err := os.ReadFile(filepath.Join(configLive, "settings.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	previous := previousDirs(swaps)
+	require.Len(t, previous, 1, "data had no live directory to keep")
+	assertRollbackOf(t, configLive, previous[0])
+
+	require.NoError(t, undoSwaps(swaps))
+	content, err = os.ReadFile(filepath.Join(configLive, "settings.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+	assert.NoDirExists(t, dataLive)
+	assert.FileExists(t, filepath.Join(dataStaged, "file"))
+}
+
+func TestSwapDirs_KeepsEarlierRollback(t *testing.T) {
+	root := t.TempDir()
+	live := filepath.Join(root, "data")
+	staged := filepath.Join(root, ".data-restore")
+	writeTestFile(t, filepath.Join(live, "file"), "old")
+	writeTestFile(t, filepath.Join(staged, "file"), "new")
+
+	// A rollback copy from an earlier restore, holding postgres files the
+	// host user can't delete
+	earlier := live + rollbackSuffix
+	writeTestFile(t, filepath.Join(earlier, "postgres", "PG_VERSION"), "16")
+	require.NoError(t, os.Chmod(filepath.Join(earlier, "postgres"), 0500))
+	t.Cleanup(func() { os.Chmod(filepath.Join(earlier, "postgres"), 0700) })
+
+	swaps := []*swap{{live: live, staged: staged}}
+	require.NoError(t, swapDirs(swaps))
+
+	assert.FileExists(t, filepath.Join(earlier, "postgres", "PG_VERSION"), "earlier rollback copy should be left alone")
+	assertRollbackOf(t, live, swaps[0].previous)
+	content, err := os.ReadFile(filepath.Join(swaps[0].previous, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	// A second restore in the same second still gets its own copy
+	writeTestFile(t, filepath.Join(staged, "file"), "newer")
+	again := []*swap{{live: live, staged: staged}}
+	require.NoError(t, swapDirs(again))
+	assertRollbackOf(t, live, again[0].previous)
+	assert.NotEqual(t, swaps[0].previous, again[0].previous)
+	assert.DirExists(t, swaps[0].previous)
+}
+
+func TestSwapDirs_UndoesOnFailure(t *testing.T) {
+	root := t.TempDir()
+	configLive := filepath.Join(root, "config")
+	configStaged := filepath.Join(root, ".config-restore")
+	writeTestFile(t, filepath.Join(configLive, "settings.yaml"), "old")
+	writeTestFile(t, filepath.Join(configStaged, "settings.yaml"), "new")
+
+	swaps := []*swap{
+		{live: configLive, staged: configStaged},
+		{live: filepath.Join(root, "data"), staged: filepath.Join(root, "missing")},
+	}
+	assert.Error(t, swapDirs(swaps))
+
+	content, err := os.ReadFile(filepath.Join(configLive, "settings.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+}
+
+func TestRestoreFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+
+	// Build the backup from a separate install
+	src := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(src, "config"))
+	cfg, err := config.NewDefaultConfig()
+	require.NoError(t, err)
+	cfg.ServerName = "restored.example.com"
+	require.NoError(t, cfg.Save())
+	srcConfig := config.ConfigDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+
+	srcData := filepath.Join(src, "data")
+	writeTestFile(t, filepath.Join(srcData, "synapse", "media_store", "abc"), "media")
+
+	path := filepath.Join(src, "backup.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(f, srcConfig, srcData, NewManifest(), nil, nil))
+	require.NoError(t, f.Close())
+
+	// A live install without a compose file, so no services need stopping
+	writeTestFile(t, filepath.Join(config.ConfigDir(), "stale.yaml"), "old")
+	writeTestFile(t, filepath.Join(config.DataDir(), "synapse", "old"), "old")
+
+	result, err := RestoreFile(path, RestoreOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, result.Manifest)
+	require.Len(t, result.Previous, 2)
+	assertRollbackOf(t, config.ConfigDir(), result.Previous[0])
+	assertRollbackOf(t, config.DataDir(), result.Previous[1])
+
+	restored, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "restored.example.com", restored.ServerName)
+	assert.FileExists(t, filepath.Join(config.DataDir(), "synapse", "media_store", "abc"))
+	assert.FileExists(t, config.DockerComposePath(), "compose file should be regenerated")
+	assert.NoFileExists(t, filepath.Join(config.ConfigDir(), "stale.yaml"))
+	assert.FileExists(t, filepath.Join(result.Previous[0], "stale.yaml"))
+}
+
+func TestRestoreFile_BadArchiveLeavesLiveState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	writeTestFile(t, config.SettingsPath(), "server_name: live")
+
+	path := filepath.Join(t.TempDir(), "evil.tar.gz")
+	buf := rawArchive(t,
+		&tar.Header{Name: "config/settings.yaml", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "data/../../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+	_, err := RestoreFile(path, RestoreOptions{})
+	assert.ErrorContains(t, err, "unsafe")
+
+	content, err := os.ReadFile(config.SettingsPath())
+	require.NoError(t, err)
+	assert.Equal(t, "server_name: live", string(content))
+
+	entries, err := os.ReadDir(filepath.Join(home, "config"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "staging directory should be cleaned up")
+}
+
+func TestCheckSchema(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current.yaml")
+	writeTestFile(t, current, fmt.Sprintf("schema_version: %d\n", config.SchemaVersion))
+	legacy := filepath.Join(dir, "legacy.yaml")
+	writeTestFile(t, legacy, "server_name: example.com\n")
+	newer := filepath.Join(dir, "newer.yaml")
+	writeTestFile(t, newer, fmt.Sprintf("schema_version: %d\n", config.SchemaVersion+1))
+
+	assert.NoError(t, checkSchema(&Manifest{SchemaVersion: config.SchemaVersion}, current))
+	assert.NoError(t, checkSchema(&Manifest{SchemaVersion: 1}, legacy))
+	assert.NoError(t, checkSchema(&Manifest{}, legacy), "older manifests don't record the schema")
+	assert.NoError(t, checkSchema(nil, legacy))
+
+	assert.ErrorContains(t, checkSchema(&Manifest{SchemaVersion: config.SchemaVersion + 1}, current), "Upgrade muxbee")
+	assert.ErrorContains(t, checkSchema(&Manifest{}, newer), "Upgrade muxbee")
+}
+
+func TestRestoreFile_NewerSchemaLeavesLiveState(t *testing.T) {
+	setupRestore(t)
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "config", "settings.yaml"), "server_name: backup.example.com\n")
+	writeTestFile(t, filepath.Join(src, "data", "synapse", "backup"), "backup")
+
+	path := filepath.Join(src, "backup.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	manifest := NewManifest()
+	manifest.SchemaVersion = config.SchemaVersion + 1
+	require.NoError(t, writeArchive(f, filepath.Join(src, "config"), filepath.Join(src, "data"), manifest, nil, nil))
+	require.NoError(t, f.Close())
+
+	_, err = RestoreFile(path, RestoreOptions{})
+	assert.ErrorContains(t, err, "Upgrade muxbee")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "live.example.com", cfg.ServerName)
+}
+
+// assertRollbackOf checks previous is a rollback copy of live
+func assertRollbackOf(t *testing.T, live, previous string) {
+	t.Helper()
+	assert.True(t, strings.HasPrefix(previous, live+rollbackSuffix+"-"), "expected a rollback copy of %s, got %s", live, previous)
+}
+
+// setupRestore points the config and data directories at a temp dir and
+// writes a live install with the signal bridge enabled
+func setupRestore(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+
+	cfg, err := config.NewDefaultConfig()
+	require.NoError(t, err)
+	cfg.ServerName = "live.example.com"
+	cfg.EnableBridge("signal")
+	require.NoError(t, cfg.Save())
+
+	writeTestFile(t, filepath.Join(config.DataDir(), "synapse", "live"), "live")
+	writeTestFile(t, filepath.Join(config.DataDir(), "bridges", "signal", "session"), "corrupt")
+	writeTestFile(t, filepath.Join(config.DataDir(), "bridges", "whatsapp", "session"), "live")
+	return home
+}
+
+// writeRestoreArchive writes a backup of a different install
+func writeRestoreArchive(t *testing.T) string {
+	t.Helper()
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "config", "settings.yaml"), "server_name: backup.example.com\nenabled_bridges: [signal]\n")
+	writeTestFile(t, filepath.Join(src, "data", "synapse", "backup"), "backup")
+	writeTestFile(t, filepath.Join(src, "data", "bridges", "signal", "session"), "good")
+	writeTestFile(t, filepath.Join(src, "data", "bridges", "whatsapp", "session"), "backup")
+
+	path := filepath.Join(src, "backup.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(f, filepath.Join(src, "config"), filepath.Join(src, "data"), NewManifest(), nil, nil))
+	require.NoError(t, f.Close())
+	return path
+}
+
+func TestRestoreFile_Bridge(t *testing.T) {
+	setupRestore(t)
+	path := writeRestoreArchive(t)
+
+	result, err := RestoreFile(path, RestoreOptions{Bridge: "signal"})
+	require.NoError(t, err)
+
+	signal := filepath.Join(config.DataDir(), "bridges", "signal")
+	require.Len(t, result.Previous, 1)
+	assertRollbackOf(t, signal, result.Previous[0])
+
+	content, err := os.ReadFile(filepath.Join(signal, "session"))
+	require.NoError(t, err)
+	assert.Equal(t, "good", string(content))
+
+	// Everything else is left alone
+	content, err = os.ReadFile(filepath.Join(config.DataDir(), "bridges", "whatsapp", "session"))
+	require.NoError(t, err)
+	assert.Equal(t, "live", string(content))
+	assert.FileExists(t, filepath.Join(config.DataDir(), "synapse", "live"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "live.example.com", cfg.ServerName)
+}
+
+func TestRestoreFile_BridgeNotInBackup(t *testing.T) {
+	setupRestore(t)
+	path := writeRestoreArchive(t)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.EnableBridge("telegram")
+	require.NoError(t, cfg.Save())
+
+	_, err = RestoreFile(path, RestoreOptions{Bridge: "telegram"})
+	assert.ErrorContains(t, err, "no data for bridge 'telegram'")
+}
+
+func TestRestoreFile_DataOnly(t *testing.T) {
+	setupRestore(t)
+	path := writeRestoreArchive(t)
+
+	_, err := RestoreFile(path, RestoreOptions{DataOnly: true})
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(config.DataDir(), "synapse", "backup"))
+	assert.NoFileExists(t, filepath.Join(config.DataDir(), "synapse", "live"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "live.example.com", cfg.ServerName)
+}
+
+func TestRestoreOptions_Targets(t *testing.T) {
+	_, err := RestoreOptions{ConfigOnly: true, DataOnly: true}.targets()
+	assert.Error(t, err)
+
+	_, err = RestoreOptions{Bridge: "../synapse"}.targets()
+	assert.Error(t, err)
+
+	targets, err := RestoreOptions{ConfigOnly: true}.targets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "config/", targets[0].prefix)
+}
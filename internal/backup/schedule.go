@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"filippo.io/age"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// snapshotPattern matches archive names written by the scheduler and by
+// 'muxbee backup' with its default name
+var snapshotPattern = regexp.MustCompile(`^muxbee-backup-(\d{8}-\d{6})(-incr)?\.tar\.gz(\.age)?$`)
+
+// snapshotTimeFormat is the timestamp format used in archive names
+const snapshotTimeFormat = "20060102-150405"
+
+// Snapshot is a backup archive found in a backup directory
+type Snapshot struct {
+	Name        string
+	Time        time.Time
+	Incremental bool
+	Parent      string // The full backup an incremental builds on, "" if unknown
+}
+
+// SnapshotName returns the archive name for a backup taken at t
+func SnapshotName(t time.Time, incremental, encrypted bool) string {
+	name := "muxbee-backup-" + t.Format(snapshotTimeFormat)
+	if incremental {
+		name += "-incr"
+	}
+	name += ".tar.gz"
+	if encrypted {
+		name += EncryptedExt
+	}
+	return name
+}
+
+// ListSnapshots returns the backups in dir, newest first
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, e := range entries {
+		m := snapshotPattern.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(snapshotTimeFormat, m[1], time.Local)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: e.Name(), Time: t, Incremental: m[2] != ""})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// latestFull returns the newest full backup taken at or before t
+func latestFull(snapshots []Snapshot, t time.Time) *Snapshot {
+	for i := range snapshots {
+		s := &snapshots[i]
+		if !s.Incremental && !s.Time.After(t) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Policy decides which backups to keep
+type Policy struct {
+	KeepDaily  int // Keep the newest backup of each of the last N days that have one
+	KeepWeekly int // Keep the newest backup of each of the last N ISO weeks that have one
+}
+
+// Prune splits snapshots (newest first) into those to keep and those to
+// delete. The newest backup is always kept, and so is the full backup every
+// kept incremental builds on: its Parent, or every older full backup when
+// that is unknown. A policy of all zeros keeps everything.
+func (p Policy) Prune(snapshots []Snapshot) (keep, remove []Snapshot) {
+	if p.KeepDaily <= 0 && p.KeepWeekly <= 0 {
+		return snapshots, nil
+	}
+
+	kept := make(map[string]bool)
+	if len(snapshots) > 0 {
+		kept[snapshots[0].Name] = true
+	}
+
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, s := range snapshots {
+		day := s.Time.Format("2006-01-02")
+		if !days[day] && len(days) < p.KeepDaily {
+			days[day] = true
+			kept[s.Name] = true
+		}
+
+		year, week := s.Time.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < p.KeepWeekly {
+			weeks[weekKey] = true
+			kept[s.Name] = true
+		}
+	}
+
+	for _, s := range snapshots {
+		if !kept[s.Name] || !s.Incremental {
+			continue
+		}
+		if s.Parent != "" {
+			kept[s.Parent] = true
+			continue
+		}
+		// No telling which one it needs, so none that it might is deleted
+		for _, full := range snapshots {
+			if !full.Incremental && !full.Time.After(s.Time) {
+				kept[full.Name] = true
+			}
+		}
+	}
+
+	for _, s := range snapshots {
+		if kept[s.Name] {
+			keep = append(keep, s)
+		} else {
+			remove = append(remove, s)
+		}
+	}
+	return keep, remove
+}
+
+// Schedule takes backups into a directory and applies a retention policy
+type Schedule struct {
+	Dir        string
+	Policy     Policy
+	FullEvery  time.Duration     // Take a full backup when the latest full is older than this
+	Recipients []age.Recipient   // Encrypt archives to these recipients (none = plain)
+	Keys       Keys              // For reading the parent manifest of encrypted backups
+	Progress   func(step string) // May be nil
+
+	MuxbeeVersion string // Recorded in each manifest
+}
+
+// RunResult describes one scheduled backup
+type RunResult struct {
+	Path     string
+	Manifest *Manifest
+	Pruned   []string
+	Note     string // Why a full backup was taken instead of an incremental, if relevant
+}
+
+// Run takes one backup (incremental if a recent full backup exists) and
+// then deletes the backups the policy no longer keeps
+func (s *Schedule) Run(cfg *config.Config, now time.Time) (*RunResult, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	snapshots, err := ListSnapshots(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RunResult{}
+	opts := CreateOptions{Progress: s.Progress, MuxbeeVersion: s.MuxbeeVersion}
+
+	if parent := latestFull(snapshots, now); parent != nil && now.Sub(parent.Time) < s.FullEvery {
+		manifest, err := s.readManifest(parent.Name)
+		switch {
+		case err != nil:
+			result.Note = fmt.Sprintf("full backup, could not read %s: %v", parent.Name, err)
+		case manifest == nil || manifest.Files == nil:
+			result.Note = fmt.Sprintf("full backup, %s has no file hashes", parent.Name)
+		default:
+			opts.Parent = manifest
+			opts.ParentName = parent.Name
+		}
+	}
+
+	name := SnapshotName(now, opts.Parent != nil, len(s.Recipients) > 0)
+	result.Path = filepath.Join(s.Dir, name)
+
+	manifest, err := s.create(cfg, result.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Manifest = manifest
+
+	// Re-list so the new backup counts towards the policy
+	snapshots, err = ListSnapshots(s.Dir)
+	if err != nil {
+		return result, err
+	}
+	s.readParents(snapshots)
+	_, remove := s.Policy.Prune(snapshots)
+	for _, old := range remove {
+		if err := os.Remove(filepath.Join(s.Dir, old.Name)); err != nil {
+			return result, fmt.Errorf("failed to remove old backup %s: %w", old.Name, err)
+		}
+		result.Pruned = append(result.Pruned, old.Name)
+	}
+
+	return result, nil
+}
+
+// create writes one archive via a temporary file so a failed run never
+// leaves a partial backup that looks complete
+func (s *Schedule) create(cfg *config.Config, path string, opts CreateOptions) (*Manifest, error) {
+	tmpPath := path + ".partial"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	manifest, err := s.write(f, cfg, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (s *Schedule) write(f *os.File, cfg *config.Config, opts CreateOptions) (*Manifest, error) {
+	if len(s.Recipients) == 0 {
+		return Create(f, cfg, opts)
+	}
+
+	ew, err := Encrypt(f, s.Recipients...)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := Create(ew, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, ew.Close()
+}
+
+// readParents sets the Parent of each incremental from its manifest. One
+// that can't be read is left unknown, which Prune treats with caution.
+func (s *Schedule) readParents(snapshots []Snapshot) {
+	for i := range snapshots {
+		if !snapshots[i].Incremental {
+			continue
+		}
+		if manifest, err := s.readManifest(snapshots[i].Name); err == nil && manifest != nil {
+			snapshots[i].Parent = manifest.Parent
+		}
+	}
+}
+
+// readManifest reads the manifest of a backup in the schedule directory
+func (s *Schedule) readManifest(name string) (*Manifest, error) {
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, _, err := Open(f, s.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return ReadManifest(r)
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotAt(t *testing.T, ts string, incremental bool) Snapshot {
+	t.Helper()
+	tm, err := time.ParseInLocation("2006-01-02 15:04", ts, time.Local)
+	require.NoError(t, err)
+	return Snapshot{Name: SnapshotName(tm, incremental, false), Time: tm, Incremental: incremental}
+}
+
+func names(snapshots []Snapshot) []string {
+	var out []string
+	for _, s := range snapshots {
+		out = append(out, s.Name)
+	}
+	return out
+}
+
+func TestSnapshotName(t *testing.T) {
+	tm := time.Date(2026, 3, 14, 3, 0, 0, 0, time.Local)
+	assert.Equal(t, "muxbee-backup-20260314-030000.tar.gz", SnapshotName(tm, false, false))
+	assert.Equal(t, "muxbee-backup-20260314-030000-incr.tar.gz.age", SnapshotName(tm, true, true))
+}
+
+func TestListSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"muxbee-backup-20260301-030000.tar.gz",
+		"muxbee-backup-20260302-030000-incr.tar.gz.age",
+		"muxbee-backup-20260303-030000.tar.gz.partial",
+		"notes.txt",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	snapshots, err := ListSnapshots(dir)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, "muxbee-backup-20260302-030000-incr.tar.gz.age", snapshots[0].Name)
+	assert.True(t, snapshots[0].Incremental)
+	assert.Equal(t, "muxbee-backup-20260301-030000.tar.gz", snapshots[1].Name)
+	assert.False(t, snapshots[1].Incremental)
+}
+
+func TestPolicyPrune_Daily(t *testing.T) {
+	// Newest first, as returned by ListSnapshots
+	snapshots := []Snapshot{
+		snapshotAt(t, "2026-03-05 15:00", true),
+		snapshotAt(t, "2026-03-05 03:00", true),
+		snapshotAt(t, "2026-03-04 03:00", true),
+		snapshotAt(t, "2026-03-03 03:00", false),
+		snapshotAt(t, "2026-03-02 03:00", true),
+		snapshotAt(t, "2026-03-01 03:00", false),
+	}
+	for _, i := range []int{0, 1, 2} {
+		snapshots[i].Parent = snapshots[3].Name
+	}
+	snapshots[4].Parent = snapshots[5].Name
+
+	keep, remove := Policy{KeepDaily: 2}.Prune(snapshots)
+
+	// Newest per day for the last two days, plus the full they depend on
+	assert.Equal(t, []string{snapshots[0].Name, snapshots[2].Name, snapshots[3].Name}, names(keep))
+	assert.Equal(t, []string{snapshots[1].Name, snapshots[4].Name, snapshots[5].Name}, names(remove))
+}
+
+func TestPolicyPrune_KeepsRecordedParent(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshotAt(t, "2026-03-05 03:00", true),
+		snapshotAt(t, "2026-03-04 03:00", false), // Taken by hand, not the parent
+		snapshotAt(t, "2026-03-03 03:00", false),
+		snapshotAt(t, "2026-03-02 03:00", false),
+	}
+	snapshots[0].Parent = snapshots[2].Name
+
+	keep, remove := Policy{KeepDaily: 1}.Prune(snapshots)
+	assert.Equal(t, []string{snapshots[0].Name, snapshots[2].Name}, names(keep))
+	assert.Equal(t, []string{snapshots[1].Name, snapshots[3].Name}, names(remove))
+}
+
+func TestPolicyPrune_UnknownParent(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshotAt(t, "2026-03-05 03:00", true),
+		snapshotAt(t, "2026-03-04 03:00", false),
+		snapshotAt(t, "2026-03-03 03:00", false),
+		snapshotAt(t, "2026-03-06 03:00", false), // Newer than the incremental
+	}
+
+	keep, remove := Policy{KeepDaily: 1}.Prune(snapshots)
+	assert.Equal(t, []string{snapshots[0].Name, snapshots[1].Name, snapshots[2].Name}, names(keep))
+	assert.Equal(t, []string{snapshots[3].Name}, names(remove))
+}
+
+func TestScheduleReadParents(t *testing.T) {
+	dir := t.TempDir()
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "config", "settings.yaml"), "server_name: example.com\n")
+	writeTestFile(t, filepath.Join(src, "data", "synapse", "homeserver.db"), "data")
+
+	full := "muxbee-backup-20260301-030000.tar.gz"
+	incr := "muxbee-backup-20260302-030000-incr.tar.gz"
+	for name, parent := range map[string]string{full: "", incr: full} {
+		f, err := os.Create(filepath.Join(dir, name))
+		require.NoError(t, err)
+		manifest := NewManifest()
+		manifest.Parent = parent
+		require.NoError(t, writeArchive(f, filepath.Join(src, "config"), filepath.Join(src, "data"), manifest, nil, nil))
+		require.NoError(t, f.Close())
+	}
+	// Unreadable, so its parent stays unknown
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "muxbee-backup-20260303-030000-incr.tar.gz"), []byte("garbage"), 0600))
+
+	snapshots, err := ListSnapshots(dir)
+	require.NoError(t, err)
+	(&Schedule{Dir: dir}).readParents(snapshots)
+
+	require.Len(t, snapshots, 3)
+	assert.Equal(t, "", snapshots[0].Parent)
+	assert.Equal(t, full, snapshots[1].Parent)
+	assert.Equal(t, "", snapshots[2].Parent)
+}
+
+func TestPolicyPrune_Weekly(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshotAt(t, "2026-03-16 03:00", false), // ISO week 12
+		snapshotAt(t, "2026-03-10 03:00", false), // week 11
+		snapshotAt(t, "2026-03-09 03:00", false), // week 11
+		snapshotAt(t, "2026-03-02 03:00", false), // week 10
+		snapshotAt(t, "2026-02-23 03:00", false), // week 9
+	}
+
+	keep, _ := Policy{KeepDaily: 1, KeepWeekly: 3}.Prune(snapshots)
+	assert.Equal(t, []string{snapshots[0].Name, snapshots[1].Name, snapshots[3].Name}, names(keep))
+}
+
+func TestPolicyPrune_KeepAll(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshotAt(t, "2026-03-02 03:00", false),
+		snapshotAt(t, "2026-03-01 03:00", false),
+	}
+
+	keep, remove := Policy{}.Prune(snapshots)
+	assert.Len(t, keep, 2)
+	assert.Empty(t, remove)
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+// sqliteBackupScript copies the database in argv[1] to argv[2] with the
+// online backup API. It runs on the Python in the synapse image, which the
+// stack already has, when the host has no sqlite3.
+const sqliteBackupScript = `import sqlite3, sys
+src = sqlite3.connect(sys.argv[1])
+dst = sqlite3.connect(sys.argv[2])
+src.backup(dst)
+dst.close()
+src.close()
+`
+
+// sqliteSidecars are the files SQLite keeps next to a WAL-mode database
+var sqliteSidecars = []string{"-wal", "-shm", "-journal"}
+
+// FindSQLiteDatabases returns the SQLite databases in each bridge data directory
+func FindSQLiteDatabases(bridgesDataDir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(bridgesDataDir, "*", "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// BackupSQLite copies a live SQLite database to dst using the online backup
+// API (sqlite3 .backup), which produces a consistent copy with the WAL folded in.
+// Uses the host's sqlite3 if available, otherwise a throwaway container of
+// image, the stack's synapse image, on rt.
+func BackupSQLite(src, dst string, rt docker.Runtime, image string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		cmd = exec.Command(sqlite3, src, ".backup "+sqliteQuote(dst))
+	} else {
+		mount := ""
+		if label := rt.MountLabel(); label != "" {
+			mount = ":" + label
+		}
+		cmd = exec.Command(rt.Binary, "run", "--rm", "--user", "root", "--network", "none",
+			"-v", filepath.Dir(src)+":/src"+mount,
+			"-v", filepath.Dir(dst)+":/dst"+mount,
+			"--entrypoint", "python",
+			image,
+			"-c", sqliteBackupScript,
+			"/src/"+filepath.Base(src), "/dst/"+filepath.Base(dst))
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("sqlite3 backup of %s: %s", filepath.Base(src), msg)
+		}
+		return fmt.Errorf("sqlite3 backup of %s: %w", filepath.Base(src), err)
+	}
+	return nil
+}
+
+// isSQLiteSidecar reports whether path is the -wal/-shm/-journal file of db
+func isSQLiteSidecar(path, db string) bool {
+	for _, suffix := range sqliteSidecars {
+		if path == db+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// removeSQLiteSidecars deletes stale WAL files so they aren't replayed over a restored database
+func removeSQLiteSidecars(db string) error {
+	for _, suffix := range sqliteSidecars {
+		if err := os.Remove(db + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteQuote quotes a path for use as a sqlite3 dot-command argument
+func sqliteQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
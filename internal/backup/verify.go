@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Report describes a backup archive without extracting it
+type Report struct {
+	Path      string
+	Encrypted bool
+	Manifest  *Manifest // nil for archives made before manifests existed
+
+	Files  int              // Regular files stored in this archive
+	Size   int64            // Total size of those files
+	Groups map[string]int64 // Size per restore target, e.g. "config", "data/synapse", "data/bridges/signal"
+
+	Problems []string // Integrity problems; empty means the archive verified
+
+	stored map[string]bool // Regular files present in the archive
+}
+
+// OK reports whether no integrity problems were found
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// GroupNames returns the restore targets in sorted order
+func (r *Report) GroupNames() []string {
+	names := make([]string, 0, len(r.Groups))
+	for name := range r.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Report) problemf(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// VerifyFile reads the whole backup at path, checking every file against
+// the SHA-256 in its manifest. For incremental backups, the inherited files
+// are checked in the parent backup too. Nothing is written to disk.
+func VerifyFile(path string, keys Keys) (*Report, error) {
+	report, err := scanFile(path, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m := report.Manifest
+	if m == nil || !m.IsIncremental() {
+		return report, nil
+	}
+
+	inherited := make(map[string]bool)
+	for _, name := range m.Inherited {
+		inherited[name] = true
+	}
+
+	parentPath := filepath.Join(filepath.Dir(path), m.Parent)
+	if _, err := os.Stat(parentPath); err != nil {
+		report.problemf("parent backup %s not found next to this backup", m.Parent)
+		return report, nil
+	}
+
+	parent, err := scanFile(parentPath, keys, func(name string) (string, bool) {
+		if !inherited[name] {
+			return "", false
+		}
+		return m.Files[name], true
+	})
+	if err != nil {
+		report.problemf("parent backup %s: %v", m.Parent, err)
+		return report, nil
+	}
+	for _, p := range parent.Problems {
+		report.problemf("parent backup %s: %s", m.Parent, p)
+	}
+	for _, name := range m.Inherited {
+		if !parent.stored[name] {
+			report.problemf("%s is inherited but missing from parent backup %s", name, m.Parent)
+		}
+	}
+
+	return report, nil
+}
+
+// scanFile reads an archive, hashing every file. By default files are
+// checked against the archive's own manifest; expect overrides that and
+// limits which entries are checked.
+func scanFile(path string, keys Keys, expect func(name string) (string, bool)) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer f.Close()
+
+	r, encrypted, err := Open(f, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Path:      path,
+		Encrypted: encrypted,
+		Groups:    make(map[string]int64),
+		stored:    make(map[string]bool),
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a muxbee backup: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.problemf("archive is truncated or corrupt: %v", err)
+			return report, nil
+		}
+
+		if header.Name == ManifestName {
+			data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+			if err != nil {
+				report.problemf("failed to read manifest: %v", err)
+				continue
+			}
+			if report.Manifest, err = ParseManifest(data); err != nil {
+				report.problemf("invalid manifest: %v", err)
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, tr)
+		if err != nil {
+			report.problemf("archive is truncated or corrupt at %s: %v", header.Name, err)
+			return report, nil
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		report.stored[header.Name] = true
+
+		report.Files++
+		report.Size += n
+		report.Groups[restoreGroup(header.Name)] += n
+
+		want, check := "", false
+		if expect != nil {
+			want, check = expect(header.Name)
+		} else if report.Manifest != nil && report.Manifest.Files != nil {
+			want, check = report.Manifest.Files[header.Name]
+			if !check {
+				report.problemf("%s is not listed in the manifest", header.Name)
+			}
+		}
+		if check && sum != want {
+			report.problemf("checksum mismatch for %s", header.Name)
+		}
+	}
+
+	if expect == nil && report.Manifest != nil {
+		inherited := make(map[string]bool)
+		for _, name := range report.Manifest.Inherited {
+			inherited[name] = true
+		}
+		names := make([]string, 0, len(report.Manifest.Files))
+		for name := range report.Manifest.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !report.stored[name] && !inherited[name] {
+				report.problemf("%s is listed in the manifest but missing", name)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// restoreGroup maps an archive path to what it restores: config, a
+// top-level data directory, a single bridge's data, or a dump
+func restoreGroup(name string) string {
+	parts := strings.Split(name, "/")
+	switch {
+	case parts[0] == "data" && len(parts) > 3 && parts[1] == "bridges":
+		return "data/bridges/" + parts[2]
+	case parts[0] == "data" && len(parts) > 2:
+		return "data/" + parts[1]
+	default:
+		return parts[0]
+	}
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeVerifyFixture(t *testing.T) (dir, configDir, dataDir string) {
+	t.Helper()
+	dir = t.TempDir()
+	configDir = filepath.Join(dir, "config")
+	dataDir = filepath.Join(dir, "data")
+	writeTestFile(t, filepath.Join(configDir, "settings.yaml"), "server_name: test")
+	writeTestFile(t, filepath.Join(dataDir, "synapse", "media_store", "abc"), "media")
+	writeTestFile(t, filepath.Join(dataDir, "bridges", "signal", "config.yaml"), "bridge config")
+	return dir, configDir, dataDir
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir, configDir, dataDir := writeVerifyFixture(t)
+
+	manifest := NewManifest()
+	manifest.ServerName = "example.com"
+	path := filepath.Join(dir, "backup.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(f, configDir, dataDir, manifest, nil, nil))
+	require.NoError(t, f.Close())
+
+	report, err := VerifyFile(path, Keys{})
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.False(t, report.Encrypted)
+	assert.Equal(t, "example.com", report.Manifest.ServerName)
+	assert.Equal(t, 3, report.Files)
+	assert.Equal(t, []string{"config", "data/bridges/signal", "data/synapse"}, report.GroupNames())
+}
+
+func TestVerifyFile_ChecksumMismatch(t *testing.T) {
+	dir, configDir, _ := writeVerifyFixture(t)
+
+	manifest := NewManifest()
+	manifest.Files = map[string]string{
+		"config/settings.yaml": "0000",
+		"config/missing.yaml":  "0000",
+	}
+	data, err := manifest.Marshal()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	aw := NewWriter(&buf)
+	require.NoError(t, aw.AddBytes(ManifestName, data))
+	require.NoError(t, aw.AddDir(configDir, "config", nil))
+	require.NoError(t, aw.Close())
+
+	path := filepath.Join(dir, "backup.tar.gz")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+	report, err := VerifyFile(path, Keys{})
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Contains(t, report.Problems, "checksum mismatch for config/settings.yaml")
+	assert.Contains(t, report.Problems, "config/missing.yaml is listed in the manifest but missing")
+}
+
+func TestVerifyFile_MissingParent(t *testing.T) {
+	dir, configDir, dataDir := writeVerifyFixture(t)
+
+	full := NewManifest()
+	var discard bytes.Buffer
+	require.NoError(t, writeArchive(&discard, configDir, dataDir, full, nil, nil))
+
+	incr := NewManifest()
+	incr.Parent = "full.tar.gz"
+	path := filepath.Join(dir, "incr.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writeArchive(f, configDir, dataDir, incr, nil, full))
+	require.NoError(t, f.Close())
+
+	report, err := VerifyFile(path, Keys{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"parent backup full.tar.gz not found next to this backup"}, report.Problems)
+
+	// With the parent in place the inherited files verify against it
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "full.tar.gz"), discard.Bytes(), 0600))
+	report, err = VerifyFile(path, Keys{})
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems)
+}
+
+func TestRestoreGroup(t *testing.T) {
+	tests := map[string]string{
+		"config/settings.yaml":                  "config",
+		"data/synapse/media_store/abc":          "data/synapse",
+		"data/bridges/signal/mautrix-signal.db": "data/bridges/signal",
+		"data/bridges/README":                   "data/bridges",
+		"dumps/synapse.pgdump":                  "dumps",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, restoreGroup(name), name)
+	}
+}
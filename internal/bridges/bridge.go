@@ -10,28 +10,56 @@ type BridgeInfo struct {
 	Note                   string `yaml:"note,omitempty"`                     // Optional note about the bridge
 	RequiresAPICredentials bool   `yaml:"requires_api_credentials,omitempty"` // Requires user to provide API credentials
 	LoginInstructions      string `yaml:"login_instructions"`
+	LoginCommand           string `yaml:"login_command,omitempty"` // Starts an interactive login with the bot
+
+	// Optional overrides, mostly used by user-defined bridges in bridges.d
+	ImageOverride       string `yaml:"image,omitempty"`
+	ServiceNameOverride string `yaml:"service_name,omitempty"`
+	BotUsernameOverride string `yaml:"bot_username,omitempty"`
+	NamespaceOverride   string `yaml:"namespace,omitempty"`
+
+	TemplatePath string `yaml:"-"` // Config template on disk; empty means the embedded template
+	Custom       bool   `yaml:"-"` // Loaded from the user's bridges.d directory
 }
 
 // Image returns the Docker image for this bridge
 func (b BridgeInfo) Image() string {
+	if b.ImageOverride != "" {
+		return b.ImageOverride
+	}
 	return fmt.Sprintf("dock.mau.dev/mautrix/%s:latest", b.Name)
 }
 
 // BotUsername returns the Matrix username for the bridge bot
 func (b BridgeInfo) BotUsername() string {
+	if b.BotUsernameOverride != "" {
+		return b.BotUsernameOverride
+	}
 	return b.Name + "bot"
 }
 
 // NamespacePrefix returns the namespace prefix for bridge users/rooms
 func (b BridgeInfo) NamespacePrefix() string {
+	if b.NamespaceOverride != "" {
+		return b.NamespaceOverride
+	}
 	return b.Name + "_"
 }
 
 // ServiceName returns the Docker Compose service name
 func (b BridgeInfo) ServiceName() string {
+	if b.ServiceNameOverride != "" {
+		return b.ServiceNameOverride
+	}
 	return "mautrix-" + b.Name
 }
 
+// HasInteractiveLogin returns true if muxbee can walk through this
+// bridge's login with its bot
+func (b BridgeInfo) HasInteractiveLogin() bool {
+	return b.LoginCommand != ""
+}
+
 // HasNote returns true if this bridge has a note
 func (b BridgeInfo) HasNote() bool {
 	return b.Note != ""
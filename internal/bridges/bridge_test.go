@@ -33,3 +33,26 @@ func TestBridgeInfo_HasNote(t *testing.T) {
 	withoutNote := BridgeInfo{}
 	assert.False(t, withoutNote.HasNote())
 }
+
+func TestBridgeInfo_Overrides(t *testing.T) {
+	b := BridgeInfo{
+		Name:                "heisenbridge",
+		ImageOverride:       "hif1/heisenbridge:1.15",
+		ServiceNameOverride: "heisenbridge",
+		BotUsernameOverride: "heisenbridge",
+		NamespaceOverride:   "irc_",
+	}
+	assert.Equal(t, "hif1/heisenbridge:1.15", b.Image())
+	assert.Equal(t, "heisenbridge", b.ServiceName())
+	assert.Equal(t, "heisenbridge", b.BotUsername())
+	assert.Equal(t, "irc_", b.NamespacePrefix())
+}
+
+func TestBridgeInfo_HasInteractiveLogin(t *testing.T) {
+	assert.True(t, BridgeInfo{LoginCommand: "login qr"}.HasInteractiveLogin())
+	assert.False(t, BridgeInfo{}.HasInteractiveLogin())
+
+	// Cookie bridges need the user to extract cookies first
+	assert.Equal(t, "login qr", Get("whatsapp").LoginCommand)
+	assert.False(t, Get("meta").HasInteractiveLogin())
+}
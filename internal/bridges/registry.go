@@ -2,7 +2,11 @@ package bridges
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 
 	"gopkg.in/yaml.v3"
@@ -34,6 +38,107 @@ func loadRegistry() error {
 	return nil
 }
 
+// validName matches bridge names that are safe to use in paths, service names and profiles
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// LoadUserBridges merges user-defined bridges from dir into the registry.
+//
+// Every *.yaml file in dir uses the same format as the embedded bridges.yaml.
+// A bridge named "foo" may ship its config template as foo.yaml.tmpl next to
+// it; without one, the embedded template of the same name is used. Entries
+// with the name of a built-in bridge replace it. A new bridge has no
+// embedded template or default image, so it needs both a template file and
+// an image for its compose service.
+//
+// Every file is checked before any is merged: if one is invalid, the
+// registry keeps only the built-in bridges and all problems are returned.
+// A missing dir is not an error.
+func LoadUserBridges(dir string) error {
+	if err := loadRegistry(); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	loaded := make(map[string]BridgeInfo)
+	definedIn := make(map[string]string)
+	var errs []error
+	for _, file := range files {
+		base := filepath.Base(file)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var raw map[string]BridgeInfo
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", base, err))
+			continue
+		}
+
+		names := make([]string, 0, len(raw))
+		for name := range raw {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			info, err := userBridge(dir, name, raw[name])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", base, err))
+				continue
+			}
+			if other, ok := definedIn[name]; ok {
+				errs = append(errs, fmt.Errorf("%s: bridge %q is already defined in %s", base, name, other))
+				continue
+			}
+			definedIn[name] = base
+			loaded[name] = info
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for name, info := range loaded {
+		registry[name] = info
+	}
+	return nil
+}
+
+// userBridge checks one entry of a bridges.d file
+func userBridge(dir, name string, info BridgeInfo) (BridgeInfo, error) {
+	if !validName.MatchString(name) {
+		return info, fmt.Errorf("invalid bridge name %q", name)
+	}
+	if info.Port <= 0 {
+		return info, fmt.Errorf("bridge %q needs a port", name)
+	}
+
+	info.Name = name
+	info.Custom = true
+
+	tmplPath := filepath.Join(dir, name+".yaml.tmpl")
+	if _, err := os.Stat(tmplPath); err == nil {
+		info.TemplatePath = tmplPath
+	}
+
+	if _, builtin := registry[name]; !builtin {
+		if info.TemplatePath == "" {
+			return info, fmt.Errorf("bridge %q needs a config template, %s", name, filepath.Base(tmplPath))
+		}
+		if info.ImageOverride == "" {
+			return info, fmt.Errorf("bridge %q needs an image for its compose service", name)
+		}
+	}
+	return info, nil
+}
+
 // Get returns bridge info by name, or nil if not found
 func Get(name string) *BridgeInfo {
 	if info, ok := registry[name]; ok {
@@ -54,7 +159,6 @@ func List() []BridgeInfo {
 	return bridges
 }
 
-
 // Names returns all bridge names sorted alphabetically
 func Names() []string {
 	names := make([]string, 0, len(registry))
@@ -65,6 +169,15 @@ func Names() []string {
 	return names
 }
 
+// ServiceNameFor returns the compose service name for a bridge, falling back
+// to the mautrix naming scheme for names missing from the registry
+func ServiceNameFor(name string) string {
+	if info, ok := registry[name]; ok {
+		return info.ServiceName()
+	}
+	return "mautrix-" + name
+}
+
 // Exists checks if a bridge with the given name exists
 func Exists(name string) bool {
 	_, ok := registry[name]
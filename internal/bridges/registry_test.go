@@ -1,6 +1,8 @@
 package bridges
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -80,3 +82,103 @@ func TestBridgeDetails(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadUserBridges(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, loadRegistry()) })
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team.yaml"), []byte(`
+heisenbridge:
+  description: IRC via Heisenbridge
+  port: 9898
+  image: hif1/heisenbridge:latest
+  service_name: heisenbridge
+  bot_username: heisenbridge
+  namespace: hbirc_
+  login_instructions: Chat with @heisenbridge:SERVER
+whatsapp:
+  description: WhatsApp (team fork)
+  port: 29318
+  image: registry.example.com/whatsapp:fork
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "heisenbridge.yaml.tmpl"), []byte("id: heisenbridge\n"), 0644))
+
+	require.NoError(t, LoadUserBridges(dir))
+
+	hb := Get("heisenbridge")
+	require.NotNil(t, hb)
+	assert.True(t, hb.Custom)
+	assert.Equal(t, 9898, hb.Port)
+	assert.Equal(t, "hif1/heisenbridge:latest", hb.Image())
+	assert.Equal(t, "heisenbridge", hb.ServiceName())
+	assert.Equal(t, "hbirc_", hb.NamespacePrefix())
+	assert.Equal(t, filepath.Join(dir, "heisenbridge.yaml.tmpl"), hb.TemplatePath)
+
+	// Built-ins can be replaced; without a template file the embedded one is used
+	wa := Get("whatsapp")
+	require.NotNil(t, wa)
+	assert.Equal(t, "registry.example.com/whatsapp:fork", wa.Image())
+	assert.Empty(t, wa.TemplatePath)
+
+	assert.Len(t, List(), 14)
+	assert.Equal(t, "heisenbridge", ServiceNameFor("heisenbridge"))
+}
+
+func TestLoadUserBridges_MissingDir(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, loadRegistry()) })
+
+	require.NoError(t, LoadUserBridges(filepath.Join(t.TempDir(), "nope")))
+	assert.Len(t, List(), 13)
+}
+
+func TestLoadUserBridges_Invalid(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, loadRegistry()) })
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no port", "mybridge:\n  description: x\n"},
+		{"bad name", "../evil:\n  port: 1234\n"},
+		{"bad yaml", "mybridge: [\n"},
+		{"no template", "mybridge:\n  port: 1234\n  image: example.com/mybridge\n"},
+		{"no image", "tmplbridge:\n  port: 1234\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(tt.content), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "tmplbridge.yaml.tmpl"), []byte("id: x\n"), 0644))
+			assert.Error(t, LoadUserBridges(dir))
+		})
+	}
+}
+
+func TestLoadUserBridges_AllOrNothing(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, loadRegistry()) })
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(`
+whatsapp:
+  port: 29318
+  image: registry.example.com/whatsapp:fork
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("mybridge:\n  port: 1234\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.yaml"), []byte("whatsapp:\n  port: 1\n"), 0644))
+
+	err := LoadUserBridges(dir)
+	require.Error(t, err)
+	// Every problem is reported, not just the first
+	assert.Contains(t, err.Error(), "b.yaml")
+	assert.Contains(t, err.Error(), "already defined in a.yaml")
+
+	// The valid file wasn't merged either
+	assert.Equal(t, "dock.mau.dev/mautrix/whatsapp:latest", Get("whatsapp").Image())
+	assert.Len(t, List(), 13)
+}
+
+func TestServiceNameFor(t *testing.T) {
+	assert.Equal(t, "mautrix-whatsapp", ServiceNameFor("whatsapp"))
+	assert.Equal(t, "mautrix-unknown", ServiceNameFor("unknown"))
+}
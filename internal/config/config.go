@@ -6,23 +6,62 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// SchemaVersion is the layout version of settings.yaml written by this
+// muxbee. Bump it when the file changes in a way older muxbee releases
+// can't read. Files without one predate it and have version 1.
+const SchemaVersion = 1
+
 // Config represents the muxbee settings
 type Config struct {
+	SchemaVersion      int                     `yaml:"schema_version"` // Set by Save
 	ServerName         string                  `yaml:"server_name"`
 	ConnectivityMode   string                  `yaml:"connectivity_mode"` // local, private, public
+	Runtime            string                  `yaml:"runtime,omitempty"` // docker, podman; empty = detect
 	ElementEnabled     *bool                   `yaml:"element_enabled,omitempty"` // nil = true (default)
 	Ports              PortsConfig             `yaml:"ports,omitempty"`
 	Postgres           PostgresConfig          `yaml:"postgres"`
 	Admin              AdminConfig             `yaml:"admin"`
+	Users              []UserConfig            `yaml:"users,omitempty"`
+	Registration       RegistrationConfig      `yaml:"registration,omitempty"`
 	HTTPS              HTTPSConfig             `yaml:"https"`
 	EnabledBridges     []string                `yaml:"enabled_bridges"`
 	BridgeTokens       map[string]BridgeTokens `yaml:"bridge_tokens,omitempty"`
 	Telegram           *TelegramConfig         `yaml:"telegram,omitempty"`
 	DoublePuppetTokens *BridgeTokens           `yaml:"double_puppet_tokens,omitempty"`
+	Versions           map[string]string       `yaml:"versions,omitempty"` // service -> pinned tag or sha256 digest
+	Alerts             AlertsConfig            `yaml:"alerts,omitempty"`
+	Metrics            MetricsConfig           `yaml:"metrics,omitempty"`
+}
+
+// AlertsConfig controls where bridge alerts are sent
+type AlertsConfig struct {
+	MatrixEnabled *bool  `yaml:"matrix_enabled,omitempty"` // nil = true (default)
+	MatrixRoom    string `yaml:"matrix_room,omitempty"`    // Created on the first alert
+	Webhook       string `yaml:"webhook,omitempty"`        // URL alerts are POSTed to as JSON
+}
+
+// IsMatrixEnabled returns whether alerts go to the admin room (defaults to true)
+func (a AlertsConfig) IsMatrixEnabled() bool {
+	return a.MatrixEnabled == nil || *a.MatrixEnabled
+}
+
+// MetricsConfig controls the Prometheus metrics services export
+type MetricsConfig struct {
+	Synapse     bool `yaml:"synapse,omitempty"`      // Turn on Synapse's metrics listener
+	SynapsePort int  `yaml:"synapse_port,omitempty"` // On 127.0.0.1. Default: 9000
+}
+
+// SynapseMetricsPort returns the host port of Synapse's metrics listener (default 9000)
+func (m MetricsConfig) SynapseMetricsPort() int {
+	if m.SynapsePort != 0 {
+		return m.SynapsePort
+	}
+	return 9000
 }
 
 // PortsConfig holds the ports for services
@@ -122,6 +161,18 @@ type AdminConfig struct {
 	Password string `yaml:"password"`
 }
 
+// UserConfig is a local user added with 'muxbee user add'. Only the admin's
+// password is kept; muxbee logs in as the others through the Admin API.
+type UserConfig struct {
+	Username    string `yaml:"username"`
+	BridgeAdmin bool   `yaml:"bridge_admin,omitempty"` // Admin of the bridges, like the admin user
+}
+
+// RegistrationConfig controls whether people can sign up themselves
+type RegistrationConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"` // Sign up with a registration token from 'muxbee user invite'
+}
+
 // HTTPSConfig holds HTTPS/TLS settings
 type HTTPSConfig struct {
 	Enabled bool   `yaml:"enabled"`
@@ -151,6 +202,7 @@ func NewDefaultConfig() (*Config, error) {
 	}
 
 	return &Config{
+		SchemaVersion:    SchemaVersion,
 		ServerName:       "localhost",
 		ConnectivityMode: "local",
 		Postgres: PostgresConfig{
@@ -171,8 +223,12 @@ func NewDefaultConfig() (*Config, error) {
 
 // Load reads the config from the settings file
 func Load() (*Config, error) {
-	path := SettingsPath()
+	return LoadFile(SettingsPath())
+}
 
+// LoadFile reads settings from path, refusing a schema newer than this
+// muxbee understands
+func LoadFile(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -183,15 +239,24 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.SchemaVersion == 0 {
+		cfg.SchemaVersion = 1
+	}
+	if cfg.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%s has settings schema %d, but this muxbee only reads up to %d\nUpgrade muxbee", path, cfg.SchemaVersion, SchemaVersion)
+	}
+
 	return &cfg, nil
 }
 
-// Save writes the config to the settings file
+// Save writes the config to the settings file, in the current schema
 func (c *Config) Save() error {
 	if err := EnsureDirs(); err != nil {
 		return err
 	}
 
+	c.SchemaVersion = SchemaVersion
+
 	data, err := yaml.Marshal(c)
 	if err != nil {
 		return err
@@ -234,6 +299,52 @@ func (c *Config) DisableBridge(name string) {
 	c.EnabledBridges = bridges
 }
 
+// Usernames returns the admin followed by the added users
+func (c *Config) Usernames() []string {
+	names := []string{c.Admin.Username}
+	for _, u := range c.Users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+// GetUser returns an added user, or nil if there's none by that name
+func (c *Config) GetUser(username string) *UserConfig {
+	for i := range c.Users {
+		if c.Users[i].Username == username {
+			return &c.Users[i]
+		}
+	}
+	return nil
+}
+
+// AddUser records an added user, replacing one with the same name
+func (c *Config) AddUser(user UserConfig) {
+	if existing := c.GetUser(user.Username); existing != nil {
+		*existing = user
+		return
+	}
+	c.Users = append(c.Users, user)
+}
+
+// RemoveUser forgets an added user, returning false if there was none
+func (c *Config) RemoveUser(username string) bool {
+	users := make([]UserConfig, 0, len(c.Users))
+	for _, u := range c.Users {
+		if u.Username != username {
+			users = append(users, u)
+		}
+	}
+	if len(users) == len(c.Users) {
+		return false
+	}
+	c.Users = users
+	if len(c.Users) == 0 {
+		c.Users = nil
+	}
+	return true
+}
+
 // GetOrCreateBridgeTokens returns existing tokens for a bridge or creates new ones
 func (c *Config) GetOrCreateBridgeTokens(bridgeName string) (BridgeTokens, error) {
 	if c.BridgeTokens == nil {
@@ -284,6 +395,31 @@ func (c *Config) GetOrCreateDoublePuppetTokens() (BridgeTokens, error) {
 	return tokens, nil
 }
 
+// PinnedVersion returns the pinned tag or digest for a service, or "" if unpinned
+func (c *Config) PinnedVersion(service string) string {
+	return c.Versions[service]
+}
+
+// PinVersion records an exact tag or sha256 digest for a service
+func (c *Config) PinVersion(service, version string) {
+	if c.Versions == nil {
+		c.Versions = make(map[string]string)
+	}
+	c.Versions[service] = version
+}
+
+// UnpinVersion removes a pinned version, returning false if none was set
+func (c *Config) UnpinVersion(service string) bool {
+	if _, ok := c.Versions[service]; !ok {
+		return false
+	}
+	delete(c.Versions, service)
+	if len(c.Versions) == 0 {
+		c.Versions = nil
+	}
+	return true
+}
+
 // PublicBaseURL returns the public URL for the homeserver
 func (c *Config) PublicBaseURL() string {
 	if c.HTTPS.Enabled && c.HTTPS.Domain != "" {
@@ -299,3 +435,11 @@ func (c *Config) ElementURL() string {
 	}
 	return fmt.Sprintf("http://%s:%d", c.ServerName, c.ElementPort())
 }
+
+// UserID returns the Matrix ID of a local user, given its name or full ID
+func (c *Config) UserID(name string) string {
+	if strings.HasPrefix(name, "@") {
+		return name
+	}
+	return fmt.Sprintf("@%s:%s", name, c.ServerName)
+}
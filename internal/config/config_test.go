@@ -296,6 +296,28 @@ func TestLoadNonexistentConfig(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestSchemaVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+	require.NoError(t, EnsureDirs())
+
+	// Files from before the version was recorded are version 1
+	require.NoError(t, os.WriteFile(SettingsPath(), []byte("server_name: old.example.com\n"), 0600))
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, cfg.SchemaVersion)
+
+	cfg.SchemaVersion = 0
+	require.NoError(t, cfg.Save())
+	data, err := os.ReadFile(SettingsPath())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), fmt.Sprintf("schema_version: %d\n", SchemaVersion))
+
+	require.NoError(t, os.WriteFile(SettingsPath(), []byte(fmt.Sprintf("schema_version: %d\n", SchemaVersion+1)), 0600))
+	_, err = Load()
+	assert.ErrorContains(t, err, "Upgrade muxbee")
+}
+
 func TestLoadInvalidYAML(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv("XDG_CONFIG_HOME", tmpDir)
@@ -376,3 +398,57 @@ func TestIsBridgeEnabled_NilList(t *testing.T) {
 	cfg := &Config{}
 	assert.False(t, cfg.IsBridgeEnabled("whatsapp"))
 }
+
+func TestVersionPinning(t *testing.T) {
+	cfg := &Config{}
+	assert.Equal(t, "", cfg.PinnedVersion("synapse"))
+
+	cfg.PinVersion("synapse", "v1.120.0")
+	cfg.PinVersion("mautrix-whatsapp", "sha256:abc123")
+	assert.Equal(t, "v1.120.0", cfg.PinnedVersion("synapse"))
+	assert.Equal(t, "sha256:abc123", cfg.PinnedVersion("mautrix-whatsapp"))
+
+	assert.True(t, cfg.UnpinVersion("synapse"))
+	assert.False(t, cfg.UnpinVersion("synapse"))
+	assert.Equal(t, "", cfg.PinnedVersion("synapse"))
+
+	assert.True(t, cfg.UnpinVersion("mautrix-whatsapp"))
+	assert.Nil(t, cfg.Versions)
+}
+
+func TestVersionPinningPersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+
+	cfg := &Config{ServerName: "localhost"}
+	cfg.PinVersion("postgres", "17.2")
+	require.NoError(t, cfg.Save())
+
+	loaded, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "17.2", loaded.PinnedVersion("postgres"))
+}
+
+func TestUserID(t *testing.T) {
+	cfg := &Config{ServerName: "example.com"}
+	assert.Equal(t, "@alice:example.com", cfg.UserID("alice"))
+	assert.Equal(t, "@bob:other.org", cfg.UserID("@bob:other.org"))
+}
+
+func TestUsers(t *testing.T) {
+	cfg := &Config{}
+	assert.Nil(t, cfg.GetUser("alice"))
+
+	cfg.AddUser(UserConfig{Username: "alice"})
+	cfg.AddUser(UserConfig{Username: "bob"})
+	cfg.AddUser(UserConfig{Username: "alice", BridgeAdmin: true})
+	require.Len(t, cfg.Users, 2)
+	assert.True(t, cfg.GetUser("alice").BridgeAdmin)
+	cfg.Admin.Username = "admin"
+	assert.Equal(t, []string{"admin", "alice", "bob"}, cfg.Usernames())
+
+	assert.True(t, cfg.RemoveUser("alice"))
+	assert.False(t, cfg.RemoveUser("alice"))
+	assert.True(t, cfg.RemoveUser("bob"))
+	assert.Nil(t, cfg.Users)
+}
@@ -33,20 +33,30 @@ func DockerComposePath() string {
 	return filepath.Join(ConfigDir(), "docker-compose.yml")
 }
 
-// EnsureDirs creates the config and data directories if they don't exist
-func EnsureDirs() error {
+// UserBridgesDir returns the directory holding user-defined bridges (bridges.d)
+func UserBridgesDir() string {
+	return filepath.Join(ConfigDir(), "bridges.d")
+}
+
+// DaemonSocketPath returns the control socket of 'muxbee daemon'
+func DaemonSocketPath() string {
+	return filepath.Join(DataDir(), "daemon.sock")
+}
+
+// AdminSessionPath returns where muxbee keeps the admin user's access token
+func AdminSessionPath() string {
+	return filepath.Join(DataDir(), "admin_session.json")
+}
+
+// RequiredDirs returns the config and data directories muxbee needs,
+// parents first
+func RequiredDirs() []string {
 	configDir := ConfigDir()
 	dataDir := DataDir()
 
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return err
-	}
-
-	// Create subdirectories
-	subdirs := []string{
+	return []string{
+		configDir,
+		dataDir,
 		filepath.Join(configDir, "synapse"),
 		filepath.Join(configDir, "element"),
 		filepath.Join(configDir, "caddy"),
@@ -56,8 +66,11 @@ func EnsureDirs() error {
 		filepath.Join(dataDir, "caddy"),
 		filepath.Join(dataDir, "bridges"),
 	}
+}
 
-	for _, dir := range subdirs {
+// EnsureDirs creates the config and data directories if they don't exist
+func EnsureDirs() error {
+	for _, dir := range RequiredDirs() {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// Restart backoff: the first restart waits for two failed checks in a row,
+// later ones twice as long as the one before, up to restartBackoffMax
+const (
+	restartBackoffMin = 30 * time.Second
+	restartBackoffMax = 30 * time.Minute
+	failedChecks      = 2
+)
+
+// stackKey is the restarter key for starting stopped services
+const stackKey = "services"
+
+// restarter decides when something broken is restarted again
+type restarter struct {
+	mu    sync.Mutex
+	state map[string]*backoffState
+	total map[string]int // Restarts since the daemon started
+}
+
+type backoffState struct {
+	failures int
+	delay    time.Duration
+	next     time.Time
+}
+
+func newRestarter() *restarter {
+	return &restarter{
+		state: make(map[string]*backoffState),
+		total: make(map[string]int),
+	}
+}
+
+// due records the result of a check and reports whether key should be
+// restarted now. A healthy check resets its backoff.
+func (r *restarter) due(key string, healthy bool, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if healthy {
+		delete(r.state, key)
+		return false
+	}
+
+	st, ok := r.state[key]
+	if !ok {
+		st = &backoffState{}
+		r.state[key] = st
+	}
+	st.failures++
+	if st.failures < failedChecks || now.Before(st.next) {
+		return false
+	}
+
+	switch {
+	case st.delay == 0:
+		st.delay = restartBackoffMin
+	case st.delay < restartBackoffMax:
+		st.delay = min(2*st.delay, restartBackoffMax)
+	}
+	st.next = now.Add(st.delay)
+	r.total[key]++
+	return true
+}
+
+// counts returns how often each key was restarted
+func (r *restarter) counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.total) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(r.total))
+	for k, v := range r.total {
+		counts[k] = v
+	}
+	return counts
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// ErrNotRunning means no daemon answers on the control socket
+var ErrNotRunning = errors.New("muxbee daemon is not running")
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	httpClient *http.Client
+}
+
+// Dial connects to the daemon, returning ErrNotRunning if there is none
+func Dial() (*Client, error) {
+	return dial(config.DaemonSocketPath())
+}
+
+func dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	conn.Close()
+
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+			// Starting the stack can mean pulling images
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+// Status returns the state of the stack
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do("GET", "/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Up starts the stack
+func (c *Client) Up() error {
+	return c.do("POST", "/up", nil)
+}
+
+// Down stops the stack, which the daemon then leaves down
+func (c *Client) Down() error {
+	return c.do("POST", "/down", nil)
+}
+
+// Restart restarts one service, or the whole stack if service is empty
+func (c *Client) Restart(service string) error {
+	path := "/restart"
+	if service != "" {
+		path += "?service=" + url.QueryEscape(service)
+	}
+	return c.do("POST", path, nil)
+}
+
+func (c *Client) do(method, path string, result any) error {
+	req, err := http.NewRequest(method, "http://muxbee"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach muxbee daemon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var body errorBody
+		if json.NewDecoder(resp.Body).Decode(&body) != nil || body.Error == "" {
+			return fmt.Errorf("muxbee daemon: %s", resp.Status)
+		}
+		return errors.New(body.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,583 @@
+// Package daemon is the long-running supervisor behind 'muxbee daemon'. It
+// keeps the stack up, restarts broken bridges, takes scheduled backups and
+// answers the other muxbee commands on a control socket.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/alerts"
+	"github.com/tobocop2/muxbee/internal/backup"
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+	"github.com/tobocop2/muxbee/internal/metrics"
+)
+
+// Defaults for Options
+const (
+	DefaultHealthInterval = time.Minute
+	DefaultBackupInterval = 24 * time.Hour
+)
+
+// runtimeRetryInterval is how often the daemon checks whether the runtime
+// is back after losing its event stream
+const runtimeRetryInterval = 5 * time.Second
+
+// dieCheckDelay is how long after a bridge dies the daemon checks on it,
+// leaving its restart policy the first go
+const dieCheckDelay = 15 * time.Second
+
+// Options controls a Daemon
+type Options struct {
+	HealthInterval time.Duration    // Zero means DefaultHealthInterval
+	Backup         *backup.Schedule // nil turns scheduled backups off
+	BackupInterval time.Duration    // Zero means DefaultBackupInterval
+	Alerts         bool             // Run the alerts watcher
+	MetricsAddr    string           // Serve Prometheus metrics on this address, or "" not to
+	Logf           func(format string, args ...any)
+}
+
+// Status is what the daemon knows about the stack, as served on the socket
+type Status struct {
+	PID       int                    `json:"pid" yaml:"pid"`
+	StartedAt time.Time              `json:"started_at" yaml:"started_at"`
+	Stopped   bool                   `json:"stopped" yaml:"stopped"` // Stopped on purpose, so left down
+	RuntimeUp bool                   `json:"runtime_up" yaml:"runtime_up"`
+	CheckedAt time.Time              `json:"checked_at" yaml:"checked_at"`
+	Services  []docker.ServiceStatus `json:"services" yaml:"services"`
+	Bridges   []health.Bridge        `json:"bridges" yaml:"bridges"` // Without login state
+	Restarts  map[string]int         `json:"restarts,omitempty" yaml:"restarts,omitempty"`
+	Backup    *BackupStatus          `json:"backup,omitempty" yaml:"backup,omitempty"`
+}
+
+// BackupStatus describes the scheduled backups
+type BackupStatus struct {
+	Dir       string    `json:"dir" yaml:"dir"`
+	Last      time.Time `json:"last,omitempty" yaml:"last,omitempty"`
+	LastPath  string    `json:"last_path,omitempty" yaml:"last_path,omitempty"`
+	LastError string    `json:"last_error,omitempty" yaml:"last_error,omitempty"`
+	Next      time.Time `json:"next" yaml:"next"`
+}
+
+// Daemon supervises the stack
+type Daemon struct {
+	opts Options
+
+	// opMu serializes starting, stopping and restarting services, and
+	// checkMu checks, so two never repair the same thing
+	opMu    sync.Mutex
+	checkMu sync.Mutex
+
+	// backingUp is set while a scheduled backup runs, so a slow one isn't
+	// joined by the next
+	backingUp atomic.Bool
+	backups   sync.WaitGroup
+
+	mu      sync.Mutex // Guards the fields below
+	cfg     *config.Config
+	compose *docker.Compose
+	status  Status
+	backoff *restarter
+}
+
+// New creates a daemon for the install cfg describes
+func New(cfg *config.Config, opts Options) *Daemon {
+	if opts.HealthInterval == 0 {
+		opts.HealthInterval = DefaultHealthInterval
+	}
+	if opts.BackupInterval == 0 {
+		opts.BackupInterval = DefaultBackupInterval
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+
+	d := &Daemon{
+		opts:    opts,
+		cfg:     cfg,
+		compose: docker.New(cfg),
+		backoff: newRestarter(),
+		status: Status{
+			PID:       os.Getpid(),
+			StartedAt: time.Now(),
+			Stopped:   StoppedOnPurpose(),
+			RuntimeUp: true,
+		},
+	}
+	if opts.Backup != nil {
+		d.status.Backup = &BackupStatus{Dir: opts.Backup.Dir}
+	}
+	return d
+}
+
+// Run supervises the stack and serves the control socket until ctx is done
+func (d *Daemon) Run(ctx context.Context) error {
+	server, err := Listen(d)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	go server.Serve()
+
+	if d.opts.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", d.opts.MetricsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for metrics: %w", err)
+		}
+		exporter := metrics.NewExporter(d.current)
+		exporter.Logf = d.opts.Logf
+		if d.opts.Backup != nil {
+			exporter.BackupDir = d.opts.Backup.Dir
+		}
+		go exporter.Serve(ctx, ln)
+	}
+
+	if d.Status().Stopped {
+		d.opts.Logf("Services were stopped with 'muxbee down'; leaving them down")
+	} else if err := d.ensureUp(); err != nil {
+		d.opts.Logf("Failed to start services: %v", err)
+	}
+	d.check()
+
+	var stopAlerts context.CancelFunc
+	var alertBridges []string
+	restartAlerts := func() {
+		if stopAlerts != nil {
+			stopAlerts()
+		}
+		cfg, compose := d.current()
+		alertBridges = cfg.EnabledBridges
+		var alertsCtx context.Context
+		alertsCtx, stopAlerts = context.WithCancel(ctx)
+		watcher := alerts.NewWatcher(cfg, compose, alerts.Notifiers(cfg))
+		watcher.Logf = d.opts.Logf
+		go watcher.Run(alertsCtx)
+	}
+	if d.opts.Alerts {
+		restartAlerts()
+		defer func() { stopAlerts() }()
+	}
+
+	healthTicker := time.NewTicker(d.opts.HealthInterval)
+	defer healthTicker.Stop()
+
+	var backupTimer <-chan time.Time
+	if d.opts.Backup != nil {
+		backupTimer = d.scheduleBackup(d.opts.BackupInterval)
+	}
+
+	_, compose := d.current()
+	events, errs := compose.Events(ctx)
+	var runtimeRetry, dieCheck <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			// A backup cut off halfway is no use, so let it finish
+			d.backups.Wait()
+			return nil
+
+		case ev, ok := <-events:
+			if !ok {
+				// The runtime went away, e.g. Docker is restarting
+				if err := <-errs; err != nil {
+					d.opts.Logf("Lost the container runtime: %v", err)
+				}
+				events, errs = nil, nil
+				d.setRuntimeUp(false)
+				runtimeRetry = time.After(runtimeRetryInterval)
+				continue
+			}
+			if (ev.Action == "die" || ev.Action == "died") && dieCheck == nil && d.isBridge(ev.Service) {
+				dieCheck = time.After(dieCheckDelay)
+			}
+
+		case <-runtimeRetry:
+			runtimeRetry = nil
+			if err := compose.Runtime().Available(); err != nil {
+				runtimeRetry = time.After(runtimeRetryInterval)
+				continue
+			}
+			d.opts.Logf("Container runtime is back")
+			d.setRuntimeUp(true)
+			_, compose = d.current()
+			events, errs = compose.Events(ctx)
+			// Containers the runtime didn't bring back itself are started again
+			if !d.Status().Stopped {
+				if err := d.ensureUp(); err != nil {
+					d.opts.Logf("Failed to start services: %v", err)
+				}
+			}
+			d.check()
+
+		case <-dieCheck:
+			dieCheck = nil
+			d.check()
+
+		case <-healthTicker.C:
+			if d.reload() && d.opts.Alerts && !sameBridges(alertBridges, d.bridgeNames()) {
+				restartAlerts()
+			}
+			d.check()
+
+		case <-backupTimer:
+			// Backups can take a while; checks and repairs carry on meanwhile
+			if d.backingUp.CompareAndSwap(false, true) {
+				d.backups.Add(1)
+				go func() {
+					defer d.backups.Done()
+					defer d.backingUp.Store(false)
+					d.runBackup()
+				}()
+			} else {
+				d.opts.Logf("Skipping scheduled backup: the previous one is still running")
+			}
+			backupTimer = d.scheduleBackup(d.opts.BackupInterval)
+		}
+	}
+}
+
+// Status returns a copy of what the daemon last saw
+func (d *Daemon) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.status
+	s.Services = append([]docker.ServiceStatus{}, s.Services...)
+	s.Bridges = append([]health.Bridge{}, s.Bridges...)
+	s.Restarts = d.backoff.counts()
+	if s.Backup != nil {
+		b := *s.Backup
+		s.Backup = &b
+	}
+	return s
+}
+
+// Refresh updates the service list right away, without probing bridges
+func (d *Daemon) Refresh() (Status, error) {
+	_, compose := d.current()
+	statuses, err := compose.DetailedStatus()
+	if err != nil {
+		return d.Status(), err
+	}
+	d.mu.Lock()
+	d.status.Services = statuses
+	d.mu.Unlock()
+	return d.Status(), nil
+}
+
+// Up starts the stack and keeps it up from then on
+func (d *Daemon) Up() error {
+	if err := SetStoppedOnPurpose(false); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.status.Stopped = false
+	d.mu.Unlock()
+	d.reload()
+
+	err := d.ensureUp()
+	d.check()
+	return err
+}
+
+// Down stops the stack and leaves it down, also across daemon restarts,
+// until Up
+func (d *Daemon) Down() error {
+	if err := SetStoppedOnPurpose(true); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.status.Stopped = true
+	d.mu.Unlock()
+
+	d.opMu.Lock()
+	cfg, compose := d.current()
+	err := compose.DownQuiet(docker.GetProfiles(cfg))
+	d.opMu.Unlock()
+
+	d.check()
+	if err != nil {
+		return fmt.Errorf("failed to stop services: %w", err)
+	}
+	return nil
+}
+
+// Restart restarts one service, or the whole stack if service is empty
+func (d *Daemon) Restart(service string) error {
+	if service == "" {
+		// Settings may have changed which services run, e.g. Element
+		d.reload()
+		d.opMu.Lock()
+		cfg, compose := d.current()
+		err := compose.DownQuiet(docker.GetProfiles(cfg))
+		if err == nil {
+			err = compose.UpQuiet(docker.GetProfiles(cfg))
+		}
+		d.opMu.Unlock()
+		d.check()
+		if err != nil {
+			return fmt.Errorf("failed to restart services: %w", err)
+		}
+		return nil
+	}
+
+	d.opMu.Lock()
+	_, compose := d.current()
+	err := compose.RestartQuiet(service)
+	d.opMu.Unlock()
+	d.check()
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %w", service, err)
+	}
+	return nil
+}
+
+// ensureUp starts every service that isn't running
+func (d *Daemon) ensureUp() error {
+	d.opMu.Lock()
+	defer d.opMu.Unlock()
+
+	cfg, compose := d.current()
+	if err := compose.WriteComposeFile(); err != nil {
+		return fmt.Errorf("failed to write docker-compose.yml: %w", err)
+	}
+	return compose.UpQuiet(docker.GetProfiles(cfg))
+}
+
+// check refreshes the status and restarts what is broken, unless the
+// stack was stopped on purpose
+func (d *Daemon) check() {
+	d.checkMu.Lock()
+	defer d.checkMu.Unlock()
+	cfg, compose := d.current()
+
+	statuses, err := compose.DetailedStatus()
+	if err != nil {
+		if errors.Is(err, docker.ErrUnavailable) {
+			d.setRuntimeUp(false)
+		}
+		d.opts.Logf("Failed to get service status: %v", err)
+		return
+	}
+	bridgeHealth := health.CheckBridges(cfg, compose, statuses, health.Options{})
+
+	d.mu.Lock()
+	d.status.Services = statuses
+	d.status.Bridges = bridgeHealth
+	d.status.CheckedAt = time.Now()
+	d.status.RuntimeUp = true
+	stopped := d.status.Stopped
+	d.mu.Unlock()
+
+	if stopped {
+		return
+	}
+	d.repair(statuses, bridgeHealth, time.Now())
+}
+
+// repair restarts broken bridges and starts stopped services, each with
+// its own backoff so a bridge that can't start isn't restarted nonstop
+func (d *Daemon) repair(statuses []docker.ServiceStatus, bridgeHealth []health.Bridge, now time.Time) {
+	cfg, compose := d.current()
+
+	// Bridges are probed from inside the synapse container, so while it is
+	// down or unhealthy every bridge looks broken. Synapse comes first.
+	ready, broken := synapseState(statuses)
+	if ready || broken {
+		if d.backoff.due(synapseService, !broken, now) {
+			d.opts.Logf("Restarting synapse: container is unhealthy")
+			d.opMu.Lock()
+			err := compose.RestartQuiet(synapseService)
+			d.opMu.Unlock()
+			if err != nil {
+				d.opts.Logf("Failed to restart synapse: %v", err)
+			}
+		}
+	}
+
+	for _, b := range bridgeHealth {
+		if !b.Running {
+			// Started below along with every other stopped service
+			continue
+		}
+		if !ready || errors.Is(b.ProbeErr, docker.ErrNotRunning) {
+			// The probe says nothing about the bridge itself
+			continue
+		}
+
+		// A bridge whose listener doesn't answer is restarted; a logged out
+		// one only needs its user, which the alerts are for
+		healthy := b.Reachable
+		if !d.backoff.due(b.Name, healthy, now) {
+			continue
+		}
+		d.opts.Logf("Restarting %s bridge: %s", b.Name, b.Detail)
+		d.opMu.Lock()
+		err := compose.RestartQuiet(b.Service)
+		d.opMu.Unlock()
+		if err != nil {
+			d.opts.Logf("Failed to restart %s bridge: %v", b.Name, err)
+		}
+	}
+
+	running := make(map[string]bool)
+	for _, s := range statuses {
+		if s.Running {
+			running[s.Service] = true
+		}
+	}
+	healthy := len(statuses) > 0
+	for _, s := range statuses {
+		healthy = healthy && s.Running
+	}
+	for _, name := range cfg.EnabledBridges {
+		healthy = healthy && running[bridges.ServiceNameFor(name)]
+	}
+	if !d.backoff.due(stackKey, healthy, now) {
+		return
+	}
+	d.opts.Logf("Starting stopped services")
+	if err := d.ensureUp(); err != nil {
+		d.opts.Logf("Failed to start services: %v", err)
+	}
+}
+
+// synapseService is the compose service bridges are probed from
+const synapseService = "synapse"
+
+// synapseState reports whether synapse is up and healthy enough to probe
+// bridges from, and whether it is running but failing its healthcheck
+func synapseState(statuses []docker.ServiceStatus) (ready, broken bool) {
+	for _, s := range statuses {
+		if s.Service != synapseService || !s.Running {
+			continue
+		}
+		switch s.Health {
+		case "unhealthy":
+			return false, true
+		case "starting":
+			return false, false
+		}
+		return true, false
+	}
+	// Not running: started along with every other stopped service
+	return false, false
+}
+
+// reload rereads settings.yaml, reporting whether it could
+func (d *Daemon) reload() bool {
+	cfg, err := config.Load()
+	if err != nil {
+		d.opts.Logf("Failed to reload config: %v", err)
+		return false
+	}
+	d.mu.Lock()
+	d.cfg = cfg
+	d.compose = docker.New(cfg)
+	d.mu.Unlock()
+	return true
+}
+
+func (d *Daemon) current() (*config.Config, *docker.Compose) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg, d.compose
+}
+
+func (d *Daemon) bridgeNames() []string {
+	cfg, _ := d.current()
+	return cfg.EnabledBridges
+}
+
+func (d *Daemon) isBridge(service string) bool {
+	for _, name := range d.bridgeNames() {
+		if bridges.ServiceNameFor(name) == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Daemon) setRuntimeUp(up bool) {
+	d.mu.Lock()
+	d.status.RuntimeUp = up
+	d.mu.Unlock()
+}
+
+// scheduleBackup sets when the next backup runs
+func (d *Daemon) scheduleBackup(after time.Duration) <-chan time.Time {
+	d.mu.Lock()
+	d.status.Backup.Next = time.Now().Add(after)
+	d.mu.Unlock()
+	return time.After(after)
+}
+
+// runBackup takes one scheduled backup
+func (d *Daemon) runBackup() {
+	cfg, _ := d.current()
+	d.opts.Logf("Creating backup in %s", d.opts.Backup.Dir)
+	result, err := d.opts.Backup.Run(cfg, time.Now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status.Backup.Last = time.Now()
+	if err != nil {
+		d.status.Backup.LastError = err.Error()
+		d.opts.Logf("Backup failed: %v", err)
+		return
+	}
+	d.status.Backup.LastError = ""
+	d.status.Backup.LastPath = result.Path
+	d.opts.Logf("Backup created: %s", result.Path)
+}
+
+func sameBridges(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stoppedMarker records that the stack was stopped on purpose, so a
+// restarted daemon doesn't bring it back up
+func stoppedMarker() string {
+	return filepath.Join(config.DataDir(), ".stopped")
+}
+
+// StoppedOnPurpose reports whether the stack was last stopped on purpose
+func StoppedOnPurpose() bool {
+	_, err := os.Stat(stoppedMarker())
+	return err == nil
+}
+
+// SetStoppedOnPurpose records whether the stack is stopped on purpose.
+// Anything that starts or stops the stack without the daemon has to call
+// it, or the next daemon keeps the stack down or brings it back up.
+func SetStoppedOnPurpose(stopped bool) error {
+	if !stopped {
+		if err := os.Remove(stoppedMarker()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(config.DataDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(stoppedMarker(), nil, 0644)
+}
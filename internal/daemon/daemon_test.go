@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+func TestRestarter(t *testing.T) {
+	r := newRestarter()
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// One failed check is forgiven, e.g. a bridge still starting
+	assert.False(t, r.due("signal", false, now))
+	assert.True(t, r.due("signal", false, now.Add(time.Minute)))
+
+	// Then it waits 30s, 1m, 2m, ...
+	assert.False(t, r.due("signal", false, now.Add(time.Minute+20*time.Second)))
+	assert.True(t, r.due("signal", false, now.Add(2*time.Minute)))
+	assert.False(t, r.due("signal", false, now.Add(2*time.Minute+50*time.Second)))
+	assert.True(t, r.due("signal", false, now.Add(3*time.Minute)))
+	assert.Equal(t, map[string]int{"signal": 3}, r.counts())
+
+	// A healthy check starts over
+	assert.False(t, r.due("signal", true, now.Add(4*time.Minute)))
+	assert.False(t, r.due("signal", false, now.Add(5*time.Minute)))
+	assert.True(t, r.due("signal", false, now.Add(6*time.Minute)))
+}
+
+func TestRestarter_MaxBackoff(t *testing.T) {
+	r := newRestarter()
+	now := time.Now()
+	r.due("signal", false, now)
+
+	for i := 0; i < 20; i++ {
+		now = now.Add(restartBackoffMax)
+		require.True(t, r.due("signal", false, now))
+	}
+	assert.Equal(t, restartBackoffMax, r.state["signal"].delay)
+}
+
+func TestSynapseState(t *testing.T) {
+	bridge := docker.ServiceStatus{Service: "mautrix-signal", Running: true}
+	tests := []struct {
+		name          string
+		synapse       *docker.ServiceStatus
+		ready, broken bool
+	}{
+		{"missing", nil, false, false},
+		{"stopped", &docker.ServiceStatus{Service: "synapse", State: "exited"}, false, false},
+		{"starting", &docker.ServiceStatus{Service: "synapse", Running: true, Health: "starting"}, false, false},
+		{"unhealthy", &docker.ServiceStatus{Service: "synapse", Running: true, Health: "unhealthy"}, false, true},
+		{"healthy", &docker.ServiceStatus{Service: "synapse", Running: true, Health: "healthy"}, true, false},
+		{"no healthcheck", &docker.ServiceStatus{Service: "synapse", Running: true}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := []docker.ServiceStatus{bridge}
+			if tt.synapse != nil {
+				statuses = append(statuses, *tt.synapse)
+			}
+			ready, broken := synapseState(statuses)
+			assert.Equal(t, tt.ready, ready)
+			assert.Equal(t, tt.broken, broken)
+		})
+	}
+}
+
+func TestStoppedOnPurpose(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	assert.False(t, StoppedOnPurpose())
+	require.NoError(t, SetStoppedOnPurpose(true))
+	assert.True(t, StoppedOnPurpose())
+	require.NoError(t, SetStoppedOnPurpose(false))
+	assert.False(t, StoppedOnPurpose())
+	require.NoError(t, SetStoppedOnPurpose(false))
+}
+
+func TestNew_LeftoverStoppedMarker(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	cfg := &config.Config{}
+
+	// Stopped through the daemon, then started again without it
+	require.NoError(t, SetStoppedOnPurpose(true))
+	assert.True(t, New(cfg, Options{}).Status().Stopped)
+	require.NoError(t, SetStoppedOnPurpose(false))
+	assert.False(t, New(cfg, Options{}).Status().Stopped, "a later daemon should repair the stack")
+}
+
+// fakeController records what the control socket asked for
+type fakeController struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeController) Refresh() (Status, error) {
+	f.calls = append(f.calls, "status")
+	return Status{PID: 42, Services: []docker.ServiceStatus{{Name: "muxbee-synapse-1", Running: true}}}, f.err
+}
+
+func (f *fakeController) Up() error {
+	f.calls = append(f.calls, "up")
+	return f.err
+}
+
+func (f *fakeController) Down() error {
+	f.calls = append(f.calls, "down")
+	return f.err
+}
+
+func (f *fakeController) Restart(service string) error {
+	f.calls = append(f.calls, "restart "+service)
+	return f.err
+}
+
+// serve runs a control socket for ctrl in a temporary directory
+func serve(t *testing.T, ctrl Controller) string {
+	t.Helper()
+	// Unix socket paths are limited to about 100 bytes, too few for t.TempDir
+	dir, err := os.MkdirTemp("", "muxbee")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "daemon.sock")
+	server, err := listen(path, ctrl)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	go server.Serve()
+	return path
+}
+
+func TestControlSocket(t *testing.T) {
+	ctrl := &fakeController{}
+	path := serve(t, ctrl)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	client, err := dial(path)
+	require.NoError(t, err)
+
+	status, err := client.Status()
+	require.NoError(t, err)
+	assert.Equal(t, 42, status.PID)
+	assert.Equal(t, "muxbee-synapse-1", status.Services[0].Name)
+
+	require.NoError(t, client.Up())
+	require.NoError(t, client.Down())
+	require.NoError(t, client.Restart("mautrix-signal"))
+	require.NoError(t, client.Restart(""))
+	assert.Equal(t, []string{"status", "up", "down", "restart mautrix-signal", "restart "}, ctrl.calls)
+}
+
+func TestControlSocket_Error(t *testing.T) {
+	path := serve(t, &fakeController{err: errors.New("failed to stop services: exit status 1")})
+
+	client, err := dial(path)
+	require.NoError(t, err)
+	assert.EqualError(t, client.Down(), "failed to stop services: exit status 1")
+}
+
+func TestControlSocket_AlreadyRunning(t *testing.T) {
+	path := serve(t, &fakeController{})
+
+	_, err := listen(path, &fakeController{})
+	assert.ErrorIs(t, err, ErrAlreadyRunning)
+}
+
+func TestControlSocket_Stale(t *testing.T) {
+	dir, err := os.MkdirTemp("", "muxbee")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "daemon.sock")
+
+	// A socket left behind by a daemon that crashed
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	_, err = dial(path)
+	assert.ErrorIs(t, err, ErrNotRunning)
+
+	server, err := listen(path, &fakeController{})
+	require.NoError(t, err)
+	server.Close()
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "socket removed on close")
+}
@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// ErrAlreadyRunning means another daemon serves the control socket
+var ErrAlreadyRunning = errors.New("muxbee daemon is already running")
+
+// Controller is what the control socket exposes. *Daemon implements it.
+type Controller interface {
+	Refresh() (Status, error)
+	Up() error
+	Down() error
+	Restart(service string) error
+}
+
+// Server serves a Controller on the control socket, as JSON over HTTP
+type Server struct {
+	listener net.Listener
+	http     *http.Server
+	path     string
+}
+
+// Listen creates the control socket at config.DaemonSocketPath
+func Listen(ctrl Controller) (*Server, error) {
+	return listen(config.DaemonSocketPath(), ctrl)
+}
+
+func listen(path string, ctrl Controller) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// A socket nobody answers on is left over from a daemon that crashed
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, ErrAlreadyRunning
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control socket: %w", err)
+	}
+	// Anyone who can talk to the socket can stop the stack
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := ctrl.Refresh()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("POST /up", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, ctrl.Up())
+	})
+	mux.HandleFunc("POST /down", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, ctrl.Down())
+	})
+	mux.HandleFunc("POST /restart", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, ctrl.Restart(r.URL.Query().Get("service")))
+	})
+
+	return &Server{
+		listener: listener,
+		http:     &http.Server{Handler: mux},
+		path:     path,
+	}, nil
+}
+
+// Serve answers requests until Close
+func (s *Server) Serve() error {
+	if err := s.http.Serve(s.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops serving and removes the socket
+func (s *Server) Close() error {
+	err := s.http.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// errorBody is the body of a failed request
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func reply(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(errorBody{Error: err.Error()})
+}
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ProjectName is the compose project name set in docker-compose.yml
+const ProjectName = "muxbee"
+
+// Compose labels on every container of a project
+const (
+	projectLabel = "com.docker.compose.project"
+	serviceLabel = "com.docker.compose.service"
+)
+
+// Backend is the container runtime muxbee talks to for everything except
+// creating and removing the stack, which stays with Docker Compose
+type Backend interface {
+	// Ping checks that the daemon answers
+	Ping() error
+	// Containers lists the containers of a compose project, stopped ones included
+	Containers(project string) ([]Container, error)
+	// Inspect returns one container by ID or name
+	Inspect(container string) (*Container, error)
+	// Logs streams a container's stdout and stderr, interleaved
+	Logs(container string, opts LogOptions) (io.ReadCloser, error)
+	// Exec runs a command in a running container. A non-zero exit status
+	// is returned as an *ExitError.
+	Exec(container string, cmd []string, stdin io.Reader, stdout io.Writer) error
+	// Events streams container events of a compose project until ctx is
+	// done. The error channel receives at most one error, then both close.
+	Events(ctx context.Context, project string) (<-chan Event, <-chan error)
+	// ImageRepoDigests returns the repository digests of a local image
+	ImageRepoDigests(image string) ([]string, error)
+	// TagImage points ref at an existing image
+	TagImage(image, ref string) error
+	// Stats samples a running container's CPU and memory use
+	Stats(container string) (*Stats, error)
+}
+
+// Container is a container as the backend reports it
+type Container struct {
+	ID      string
+	Name    string // Without the leading slash
+	Service string // Compose service name
+	Image   string // Image reference the container was created from
+	ImageID string
+	State   string // created, running, restarting, exited, ...
+	Health  string // starting, healthy, unhealthy, or "" without a healthcheck
+	Labels  map[string]string
+
+	// Set by Inspect only
+	ExitCode     int
+	RestartCount int
+	StartedAt    time.Time
+}
+
+// LogOptions controls Backend.Logs
+type LogOptions struct {
+	Follow bool
+	Tail   string // Number of lines, or "" for all
+}
+
+// Event is a change to a container, such as "start", "die" or
+// "health_status: healthy"
+type Event struct {
+	Action     string
+	ID         string
+	Container  string
+	Service    string
+	Time       time.Time
+	Attributes map[string]string
+}
+
+// ErrUnavailable means the container runtime could not be reached
+var ErrUnavailable = errors.New("cannot connect to the container runtime")
+
+// ErrNotFound means a container or image doesn't exist
+var ErrNotFound = errors.New("no such object")
+
+// ErrNotRunning means a service has no running container
+var ErrNotRunning = errors.New("not running")
+
+// APIError is an error response from the daemon
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Is makes errors.Is(err, ErrNotFound) true for 404 responses
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == 404
+}
+
+// ExitError is a command run in a container that exited non-zero
+type ExitError struct {
+	Code   int
+	Stderr string // Trimmed stderr output of the command
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr != "" {
+		return e.Stderr
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// inspectResult is the container JSON from 'docker inspect' and the
+// /containers/{id}/json endpoint
+type inspectResult struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"` // Image ID
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status    string    `json:"Status"`
+		ExitCode  int       `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+		Health    *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+		Healthcheck *struct { // Podman before 4.3
+			Status string `json:"Status"`
+		} `json:"Healthcheck"`
+	} `json:"State"`
+	RestartCount int `json:"RestartCount"`
+}
+
+func (r inspectResult) container() Container {
+	c := Container{
+		ID:           r.ID,
+		Name:         strings.TrimPrefix(r.Name, "/"),
+		Service:      r.Config.Labels[serviceLabel],
+		Image:        r.Config.Image,
+		ImageID:      r.Image,
+		State:        r.State.Status,
+		Labels:       r.Config.Labels,
+		ExitCode:     r.State.ExitCode,
+		RestartCount: r.RestartCount,
+		StartedAt:    r.State.StartedAt,
+	}
+	if r.State.Health != nil {
+		c.Health = r.State.Health.Status
+	} else if r.State.Healthcheck != nil {
+		c.Health = r.State.Healthcheck.Status
+	}
+	return c
+}
+
+// eventMessage is one event from the /events endpoint and 'docker events'.
+// 'podman events' prints its own layout, read into the second group.
+type eventMessage struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+
+	Status     string            `json:"Status"`
+	ID         string            `json:"ID"`
+	Name       string            `json:"Name"`
+	Attributes map[string]string `json:"Attributes"`
+}
+
+// event converts a message, skipping exec events that muxbee's own
+// probes and version checks cause
+func (m eventMessage) event() (Event, bool) {
+	action, id, attrs := m.Action, m.Actor.ID, m.Actor.Attributes
+	if action == "" {
+		action, id, attrs = m.Status, m.ID, m.Attributes
+	}
+	if m.Type != "container" || action == "exec" || strings.HasPrefix(action, "exec_") {
+		return Event{}, false
+	}
+
+	ev := Event{
+		Action:     action,
+		ID:         id,
+		Container:  attrs["name"],
+		Service:    attrs[serviceLabel],
+		Time:       time.Now(),
+		Attributes: attrs,
+	}
+	if ev.Container == "" {
+		ev.Container = m.Name
+	}
+	if m.TimeNano != 0 {
+		ev.Time = time.Unix(0, m.TimeNano)
+	}
+	return ev, true
+}
+
+// splitImageRef splits an image reference into repository and tag,
+// defaulting the tag to latest
+func splitImageRef(ref string) (repo, tag string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
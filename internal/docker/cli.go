@@ -0,0 +1,253 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// CLI implements Backend with the docker or podman command, for daemons
+// the Engine backend can't reach: Windows named pipes, TCP hosts, docker
+// contexts and Podman without its API service
+type CLI struct {
+	binary string
+}
+
+// NewCLI creates a CLI backend that runs binary
+func NewCLI(binary string) *CLI {
+	return &CLI{binary: binary}
+}
+
+// Ping checks that the daemon answers
+func (c *CLI) Ping() error {
+	_, err := c.output("info", "--format", "{{.ServerVersion}}")
+	return err
+}
+
+// Containers lists the containers of a compose project. It costs two
+// processes however many containers there are.
+func (c *CLI) Containers(project string) ([]Container, error) {
+	out, err := c.output("ps", "-a", "-q", "--no-trunc", "--filter", "label="+projectLabel+"="+project)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	results, err := c.inspect(ids...)
+	if err != nil {
+		return nil, err
+	}
+	containers := make([]Container, 0, len(results))
+	for _, r := range results {
+		containers = append(containers, r.container())
+	}
+	return containers, nil
+}
+
+// Inspect returns one container by ID or name
+func (c *CLI) Inspect(container string) (*Container, error) {
+	results, err := c.inspect(container)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, container)
+	}
+	ctr := results[0].container()
+	return &ctr, nil
+}
+
+func (c *CLI) inspect(containers ...string) ([]inspectResult, error) {
+	out, err := c.output(append([]string{"inspect", "--type", "container"}, containers...)...)
+	if err != nil {
+		return nil, err
+	}
+	var results []inspectResult
+	if err := json.Unmarshal(out, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse %s inspect output: %w", c.binary, err)
+	}
+	return results, nil
+}
+
+// Logs streams a container's stdout and stderr, interleaved
+func (c *CLI) Logs(container string, opts LogOptions) (io.ReadCloser, error) {
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	args = append(args, container)
+
+	pr, pw := io.Pipe()
+	cmd := exec.Command(c.binary, args...)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, c.unavailable(err)
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	return &processReader{PipeReader: pr, cmd: cmd}, nil
+}
+
+// Exec runs a command in a running container
+func (c *CLI) Exec(container string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	args := []string{"exec"}
+	if stdin != nil {
+		args = append(args, "-i")
+	}
+	args = append(args, container)
+	args = append(args, cmd...)
+
+	var stderr bytes.Buffer
+	run := exec.Command(c.binary, args...)
+	run.Stdin = stdin
+	run.Stdout = stdout
+	run.Stderr = &stderr
+
+	err := run.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		msg := strings.TrimSpace(stderr.String())
+		if cliError := classify(msg); cliError != nil {
+			return cliError
+		}
+		return &ExitError{Code: exitErr.ExitCode(), Stderr: msg}
+	}
+	if err != nil {
+		return c.unavailable(err)
+	}
+	return nil
+}
+
+// Events streams container events of a compose project until ctx is done
+func (c *CLI) Events(ctx context.Context, project string) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(events)
+
+		cmd := exec.CommandContext(ctx, c.binary, "events", "--format", "{{json .}}",
+			"--filter", "type=container", "--filter", "label="+projectLabel+"="+project)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			errs <- err
+			return
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			errs <- c.unavailable(err)
+			return
+		}
+
+		err = decodeEvents(ctx, bufio.NewReader(stdout), events)
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil && ctx.Err() == nil {
+			err = c.failure(strings.TrimSpace(stderr.String()), waitErr)
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs
+}
+
+// ImageRepoDigests returns the repository digests of a local image
+func (c *CLI) ImageRepoDigests(image string) ([]string, error) {
+	out, err := c.output("image", "inspect", image, "--format", "{{json .RepoDigests}}")
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	if err := json.Unmarshal(out, &digests); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// TagImage points ref at an existing image
+func (c *CLI) TagImage(image, ref string) error {
+	_, err := c.output("tag", image, ref)
+	return err
+}
+
+// output runs the CLI and returns its stdout, turning failures
+// into the backend's typed errors
+func (c *CLI) output(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.binary, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, c.failure(strings.TrimSpace(stderr.String()), err)
+	}
+	return out, nil
+}
+
+func (c *CLI) unavailable(err error) error {
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// failure converts a failed command into a typed error
+func (c *CLI) failure(stderr string, err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return c.unavailable(err) // The CLI itself didn't run
+	}
+	if typed := classify(stderr); typed != nil {
+		return typed
+	}
+	if stderr == "" {
+		return fmt.Errorf("%s: %w", c.binary, err)
+	}
+	return fmt.Errorf("%s: %s", c.binary, stderr)
+}
+
+// classify recognizes the docker and podman CLIs' messages for a missing
+// daemon or object
+func classify(stderr string) error {
+	lower := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(lower, "cannot connect to the docker daemon"),
+		strings.Contains(lower, "cannot connect to podman"),
+		strings.Contains(lower, "error during connect"):
+		return fmt.Errorf("%w: %s", ErrUnavailable, stderr)
+	case strings.Contains(lower, "no such container"),
+		strings.Contains(lower, "no such object"),
+		strings.Contains(lower, "no such image"),
+		strings.Contains(lower, "image not known"):
+		return fmt.Errorf("%w: %s", ErrNotFound, stderr)
+	}
+	return nil
+}
+
+// processReader is the output of a running command; closing it stops the
+// command
+type processReader struct {
+	*io.PipeReader
+	cmd *exec.Cmd
+}
+
+func (p *processReader) Close() error {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+	return p.PipeReader.Close()
+}
@@ -1,47 +1,66 @@
 package docker
 
 import (
-	"bufio"
 	"bytes"
-	_ "embed"
-	"encoding/json"
+	"context"
+	"embed"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"github.com/tobocop2/muxbee/internal/bridges"
 	"github.com/tobocop2/muxbee/internal/config"
 )
 
-//go:embed docker-compose.yml
-var DockerComposeYAML []byte
+//go:embed templates/*
+var templateFS embed.FS
 
 // Compose provides Docker Compose operations
 type Compose struct {
+	cfg       *config.Config
 	configDir string
 	dataDir   string
 	env       []string
+	runtime   Runtime
+	backend   Backend
 }
 
 // ServiceStatus represents the status of a Docker service
 type ServiceStatus struct {
-	Name    string
-	State   string
-	Health  string
-	Running bool
-	Version string
+	Name    string `json:"name" yaml:"name"`
+	Service string `json:"service" yaml:"service"`
+	Image   string `json:"image" yaml:"image"`
+	State   string `json:"state" yaml:"state"`
+	Health  string `json:"health,omitempty" yaml:"health,omitempty"`
+	Running bool   `json:"running" yaml:"running"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Set by DetailedStatus and InspectService only
+	ExitCode     int `json:"exit_code,omitempty" yaml:"exit_code,omitempty"` // Of the last run
+	RestartCount int `json:"restart_count,omitempty" yaml:"restart_count,omitempty"`
 }
 
-// New creates a new Compose instance
+// New creates a new Compose instance for the runtime cfg selects
 func New(cfg *config.Config) *Compose {
+	return NewWithBackend(cfg, RuntimeFor(cfg).Backend())
+}
+
+// NewWithBackend creates a Compose instance that inspects containers
+// through the given backend
+func NewWithBackend(cfg *config.Config, backend Backend) *Compose {
 	configDir := config.ConfigDir()
 	dataDir := config.DataDir()
 
 	return &Compose{
+		cfg:       cfg,
 		configDir: configDir,
 		dataDir:   dataDir,
 		env: []string{
@@ -50,17 +69,101 @@ func New(cfg *config.Config) *Compose {
 			"POSTGRES_PASSWORD=" + cfg.Postgres.Password,
 			fmt.Sprintf("SYNAPSE_PORT=%d", cfg.SynapsePort()),
 			fmt.Sprintf("ELEMENT_PORT=%d", cfg.ElementPort()),
+			fmt.Sprintf("SYNAPSE_METRICS_PORT=%d", cfg.Metrics.SynapseMetricsPort()),
 		},
+		runtime: RuntimeFor(cfg),
+		backend: backend,
+	}
+}
+
+// ComposeData contains data for the docker-compose.yml template
+type ComposeData struct {
+	Element        bool
+	HTTPS          bool
+	SynapseMetrics bool
+	PostgresImage  string
+	SynapseImage   string
+	ElementImage   string
+	CaddyImage     string
+	Bridges        []ComposeBridge
+
+	// Bind mount options, with the SELinux label the runtime needs
+	Mount   string // "" or ":z"
+	MountRO string // ":ro" or ":ro,z"
+}
+
+// ComposeBridge describes a bridge service in the compose file
+type ComposeBridge struct {
+	Name        string
+	ServiceName string
+	Image       string
+}
+
+// NewComposeData builds the template data for the given config.
+// Only enabled bridges that exist in the registry are included, and
+// pinned versions from settings.yaml are applied to every image.
+func NewComposeData(cfg *config.Config) ComposeData {
+	data := ComposeData{
+		MountRO:        ":ro",
+		Element:        cfg.IsElementEnabled(),
+		HTTPS:          cfg.HTTPS.Enabled,
+		SynapseMetrics: cfg.Metrics.Synapse,
+		PostgresImage:  ServiceImage(cfg, "postgres"),
+		SynapseImage:   ServiceImage(cfg, "synapse"),
+		ElementImage:   ServiceImage(cfg, "element"),
+		CaddyImage:     ServiceImage(cfg, "caddy"),
+		Bridges:        []ComposeBridge{},
+	}
+
+	for _, b := range bridges.List() {
+		if !cfg.IsBridgeEnabled(b.Name) {
+			continue
+		}
+		data.Bridges = append(data.Bridges, ComposeBridge{
+			Name:        b.Name,
+			ServiceName: b.ServiceName(),
+			Image:       ApplyPin(b.Image(), cfg.PinnedVersion(b.ServiceName())),
+		})
+	}
+
+	if label := RuntimeFor(cfg).MountLabel(); label != "" {
+		data.Mount = ":" + label
+		data.MountRO += "," + label
+	}
+
+	return data
+}
+
+// RenderComposeFile renders the docker-compose.yml for the given config
+func RenderComposeFile(cfg *config.Config) ([]byte, error) {
+	if err := ValidatePins(cfg); err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.ParseFS(templateFS, "templates/docker-compose.yml.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, NewComposeData(cfg)); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
 }
 
-// WriteComposeFile writes the docker-compose.yml to the config directory
+// WriteComposeFile renders the docker-compose.yml to the config directory
 func (c *Compose) WriteComposeFile() error {
 	if err := config.EnsureDirs(); err != nil {
 		return err
 	}
-	path := filepath.Join(c.configDir, "docker-compose.yml")
-	return os.WriteFile(path, DockerComposeYAML, 0644)
+
+	content, err := RenderComposeFile(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.composePath(), content, 0644)
 }
 
 // composePath returns the path to docker-compose.yml
@@ -68,10 +171,11 @@ func (c *Compose) composePath() string {
 	return filepath.Join(c.configDir, "docker-compose.yml")
 }
 
-// buildCommand creates an exec.Cmd for docker compose
+// buildCommand creates an exec.Cmd for the runtime's compose command
 func (c *Compose) buildCommand(args ...string) *exec.Cmd {
-	fullArgs := append([]string{"compose", "-f", c.composePath()}, args...)
-	cmd := exec.Command("docker", fullArgs...)
+	fullArgs := append(slices.Clone(c.runtime.Compose[1:]), "-f", c.composePath())
+	fullArgs = append(fullArgs, args...)
+	cmd := exec.Command(c.runtime.Compose[0], fullArgs...)
 	cmd.Env = append(os.Environ(), c.env...)
 	return cmd
 }
@@ -82,7 +186,7 @@ func (c *Compose) Up(profiles []string) error {
 	for _, p := range profiles {
 		args = append(args, "--profile", p)
 	}
-	args = append(args, "up", "-d")
+	args = append(args, "up", "-d", "--remove-orphans")
 
 	cmd := c.buildCommand(args...)
 	cmd.Stdout = os.Stdout
@@ -96,7 +200,7 @@ func (c *Compose) UpForceRecreate(profiles []string) error {
 	for _, p := range profiles {
 		args = append(args, "--profile", p)
 	}
-	args = append(args, "up", "-d", "--force-recreate")
+	args = append(args, "up", "-d", "--remove-orphans", "--force-recreate")
 
 	cmd := c.buildCommand(args...)
 	cmd.Stdout = os.Stdout
@@ -110,7 +214,7 @@ func (c *Compose) UpQuiet(profiles []string) error {
 	for _, p := range profiles {
 		args = append(args, "--profile", p)
 	}
-	args = append(args, "up", "-d", "--quiet-pull")
+	args = append(args, "up", "-d", "--remove-orphans", "--quiet-pull")
 
 	cmd := c.buildCommand(args...)
 	return cmd.Run()
@@ -122,7 +226,7 @@ func (c *Compose) UpForceRecreateQuiet(profiles []string) error {
 	for _, p := range profiles {
 		args = append(args, "--profile", p)
 	}
-	args = append(args, "up", "-d", "--force-recreate", "--quiet-pull")
+	args = append(args, "up", "-d", "--remove-orphans", "--force-recreate", "--quiet-pull")
 
 	cmd := c.buildCommand(args...)
 	return cmd.Run()
@@ -162,103 +266,145 @@ func (c *Compose) DownVolumes() error {
 	return cmd.Run()
 }
 
-// Status returns the status of all services
+// Status returns the status of all services. Errors reaching the
+// container runtime are returned; a stack that was never started has no
+// services and no error.
 func (c *Compose) Status() ([]ServiceStatus, error) {
-	cmd := c.buildCommand("ps", "--format", "json", "-a")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = nil
+	containers, err := c.backend.Containers(ProjectName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
 
-	if err := cmd.Run(); err != nil {
-		return nil, nil
+	// Look up versions in parallel; each is found at most once per container
+	versions := make([]string, len(containers))
+	var wg sync.WaitGroup
+	for i, ctr := range containers {
+		if ctr.State != "running" {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, ctr Container) {
+			defer wg.Done()
+			versions[idx] = c.containerVersion(ctr)
+		}(i, ctr)
 	}
+	wg.Wait()
 
-	// First pass: collect all services
-	type svcInfo struct {
-		Name    string `json:"Name"`
-		State   string `json:"State"`
-		Health  string `json:"Health"`
-		Image   string `json:"Image"`
-		Service string `json:"Service"`
+	statuses := make([]ServiceStatus, 0, len(containers))
+	for i, ctr := range containers {
+		statuses = append(statuses, serviceStatus(ctr, versions[i]))
 	}
-	var services []svcInfo
 
-	scanner := bufio.NewScanner(&out)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+	return statuses, nil
+}
 
-		var svc svcInfo
-		if err := json.Unmarshal([]byte(line), &svc); err != nil {
-			continue
-		}
-		services = append(services, svc)
+// DetailedStatus is Status with each container's restart count and last
+// exit code, which takes one more call to the runtime per container
+func (c *Compose) DetailedStatus() ([]ServiceStatus, error) {
+	statuses, err := c.Status()
+	if err != nil {
+		return nil, err
 	}
 
-	// Fetch versions in parallel for running containers
-	versions := make([]string, len(services))
 	var wg sync.WaitGroup
-	for i, svc := range services {
-		if svc.State != "running" {
-			continue
-		}
+	for i := range statuses {
 		wg.Add(1)
-		go func(idx int, s svcInfo) {
+		go func(s *ServiceStatus) {
 			defer wg.Done()
-			versions[idx] = getContainerVersion(s.Name, s.Service, s.Image)
-		}(i, svc)
+			// A container removed meanwhile keeps what the list said
+			if ctr, err := c.backend.Inspect(s.Name); err == nil {
+				s.ExitCode = ctr.ExitCode
+				s.RestartCount = ctr.RestartCount
+			}
+		}(&statuses[i])
 	}
 	wg.Wait()
 
-	// Build result
-	var statuses []ServiceStatus
-	for i, svc := range services {
-		statuses = append(statuses, ServiceStatus{
-			Name:    svc.Name,
-			State:   svc.State,
-			Health:  svc.Health,
-			Running: svc.State == "running",
-			Version: versions[i],
-		})
+	return statuses, nil
+}
+
+// InspectService returns the status of one container, by ID or name,
+// with its restart count and last exit code
+func (c *Compose) InspectService(container string) (ServiceStatus, error) {
+	ctr, err := c.backend.Inspect(container)
+	if err != nil {
+		return ServiceStatus{}, err
 	}
 
-	return statuses, nil
+	version := ""
+	if ctr.State == "running" {
+		version = c.containerVersion(*ctr)
+	}
+	s := serviceStatus(*ctr, version)
+	s.ExitCode = ctr.ExitCode
+	s.RestartCount = ctr.RestartCount
+	return s, nil
+}
+
+func serviceStatus(ctr Container, version string) ServiceStatus {
+	return ServiceStatus{
+		Name:    ctr.Name,
+		Service: ctr.Service,
+		Image:   ctr.Image,
+		State:   ctr.State,
+		Health:  ctr.Health,
+		Running: ctr.State == "running",
+		Version: version,
+	}
+}
+
+// versionCache holds versions by image ID. Finding a version can mean
+// running a command in the container, so it is done once per image; a
+// failed lookup, say in a container still starting, is tried again.
+var versionCache sync.Map
+
+// containerVersion returns the version of the software in a container
+func (c *Compose) containerVersion(ctr Container) string {
+	key := ctr.ImageID
+	if key == "" {
+		key = ctr.ID
+	}
+	if v, ok := versionCache.Load(key); ok {
+		return v.(string)
+	}
+	version := c.findVersion(ctr)
+	if version != "" {
+		versionCache.Store(key, version)
+	}
+	return version
 }
 
-// getContainerVersion attempts to get the version of a container
-func getContainerVersion(containerName, service, image string) string {
+// findVersion attempts to get the version of a container
+func (c *Compose) findVersion(ctr Container) string {
 	// First try the OCI version label
-	cmd := exec.Command("docker", "inspect", containerName,
-		"--format", `{{index .Config.Labels "org.opencontainers.image.version"}}`)
-	out, err := cmd.Output()
-	if err == nil {
-		version := strings.TrimSpace(string(out))
-		if version != "" && version != "<no value>" {
-			return cleanVersion(version)
-		}
+	if version := ctr.Labels["org.opencontainers.image.version"]; version != "" {
+		return cleanVersion(version)
 	}
 
 	// For images with explicit version tags (e.g., postgres:17), extract from image
-	if idx := strings.LastIndex(image, ":"); idx != -1 {
-		tag := image[idx+1:]
-		if tag != "latest" && tag != "" {
+	if idx := strings.LastIndex(ctr.Image, ":"); idx != -1 {
+		tag := ctr.Image[idx+1:]
+		if tag != "latest" && tag != "" && !strings.Contains(tag, "/") {
 			return tag
 		}
 	}
 
+	service := ctr.Service
+	if service == "" {
+		service = ParseServiceName(ctr.Name)
+	}
+
 	// For mautrix bridges, try multiple methods
 	if strings.HasPrefix(service, "mautrix-") {
 		// Method 1: Try running the Go binary with --version
-		binary := "/usr/bin/" + service
-		cmd = exec.Command("docker", "exec", containerName, binary, "--version")
-		out, err = cmd.Output()
-		if err == nil {
+		var out bytes.Buffer
+		if err := c.backend.Exec(ctr.ID, []string{"/usr/bin/" + service, "--version"}, nil, &out); err == nil {
 			// Parse "mautrix-discord 0.7.5+dev.11b1ea5a (Nov 25 2025...)"
 			// or "mautrix-whatsapp v26.01+dev.4d9366c2 (built at...)"
-			version := strings.TrimSpace(string(out))
-			parts := strings.Fields(version)
+			parts := strings.Fields(out.String())
 			if len(parts) >= 2 {
 				v := parts[1]
 				// Strip +dev.xxx suffix
@@ -271,11 +417,10 @@ func getContainerVersion(containerName, service, image string) string {
 
 		// Method 2: Try pip show for Python bridges
 		pipPkg := strings.Replace(service, "-", "_", 1) // mautrix-telegram -> mautrix_telegram
-		cmd = exec.Command("docker", "exec", containerName, "pip", "show", pipPkg)
-		out, err = cmd.Output()
-		if err == nil {
+		out.Reset()
+		if err := c.backend.Exec(ctr.ID, []string{"pip", "show", pipPkg}, nil, &out); err == nil {
 			// Parse "Version: 0.5.2+dev.xxx"
-			for _, line := range strings.Split(string(out), "\n") {
+			for _, line := range strings.Split(out.String(), "\n") {
 				if strings.HasPrefix(line, "Version:") {
 					v := strings.TrimSpace(strings.TrimPrefix(line, "Version:"))
 					// Strip +dev.xxx suffix
@@ -325,49 +470,69 @@ func (c *Compose) IsServiceRunning(serviceName string) bool {
 	return false
 }
 
-// Logs streams logs for a specific service or all services
-func (c *Compose) Logs(service string, follow bool, tail string) error {
-	args := []string{"logs"}
-	if follow {
-		args = append(args, "-f")
-	}
-	if tail != "" {
-		args = append(args, "--tail", tail)
+// serviceContainer returns the running container of a service
+func (c *Compose) serviceContainer(service string) (Container, error) {
+	containers, err := c.backend.Containers(ProjectName)
+	if err != nil {
+		return Container{}, err
 	}
-	if service != "" {
-		args = append(args, service)
+	for _, ctr := range containers {
+		if ctr.Service == service && ctr.State == "running" {
+			return ctr, nil
+		}
 	}
+	return Container{}, fmt.Errorf("%s is %w", service, ErrNotRunning)
+}
 
-	cmd := c.buildCommand(args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// Events streams changes to the stack's containers until ctx is done
+func (c *Compose) Events(ctx context.Context) (<-chan Event, <-chan error) {
+	return c.backend.Events(ctx, ProjectName)
 }
 
-// LogsReader returns a reader for streaming logs
-func (c *Compose) LogsReader(service string, follow bool, tail string) (io.ReadCloser, error) {
-	args := []string{"logs"}
-	if follow {
-		args = append(args, "-f")
-	}
-	if tail != "" {
-		args = append(args, "--tail", tail)
-	}
-	if service != "" {
-		args = append(args, service)
+// Logs streams logs for a specific service or all services
+func (c *Compose) Logs(service string, follow bool, tail string) error {
+	reader, err := c.LogsReader(service, follow, tail)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
 
-	cmd := c.buildCommand(args...)
-	stdout, err := cmd.StdoutPipe()
+	_, err = io.Copy(os.Stdout, reader)
+	return err
+}
+
+// LogsReader returns a reader for streaming logs. Each line starts with
+// the container name, as in 'docker compose logs'.
+func (c *Compose) LogsReader(service string, follow bool, tail string) (io.ReadCloser, error) {
+	containers, err := c.backend.Containers(ProjectName)
 	if err != nil {
 		return nil, err
 	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	var names []string
+	var streams []io.ReadCloser
+	for _, ctr := range containers {
+		if service != "" && ctr.Service != service {
+			continue
+		}
+		stream, err := c.backend.Logs(ctr.ID, LogOptions{Follow: follow, Tail: tail})
+		if err != nil {
+			for _, s := range streams {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to read logs of %s: %w", ctr.Name, err)
+		}
+		names = append(names, ctr.Name)
+		streams = append(streams, stream)
 	}
 
-	return stdout, nil
+	if service != "" && len(streams) == 0 {
+		return nil, fmt.Errorf("no containers for service %s", service)
+	}
+	return mergeLogs(names, streams), nil
 }
 
 // Pull pulls images for specified profiles
@@ -412,8 +577,38 @@ func (c *Compose) RestartQuiet(service string) error {
 
 // StopService stops and removes a specific service container
 func (c *Compose) StopService(service string) error {
-	cmd := c.buildCommand("rm", "-f", "-s", service)
-	return cmd.Run()
+	cmds, err := c.stopServiceCommands(service)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopServiceCommands stops a service through compose, then removes its
+// containers with the runtime's CLI. podman-compose has no 'rm', so
+// 'compose rm -s' can't do both.
+func (c *Compose) stopServiceCommands(service string) ([]*exec.Cmd, error) {
+	containers, err := c.backend.Containers(ProjectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	cmds := []*exec.Cmd{c.buildCommand("stop", service)}
+	rm := []string{"rm", "-f"}
+	for _, ctr := range containers {
+		if ctr.Service == service {
+			rm = append(rm, ctr.Name)
+		}
+	}
+	if len(rm) > 2 {
+		cmds = append(cmds, exec.Command(c.runtime.Binary, rm...))
+	}
+	return cmds, nil
 }
 
 // GetProfiles returns the profiles to enable based on config
@@ -432,72 +627,65 @@ func GetProfiles(cfg *config.Config) []string {
 	return profiles
 }
 
-// DockerAvailable checks if Docker is available and running
-func DockerAvailable() error {
-	cmd := exec.Command("docker", "info")
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	return cmd.Run()
+// Runtime returns the container runtime the stack runs on
+func (c *Compose) Runtime() Runtime {
+	return c.runtime
 }
 
-// WaitForBridges waits for all specified bridges to be running
-func (c *Compose) WaitForBridges(bridges []string, timeout int) error {
-	if len(bridges) == 0 {
+// MinComposeVersion is the oldest Docker Compose muxbee is tested with
+const MinComposeVersion = "2.21.0"
+
+// WaitForBridges waits until every specified bridge is running and its
+// appservice listener answers, or the timeout (in seconds) passes
+func (c *Compose) WaitForBridges(bridgeNames []string, timeout int) error {
+	if len(bridgeNames) == 0 {
 		return nil
 	}
 
-	expected := make(map[string]bool)
-	for _, b := range bridges {
-		expected["mautrix-"+b] = false
+	pending := make(map[string]bridges.BridgeInfo)
+	for _, name := range bridgeNames {
+		if b := bridges.Get(name); b != nil {
+			pending[b.ServiceName()] = *b
+		}
 	}
 
-	for i := 0; i < timeout; i++ {
+	for i := 0; i < timeout && len(pending) > 0; i++ {
 		statuses, err := c.Status()
 		if err != nil {
 			time.Sleep(time.Second)
 			continue
 		}
 
-		allRunning := true
 		for _, s := range statuses {
 			svcName := ParseServiceName(s.Name)
-			if _, ok := expected[svcName]; ok {
-				if s.Running {
-					expected[svcName] = true
-				} else {
-					allRunning = false
-				}
+			b, ok := pending[svcName]
+			if !ok || !s.Running {
+				continue
 			}
-		}
-
-		if allRunning {
-			allFound := true
-			for _, running := range expected {
-				if !running {
-					allFound = false
-					break
-				}
-			}
-			if allFound {
-				return nil
+			if c.ProbeAppservice(b) == nil {
+				delete(pending, svcName)
 			}
 		}
 
-		time.Sleep(time.Second)
+		if len(pending) > 0 {
+			time.Sleep(time.Second)
+		}
 	}
 
 	return nil
 }
 
-// ParseServiceName extracts the service name from a container name
+// ParseServiceName extracts the service name from a container name, as
+// Docker Compose ("muxbee-synapse-1") and podman-compose
+// ("muxbee_synapse_1") name them
 func ParseServiceName(containerName string) string {
-	const prefix = "muxbee-"
-	if strings.HasPrefix(containerName, prefix) {
-		remainder := strings.TrimPrefix(containerName, prefix)
-		if idx := strings.LastIndex(remainder, "-"); idx > 0 {
-			return remainder[:idx]
+	for _, sep := range []string{"-", "_"} {
+		if remainder, ok := strings.CutPrefix(containerName, ProjectName+sep); ok {
+			if idx := strings.LastIndex(remainder, sep); idx > 0 {
+				return remainder[:idx]
+			}
+			return remainder
 		}
-		return remainder
 	}
 	parts := strings.Split(containerName, "-")
 	if len(parts) >= 2 {
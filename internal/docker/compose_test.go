@@ -3,6 +3,7 @@ package docker
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,13 +11,158 @@ import (
 	"github.com/tobocop2/muxbee/internal/config"
 )
 
-func TestDockerComposeYAMLEmbedded(t *testing.T) {
-	assert.NotEmpty(t, DockerComposeYAML)
-	assert.Contains(t, string(DockerComposeYAML), "name: muxbee")
-	assert.Contains(t, string(DockerComposeYAML), "postgres:")
-	assert.Contains(t, string(DockerComposeYAML), "synapse:")
-	assert.Contains(t, string(DockerComposeYAML), "element:")
-	assert.Contains(t, string(DockerComposeYAML), "mautrix-whatsapp:")
+// runtimeCase is how a runtime differs in what muxbee generates and runs
+type runtimeCase struct {
+	name    string
+	mount   string // Suffix of read-write bind mounts
+	mountRO string // Suffix of read-only bind mounts
+	compose []string
+}
+
+// forEachRuntime runs test once per container runtime, on a host where
+// every tool is installed and SELinux is enforcing
+func forEachRuntime(t *testing.T, test func(t *testing.T, rt runtimeCase)) {
+	cases := []runtimeCase{
+		{name: RuntimeDocker, mount: "", mountRO: ":ro", compose: []string{"docker", "compose"}},
+		{name: RuntimePodman, mount: ":z", mountRO: ":ro,z", compose: []string{"podman-compose"}},
+	}
+
+	origLookPath, origSELinux := lookPath, selinuxEnabled
+	t.Cleanup(func() { lookPath, selinuxEnabled = origLookPath, origSELinux })
+	lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	selinuxEnabled = func() bool { return true }
+
+	for _, rt := range cases {
+		t.Run(rt.name, func(t *testing.T) { test(t, rt) })
+	}
+}
+
+func TestRenderComposeFile_Minimal(t *testing.T) {
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		elementDisabled := false
+		cfg := &config.Config{
+			Runtime:        rt.name,
+			ElementEnabled: &elementDisabled,
+			EnabledBridges: []string{},
+		}
+
+		content, err := RenderComposeFile(cfg)
+		require.NoError(t, err)
+
+		out := string(content)
+		assert.Contains(t, out, "name: muxbee")
+		assert.Contains(t, out, "  postgres:\n")
+		assert.Contains(t, out, "  synapse:\n")
+		assert.NotContains(t, out, "  element:\n")
+		assert.NotContains(t, out, "  caddy:\n")
+		assert.NotContains(t, out, "mautrix-")
+		assert.Contains(t, out, "networks:\n  muxbee:\n    driver: bridge")
+		assert.Contains(t, out, "      - ${DATA_DIR}/postgres:/var/lib/postgresql/data"+rt.mount+"\n")
+		assert.Contains(t, out, "      - ${CONFIG_DIR}/bridges:/bridges"+rt.mountRO+"\n")
+		assert.Contains(t, out, "      - ${DATA_DIR}/synapse:/data"+rt.mount+"\n")
+	})
+}
+
+func TestRenderComposeFile_Defaults(t *testing.T) {
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		cfg := &config.Config{Runtime: rt.name, EnabledBridges: []string{}}
+
+		content, err := RenderComposeFile(cfg)
+		require.NoError(t, err)
+
+		out := string(content)
+		assert.Contains(t, out, "  element:\n    image: vectorim/element-web:latest\n    profiles: [\"element\"]")
+		assert.Contains(t, out, "${CONFIG_DIR}/element/config.json:/app/config.json"+rt.mountRO+"\n")
+		assert.NotContains(t, out, "  caddy:\n")
+	})
+}
+
+func TestRenderComposeFile_HTTPS(t *testing.T) {
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		cfg := &config.Config{
+			Runtime:        rt.name,
+			HTTPS:          config.HTTPSConfig{Enabled: true, Domain: "chat.example.com"},
+			EnabledBridges: []string{},
+		}
+
+		content, err := RenderComposeFile(cfg)
+		require.NoError(t, err)
+
+		out := string(content)
+		assert.Contains(t, out, "  caddy:\n    image: caddy:latest\n    profiles: [\"https\"]")
+		assert.Contains(t, out, "${CONFIG_DIR}/caddy/Caddyfile:/etc/caddy/Caddyfile"+rt.mountRO+"\n")
+		assert.Contains(t, out, "${DATA_DIR}/caddy/data:/data"+rt.mount+"\n")
+	})
+}
+
+func TestRenderComposeFile_SynapseMetrics(t *testing.T) {
+	cfg := &config.Config{EnabledBridges: []string{}}
+	content, err := RenderComposeFile(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), ":9000")
+
+	cfg.Metrics.Synapse = true
+	content, err = RenderComposeFile(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "      - \"${SYNAPSE_PORT:-8008}:8008\"\n      - \"127.0.0.1:${SYNAPSE_METRICS_PORT:-9000}:9000\"\n")
+}
+
+func TestRenderComposeFile_Bridges(t *testing.T) {
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		cfg := &config.Config{
+			Runtime:        rt.name,
+			EnabledBridges: []string{"whatsapp", "telegram", "nonexistent"},
+		}
+
+		content, err := RenderComposeFile(cfg)
+		require.NoError(t, err)
+
+		out := string(content)
+		assert.Contains(t, out, `  mautrix-whatsapp:
+    image: dock.mau.dev/mautrix/whatsapp:latest
+    profiles: ["whatsapp"]
+    restart: unless-stopped
+    depends_on:
+      synapse:
+        condition: service_healthy
+    volumes:
+      - ${DATA_DIR}/bridges/whatsapp:/data`+rt.mount+`
+    networks:
+      - muxbee
+`)
+		assert.Contains(t, out, "  mautrix-telegram:\n    image: dock.mau.dev/mautrix/telegram:latest")
+		assert.NotContains(t, out, "mautrix-signal")
+		assert.NotContains(t, out, "nonexistent")
+
+		// Bridges are rendered in registry order
+		assert.Less(t, strings.Index(out, "mautrix-telegram:"), strings.Index(out, "mautrix-whatsapp:"))
+	})
+}
+
+func TestRenderComposeFile_PodmanWithoutSELinux(t *testing.T) {
+	origSELinux := selinuxEnabled
+	t.Cleanup(func() { selinuxEnabled = origSELinux })
+	selinuxEnabled = func() bool { return false }
+
+	content, err := RenderComposeFile(&config.Config{Runtime: RuntimePodman, EnabledBridges: []string{}})
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), ":z")
+}
+
+func TestNewComposeData(t *testing.T) {
+	elementDisabled := false
+	cfg := &config.Config{
+		ElementEnabled: &elementDisabled,
+		HTTPS:          config.HTTPSConfig{Enabled: true},
+		EnabledBridges: []string{"signal", "discord"},
+	}
+
+	data := NewComposeData(cfg)
+	assert.False(t, data.Element)
+	assert.True(t, data.HTTPS)
+	require.Len(t, data.Bridges, 2)
+	assert.Equal(t, ComposeBridge{Name: "discord", ServiceName: "mautrix-discord", Image: "dock.mau.dev/mautrix/discord:latest"}, data.Bridges[0])
+	assert.Equal(t, "signal", data.Bridges[1].Name)
 }
 
 func TestNew(t *testing.T) {
@@ -77,7 +223,9 @@ func TestWriteComposeFile(t *testing.T) {
 	// Verify contents
 	content, err := os.ReadFile(composePath)
 	require.NoError(t, err)
-	assert.Equal(t, DockerComposeYAML, content)
+	expected, err := RenderComposeFile(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
 }
 
 func TestGetProfiles(t *testing.T) {
@@ -148,6 +296,8 @@ func TestParseServiceName(t *testing.T) {
 		{"muxbee-synapse-1", "synapse"},
 		{"muxbee-mautrix-whatsapp-1", "mautrix-whatsapp"},
 		{"muxbee-element-1", "element"},
+		{"muxbee_synapse_1", "synapse"},
+		{"muxbee_mautrix-whatsapp_1", "mautrix-whatsapp"},
 		{"simple", "simple"},
 	}
 
@@ -175,26 +325,42 @@ func TestComposePath(t *testing.T) {
 }
 
 func TestBuildCommand(t *testing.T) {
-	tmpDir := t.TempDir()
-	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, "config"))
-	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
-
-	cfg := &config.Config{
-		Postgres: config.PostgresConfig{Password: "testpass"},
-	}
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		tmpDir := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, "config"))
+		t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+		cfg := &config.Config{
+			Runtime:  rt.name,
+			Postgres: config.PostgresConfig{Password: "testpass"},
+		}
 
-	compose := New(cfg)
-	cmd := compose.buildCommand("up", "-d")
+		compose := New(cfg)
+		cmd := compose.buildCommand("up", "-d")
 
-	// Check that the command is docker
-	assert.Equal(t, "docker", cmd.Path[len(cmd.Path)-6:])
+		// The runtime's compose command, then -f and the actual args
+		expected := append(rt.compose, "-f", compose.composePath(), "up", "-d")
+		assert.Equal(t, expected, cmd.Args)
+	})
+}
 
-	// Check args include compose, -f, and the actual args
-	args := cmd.Args[1:] // skip program name
-	assert.Contains(t, args, "compose")
-	assert.Contains(t, args, "-f")
-	assert.Contains(t, args, "up")
-	assert.Contains(t, args, "-d")
+func TestStopServiceCommands(t *testing.T) {
+	forEachRuntime(t, func(t *testing.T, rt runtimeCase) {
+		tmpDir := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, "config"))
+		t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+		compose := NewWithBackend(&config.Config{Runtime: rt.name}, &fakeBackend{containers: []Container{
+			{Name: "muxbee-mautrix-signal-1", Service: "mautrix-signal", State: "running"},
+			{Name: "muxbee-synapse-1", Service: "synapse", State: "running"},
+		}})
+		cmds, err := compose.stopServiceCommands("mautrix-signal")
+		require.NoError(t, err)
+		require.Len(t, cmds, 2)
+
+		assert.Equal(t, append(rt.compose, "-f", compose.composePath(), "stop", "mautrix-signal"), cmds[0].Args)
+		assert.Equal(t, []string{rt.name, "rm", "-f", "muxbee-mautrix-signal-1"}, cmds[1].Args)
+	})
 }
 
 func TestBuildCommand_Environment(t *testing.T) {
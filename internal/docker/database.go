@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec runs a command inside a running service container without a TTY,
+// wiring stdin/stdout to the given reader and writer (either may be nil).
+// A failing command's error wraps an *ExitError carrying its stderr.
+func (c *Compose) Exec(service string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	ctr, err := c.serviceContainer(service)
+	if err != nil {
+		return err
+	}
+
+	if err := c.backend.Exec(ctr.ID, args, stdin, stdout); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// serviceWaitTimeout bounds how long StartServiceWait polls a service
+// when the compose tool can't wait itself
+const serviceWaitTimeout = 2 * time.Minute
+
+// StartServiceWait starts a single service (and its dependencies) and waits
+// until it is running and healthy
+func (c *Compose) StartServiceWait(service string) error {
+	args := []string{"up", "-d"}
+	if c.runtime.ComposeWait {
+		args = append(args, "--wait")
+	}
+	args = append(args, service)
+
+	var stderr bytes.Buffer
+	cmd := c.buildCommand(args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to start %s: %s", service, msg)
+		}
+		return fmt.Errorf("failed to start %s: %w", service, err)
+	}
+
+	if !c.runtime.ComposeWait {
+		return c.waitHealthy(service, serviceWaitTimeout, time.Second)
+	}
+	return nil
+}
+
+// waitHealthy polls until a service's container is running and, if it has
+// a healthcheck, healthy
+func (c *Compose) waitHealthy(service string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ctr, err := c.serviceContainer(service)
+		if err == nil && (ctr.Health == "" || ctr.Health == "healthy") {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("failed to start %s: %w", service, err)
+			}
+			return fmt.Errorf("failed to start %s: container is %s", service, ctr.Health)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// DumpPostgres writes a pg_dump custom-format archive of the Synapse database
+func (c *Compose) DumpPostgres(w io.Writer) error {
+	return c.Exec("postgres", nil, w,
+		"pg_dump", "-U", c.cfg.Postgres.User, "-d", c.cfg.Postgres.Database, "--format=custom")
+}
+
+// RestorePostgres loads a pg_dump custom-format archive into the Synapse database
+func (c *Compose) RestorePostgres(r io.Reader) error {
+	return c.Exec("postgres", r, nil,
+		"pg_restore", "-U", c.cfg.Postgres.User, "-d", c.cfg.Postgres.Database,
+		"--no-owner", "--clean", "--if-exists", "--exit-on-error")
+}
+
+// DatabaseSizes returns the size in bytes of each database in the
+// Postgres container, keyed by name
+func (c *Compose) DatabaseSizes() (map[string]int64, error) {
+	var out bytes.Buffer
+	err := c.Exec("postgres", nil, &out,
+		"psql", "-U", c.cfg.Postgres.User, "-d", c.cfg.Postgres.Database, "-tA", "-F", "\t",
+		"-c", "SELECT datname, pg_database_size(datname) FROM pg_database WHERE NOT datistemplate")
+	if err != nil {
+		return nil, err
+	}
+	return parseDatabaseSizes(out.String())
+}
+
+// parseDatabaseSizes reads psql's unaligned "name<TAB>size" rows
+func parseDatabaseSizes(out string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		name, size, ok := strings.Cut(line, "\t")
+		n, err := strconv.ParseInt(size, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("unexpected psql output: %q", line)
+		}
+		sizes[name] = n
+	}
+	return sizes, nil
+}
@@ -0,0 +1,381 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// engineTimeout bounds API calls that don't stream
+const engineTimeout = 30 * time.Second
+
+// healthPattern finds the health state in a container list status such as
+// "Up 5 minutes (healthy)" or "Up 3 seconds (health: starting)"
+var healthPattern = regexp.MustCompile(`\((?:health: )?(healthy|unhealthy|starting)\)`)
+
+// Engine talks to the Docker Engine API over a Unix socket
+type Engine struct {
+	socket string
+	client *http.Client
+}
+
+// NewEngine creates an Engine for the daemon listening on socket
+func NewEngine(socket string) *Engine {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Engine{socket: socket, client: &http.Client{Transport: transport}}
+}
+
+// Ping checks that the daemon answers
+func (e *Engine) Ping() error {
+	return e.call(http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// Containers lists the containers of a compose project
+func (e *Engine) Containers(project string) ([]Container, error) {
+	query := url.Values{
+		"all":     {"1"},
+		"filters": {projectFilter(project, false)},
+	}
+
+	var list []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		ImageID string            `json:"ImageID"`
+		State   string            `json:"State"`
+		Status  string            `json:"Status"`
+		Labels  map[string]string `json:"Labels"`
+	}
+	if err := e.call(http.MethodGet, "/containers/json", query, nil, &list); err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0, len(list))
+	for _, l := range list {
+		c := Container{
+			ID:      l.ID,
+			Service: l.Labels[serviceLabel],
+			Image:   l.Image,
+			ImageID: l.ImageID,
+			State:   l.State,
+			Labels:  l.Labels,
+		}
+		if len(l.Names) > 0 {
+			c.Name = strings.TrimPrefix(l.Names[0], "/")
+		}
+		if m := healthPattern.FindStringSubmatch(l.Status); m != nil {
+			c.Health = m[1]
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// Inspect returns one container by ID or name
+func (e *Engine) Inspect(container string) (*Container, error) {
+	var result inspectResult
+	if err := e.call(http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	c := result.container()
+	return &c, nil
+}
+
+// Logs streams a container's stdout and stderr, interleaved. Containers
+// without a TTY send both multiplexed on one stream.
+func (e *Engine) Logs(container string, opts LogOptions) (io.ReadCloser, error) {
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+
+	resp, err := e.stream(context.Background(), "/containers/"+url.PathEscape(container)+"/logs", query)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(demux(resp.Body, pw, pw))
+	}()
+	return &streamReader{PipeReader: pr, body: resp.Body}, nil
+}
+
+// Exec runs a command in a running container
+func (e *Engine) Exec(container string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := e.call(http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, map[string]any{
+		"AttachStdin":  stdin != nil,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	conn, br, err := e.hijack("/exec/"+created.ID+"/start", map[string]any{"Detach": false, "Tty": false})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var stderr bytes.Buffer
+	if err := demux(br, stdout, &stderr); err != nil {
+		return fmt.Errorf("failed to read output: %w", err)
+	}
+
+	var inspect struct {
+		ExitCode int  `json:"ExitCode"`
+		Running  bool `json:"Running"`
+	}
+	if err := e.call(http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect); err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return &ExitError{Code: inspect.ExitCode, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return nil
+}
+
+// Events streams container events of a compose project until ctx is done
+func (e *Engine) Events(ctx context.Context, project string) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(events)
+
+		resp, err := e.stream(ctx, "/events", url.Values{"filters": {projectFilter(project, true)}})
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		if err := decodeEvents(ctx, resp.Body, events); err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs
+}
+
+// ImageRepoDigests returns the repository digests of a local image
+func (e *Engine) ImageRepoDigests(image string) ([]string, error) {
+	var result struct {
+		RepoDigests []string `json:"RepoDigests"`
+	}
+	if err := e.call(http.MethodGet, "/images/"+url.PathEscape(image)+"/json", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.RepoDigests, nil
+}
+
+// TagImage points ref at an existing image
+func (e *Engine) TagImage(image, ref string) error {
+	repo, tag := splitImageRef(ref)
+	query := url.Values{"repo": {repo}, "tag": {tag}}
+	return e.call(http.MethodPost, "/images/"+url.PathEscape(image)+"/tag", query, nil, nil)
+}
+
+// call makes a request that doesn't stream, decoding the JSON response
+// into out if it isn't nil
+func (e *Engine) call(method, path string, query url.Values, body, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), engineTimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, e.url(path, query), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return e.unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream starts a GET whose response body the caller reads and closes
+func (e *Engine) stream(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url(path, query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, e.unavailable(err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// hijack POSTs to an endpoint that takes over the connection for raw
+// stdin/stdout, as exec start does
+func (e *Engine) hijack(path string, body any) (net.Conn, *bufio.Reader, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.Dial("unix", e.socket)
+	if err != nil {
+		return nil, nil, e.unavailable(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url(path, nil), bytes.NewReader(data))
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, e.unavailable(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, e.unavailable(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, nil, apiError(resp)
+	}
+	return conn, br, nil
+}
+
+func (e *Engine) url(path string, query url.Values) string {
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (e *Engine) unavailable(err error) error {
+	return fmt.Errorf("%w at %s: %v", ErrUnavailable, e.socket, err)
+}
+
+// apiError reads the daemon's {"message": ...} error body
+func apiError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	if body.Message == "" {
+		body.Message = http.StatusText(resp.StatusCode)
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: body.Message}
+}
+
+// projectFilter returns the filters parameter selecting a compose project
+func projectFilter(project string, onlyContainers bool) string {
+	filters := map[string][]string{"label": {projectLabel + "=" + project}}
+	if onlyContainers {
+		filters["type"] = []string{"container"}
+	}
+	data, _ := json.Marshal(filters)
+	return string(data)
+}
+
+// decodeEvents reads a stream of JSON event messages into events until
+// the stream ends or ctx is done
+func decodeEvents(ctx context.Context, r io.Reader, events chan<- Event) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg eventMessage
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil || err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+
+		ev, ok := msg.event()
+		if !ok {
+			continue
+		}
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// streamReader is a demultiplexed stream; closing it closes the response
+type streamReader struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+func (s *streamReader) Close() error {
+	s.body.Close()
+	return s.PipeReader.Close()
+}
@@ -0,0 +1,448 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// fakeDaemon serves handler on a Unix socket and returns an Engine for it
+func fakeDaemon(t *testing.T, handler http.Handler) *Engine {
+	t.Helper()
+
+	// Socket paths are limited to ~100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "muxbee")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return NewEngine(socket)
+}
+
+// frame encodes output the way the daemon multiplexes it
+func frame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestEngine_Ping(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_ping", r.URL.Path)
+		w.Write([]byte("OK"))
+	}))
+	assert.NoError(t, engine.Ping())
+}
+
+func TestEngine_Unavailable(t *testing.T) {
+	engine := NewEngine(filepath.Join(t.TempDir(), "missing.sock"))
+
+	err := engine.Ping()
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	_, err = engine.Containers(ProjectName)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestEngine_Containers(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/json", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("all"))
+
+		var filters map[string][]string
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters))
+		assert.Equal(t, []string{"com.docker.compose.project=muxbee"}, filters["label"])
+
+		w.Write([]byte(`[
+			{"Id": "a1", "Names": ["/muxbee-synapse-1"], "Image": "matrixdotorg/synapse:latest", "ImageID": "sha256:s",
+			 "State": "running", "Status": "Up 5 minutes (healthy)",
+			 "Labels": {"com.docker.compose.service": "synapse"}},
+			{"Id": "b2", "Names": ["/muxbee-postgres-1"], "Image": "postgres:17", "ImageID": "sha256:p",
+			 "State": "running", "Status": "Up 3 seconds (health: starting)",
+			 "Labels": {"com.docker.compose.service": "postgres"}},
+			{"Id": "c3", "Names": ["/muxbee-element-1"], "Image": "vectorim/element-web:latest",
+			 "State": "exited", "Status": "Exited (0) 2 hours ago",
+			 "Labels": {"com.docker.compose.service": "element"}}
+		]`))
+	}))
+
+	containers, err := engine.Containers(ProjectName)
+	require.NoError(t, err)
+	require.Len(t, containers, 3)
+
+	assert.Equal(t, "a1", containers[0].ID)
+	assert.Equal(t, "muxbee-synapse-1", containers[0].Name)
+	assert.Equal(t, "synapse", containers[0].Service)
+	assert.Equal(t, "sha256:s", containers[0].ImageID)
+	assert.Equal(t, "healthy", containers[0].Health)
+	assert.Equal(t, "starting", containers[1].Health)
+	assert.Equal(t, "exited", containers[2].State)
+	assert.Equal(t, "", containers[2].Health)
+}
+
+func TestEngine_Inspect(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/muxbee-synapse-1/json" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "No such container: gone"}`))
+			return
+		}
+		w.Write([]byte(`{
+			"Id": "a1", "Name": "/muxbee-synapse-1", "Image": "sha256:s", "RestartCount": 4,
+			"Config": {"Image": "matrixdotorg/synapse:latest", "Labels": {"com.docker.compose.service": "synapse"}},
+			"State": {"Status": "restarting", "ExitCode": 1, "StartedAt": "2026-01-02T03:04:05Z",
+			          "Health": {"Status": "unhealthy"}}
+		}`))
+	}))
+
+	ctr, err := engine.Inspect("muxbee-synapse-1")
+	require.NoError(t, err)
+	assert.Equal(t, "muxbee-synapse-1", ctr.Name)
+	assert.Equal(t, "synapse", ctr.Service)
+	assert.Equal(t, "matrixdotorg/synapse:latest", ctr.Image)
+	assert.Equal(t, "sha256:s", ctr.ImageID)
+	assert.Equal(t, "restarting", ctr.State)
+	assert.Equal(t, "unhealthy", ctr.Health)
+	assert.Equal(t, 1, ctr.ExitCode)
+	assert.Equal(t, 4, ctr.RestartCount)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), ctr.StartedAt)
+
+	_, err = engine.Inspect("gone")
+	assert.ErrorIs(t, err, ErrNotFound)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "No such container: gone", apiErr.Message)
+}
+
+func TestEngine_Logs(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/a1/logs", r.URL.Path)
+		assert.Equal(t, "10", r.URL.Query().Get("tail"))
+		assert.Equal(t, "", r.URL.Query().Get("follow"))
+		w.Write(frame(streamStdout, "starting\n"))
+		w.Write(frame(streamStderr, "warning\n"))
+		w.Write(frame(streamStdout, "ready\n"))
+	}))
+
+	logs, err := engine.Logs("a1", LogOptions{Tail: "10"})
+	require.NoError(t, err)
+	defer logs.Close()
+
+	out, err := io.ReadAll(logs)
+	require.NoError(t, err)
+	assert.Equal(t, "starting\nwarning\nready\n", string(out))
+}
+
+func TestEngine_Exec(t *testing.T) {
+	var exitCode int
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/a1/exec":
+			var body struct {
+				AttachStdin bool
+				Cmd         []string
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, []string{"cat"}, body.Cmd)
+			assert.True(t, body.AttachStdin)
+			w.Write([]byte(`{"Id": "e1"}`))
+
+		case "/exec/e1/start":
+			assert.Equal(t, "tcp", r.Header.Get("Upgrade"))
+			io.ReadAll(r.Body)
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			defer conn.Close()
+
+			rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			rw.Flush()
+
+			// Echo stdin back once the client closes its side
+			stdin, _ := io.ReadAll(rw)
+			conn.Write(frame(streamStdout, string(stdin)))
+			conn.Write(frame(streamStderr, "oops\n"))
+
+		case "/exec/e1/json":
+			json.NewEncoder(w).Encode(map[string]any{"ExitCode": exitCode, "Running": false})
+
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+
+	var out bytes.Buffer
+	err := engine.Exec("a1", []string{"cat"}, strings.NewReader("hello"), &out)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", out.String())
+
+	exitCode = 3
+	out.Reset()
+	err = engine.Exec("a1", []string{"cat"}, strings.NewReader("hello"), &out)
+	var exitErr *ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.Code)
+	assert.Equal(t, "oops", exitErr.Stderr)
+}
+
+func TestEngine_Events(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters))
+		assert.Equal(t, []string{"container"}, filters["type"])
+
+		w.Write([]byte(`{"Type": "container", "Action": "exec_start: cat", "Actor": {"ID": "a1"}}` + "\n"))
+		w.Write([]byte(`{"Type": "container", "Action": "die", "timeNano": 1700000000000000000,
+			"Actor": {"ID": "a1", "Attributes": {"name": "muxbee-synapse-1", "com.docker.compose.service": "synapse", "exitCode": "1"}}}` + "\n"))
+	}))
+
+	events, errs := engine.Events(context.Background(), ProjectName)
+
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	assert.NoError(t, <-errs)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "die", got[0].Action)
+	assert.Equal(t, "muxbee-synapse-1", got[0].Container)
+	assert.Equal(t, "synapse", got[0].Service)
+	assert.Equal(t, "1", got[0].Attributes["exitCode"])
+	assert.Equal(t, int64(1700000000), got[0].Time.Unix())
+}
+
+func TestEngine_TagImage(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/images/sha256:old/tag", r.URL.Path)
+		assert.Equal(t, "registry:5000/bridge", r.URL.Query().Get("repo"))
+		assert.Equal(t, "v2", r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	assert.NoError(t, engine.TagImage("sha256:old", "registry:5000/bridge:v2"))
+}
+
+func TestEngine_Stats(t *testing.T) {
+	engine := fakeDaemon(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/muxbee-synapse-1/stats", r.URL.Path)
+		assert.Equal(t, "0", r.URL.Query().Get("stream"))
+		w.Write([]byte(`{
+			"cpu_stats": {"cpu_usage": {"total_usage": 3000000000}, "system_cpu_usage": 20000000000, "online_cpus": 4},
+			"precpu_stats": {"cpu_usage": {"total_usage": 2000000000}, "system_cpu_usage": 10000000000},
+			"memory_stats": {"usage": 300000000, "limit": 8000000000, "stats": {"inactive_file": 100000000}}
+		}`))
+	}))
+
+	stats, err := engine.Stats("muxbee-synapse-1")
+	require.NoError(t, err)
+	assert.InDelta(t, 40.0, stats.CPUPercent, 0.001)
+	assert.Equal(t, uint64(200000000), stats.MemoryBytes)
+	assert.Equal(t, uint64(8000000000), stats.MemoryLimit)
+}
+
+func TestParseStatsLine(t *testing.T) {
+	// Docker
+	stats, err := parseStatsLine("0.52%\t27.5MiB / 7.5GiB")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.52, stats.CPUPercent, 0.001)
+	assert.Equal(t, uint64(27.5*(1<<20)), stats.MemoryBytes)
+	assert.Equal(t, uint64(7.5*(1<<30)), stats.MemoryLimit)
+
+	// Podman
+	stats, err = parseStatsLine("12.00%\t1.5GB / 16GB")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1.5e9), stats.MemoryBytes)
+	assert.Equal(t, uint64(16e9), stats.MemoryLimit)
+
+	_, err = parseStatsLine("--")
+	assert.Error(t, err)
+	_, err = parseStatsLine("1%\t12 parsecs")
+	assert.Error(t, err)
+}
+
+func TestParseDatabaseSizes(t *testing.T) {
+	sizes, err := parseDatabaseSizes("postgres\t7561763\nsynapse\t52109859\n")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"postgres": 7561763, "synapse": 52109859}, sizes)
+
+	_, err = parseDatabaseSizes("ERROR: oops")
+	assert.Error(t, err)
+}
+
+func TestSplitImageRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		repo string
+		tag  string
+	}{
+		{"postgres:17", "postgres", "17"},
+		{"caddy", "caddy", "latest"},
+		{"registry:5000/bridge", "registry:5000/bridge", "latest"},
+		{"registry:5000/bridge:v2", "registry:5000/bridge", "v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			repo, tag := splitImageRef(tt.ref)
+			assert.Equal(t, tt.repo, repo)
+			assert.Equal(t, tt.tag, tag)
+		})
+	}
+}
+
+func TestMergeLogs(t *testing.T) {
+	logs := mergeLogs(
+		[]string{"muxbee-synapse-1", "muxbee-db-1"},
+		[]io.ReadCloser{
+			io.NopCloser(strings.NewReader("one\ntwo\n")),
+			io.NopCloser(strings.NewReader("three")),
+		},
+	)
+	defer logs.Close()
+
+	out, err := io.ReadAll(logs)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	assert.ElementsMatch(t, []string{
+		"muxbee-synapse-1 | one",
+		"muxbee-synapse-1 | two",
+		"muxbee-db-1      | three",
+	}, lines)
+}
+
+func TestClassify(t *testing.T) {
+	assert.ErrorIs(t, classify("Cannot connect to the Docker daemon at unix:///var/run/docker.sock"), ErrUnavailable)
+	assert.ErrorIs(t, classify("Error response from daemon: No such container: x"), ErrNotFound)
+	assert.NoError(t, classify("something else"))
+}
+
+// fakeBackend serves canned containers and counts exec calls
+type fakeBackend struct {
+	Backend
+	containers []Container
+	err        error
+	execs      int
+}
+
+func (f *fakeBackend) Containers(project string) ([]Container, error) {
+	return f.containers, f.err
+}
+
+func (f *fakeBackend) Exec(container string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	f.execs++
+	io.WriteString(stdout, "mautrix-signal v0.8.1+dev.abc (built today)")
+	return nil
+}
+
+func (f *fakeBackend) Inspect(container string) (*Container, error) {
+	for _, ctr := range f.containers {
+		if ctr.ID == container || ctr.Name == container {
+			return &ctr, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func TestStatus(t *testing.T) {
+	backend := &fakeBackend{containers: []Container{
+		{ID: "status-test-signal", Name: "muxbee-mautrix-signal-1", Service: "mautrix-signal", Image: "dock.mau.dev/mautrix/signal:latest", State: "running"},
+		{ID: "status-test-db", Name: "muxbee-postgres-1", Service: "postgres", Image: "postgres:17", State: "running", Health: "healthy"},
+		{ID: "status-test-web", Name: "muxbee-element-1", Service: "element", State: "exited"},
+	}}
+	c := NewWithBackend(&config.Config{}, backend)
+
+	statuses, err := c.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	// Sorted by container name
+	assert.Equal(t, "muxbee-element-1", statuses[0].Name)
+	assert.False(t, statuses[0].Running)
+	assert.Equal(t, "", statuses[0].Version)
+
+	assert.Equal(t, "0.8.1", statuses[1].Version)
+	assert.Equal(t, "17", statuses[2].Version)
+	assert.Equal(t, "healthy", statuses[2].Health)
+	assert.Equal(t, 1, backend.execs)
+
+	// Versions are cached per container
+	_, err = c.Status()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backend.execs)
+}
+
+func TestStatus_Error(t *testing.T) {
+	c := NewWithBackend(&config.Config{}, &fakeBackend{err: ErrUnavailable})
+
+	statuses, err := c.Status()
+	assert.Nil(t, statuses)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestDetailedStatus(t *testing.T) {
+	c := NewWithBackend(&config.Config{}, &fakeBackend{containers: []Container{
+		{ID: "detail-test-db", Name: "muxbee-postgres-1", Service: "postgres", Image: "postgres:17", State: "running", RestartCount: 2},
+		{ID: "detail-test-web", Name: "muxbee-element-1", Service: "element", State: "exited", ExitCode: 137},
+	}})
+
+	statuses, err := c.DetailedStatus()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, 137, statuses[0].ExitCode)
+	assert.Equal(t, 0, statuses[0].RestartCount)
+	assert.Equal(t, 2, statuses[1].RestartCount)
+	assert.Equal(t, "17", statuses[1].Version)
+}
+
+func TestInspectService(t *testing.T) {
+	c := NewWithBackend(&config.Config{}, &fakeBackend{containers: []Container{
+		{ID: "inspect-test-db", Name: "muxbee-postgres-1", Service: "postgres", Image: "postgres:16", State: "running", Health: "starting", RestartCount: 1},
+	}})
+
+	s, err := c.InspectService("inspect-test-db")
+	require.NoError(t, err)
+	assert.Equal(t, "muxbee-postgres-1", s.Name)
+	assert.True(t, s.Running)
+	assert.Equal(t, "starting", s.Health)
+	assert.Equal(t, "16", s.Version)
+	assert.Equal(t, 1, s.RestartCount)
+
+	_, err = c.InspectService("gone")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestServiceContainer_NotRunning(t *testing.T) {
+	c := NewWithBackend(&config.Config{}, &fakeBackend{containers: []Container{
+		{ID: "x", Service: "synapse", State: "exited"},
+	}})
+
+	_, err := c.serviceContainer("synapse")
+	assert.ErrorIs(t, err, ErrNotRunning)
+	assert.EqualError(t, err, "synapse is not running")
+}
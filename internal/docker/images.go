@@ -0,0 +1,177 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// CoreServices lists the non-bridge services in the order they appear in the compose file
+var CoreServices = []string{"postgres", "synapse", "element", "caddy"}
+
+// coreImages holds the default image for each core service
+var coreImages = map[string]string{
+	"postgres": "postgres:17",
+	"synapse":  "matrixdotorg/synapse:latest",
+	"element":  "vectorim/element-web:latest",
+	"caddy":    "caddy:latest",
+}
+
+// DefaultImage returns the unpinned image for a compose service, or "" if unknown
+func DefaultImage(service string) string {
+	if image, ok := coreImages[service]; ok {
+		return image
+	}
+	for _, b := range bridges.List() {
+		if b.ServiceName() == service {
+			return b.Image()
+		}
+	}
+	return ""
+}
+
+// ServiceImage returns the image for a compose service with any pinned version applied
+func ServiceImage(cfg *config.Config, service string) string {
+	return ApplyPin(DefaultImage(service), cfg.PinnedVersion(service))
+}
+
+// Pinned versions are written into image references in the compose file,
+// so they are limited to what a tag or a digest can be
+var (
+	tagPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ValidatePin checks that pin is an image tag or a sha256 digest, in any
+// form ApplyPin accepts
+func ValidatePin(pin string) error {
+	pin = strings.TrimPrefix(pin, "@")
+	if strings.HasPrefix(pin, "sha256:") {
+		if !digestPattern.MatchString(pin) {
+			return fmt.Errorf("invalid digest %q: expected sha256: and 64 lowercase hex digits", pin)
+		}
+		return nil
+	}
+	if !tagPattern.MatchString(strings.TrimPrefix(pin, ":")) {
+		return fmt.Errorf("invalid version %q: expected an image tag (letters, digits, '.', '_' and '-', at most 128) or a sha256: digest", pin)
+	}
+	return nil
+}
+
+// ValidatePins checks every pinned version in settings.yaml, naming the
+// service of each bad one
+func ValidatePins(cfg *config.Config) error {
+	services := make([]string, 0, len(cfg.Versions))
+	for service := range cfg.Versions {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var errs []error
+	for _, service := range services {
+		if err := ValidatePin(cfg.Versions[service]); err != nil {
+			errs = append(errs, fmt.Errorf("pinned version of %s in %s: %w", service, config.SettingsPath(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ApplyPin replaces the tag or digest of an image reference with a pinned version.
+// Pins starting with "sha256:" (optionally prefixed by "@") are applied as digests,
+// anything else as a tag.
+func ApplyPin(image, pin string) string {
+	if image == "" || pin == "" {
+		return image
+	}
+
+	repo := imageRepository(image)
+	pin = strings.TrimPrefix(pin, "@")
+	if strings.HasPrefix(pin, "sha256:") {
+		return repo + "@" + pin
+	}
+	return repo + ":" + strings.TrimPrefix(pin, ":")
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	if idx := strings.Index(image, "@"); idx != -1 {
+		image = image[:idx]
+	}
+	// A colon after the last slash is a tag; one before it is a registry port
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		image = image[:idx]
+	}
+	return image
+}
+
+// ConfiguredServices returns the compose services the config enables,
+// core services first, then bridges in the order they were enabled
+func ConfiguredServices(cfg *config.Config) []string {
+	services := []string{"postgres", "synapse"}
+	if cfg.IsElementEnabled() {
+		services = append(services, "element")
+	}
+	if cfg.HTTPS.Enabled {
+		services = append(services, "caddy")
+	}
+	for _, name := range cfg.EnabledBridges {
+		services = append(services, bridges.ServiceNameFor(name))
+	}
+	return services
+}
+
+// ResolveService maps a service or bridge name to its compose service name.
+// Returns false if the name matches neither a core service nor a known bridge.
+func ResolveService(name string) (string, bool) {
+	if _, ok := coreImages[name]; ok {
+		return name, true
+	}
+	if b := bridges.Get(name); b != nil {
+		return b.ServiceName(), true
+	}
+	for _, b := range bridges.List() {
+		if b.ServiceName() == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ShortVersion shortens sha256 digests for display, leaving tags unchanged
+func ShortVersion(version string) string {
+	if strings.HasPrefix(version, "sha256:") && len(version) > 19 {
+		return version[:19]
+	}
+	return version
+}
+
+// RunningImageDigest returns the repository digest (sha256:...) of the image
+// the service's container is currently running
+func (c *Compose) RunningImageDigest(service string) (string, error) {
+	ctr, err := c.serviceContainer(service)
+	if err != nil {
+		return "", err
+	}
+
+	return c.imageRepoDigest(ctr.ImageID)
+}
+
+// imageRepoDigest returns the first repository digest of a local image
+func (c *Compose) imageRepoDigest(imageID string) (string, error) {
+	digests, err := c.backend.ImageRepoDigests(imageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", imageID, err)
+	}
+
+	for _, d := range digests {
+		if idx := strings.Index(d, "@"); idx != -1 {
+			return d[idx+1:], nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no repository digest (built locally?)", imageID)
+}
@@ -0,0 +1,137 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+func TestApplyPin(t *testing.T) {
+	tests := []struct {
+		image    string
+		pin      string
+		expected string
+	}{
+		{"matrixdotorg/synapse:latest", "", "matrixdotorg/synapse:latest"},
+		{"matrixdotorg/synapse:latest", "v1.120.0", "matrixdotorg/synapse:v1.120.0"},
+		{"postgres:17", "17.2", "postgres:17.2"},
+		{"caddy", "2.8", "caddy:2.8"},
+		{"dock.mau.dev/mautrix/whatsapp:latest", "sha256:abc", "dock.mau.dev/mautrix/whatsapp@sha256:abc"},
+		{"dock.mau.dev/mautrix/whatsapp:latest", "@sha256:abc", "dock.mau.dev/mautrix/whatsapp@sha256:abc"},
+		{"registry:5000/bridge@sha256:old", "v2", "registry:5000/bridge:v2"},
+		{"registry:5000/bridge", "v2", "registry:5000/bridge:v2"},
+		{"", "v2", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image+"+"+tt.pin, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ApplyPin(tt.image, tt.pin))
+		})
+	}
+}
+
+func TestValidatePin(t *testing.T) {
+	digest := "sha256:4f2a0c1e9b8d7a6f5e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f"
+	for _, pin := range []string{"v1.120.0", "17.2", "latest", "_build-1", ":v2", digest, "@" + digest} {
+		assert.NoError(t, ValidatePin(pin), pin)
+	}
+	for _, pin := range []string{
+		"",
+		".hidden",
+		"-flag",
+		"v1 ; rm -rf",
+		"v1\nimage: evil",
+		"registry/evil:v1",
+		"sha256:abc",
+		"sha256:" + strings.Repeat("A", 64),
+		strings.Repeat("a", 129),
+	} {
+		assert.Error(t, ValidatePin(pin), pin)
+	}
+}
+
+func TestDefaultImage(t *testing.T) {
+	assert.Equal(t, "postgres:17", DefaultImage("postgres"))
+	assert.Equal(t, "matrixdotorg/synapse:latest", DefaultImage("synapse"))
+	assert.Equal(t, "dock.mau.dev/mautrix/signal:latest", DefaultImage("mautrix-signal"))
+	assert.Equal(t, "", DefaultImage("unknown"))
+}
+
+func TestResolveService(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"synapse", "synapse", true},
+		{"whatsapp", "mautrix-whatsapp", true},
+		{"mautrix-telegram", "mautrix-telegram", true},
+		{"unknown", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, ok := ResolveService(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, service)
+		})
+	}
+}
+
+func TestConfiguredServices(t *testing.T) {
+	disabled := false
+	cfg := &config.Config{
+		ElementEnabled: &disabled,
+		EnabledBridges: []string{"whatsapp", "signal"},
+	}
+	assert.Equal(t, []string{"postgres", "synapse", "mautrix-whatsapp", "mautrix-signal"}, ConfiguredServices(cfg))
+
+	cfg.ElementEnabled = nil
+	cfg.HTTPS.Enabled = true
+	cfg.EnabledBridges = nil
+	assert.Equal(t, []string{"postgres", "synapse", "element", "caddy"}, ConfiguredServices(cfg))
+}
+
+func TestShortVersion(t *testing.T) {
+	assert.Equal(t, "v1.120.0", ShortVersion("v1.120.0"))
+	assert.Equal(t, "sha256:4f2a9c1b0d3e", ShortVersion("sha256:4f2a9c1b0d3e5f6a7b8c9d0e1f2a3b4c"))
+}
+
+func TestRenderComposeFile_PinnedVersions(t *testing.T) {
+	cfg := &config.Config{
+		EnabledBridges: []string{"whatsapp"},
+		Versions: map[string]string{
+			"synapse":          "v1.120.0",
+			"postgres":         "17.2",
+			"mautrix-whatsapp": "sha256:" + strings.Repeat("de", 32),
+		},
+	}
+
+	content, err := RenderComposeFile(cfg)
+	assert.NoError(t, err)
+
+	out := string(content)
+	assert.Contains(t, out, "image: matrixdotorg/synapse:v1.120.0")
+	assert.Contains(t, out, "image: postgres:17.2")
+	assert.Contains(t, out, "image: dock.mau.dev/mautrix/whatsapp@sha256:"+strings.Repeat("de", 32))
+	assert.Contains(t, out, "image: vectorim/element-web:latest")
+}
+
+func TestRenderComposeFile_InvalidPin(t *testing.T) {
+	cfg := &config.Config{
+		Versions: map[string]string{
+			"synapse":  "v1.120.0",
+			"postgres": "17 2",
+			"caddy":    "sha256:deadbeef",
+		},
+	}
+
+	_, err := RenderComposeFile(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pinned version of caddy")
+	assert.Contains(t, err.Error(), "pinned version of postgres")
+	assert.NotContains(t, err.Error(), "synapse")
+}
@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+)
+
+// probeScript requests a URL and exits non-zero only if nothing answers.
+// Any HTTP status counts as reachable: the listener is alive, which is all
+// Synapse needs to deliver events.
+const probeScript = `import sys, urllib.request, urllib.error
+try:
+    urllib.request.urlopen(sys.argv[1], timeout=5)
+except urllib.error.HTTPError:
+    pass
+except Exception as e:
+    sys.exit(str(getattr(e, "reason", e)))
+`
+
+// AppserviceURL returns the address Synapse uses to reach a bridge's
+// appservice listener inside the compose network
+func AppserviceURL(bridge bridges.BridgeInfo) string {
+	return fmt.Sprintf("http://%s:%d", bridge.ServiceName(), bridge.Port)
+}
+
+// ProbeAppservice checks that a bridge's appservice listener answers. The
+// request is made from the synapse container, over the same network path
+// Synapse uses to push events to the bridge.
+func (c *Compose) ProbeAppservice(bridge bridges.BridgeInfo) error {
+	url := AppserviceURL(bridge) + "/_matrix/mau/live"
+
+	err := c.Exec("synapse", nil, nil, "python", "-c", probeScript, url)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.Stderr != "":
+		return fmt.Errorf("%s not reachable: %s", AppserviceURL(bridge), lastLine(exitErr.Stderr))
+	default:
+		return fmt.Errorf("%s not reachable: %w", AppserviceURL(bridge), err)
+	}
+}
+
+// lastLine returns the last line of multi-line command output
+func lastLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
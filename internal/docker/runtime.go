@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// Runtime names accepted for 'runtime' in settings.yaml
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+// Runtimes lists the runtime names muxbee supports
+var Runtimes = []string{RuntimeDocker, RuntimePodman}
+
+// Runtime is the container engine muxbee drives: which CLI it runs, how it
+// runs compose files and where its API socket lives
+type Runtime struct {
+	Name    string   // RuntimeDocker or RuntimePodman
+	Binary  string   // CLI for the fallback backend and one-off containers
+	Compose []string // Command that runs compose files
+
+	// ComposeWait is set when 'up --wait' is supported. podman-compose
+	// doesn't have it, so muxbee polls container health instead.
+	ComposeWait bool
+}
+
+// Seams for tests
+var (
+	lookPath       = exec.LookPath
+	selinuxEnabled = func() bool {
+		_, err := os.Stat("/sys/fs/selinux/enforce")
+		return err == nil
+	}
+)
+
+// versionPattern finds the first version number in a compose version banner
+var versionPattern = regexp.MustCompile(`v?(\d+\.\d+(?:\.\d+)?)`)
+
+// RuntimeFor returns the runtime settings.yaml selects, detecting one if
+// cfg is nil or doesn't name one
+func RuntimeFor(cfg *config.Config) Runtime {
+	if cfg == nil {
+		return DetectRuntime("")
+	}
+	return DetectRuntime(cfg.Runtime)
+}
+
+// DetectRuntime returns the named runtime. An empty or unknown name picks
+// Docker if it is installed, then Podman, then Docker again so errors
+// mention the usual tool.
+func DetectRuntime(name string) Runtime {
+	switch name {
+	case RuntimeDocker:
+		return dockerRuntime()
+	case RuntimePodman:
+		return podmanRuntime()
+	}
+
+	if _, err := lookPath("docker"); err == nil {
+		return dockerRuntime()
+	}
+	if _, err := lookPath("podman"); err == nil {
+		return podmanRuntime()
+	}
+	return dockerRuntime()
+}
+
+// IsRuntime reports whether name is a supported runtime
+func IsRuntime(name string) bool {
+	return slices.Contains(Runtimes, name)
+}
+
+func dockerRuntime() Runtime {
+	return Runtime{
+		Name:        RuntimeDocker,
+		Binary:      "docker",
+		Compose:     []string{"docker", "compose"},
+		ComposeWait: true,
+	}
+}
+
+// podmanRuntime prefers podman-compose when it is installed. 'podman
+// compose' only wraps an external provider, which may be podman-compose
+// anyway, and doesn't exist before Podman 4.7.
+func podmanRuntime() Runtime {
+	rt := Runtime{
+		Name:    RuntimePodman,
+		Binary:  "podman",
+		Compose: []string{"podman", "compose"},
+	}
+	if _, err := lookPath("podman-compose"); err == nil {
+		rt.Compose = []string{"podman-compose"}
+	}
+	return rt
+}
+
+// Title is the runtime's name for messages, e.g. "Docker"
+func (r Runtime) Title() string {
+	if r.Name == RuntimePodman {
+		return "Podman"
+	}
+	return "Docker"
+}
+
+// ComposeTitle is the name of the compose tool for messages
+func (r Runtime) ComposeTitle() string {
+	if r.Name == RuntimePodman {
+		return strings.Join(r.Compose, " ")
+	}
+	return "Docker Compose"
+}
+
+// Backend returns the Engine API backend when the runtime's socket is
+// found, and its CLI otherwise (Windows named pipes, TCP hosts, contexts)
+func (r Runtime) Backend() Backend {
+	if socket := r.Socket(); socket != "" {
+		return NewEngine(socket)
+	}
+	return NewCLI(r.Binary)
+}
+
+// Available checks that the runtime is installed and its daemon answers
+func (r Runtime) Available() error {
+	return r.Backend().Ping()
+}
+
+// ComposeVersion returns the version of the compose tool, e.g. "2.29.1"
+func (r Runtime) ComposeVersion() (string, error) {
+	args := append(slices.Clone(r.Compose[1:]), "version")
+	if r.Name == RuntimeDocker {
+		args = append(args, "--short")
+	}
+	out, err := exec.Command(r.Compose[0], args...).Output()
+	if err != nil {
+		return "", err
+	}
+	m := versionPattern.FindSubmatch(out)
+	if m == nil {
+		return "", fmt.Errorf("no version in %q", strings.TrimSpace(string(out)))
+	}
+	return string(m[1]), nil
+}
+
+// MountLabel returns the SELinux relabeling option for bind mounts, "z"
+// for Podman on an SELinux host and "" otherwise. Rootless Podman can't
+// read unlabeled host directories there; Docker usually runs without
+// SELinux enforcement in its containers.
+func (r Runtime) MountLabel() string {
+	if r.Name == RuntimePodman && selinuxEnabled() {
+		return "z"
+	}
+	return ""
+}
+
+// Socket returns the Unix socket of the runtime's API, or "" if the
+// environment points somewhere only the CLI can reach
+func (r Runtime) Socket() string {
+	if r.Name == RuntimePodman {
+		return podmanSocket()
+	}
+	return dockerSocket()
+}
+
+func dockerSocket() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return unixSocket(host)
+	}
+	if usesDockerContext() {
+		return ""
+	}
+
+	candidates := []string{"/var/run/docker.sock"}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "docker.sock")) // Rootless
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".docker", "run", "docker.sock")) // Docker Desktop
+	}
+	return firstSocket(candidates)
+}
+
+// podmanSocket finds the socket of 'podman system service', which
+// podman.socket starts on demand. Without it muxbee uses the podman CLI.
+func podmanSocket() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return unixSocket(host)
+	}
+
+	var candidates []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "podman", "podman.sock")) // Rootless
+	}
+	if os.Geteuid() == 0 {
+		candidates = append(candidates, "/run/podman/podman.sock")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".local", "share", "containers", "podman", "machine", "podman.sock")) // podman machine
+	}
+	return firstSocket(candidates)
+}
+
+// unixSocket returns the path of a unix:// host, or "" for other schemes
+func unixSocket(host string) string {
+	if socket, ok := strings.CutPrefix(host, "unix://"); ok {
+		return socket
+	}
+	return ""
+}
+
+func firstSocket(candidates []string) string {
+	for _, socket := range candidates {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return socket
+		}
+	}
+	return ""
+}
+
+// usesDockerContext reports whether a docker context other than the
+// default is selected, whose endpoint only the CLI knows how to reach
+func usesDockerContext() bool {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name != "default"
+	}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return false
+	}
+	var cfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if json.Unmarshal(data, &cfg) != nil {
+		return false
+	}
+	return cfg.CurrentContext != "" && cfg.CurrentContext != "default"
+}
@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// installed makes lookPath find only the given commands
+func installed(t *testing.T, commands ...string) {
+	t.Helper()
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+	lookPath = func(file string) (string, error) {
+		for _, c := range commands {
+			if c == file {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+func TestDetectRuntime(t *testing.T) {
+	tests := []struct {
+		name      string
+		setting   string
+		installed []string
+		runtime   string
+		compose   []string
+	}{
+		{"docker installed", "", []string{"docker", "podman"}, RuntimeDocker, []string{"docker", "compose"}},
+		{"only podman", "", []string{"podman", "podman-compose"}, RuntimePodman, []string{"podman-compose"}},
+		{"podman without podman-compose", "", []string{"podman"}, RuntimePodman, []string{"podman", "compose"}},
+		{"nothing installed", "", nil, RuntimeDocker, []string{"docker", "compose"}},
+		{"podman selected", RuntimePodman, []string{"docker", "podman"}, RuntimePodman, []string{"podman", "compose"}},
+		{"docker selected", RuntimeDocker, []string{"podman"}, RuntimeDocker, []string{"docker", "compose"}},
+		{"unknown setting", "containerd", []string{"podman"}, RuntimePodman, []string{"podman", "compose"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installed(t, tt.installed...)
+			rt := RuntimeFor(&config.Config{Runtime: tt.setting})
+			assert.Equal(t, tt.runtime, rt.Name)
+			assert.Equal(t, tt.runtime, rt.Binary)
+			assert.Equal(t, tt.compose, rt.Compose)
+			assert.Equal(t, tt.runtime == RuntimeDocker, rt.ComposeWait)
+		})
+	}
+}
+
+func TestRuntimeFor_NilConfig(t *testing.T) {
+	installed(t, "podman")
+	assert.Equal(t, RuntimePodman, RuntimeFor(nil).Name)
+}
+
+func TestMountLabel(t *testing.T) {
+	orig := selinuxEnabled
+	t.Cleanup(func() { selinuxEnabled = orig })
+
+	selinuxEnabled = func() bool { return true }
+	assert.Equal(t, "z", DetectRuntime(RuntimePodman).MountLabel())
+	assert.Equal(t, "", DetectRuntime(RuntimeDocker).MountLabel())
+
+	selinuxEnabled = func() bool { return false }
+	assert.Equal(t, "", DetectRuntime(RuntimePodman).MountLabel())
+}
+
+func TestSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "muxbee")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	podmanSock := filepath.Join(dir, "podman", "podman.sock")
+	require.NoError(t, os.MkdirAll(filepath.Dir(podmanSock), 0755))
+	listener, err := net.Listen("unix", podmanSock)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	podman := DetectRuntime(RuntimePodman)
+	t.Setenv("CONTAINER_HOST", "")
+	assert.Equal(t, podmanSock, podman.Socket())
+
+	t.Setenv("CONTAINER_HOST", "unix:///run/other.sock")
+	assert.Equal(t, "/run/other.sock", podman.Socket())
+
+	t.Setenv("CONTAINER_HOST", "ssh://core@localhost:2222/run/podman/podman.sock")
+	assert.Equal(t, "", podman.Socket(), "remote hosts go through the CLI")
+	assert.IsType(t, &CLI{}, podman.Backend())
+
+	dockerRT := DetectRuntime(RuntimeDocker)
+	t.Setenv("DOCKER_HOST", "tcp://10.0.0.2:2376")
+	assert.Equal(t, "", dockerRT.Socket())
+
+	t.Setenv("DOCKER_HOST", "unix:///tmp/docker.sock")
+	assert.Equal(t, "/tmp/docker.sock", dockerRT.Socket())
+	assert.IsType(t, &Engine{}, dockerRT.Backend())
+
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "colima")
+	assert.Equal(t, "", dockerRT.Socket())
+}
+
+func TestEventMessage_Podman(t *testing.T) {
+	var msg eventMessage
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"ID": "a1", "Image": "docker.io/matrixdotorg/synapse:latest", "Name": "muxbee_synapse_1",
+		"Status": "died", "Time": "2026-01-02T03:04:05Z", "Type": "container",
+		"Attributes": {"com.docker.compose.service": "synapse", "containerExitCode": "1"}
+	}`), &msg))
+
+	ev, ok := msg.event()
+	require.True(t, ok)
+	assert.Equal(t, "died", ev.Action)
+	assert.Equal(t, "a1", ev.ID)
+	assert.Equal(t, "muxbee_synapse_1", ev.Container)
+	assert.Equal(t, "synapse", ev.Service)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"ID": "a1", "Status": "exec", "Type": "container"}`), &msg))
+	_, ok = msg.event()
+	assert.False(t, ok, "exec events are skipped")
+}
+
+func TestClassify_Podman(t *testing.T) {
+	assert.ErrorIs(t, classify(`Error: no container with name or ID "x" found: no such container`), ErrNotFound)
+	assert.ErrorIs(t, classify("Error: unable to connect to Podman socket: Cannot connect to Podman."), ErrUnavailable)
+}
+
+// healthSequence reports the containers' health one call at a time
+type healthSequence struct {
+	Backend
+	health []string
+}
+
+func (h *healthSequence) Containers(project string) ([]Container, error) {
+	health := h.health[0]
+	if len(h.health) > 1 {
+		h.health = h.health[1:]
+	}
+	return []Container{{ID: "a1", Service: "postgres", State: "running", Health: health}}, nil
+}
+
+func TestWaitHealthy(t *testing.T) {
+	backend := &healthSequence{health: []string{"starting", "starting", "healthy"}}
+	c := NewWithBackend(&config.Config{}, backend)
+	assert.NoError(t, c.waitHealthy("postgres", time.Second, time.Millisecond))
+
+	backend = &healthSequence{health: []string{"unhealthy"}}
+	c = NewWithBackend(&config.Config{}, backend)
+	err := c.waitHealthy("postgres", 10*time.Millisecond, time.Millisecond)
+	assert.EqualError(t, err, "failed to start postgres: container is unhealthy")
+
+	c = NewWithBackend(&config.Config{}, &fakeBackend{})
+	err = c.waitHealthy("postgres", 10*time.Millisecond, time.Millisecond)
+	assert.ErrorIs(t, err, ErrNotRunning)
+}
@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Stats is a container's resource use
+type Stats struct {
+	CPUPercent  float64 // Of one CPU, so up to 100 times the number of CPUs
+	MemoryBytes uint64  // Without the page cache, as 'docker stats' counts it
+	MemoryLimit uint64
+}
+
+// Stats samples a running container's CPU and memory use. The daemon
+// takes two samples a second apart to work out the CPU use.
+func (e *Engine) Stats(container string) (*Stats, error) {
+	var result statsResult
+	query := url.Values{"stream": {"0"}}
+	if err := e.call(http.MethodGet, "/containers/"+url.PathEscape(container)+"/stats", query, nil, &result); err != nil {
+		return nil, err
+	}
+	s := result.stats()
+	return &s, nil
+}
+
+// Stats samples a running container's CPU and memory use
+func (c *CLI) Stats(container string) (*Stats, error) {
+	// Both CLIs know these fields; their JSON layouts differ
+	out, err := c.output("stats", "--no-stream", "--format", "{{.CPUPerc}}\t{{.MemUsage}}", container)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatsLine(strings.TrimSpace(string(out)))
+}
+
+// Stats samples the CPU and memory use of a running container
+func (c *Compose) Stats(container string) (*Stats, error) {
+	return c.backend.Stats(container)
+}
+
+// statsResult is the JSON from the /containers/{id}/stats endpoint
+type statsResult struct {
+	CPUStats    cpuStats `json:"cpu_stats"`
+	PreCPUStats cpuStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+}
+
+type cpuStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  int    `json:"online_cpus"`
+}
+
+// stats works out CPU and memory use the way 'docker stats' does
+func (r statsResult) stats() Stats {
+	s := Stats{MemoryLimit: r.MemoryStats.Limit}
+
+	cpus := r.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = len(r.CPUStats.CPUUsage.PercpuUsage)
+	}
+	cpuDelta := float64(r.CPUStats.CPUUsage.TotalUsage) - float64(r.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(r.CPUStats.SystemUsage) - float64(r.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		s.CPUPercent = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+
+	// The page cache counts as used but is given back under pressure. Its
+	// name differs between cgroup v2, cgroup v1 and older daemons.
+	s.MemoryBytes = r.MemoryStats.Usage
+	for _, key := range []string{"inactive_file", "total_inactive_file", "cache"} {
+		if cache, ok := r.MemoryStats.Stats[key]; ok && cache < s.MemoryBytes {
+			s.MemoryBytes -= cache
+			break
+		}
+	}
+	return s
+}
+
+// parseStatsLine reads "0.52%\t27.3MiB / 7.7GiB" from the stats CLI
+func parseStatsLine(line string) (*Stats, error) {
+	cpu, mem, ok := strings.Cut(line, "\t")
+	if !ok {
+		return nil, fmt.Errorf("unexpected stats output: %q", line)
+	}
+	usage, limit, _ := strings.Cut(mem, "/")
+
+	var s Stats
+	var err error
+	if s.CPUPercent, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(cpu), "%"), 64); err != nil {
+		return nil, fmt.Errorf("unexpected CPU use: %q", cpu)
+	}
+	if s.MemoryBytes, err = parseSize(usage); err != nil {
+		return nil, err
+	}
+	if limit != "" {
+		if s.MemoryLimit, err = parseSize(limit); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// sizeUnits are the units the stats CLIs print sizes in: binary ones from
+// Docker, decimal ones from Podman
+var sizeUnits = map[string]float64{
+	"b":   1,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+}
+
+// parseSize reads a size such as "27.3MiB" or "1.2GB"
+func parseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0, fmt.Errorf("unexpected size: %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok {
+		return 0, fmt.Errorf("unexpected size: %q", s)
+	}
+	return uint64(n * unit), nil
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Stream IDs in the multiplexed output of containers without a TTY
+const (
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// demux splits a multiplexed stream into stdout and stderr. Each frame is
+// an 8-byte header (stream ID, three zero bytes, big-endian length)
+// followed by that many bytes of output.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		w := stdout
+		if header[0] == streamStderr {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// mergedLogs interleaves several log streams line by line
+type mergedLogs struct {
+	*io.PipeReader
+	streams []io.ReadCloser
+}
+
+// mergeLogs prefixes each line with its container name, padded the way
+// 'docker compose logs' does, and interleaves the streams as lines arrive.
+// The result ends when every stream has.
+func mergeLogs(names []string, streams []io.ReadCloser) io.ReadCloser {
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	pr, pw := io.Pipe()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(name string, stream io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				mu.Lock()
+				_, err := fmt.Fprintf(pw, "%-*s | %s\n", width, name, scanner.Text())
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(names[i], stream)
+	}
+
+	go func() {
+		wg.Wait()
+		pw.Close()
+	}()
+
+	return &mergedLogs{PipeReader: pr, streams: streams}
+}
+
+func (m *mergedLogs) Close() error {
+	for _, s := range m.streams {
+		s.Close()
+	}
+	return m.PipeReader.Close()
+}
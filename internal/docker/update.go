@@ -0,0 +1,355 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UpdateOutcome describes what happened to a service during an update
+type UpdateOutcome string
+
+const (
+	UpdateUpgraded   UpdateOutcome = "upgraded"
+	UpdateKept       UpdateOutcome = "kept"
+	UpdateRolledBack UpdateOutcome = "rolled back"
+	UpdateFailed     UpdateOutcome = "failed"
+)
+
+// ServiceUpdate is the per-service result of an update
+type ServiceUpdate struct {
+	Service       string
+	Outcome       UpdateOutcome
+	PreviousImage string // Image ID before the update
+	CurrentImage  string // Image ID after the update (and rollback, if any)
+	Detail        string
+}
+
+// UpdateReport summarizes an update run
+type UpdateReport struct {
+	Services []ServiceUpdate
+}
+
+// Count returns the number of services with the given outcome
+func (r *UpdateReport) Count(outcome UpdateOutcome) int {
+	n := 0
+	for _, s := range r.Services {
+		if s.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary returns a one-line summary like "3 upgraded, 2 kept, 1 rolled back"
+func (r *UpdateReport) Summary() string {
+	var parts []string
+	for _, outcome := range []UpdateOutcome{UpdateUpgraded, UpdateKept, UpdateRolledBack, UpdateFailed} {
+		if n := r.Count(outcome); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, outcome))
+		}
+	}
+	if len(parts) == 0 {
+		return "no services"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// UpdateOptions controls an update run
+type UpdateOptions struct {
+	Quiet         bool              // Suppress docker compose output (TUI)
+	Progress      func(step string) // Called at the start of each step, may be nil
+	VerifyTimeout time.Duration     // How long to wait for services to settle (default 60s)
+}
+
+// Timing of the checks after an update, variables so tests can shorten them
+var (
+	// updateSettle is how long every service has to keep running without
+	// a restart before an update counts as working. Most bridges have no
+	// healthcheck, and one with a broken image often starts and then dies
+	// a few seconds later.
+	updateSettle = 20 * time.Second
+
+	updatePollInterval = 2 * time.Second
+)
+
+// serviceSnapshot records the container and image a service was running
+type serviceSnapshot struct {
+	Container    string
+	ContainerID  string
+	ImageRef     string
+	ImageID      string
+	RestartCount int
+}
+
+// Update pulls new images and recreates services, then verifies them.
+// Services that are crash-looping afterwards are switched back to the image
+// they were running before the pull.
+func (c *Compose) Update(profiles []string, opts UpdateOptions) (*UpdateReport, error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+	timeout := opts.VerifyTimeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+
+	progress("Recording current images")
+	before := c.snapshotServices()
+
+	progress("Pulling images")
+	if err := c.pull(profiles, opts.Quiet); err != nil {
+		return nil, fmt.Errorf("failed to pull images: %w", err)
+	}
+
+	progress("Stopping services")
+	if err := c.down(profiles, opts.Quiet); err != nil {
+		return nil, fmt.Errorf("failed to stop services: %w", err)
+	}
+
+	progress("Starting services")
+	if err := c.upForceRecreate(profiles, opts.Quiet); err != nil {
+		return nil, fmt.Errorf("failed to start services: %w", err)
+	}
+
+	progress("Verifying services")
+	failing := c.waitForHealthy(before, timeout)
+
+	// Only services whose image actually changed can be rolled back
+	current := c.snapshotServices()
+	rolledBack := make(map[string]error)
+	for service := range failing {
+		prev, ok := before[service]
+		if !ok || prev.ImageID == "" || prev.ImageID == current[service].ImageID {
+			continue
+		}
+		progress("Rolling back " + service)
+		rolledBack[service] = c.rollbackService(service, prev, opts.Quiet)
+	}
+
+	if len(rolledBack) > 0 {
+		progress("Verifying rollback")
+		failing = c.waitForHealthy(before, timeout)
+	}
+
+	after := c.snapshotServices()
+	return buildUpdateReport(before, after, failing, rolledBack), nil
+}
+
+// buildUpdateReport classifies every service seen before or after the update
+func buildUpdateReport(before, after map[string]serviceSnapshot, failing map[string]string, rolledBack map[string]error) *UpdateReport {
+	names := make(map[string]bool)
+	for s := range before {
+		names[s] = true
+	}
+	for s := range after {
+		names[s] = true
+	}
+	for s := range failing {
+		names[s] = true
+	}
+
+	services := make([]string, 0, len(names))
+	for s := range names {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+
+	report := &UpdateReport{}
+	for _, service := range services {
+		u := ServiceUpdate{
+			Service:       service,
+			PreviousImage: before[service].ImageID,
+			CurrentImage:  after[service].ImageID,
+		}
+
+		reason, stillFailing := failing[service]
+		rbErr, attempted := rolledBack[service]
+
+		switch {
+		case attempted && rbErr == nil && !stillFailing:
+			u.Outcome = UpdateRolledBack
+			u.Detail = "new image failed, restored previous image"
+		case attempted && rbErr != nil:
+			u.Outcome = UpdateFailed
+			u.Detail = "rollback failed: " + rbErr.Error()
+		case stillFailing:
+			u.Outcome = UpdateFailed
+			u.Detail = reason
+			if attempted {
+				u.Detail += " (also after rollback)"
+			}
+		case u.PreviousImage != "" && u.PreviousImage == u.CurrentImage:
+			u.Outcome = UpdateKept
+		default:
+			u.Outcome = UpdateUpgraded
+		}
+
+		report.Services = append(report.Services, u)
+	}
+
+	return report
+}
+
+// snapshotServices maps each compose service to the container and image it runs
+func (c *Compose) snapshotServices() map[string]serviceSnapshot {
+	snapshots := make(map[string]serviceSnapshot)
+	for service, ctr := range c.inspectServices() {
+		snapshots[service] = serviceSnapshot{
+			Container:    ctr.Name,
+			ContainerID:  ctr.ID,
+			ImageRef:     ctr.Image,
+			ImageID:      ctr.ImageID,
+			RestartCount: ctr.RestartCount,
+		}
+	}
+	return snapshots
+}
+
+// inspectServices maps each compose service to its container, inspected
+// for its restart count
+func (c *Compose) inspectServices() map[string]Container {
+	services := make(map[string]Container)
+
+	containers, err := c.backend.Containers(ProjectName)
+	if err != nil {
+		return services
+	}
+
+	for _, ctr := range containers {
+		service := ctr.Service
+		if service == "" {
+			service = ParseServiceName(ctr.Name)
+		}
+		// A container removed meanwhile keeps what the list said
+		if inspected, err := c.backend.Inspect(ctr.ID); err == nil {
+			ctr = *inspected
+		}
+		services[service] = ctr
+	}
+
+	return services
+}
+
+// serviceFailure returns why a service looks broken, or "" if it looks fine
+func serviceFailure(s ServiceStatus) string {
+	switch {
+	case s.State == "restarting":
+		return "crash-looping"
+	case !s.Running:
+		return "not running (" + s.State + ")"
+	case s.Health == "unhealthy":
+		return "unhealthy"
+	}
+	return ""
+}
+
+// restartedSince returns how often a container restarted after the
+// snapshot. A container created since then started from zero.
+func restartedSince(prev serviceSnapshot, ctr Container) int {
+	if prev.ContainerID != ctr.ID {
+		return ctr.RestartCount
+	}
+	return ctr.RestartCount - prev.RestartCount
+}
+
+// waitForHealthy polls until every service and Synapse's /health have
+// looked fine for updateSettle in a row, or the timeout expires. A service
+// that restarted since before is failing even if it is running again.
+// Returns the services still failing.
+func (c *Compose) waitForHealthy(before map[string]serviceSnapshot, timeout time.Duration) map[string]string {
+	if timeout < updateSettle {
+		timeout = updateSettle
+	}
+	deadline := time.Now().Add(timeout)
+	var settledSince time.Time
+
+	for {
+		failing := make(map[string]string)
+		starting := false
+
+		for service, ctr := range c.inspectServices() {
+			if reason := serviceFailure(serviceStatus(ctr, "")); reason != "" {
+				failing[service] = reason
+			} else if n := restartedSince(before[service], ctr); n > 0 {
+				failing[service] = fmt.Sprintf("restarted %d times", n)
+			}
+			if ctr.Health == "starting" {
+				starting = true
+			}
+		}
+
+		if _, bad := failing["synapse"]; !bad && !c.SynapseHealthy() {
+			failing["synapse"] = "health check failed"
+		}
+
+		now := time.Now()
+		if len(failing) > 0 || starting {
+			settledSince = time.Time{}
+		} else if settledSince.IsZero() {
+			settledSince = now
+		}
+
+		if (!settledSince.IsZero() && now.Sub(settledSince) >= updateSettle) || now.After(deadline) {
+			return failing
+		}
+
+		time.Sleep(updatePollInterval)
+	}
+}
+
+// SynapseHealthy checks Synapse's /health endpoint on the published port
+func (c *Compose) SynapseHealthy() bool {
+	client := http.Client{Timeout: 5 * time.Second}
+
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/health", c.cfg.SynapsePort()))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// rollbackService points the service's image reference back at the previous
+// image and recreates just that container
+func (c *Compose) rollbackService(service string, prev serviceSnapshot, quiet bool) error {
+	// Digest references can't be re-tagged, and pulling them never changes anything
+	if prev.ImageRef != "" && !strings.Contains(prev.ImageRef, "@") {
+		if err := c.backend.TagImage(prev.ImageID, prev.ImageRef); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", prev.ImageRef, err)
+		}
+	}
+
+	cmd := c.buildCommand("up", "-d", "--no-deps", "--force-recreate", service)
+	if !quiet {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	return cmd.Run()
+}
+
+func (c *Compose) pull(profiles []string, quiet bool) error {
+	if quiet {
+		return c.PullQuiet(profiles)
+	}
+	return c.Pull(profiles)
+}
+
+func (c *Compose) down(profiles []string, quiet bool) error {
+	if quiet {
+		return c.DownQuiet(profiles)
+	}
+	return c.Down(profiles)
+}
+
+func (c *Compose) upForceRecreate(profiles []string, quiet bool) error {
+	if quiet {
+		return c.UpForceRecreateQuiet(profiles)
+	}
+	return c.UpForceRecreate(profiles)
+}
@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+func TestServiceFailure(t *testing.T) {
+	tests := []struct {
+		status   ServiceStatus
+		expected string
+	}{
+		{ServiceStatus{State: "running", Running: true}, ""},
+		{ServiceStatus{State: "running", Running: true, Health: "healthy"}, ""},
+		{ServiceStatus{State: "running", Running: true, Health: "starting"}, ""},
+		{ServiceStatus{State: "running", Running: true, Health: "unhealthy"}, "unhealthy"},
+		{ServiceStatus{State: "restarting"}, "crash-looping"},
+		{ServiceStatus{State: "exited"}, "not running (exited)"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, serviceFailure(tt.status), "state=%s health=%s", tt.status.State, tt.status.Health)
+	}
+}
+
+func TestBuildUpdateReport(t *testing.T) {
+	before := map[string]serviceSnapshot{
+		"postgres":         {ImageID: "sha256:pg"},
+		"synapse":          {ImageID: "sha256:syn-old"},
+		"mautrix-whatsapp": {ImageID: "sha256:wa-old"},
+		"mautrix-signal":   {ImageID: "sha256:sig-old"},
+		"mautrix-discord":  {ImageID: "sha256:dc-old"},
+	}
+	after := map[string]serviceSnapshot{
+		"postgres":         {ImageID: "sha256:pg"},
+		"synapse":          {ImageID: "sha256:syn-new"},
+		"mautrix-whatsapp": {ImageID: "sha256:wa-old"},
+		"mautrix-signal":   {ImageID: "sha256:sig-new"},
+		"mautrix-discord":  {ImageID: "sha256:dc-new"},
+	}
+	failing := map[string]string{
+		"mautrix-signal":  "crash-looping",
+		"mautrix-discord": "crash-looping",
+	}
+	rolledBack := map[string]error{
+		"mautrix-whatsapp": nil,
+		"mautrix-discord":  errors.New("no such image"),
+	}
+
+	report := buildUpdateReport(before, after, failing, rolledBack)
+	require.Len(t, report.Services, 5)
+
+	outcomes := make(map[string]UpdateOutcome)
+	for _, s := range report.Services {
+		outcomes[s.Service] = s.Outcome
+	}
+
+	assert.Equal(t, UpdateKept, outcomes["postgres"])
+	assert.Equal(t, UpdateUpgraded, outcomes["synapse"])
+	assert.Equal(t, UpdateRolledBack, outcomes["mautrix-whatsapp"])
+	assert.Equal(t, UpdateFailed, outcomes["mautrix-signal"])
+	assert.Equal(t, UpdateFailed, outcomes["mautrix-discord"])
+
+	// Sorted by service name
+	assert.Equal(t, "mautrix-discord", report.Services[0].Service)
+	assert.Contains(t, report.Services[0].Detail, "rollback failed")
+
+	assert.Equal(t, "1 upgraded, 1 kept, 1 rolled back, 2 failed", report.Summary())
+}
+
+func TestBuildUpdateReport_NewService(t *testing.T) {
+	before := map[string]serviceSnapshot{}
+	after := map[string]serviceSnapshot{
+		"synapse": {ImageID: "sha256:syn"},
+	}
+
+	report := buildUpdateReport(before, after, nil, nil)
+	require.Len(t, report.Services, 1)
+	assert.Equal(t, UpdateUpgraded, report.Services[0].Outcome)
+}
+
+func TestUpdateReportSummary_Empty(t *testing.T) {
+	report := &UpdateReport{}
+	assert.Equal(t, "no services", report.Summary())
+	assert.Equal(t, 0, report.Count(UpdateUpgraded))
+}
+
+// restartingBackend serves running containers; the ones in crashing gain a
+// restart every time they are inspected
+type restartingBackend struct {
+	fakeBackend
+	crashing map[string]bool
+
+	mu       sync.Mutex
+	restarts map[string]int
+}
+
+func (f *restartingBackend) Inspect(container string) (*Container, error) {
+	ctr, err := f.fakeBackend.Inspect(container)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashing[ctr.Name] {
+		f.restarts[ctr.Name]++
+	}
+	ctr.RestartCount += f.restarts[ctr.Name]
+	return ctr, nil
+}
+
+// testSynapse serves a healthy /health and returns a config publishing it
+func testSynapse(t *testing.T) *config.Config {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	cfg := &config.Config{}
+	cfg.Ports.Synapse = port
+	return cfg
+}
+
+func TestWaitForHealthy_RestartCount(t *testing.T) {
+	settle, poll := updateSettle, updatePollInterval
+	updateSettle, updatePollInterval = 50*time.Millisecond, 5*time.Millisecond
+	defer func() { updateSettle, updatePollInterval = settle, poll }()
+
+	backend := &restartingBackend{
+		fakeBackend: fakeBackend{containers: []Container{
+			{ID: "wait-test-syn", Name: "muxbee-synapse-1", Service: "synapse", State: "running"},
+			{ID: "wait-test-sig", Name: "muxbee-mautrix-signal-1", Service: "mautrix-signal", State: "running", RestartCount: 3},
+			{ID: "wait-test-wa", Name: "muxbee-mautrix-whatsapp-1", Service: "mautrix-whatsapp", State: "running"},
+		}},
+		crashing: map[string]bool{"muxbee-mautrix-whatsapp-1": true},
+		restarts: make(map[string]int),
+	}
+	c := NewWithBackend(testSynapse(t), backend)
+
+	before := c.snapshotServices()
+	assert.Equal(t, 3, before["mautrix-signal"].RestartCount)
+
+	failing := c.waitForHealthy(before, 0)
+	assert.Equal(t, []string{"mautrix-whatsapp"}, keys(failing), "restarts from before the update don't count")
+	assert.Contains(t, failing["mautrix-whatsapp"], "restarted")
+
+	// A recreated container counts its restarts from zero
+	before["mautrix-signal"] = serviceSnapshot{ContainerID: "old-signal", RestartCount: 5}
+	failing = c.waitForHealthy(before, 0)
+	assert.Equal(t, "restarted 3 times", failing["mautrix-signal"])
+}
+
+func TestWaitForHealthy_Settles(t *testing.T) {
+	settle, poll := updateSettle, updatePollInterval
+	updateSettle, updatePollInterval = 50*time.Millisecond, 5*time.Millisecond
+	defer func() { updateSettle, updatePollInterval = settle, poll }()
+
+	c := NewWithBackend(testSynapse(t), &fakeBackend{containers: []Container{
+		{ID: "settle-test-syn", Name: "muxbee-synapse-1", Service: "synapse", State: "running"},
+	}})
+
+	start := time.Now()
+	assert.Empty(t, c.waitForHealthy(c.snapshotServices(), time.Minute))
+	assert.GreaterOrEqual(t, time.Since(start), updateSettle, "services should stay up for the settle window")
+}
+
+func keys(m map[string]string) []string {
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,589 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// Free space thresholds for the data directory
+const (
+	diskWarnBytes = 5 << 30
+	diskFailBytes = 1 << 30
+)
+
+// bridgeRegistrationDir is where Synapse sees bridge registrations inside
+// its container
+const bridgeRegistrationDir = "/bridges"
+
+// runtimeHints are install and start instructions per container runtime
+var runtimeHints = map[string]struct{ install, start, compose string }{
+	docker.RuntimeDocker: {
+		install: "Install Docker: https://docs.docker.com/get-docker/",
+		start:   "Start Docker (or Docker Desktop) and run 'muxbee doctor' again",
+		compose: "Install the Docker Compose plugin: https://docs.docker.com/compose/install/",
+	},
+	docker.RuntimePodman: {
+		install: "Install Podman: https://podman.io/docs/installation",
+		start:   "Check 'podman info', or 'podman machine start' on macOS, and run 'muxbee doctor' again",
+		compose: "Install podman-compose: https://github.com/containers/podman-compose",
+	},
+}
+
+func (e *env) checkRuntime(r *Report) {
+	rt := e.runtime
+	hints := runtimeHints[rt.Name]
+	composeName := rt.Title() + " Compose"
+
+	if _, err := e.lookPath(rt.Binary); err != nil {
+		r.add(Check{
+			Name:   rt.Title(),
+			Status: StatusFail,
+			Detail: rt.Binary + " command not found",
+			Hint:   hints.install,
+		})
+		r.add(Check{Name: composeName, Status: StatusSkip, Detail: "needs " + rt.Title()})
+		return
+	}
+
+	if err := e.runtimeInfo(); err != nil {
+		detail := "Docker daemon is not running"
+		if rt.Name == docker.RuntimePodman {
+			detail = "Podman is not responding"
+		}
+		r.add(Check{
+			Name:   rt.Title(),
+			Status: StatusFail,
+			Detail: detail,
+			Hint:   hints.start,
+		})
+	} else {
+		r.add(Check{Name: rt.Title(), Status: StatusOK})
+	}
+
+	version, err := e.composeVersion()
+	switch {
+	case err != nil:
+		r.add(Check{
+			Name:   composeName,
+			Status: StatusFail,
+			Detail: fmt.Sprintf("'%s' is not available", strings.Join(rt.Compose, " ")),
+			Hint:   hints.compose,
+		})
+	case rt.Name == docker.RuntimeDocker && !versionAtLeast(version, docker.MinComposeVersion):
+		r.add(Check{
+			Name:   composeName,
+			Status: StatusFail,
+			Detail: fmt.Sprintf("version %s is too old, muxbee needs %s or newer", version, docker.MinComposeVersion),
+			Hint:   "Upgrade Docker Compose: https://docs.docker.com/compose/install/",
+		})
+	default:
+		r.add(Check{Name: composeName, Status: StatusOK, Detail: version})
+	}
+}
+
+func (e *env) checkDiskSpace(r *Report) {
+	dir := existingParent(config.DataDir())
+	free, err := e.freeSpace(dir)
+	if err != nil {
+		r.add(Check{Name: "Disk space", Status: StatusSkip, Detail: err.Error()})
+		return
+	}
+
+	detail := fmt.Sprintf("%s free on %s", formatGiB(free), dir)
+	switch {
+	case free < diskFailBytes:
+		r.add(Check{
+			Name:   "Disk space",
+			Status: StatusFail,
+			Detail: detail,
+			Hint:   "Free up space; PostgreSQL and Synapse stop working when the disk fills up",
+		})
+	case free < diskWarnBytes:
+		r.add(Check{
+			Name:   "Disk space",
+			Status: StatusWarn,
+			Detail: detail,
+			Hint:   "Media and the database grow over time; free up space soon",
+		})
+	default:
+		r.add(Check{Name: "Disk space", Status: StatusOK, Detail: detail})
+	}
+}
+
+// checkConfig reports whether settings.yaml loaded, which every later
+// check needs
+func (e *env) checkConfig(r *Report) bool {
+	switch {
+	case e.cfgErr != nil:
+		r.add(Check{
+			Name:   "Config",
+			Status: StatusFail,
+			Detail: e.cfgErr.Error(),
+			Hint:   fmt.Sprintf("Fix %s by hand or restore it with 'muxbee restore --config-only'", config.SettingsPath()),
+		})
+		return false
+	case e.cfg == nil:
+		r.add(Check{
+			Name:   "Config",
+			Status: StatusFail,
+			Detail: "no configuration found",
+			Hint:   "Run 'muxbee init' first",
+		})
+		return false
+	}
+
+	ok := true
+	if e.cfg.Runtime != "" && !docker.IsRuntime(e.cfg.Runtime) {
+		r.add(Check{
+			Name:   "Config",
+			Status: StatusWarn,
+			Detail: fmt.Sprintf("unknown runtime %q, using %s", e.cfg.Runtime, e.runtime.Name),
+			Hint:   fmt.Sprintf("Set runtime in %s to one of: %s", config.SettingsPath(), strings.Join(docker.Runtimes, ", ")),
+		})
+		ok = false
+	}
+
+	var unknown []string
+	for _, name := range e.cfg.EnabledBridges {
+		if bridges.Get(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		r.add(Check{
+			Name:   "Config",
+			Status: StatusWarn,
+			Detail: "unknown bridges enabled: " + strings.Join(unknown, ", "),
+			Hint:   fmt.Sprintf("Remove them from enabled_bridges in %s, or add their definitions to %s", config.SettingsPath(), config.UserBridgesDir()),
+		})
+		ok = false
+	}
+
+	if ok {
+		r.add(Check{Name: "Config", Status: StatusOK})
+	}
+	return true
+}
+
+func (e *env) checkDirs(r *Report) {
+	dirs := config.RequiredDirs()
+	for _, b := range e.enabledBridges() {
+		dirs = append(dirs, filepath.Join(config.DataDir(), "bridges", b.Name))
+	}
+
+	var missing []string
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			missing = append(missing, dir)
+		}
+	}
+
+	if len(missing) > 0 {
+		r.add(Check{
+			Name:   "Directories",
+			Status: StatusFail,
+			Detail: "missing " + strings.Join(missing, ", "),
+			Fix:    FixDirs,
+		})
+		return
+	}
+	r.add(Check{Name: "Directories", Status: StatusOK})
+}
+
+func (e *env) checkConfigFiles(r *Report) {
+	var missing []string
+	for _, path := range e.expectedFiles() {
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, path)
+		}
+	}
+
+	if len(missing) > 0 {
+		r.add(Check{
+			Name:   "Config files",
+			Status: StatusFail,
+			Detail: "missing " + strings.Join(missing, ", "),
+			Fix:    FixRegenerate,
+		})
+		return
+	}
+	r.add(Check{Name: "Config files", Status: StatusOK})
+}
+
+// expectedFiles lists the files GenerateAll and WriteComposeFile write for cfg
+func (e *env) expectedFiles() []string {
+	configDir := config.ConfigDir()
+	dataDir := config.DataDir()
+
+	files := []string{
+		config.DockerComposePath(),
+		filepath.Join(configDir, "synapse", "homeserver.yaml"),
+		filepath.Join(configDir, "synapse", "log.config"),
+		filepath.Join(dataDir, "synapse", "doublepuppet-registration.yaml"),
+	}
+	if e.cfg.IsElementEnabled() {
+		files = append(files, filepath.Join(configDir, "element", "config.json"))
+	}
+	if e.cfg.HTTPS.Enabled {
+		files = append(files, filepath.Join(configDir, "caddy", "Caddyfile"))
+	}
+	for _, b := range e.enabledBridges() {
+		files = append(files,
+			filepath.Join(dataDir, "bridges", b.Name, "config.yaml"),
+			filepath.Join(configDir, "bridges", b.Name, "registration.yaml"),
+			filepath.Join(dataDir, "bridges", b.Name, "registration.yaml"),
+		)
+	}
+	return files
+}
+
+// checkTokens compares the appservice tokens in settings.yaml with the
+// copies in each registration and bridge config. Synapse and the bridge
+// reject each other when they differ.
+func (e *env) checkTokens(r *Report) {
+	configDir := config.ConfigDir()
+	dataDir := config.DataDir()
+	ok := true
+
+	for _, b := range e.enabledBridges() {
+		tokens, found := e.cfg.BridgeTokens[b.Name]
+		if !found || tokens.ASToken == "" || tokens.HSToken == "" {
+			r.add(Check{
+				Name:   "Tokens " + b.Name,
+				Status: StatusFail,
+				Detail: "settings.yaml has no appservice tokens for this bridge",
+				Fix:    FixRegenerate,
+			})
+			ok = false
+			continue
+		}
+
+		stale := staleTokenFiles(tokens,
+			filepath.Join(configDir, "bridges", b.Name, "registration.yaml"),
+			filepath.Join(dataDir, "bridges", b.Name, "registration.yaml"),
+			filepath.Join(dataDir, "bridges", b.Name, "config.yaml"),
+		)
+		if len(stale) > 0 {
+			r.add(Check{
+				Name:   "Tokens " + b.Name,
+				Status: StatusFail,
+				Detail: "tokens don't match settings.yaml in " + strings.Join(stale, ", "),
+				Fix:    FixRegenerate,
+			})
+			ok = false
+		}
+	}
+
+	if tokens := e.cfg.DoublePuppetTokens; tokens == nil || tokens.ASToken == "" || tokens.HSToken == "" {
+		r.add(Check{
+			Name:   "Tokens doublepuppet",
+			Status: StatusFail,
+			Detail: "settings.yaml has no double puppeting tokens",
+			Fix:    FixRegenerate,
+		})
+		ok = false
+	} else {
+		stale := staleTokenFiles(*tokens, filepath.Join(dataDir, "synapse", "doublepuppet-registration.yaml"))
+		if len(stale) > 0 {
+			r.add(Check{
+				Name:   "Tokens doublepuppet",
+				Status: StatusFail,
+				Detail: "tokens don't match settings.yaml in " + strings.Join(stale, ", "),
+				Fix:    FixRegenerate,
+			})
+			ok = false
+		}
+	}
+
+	if ok {
+		r.add(Check{Name: "Tokens", Status: StatusOK})
+	}
+}
+
+// staleTokenFiles returns the files whose as_token or hs_token differ from
+// tokens. Missing files are left to the config files check.
+func staleTokenFiles(tokens config.BridgeTokens, paths ...string) []string {
+	var stale []string
+	for _, path := range paths {
+		values, err := yamlValues(path, "as_token", "hs_token")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil || values["as_token"] != tokens.ASToken || values["hs_token"] != tokens.HSToken {
+			stale = append(stale, path)
+		}
+	}
+	return stale
+}
+
+// checkAppservices compares the registrations Synapse loads with the
+// enabled bridges. Synapse won't start if a listed file is missing, and
+// an unlisted bridge never receives events.
+func (e *env) checkAppservices(r *Report) {
+	path := filepath.Join(config.ConfigDir(), "synapse", "homeserver.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.add(Check{Name: "Synapse appservices", Status: StatusSkip, Detail: "homeserver.yaml not found"})
+		return
+	}
+
+	var hs struct {
+		AppServiceConfigFiles []string `yaml:"app_service_config_files"`
+	}
+	if err := yaml.Unmarshal(data, &hs); err != nil {
+		r.add(Check{
+			Name:   "Synapse appservices",
+			Status: StatusFail,
+			Detail: fmt.Sprintf("failed to parse %s: %v", path, err),
+			Fix:    FixRegenerate,
+		})
+		return
+	}
+
+	listed := make(map[string]bool)
+	var problems []string
+	for _, file := range hs.AppServiceConfigFiles {
+		name, ok := registrationBridge(file)
+		if !ok {
+			continue
+		}
+		listed[name] = true
+		if !e.cfg.IsBridgeEnabled(name) {
+			problems = append(problems, fmt.Sprintf("registration listed for disabled bridge %s", name))
+		}
+	}
+	for _, b := range e.enabledBridges() {
+		if !listed[b.Name] {
+			problems = append(problems, fmt.Sprintf("no registration listed for %s", b.Name))
+		}
+	}
+
+	if len(problems) > 0 {
+		r.add(Check{
+			Name:   "Synapse appservices",
+			Status: StatusFail,
+			Detail: strings.Join(problems, "; "),
+			Fix:    FixRegenerate,
+		})
+		return
+	}
+	r.add(Check{Name: "Synapse appservices", Status: StatusOK})
+}
+
+// registrationBridge returns the bridge a registration path in
+// homeserver.yaml belongs to, e.g. signal for /bridges/signal/registration.yaml
+func registrationBridge(file string) (string, bool) {
+	dir := path.Dir(file)
+	if path.Base(file) != "registration.yaml" || path.Dir(dir) != bridgeRegistrationDir {
+		return "", false
+	}
+	return path.Base(dir), true
+}
+
+func (e *env) checkTelegram(r *Report) {
+	if !e.cfg.IsBridgeEnabled("telegram") {
+		return
+	}
+
+	creds := e.cfg.Telegram
+	if creds == nil || creds.APIID == "" || creds.APIHash == "" {
+		r.add(Check{
+			Name:   "Telegram credentials",
+			Status: StatusFail,
+			Detail: "the telegram bridge is enabled but settings.yaml has no api_id and api_hash",
+			Hint: fmt.Sprintf("Create an app at https://my.telegram.org, add its api_id and api_hash under telegram: in %s, then run 'muxbee doctor --fix'",
+				config.SettingsPath()),
+		})
+		return
+	}
+
+	path := filepath.Join(config.DataDir(), "bridges", "telegram", "config.yaml")
+	values, err := yamlValues(path, "api_id", "api_hash")
+	if err == nil && (values["api_id"] != creds.APIID || values["api_hash"] != creds.APIHash) {
+		r.add(Check{
+			Name:   "Telegram credentials",
+			Status: StatusFail,
+			Detail: "bridge config doesn't have the credentials from settings.yaml",
+			Fix:    FixRegenerate,
+		})
+		return
+	}
+	r.add(Check{Name: "Telegram credentials", Status: StatusOK})
+}
+
+// publishedPort is a host port the compose file publishes
+type publishedPort struct {
+	port int
+	what string // Service publishing it
+	hint string // How to move muxbee off it
+}
+
+// checkPorts looks for other programs holding the ports muxbee publishes.
+// While the stack runs, muxbee holds them itself.
+func (e *env) checkPorts(r *Report) {
+	if e.stackRunning() {
+		r.add(Check{Name: "Ports", Status: StatusOK, Detail: "in use by the running muxbee services"})
+		return
+	}
+
+	ports := []publishedPort{
+		{e.cfg.SynapsePort(), "Synapse", "set ports.synapse in settings.yaml to a free port"},
+	}
+	if e.cfg.IsElementEnabled() {
+		ports = append(ports, publishedPort{e.cfg.ElementPort(), "Element", "set ports.element in settings.yaml to a free port"})
+	}
+	if e.cfg.HTTPS.Enabled {
+		ports = append(ports,
+			publishedPort{80, "Caddy", "disable HTTPS; Caddy needs it for certificates"},
+			publishedPort{443, "Caddy", "disable HTTPS; Caddy needs it for certificates"},
+		)
+	}
+
+	ok := true
+	for _, p := range ports {
+		if e.portAvailable(p.port) {
+			continue
+		}
+		r.add(Check{
+			Name:   "Port " + strconv.Itoa(p.port),
+			Status: StatusFail,
+			Detail: fmt.Sprintf("in use by another program (needed by %s)", p.what),
+			Hint:   "Stop the program using it, or " + p.hint,
+		})
+		ok = false
+	}
+	if ok {
+		r.add(Check{Name: "Ports", Status: StatusOK})
+	}
+}
+
+// checkServerName makes sure the names clients and other servers connect
+// to resolve. Local installs only use the server name inside Matrix IDs.
+func (e *env) checkServerName(r *Report) {
+	if e.cfg.ConnectivityMode == "local" || e.cfg.ServerName == "localhost" {
+		r.add(Check{Name: "Server name", Status: StatusSkip, Detail: "local install"})
+		return
+	}
+
+	names := []string{e.cfg.ServerName}
+	if d := e.cfg.HTTPS.Domain; e.cfg.HTTPS.Enabled && d != "" && d != e.cfg.ServerName {
+		names = append(names, d)
+	}
+
+	ok := true
+	for _, name := range names {
+		if err := e.lookupHost(name); err != nil {
+			r.add(Check{
+				Name:   "Server name",
+				Status: StatusFail,
+				Detail: fmt.Sprintf("%s does not resolve: %v", name, err),
+				Hint:   fmt.Sprintf("Add a DNS record for %s pointing at this machine", name),
+			})
+			ok = false
+		}
+	}
+	if ok {
+		r.add(Check{Name: "Server name", Status: StatusOK, Detail: strings.Join(names, ", ")})
+	}
+}
+
+// enabledBridges returns the enabled bridges muxbee knows about
+func (e *env) enabledBridges() []bridges.BridgeInfo {
+	var infos []bridges.BridgeInfo
+	for _, name := range e.cfg.EnabledBridges {
+		if b := bridges.Get(name); b != nil {
+			infos = append(infos, *b)
+		}
+	}
+	return infos
+}
+
+// yamlValues reads a YAML file and returns the first value found for each
+// key, at any depth
+func yamlValues(path string, keys ...string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]bool)
+	for _, k := range keys {
+		want[k] = true
+	}
+	values := make(map[string]string)
+
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k, v := n.Content[i], n.Content[i+1]
+				if _, seen := values[k.Value]; want[k.Value] && !seen && v.Kind == yaml.ScalarNode {
+					values[k.Value] = v.Value
+				}
+			}
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(&doc)
+
+	return values, nil
+}
+
+// versionAtLeast compares dotted version numbers, ignoring suffixes such
+// as "-desktop.1"
+func versionAtLeast(version, min string) bool {
+	v, m := versionParts(version), versionParts(min)
+	for i := range m {
+		if v[i] != m[i] {
+			return v[i] > m[i]
+		}
+	}
+	return true
+}
+
+func versionParts(version string) [3]int {
+	var parts [3]int
+	for i, field := range strings.SplitN(version, ".", 3) {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		parts[i], _ = strconv.Atoi(field[:end])
+	}
+	return parts
+}
+
+// existingParent returns path or its nearest ancestor that exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func formatGiB(bytes uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(bytes)/(1<<30))
+}
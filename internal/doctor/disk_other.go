@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package doctor
+
+import "errors"
+
+// freeSpace isn't implemented on this platform
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
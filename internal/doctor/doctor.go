@@ -0,0 +1,179 @@
+// Package doctor diagnoses the usual reasons a muxbee install doesn't work
+// and repairs the ones muxbee can fix itself.
+package doctor
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skipped" // Check didn't apply or couldn't run
+)
+
+// Fix is a repair muxbee can make on its own
+type Fix string
+
+const (
+	FixRegenerate Fix = "regenerate" // Rewrite configs from settings.yaml, issuing missing tokens
+	FixDirs       Fix = "dirs"       // Recreate missing config and data directories
+)
+
+// Check is the result of one diagnosis
+type Check struct {
+	Name   string `json:"name" yaml:"name"`
+	Status Status `json:"status" yaml:"status"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"` // What is wrong
+	Hint   string `json:"hint,omitempty" yaml:"hint,omitempty"`     // How to fix it by hand
+	Fix    Fix    `json:"fix,omitempty" yaml:"fix,omitempty"`       // Set if --fix can repair it
+}
+
+// Report is the result of a doctor run
+type Report struct {
+	Checks []Check `json:"checks" yaml:"checks"`
+
+	cfg *config.Config // nil when settings.yaml is missing or unreadable
+}
+
+// Problems returns the checks that failed or warned
+func (r *Report) Problems() []Check {
+	var problems []Check
+	for _, c := range r.Checks {
+		if c.Status == StatusFail || c.Status == StatusWarn {
+			problems = append(problems, c)
+		}
+	}
+	return problems
+}
+
+// Failed reports whether any check failed
+func (r *Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Fixes returns the repairs that would address the problems found, in the
+// order they should run
+func (r *Report) Fixes() []Fix {
+	need := make(map[Fix]bool)
+	for _, c := range r.Problems() {
+		if c.Fix != "" {
+			need[c.Fix] = true
+		}
+	}
+
+	var fixes []Fix
+	for _, fix := range []Fix{FixDirs, FixRegenerate} {
+		if need[fix] {
+			fixes = append(fixes, fix)
+		}
+	}
+	return fixes
+}
+
+func (r *Report) add(c Check) {
+	r.Checks = append(r.Checks, c)
+}
+
+// env is everything the checks look at, swappable for tests
+type env struct {
+	cfg     *config.Config
+	cfgErr  error // Why cfg is nil
+	runtime docker.Runtime
+
+	lookPath       func(file string) (string, error)
+	runtimeInfo    func() error
+	composeVersion func() (string, error)
+	stackRunning   func() bool
+	portAvailable  func(port int) bool
+	lookupHost     func(host string) error
+	freeSpace      func(path string) (uint64, error)
+}
+
+// Run performs every check against the current install
+func Run() *Report {
+	return newEnv().run()
+}
+
+func newEnv() *env {
+	e := &env{
+		lookPath:      exec.LookPath,
+		stackRunning:  func() bool { return false },
+		portAvailable: portAvailable,
+		lookupHost:    lookupHost,
+		freeSpace:     freeSpace,
+	}
+
+	if config.Exists() {
+		e.cfg, e.cfgErr = config.Load()
+	}
+	e.runtime = docker.RuntimeFor(e.cfg)
+	e.runtimeInfo = e.runtime.Available
+	e.composeVersion = e.runtime.ComposeVersion
+	if e.cfg != nil {
+		compose := docker.New(e.cfg)
+		e.stackRunning = compose.IsRunning
+	}
+
+	return e
+}
+
+func (e *env) run() *Report {
+	r := &Report{cfg: e.cfg}
+
+	e.checkRuntime(r)
+	e.checkDiskSpace(r)
+	if !e.checkConfig(r) {
+		return r
+	}
+
+	e.checkDirs(r)
+	e.checkConfigFiles(r)
+	e.checkTokens(r)
+	e.checkAppservices(r)
+	e.checkTelegram(r)
+	e.checkPorts(r)
+	e.checkServerName(r)
+
+	return r
+}
+
+// portAvailable reports whether a port can be published. Binding ports
+// below 1024 needs root, which Docker has and muxbee usually doesn't, so
+// those count as free unless something answers on them.
+func portAvailable(port int) bool {
+	if port >= 1024 {
+		return config.IsPortAvailable(port)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return true
+	}
+	conn.Close()
+	return false
+}
+
+// lookupHost resolves host with a short timeout
+func lookupHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
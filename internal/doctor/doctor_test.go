@@ -0,0 +1,270 @@
+package doctor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/generator"
+)
+
+// testEnv returns an env for a freshly generated install with a working
+// Docker, free ports and plenty of disk
+func testEnv(t *testing.T, cfg *config.Config) *env {
+	t.Helper()
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	require.NoError(t, cfg.Save())
+	require.NoError(t, generator.New().GenerateAll(cfg))
+	require.NoError(t, docker.New(cfg).WriteComposeFile())
+
+	return &env{
+		cfg:            cfg,
+		runtime:        docker.DetectRuntime(docker.RuntimeDocker),
+		lookPath:       func(file string) (string, error) { return "/usr/bin/" + file, nil },
+		runtimeInfo:    func() error { return nil },
+		composeVersion: func() (string, error) { return "2.29.1", nil },
+		stackRunning:   func() bool { return false },
+		portAvailable:  func(int) bool { return true },
+		lookupHost:     func(string) error { return nil },
+		freeSpace:      func(string) (uint64, error) { return 100 << 30, nil },
+	}
+}
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.NewDefaultConfig()
+	require.NoError(t, err)
+	cfg.EnabledBridges = []string{"signal", "telegram"}
+	cfg.Telegram = &config.TelegramConfig{APIID: "12345", APIHash: "abcdef"}
+	return cfg
+}
+
+// statuses maps check names to their status
+func statuses(r *Report) map[string]Status {
+	m := make(map[string]Status)
+	for _, c := range r.Checks {
+		m[c.Name] = c.Status
+	}
+	return m
+}
+
+func TestRun_HealthyInstall(t *testing.T) {
+	e := testEnv(t, testConfig(t))
+
+	r := e.run()
+	assert.Empty(t, r.Problems())
+	assert.False(t, r.Failed())
+	assert.Empty(t, r.Fixes())
+	assert.Equal(t, StatusSkip, statuses(r)["Server name"], "local installs aren't looked up")
+}
+
+func TestRun_NoConfig(t *testing.T) {
+	e := testEnv(t, testConfig(t))
+	e.cfg = nil
+
+	r := e.run()
+	assert.True(t, r.Failed())
+	assert.Equal(t, StatusFail, statuses(r)["Config"])
+	assert.NotContains(t, statuses(r), "Tokens", "checks needing a config should not run")
+}
+
+func TestRun_Docker(t *testing.T) {
+	e := testEnv(t, testConfig(t))
+	e.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	assert.Equal(t, StatusFail, statuses(e.run())["Docker"])
+	assert.Equal(t, StatusSkip, statuses(e.run())["Docker Compose"])
+
+	e = testEnv(t, testConfig(t))
+	e.composeVersion = func() (string, error) { return "2.17.3", nil }
+	assert.Equal(t, StatusFail, statuses(e.run())["Docker Compose"])
+
+	e.composeVersion = func() (string, error) { return "", errors.New("unknown command") }
+	assert.Equal(t, StatusFail, statuses(e.run())["Docker Compose"])
+}
+
+func TestRun_Podman(t *testing.T) {
+	e := testEnv(t, testConfig(t))
+	e.runtime = docker.DetectRuntime(docker.RuntimePodman)
+	e.composeVersion = func() (string, error) { return "1.0.6", nil }
+
+	r := e.run()
+	assert.Empty(t, r.Problems(), "the Docker Compose minimum doesn't apply to podman-compose")
+	assert.Equal(t, StatusOK, statuses(r)["Podman"])
+	assert.Equal(t, StatusOK, statuses(r)["Podman Compose"])
+
+	e.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	r = e.run()
+	assert.Equal(t, StatusFail, statuses(r)["Podman"])
+	assert.Equal(t, StatusSkip, statuses(r)["Podman Compose"])
+}
+
+func TestRun_UnknownRuntime(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Runtime = "containerd"
+	e := testEnv(t, cfg)
+
+	assert.Equal(t, StatusWarn, statuses(e.run())["Config"])
+}
+
+func TestRun_ConfigProblemsTogether(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+	cfg.Runtime = "containerd"
+	cfg.EnabledBridges = append(cfg.EnabledBridges, "myspace")
+
+	var details []string
+	for _, c := range e.run().Checks {
+		if c.Name == "Config" {
+			assert.Equal(t, StatusWarn, c.Status)
+			details = append(details, c.Detail)
+		}
+	}
+	require.Len(t, details, 2, "both problems should be reported")
+	assert.Contains(t, details[0], "containerd")
+	assert.Contains(t, details[1], "myspace")
+}
+
+func TestRun_StaleTokens(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+
+	// A re-init regenerated settings.yaml tokens but not the registration
+	tokens := cfg.BridgeTokens["signal"]
+	tokens.HSToken = "reissued"
+	cfg.BridgeTokens["signal"] = tokens
+
+	r := e.run()
+	assert.Equal(t, StatusFail, statuses(r)["Tokens signal"])
+	assert.NotContains(t, statuses(r), "Tokens telegram")
+	assert.Equal(t, []Fix{FixRegenerate}, r.Fixes())
+}
+
+func TestRun_DisabledBridgeRegistration(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+
+	// Disabled in settings.yaml without regenerating homeserver.yaml
+	cfg.DisableBridge("signal")
+
+	r := e.run()
+	var appservices Check
+	for _, c := range r.Checks {
+		if c.Name == "Synapse appservices" {
+			appservices = c
+		}
+	}
+	assert.Equal(t, StatusFail, appservices.Status)
+	assert.Contains(t, appservices.Detail, "disabled bridge signal")
+	assert.Equal(t, FixRegenerate, appservices.Fix)
+}
+
+func TestRun_Telegram(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+
+	cfg.Telegram.APIHash = "changed"
+	check := statuses(e.run())["Telegram credentials"]
+	assert.Equal(t, StatusFail, check, "bridge config should be stale")
+
+	cfg.Telegram = nil
+	r := e.run()
+	assert.Equal(t, StatusFail, statuses(r)["Telegram credentials"])
+	for _, c := range r.Problems() {
+		if c.Name == "Telegram credentials" {
+			assert.Empty(t, c.Fix, "muxbee can't make up credentials")
+		}
+	}
+}
+
+func TestRun_Ports(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+	e.portAvailable = func(port int) bool { return port != cfg.SynapsePort() }
+
+	r := e.run()
+	assert.Equal(t, StatusFail, statuses(r)["Port 8008"])
+	assert.NotContains(t, statuses(r), "Ports")
+
+	// The running stack holds its own ports
+	e.stackRunning = func() bool { return true }
+	assert.Equal(t, StatusOK, statuses(e.run())["Ports"])
+}
+
+func TestRun_ServerName(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ServerName = "matrix.example.com"
+	cfg.ConnectivityMode = "public"
+	e := testEnv(t, cfg)
+
+	var looked []string
+	e.lookupHost = func(host string) error {
+		looked = append(looked, host)
+		return errors.New("no such host")
+	}
+
+	assert.Equal(t, StatusFail, statuses(e.run())["Server name"])
+	assert.Equal(t, []string{"matrix.example.com"}, looked)
+}
+
+func TestRun_DiskSpace(t *testing.T) {
+	e := testEnv(t, testConfig(t))
+
+	e.freeSpace = func(string) (uint64, error) { return 3 << 30, nil }
+	assert.Equal(t, StatusWarn, statuses(e.run())["Disk space"])
+
+	e.freeSpace = func(string) (uint64, error) { return 100 << 20, nil }
+	assert.Equal(t, StatusFail, statuses(e.run())["Disk space"])
+
+	e.freeSpace = func(string) (uint64, error) { return 0, errors.New("not supported") }
+	assert.Equal(t, StatusSkip, statuses(e.run())["Disk space"])
+}
+
+func TestRepair(t *testing.T) {
+	cfg := testConfig(t)
+	e := testEnv(t, cfg)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(config.DataDir(), "bridges", "signal")))
+	require.NoError(t, os.Remove(filepath.Join(config.ConfigDir(), "synapse", "homeserver.yaml")))
+	delete(cfg.BridgeTokens, "telegram")
+
+	r := e.run()
+	assert.Equal(t, []Fix{FixDirs, FixRegenerate}, r.Fixes())
+
+	done, err := Repair(r)
+	require.NoError(t, err)
+	assert.Contains(t, done, "Issued new appservice tokens for telegram")
+
+	r = e.run()
+	assert.Empty(t, r.Problems())
+
+	saved, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, cfg.BridgeTokens["telegram"], saved.BridgeTokens["telegram"], "new tokens should be saved")
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, versionAtLeast("2.21.0", "2.21.0"))
+	assert.True(t, versionAtLeast("2.29.1-desktop.1", "2.21.0"))
+	assert.True(t, versionAtLeast("3.0", "2.21.0"))
+	assert.False(t, versionAtLeast("2.20.3", "2.21.0"))
+	assert.False(t, versionAtLeast("1.29.2", "2.21.0"))
+}
+
+func TestRegistrationBridge(t *testing.T) {
+	name, ok := registrationBridge("/bridges/signal/registration.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, "signal", name)
+
+	_, ok = registrationBridge("/data/doublepuppet-registration.yaml")
+	assert.False(t, ok)
+	_, ok = registrationBridge("/bridges/registration.yaml")
+	assert.False(t, ok)
+}
@@ -0,0 +1,74 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/generator"
+)
+
+// Repair applies the fixes for the problems in r and returns what it did.
+// Problems without a fix are left alone.
+func Repair(r *Report) ([]string, error) {
+	var done []string
+
+	for _, fix := range r.Fixes() {
+		switch fix {
+		case FixDirs:
+			if err := ensureDirs(r.cfg); err != nil {
+				return done, fmt.Errorf("failed to create directories: %w", err)
+			}
+			done = append(done, "Recreated missing directories")
+
+		case FixRegenerate:
+			issued := missingTokens(r.cfg)
+			if err := generator.New().GenerateAll(r.cfg); err != nil {
+				return done, fmt.Errorf("failed to generate configs: %w", err)
+			}
+			if err := docker.New(r.cfg).WriteComposeFile(); err != nil {
+				return done, fmt.Errorf("failed to write docker-compose.yml: %w", err)
+			}
+			done = append(done, "Regenerated configs from settings.yaml")
+			if len(issued) > 0 {
+				done = append(done, "Issued new appservice tokens for "+strings.Join(issued, ", "))
+			}
+		}
+	}
+
+	return done, nil
+}
+
+// ensureDirs creates the directories checkDirs looks for
+func ensureDirs(cfg *config.Config) error {
+	if err := config.EnsureDirs(); err != nil {
+		return err
+	}
+	for _, name := range cfg.EnabledBridges {
+		if err := os.MkdirAll(filepath.Join(config.DataDir(), "bridges", name), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingTokens returns the appservices GenerateAll will issue new tokens for
+func missingTokens(cfg *config.Config) []string {
+	var missing []string
+	for _, name := range cfg.EnabledBridges {
+		if bridges.Get(name) == nil {
+			continue // GenerateAll skips unknown bridges
+		}
+		if t := cfg.BridgeTokens[name]; t.ASToken == "" || t.HSToken == "" {
+			missing = append(missing, name)
+		}
+	}
+	if t := cfg.DoublePuppetTokens; t == nil || t.ASToken == "" || t.HSToken == "" {
+		missing = append(missing, "doublepuppet")
+	}
+	return missing
+}
@@ -22,6 +22,8 @@ type SynapseData struct {
 	RegistrationSecret string
 	DoublePuppetSecret string
 	Bridges            []string
+	Metrics            bool // Synapse's Prometheus listener on port 9000
+	Registration       bool // Sign up with a registration token
 }
 
 // ElementData contains data for Element Web config template
@@ -44,14 +46,22 @@ type BridgeConfigData struct {
 	ASToken            string
 	HSToken            string
 	BotUsername        string
-	TelegramAPIID      string // Only used for telegram bridge
-	TelegramAPIHash    string // Only used for telegram bridge
-	DoublePuppetSecret string // Shared secret for double puppeting
+	TelegramAPIID      string       // Only used for telegram bridge
+	TelegramAPIHash    string       // Only used for telegram bridge
+	DoublePuppetSecret string       // Shared secret for double puppeting
+	Users              []BridgeUser // Added users, each with their own permission
+}
+
+// BridgeUser is a local user listed in a bridge's permissions
+type BridgeUser struct {
+	ID    string // Full Matrix ID
+	Admin bool   // Admin of the bridge rather than a user
 }
 
 // BridgeRegistrationData contains data for bridge registration template
 type BridgeRegistrationData struct {
 	Name            string
+	ServiceName     string
 	Port            int
 	ASToken         string
 	HSToken         string
@@ -168,8 +178,7 @@ func (g *Generator) GenerateCaddy(data CaddyData) error {
 // GenerateBridgeConfig generates configuration for a specific bridge
 // Writes to data directory since bridges expect writable /data with config.yaml inside
 func (g *Generator) GenerateBridgeConfig(bridgeName string, data BridgeConfigData) error {
-	templatePath := "templates/bridges/" + bridgeName + ".yaml.tmpl"
-	tmpl, err := template.ParseFS(templateFS, templatePath)
+	tmpl, err := parseBridgeTemplate(bridgeName)
 	if err != nil {
 		return err
 	}
@@ -189,6 +198,15 @@ func (g *Generator) GenerateBridgeConfig(bridgeName string, data BridgeConfigDat
 	return tmpl.Execute(f, data)
 }
 
+// parseBridgeTemplate loads a bridge's config template, preferring a
+// user-supplied template from bridges.d over the embedded one
+func parseBridgeTemplate(bridgeName string) (*template.Template, error) {
+	if bridge := bridges.Get(bridgeName); bridge != nil && bridge.TemplatePath != "" {
+		return template.ParseFiles(bridge.TemplatePath)
+	}
+	return template.ParseFS(templateFS, "templates/bridges/"+bridgeName+".yaml.tmpl")
+}
+
 // GenerateDoublePuppetRegistration generates the doublepuppet appservice registration for Synapse
 func (g *Generator) GenerateDoublePuppetRegistration(data DoublePuppetRegistrationData) error {
 	tmpl, err := template.ParseFS(templateFS, "templates/synapse/doublepuppet-registration.yaml.tmpl")
@@ -214,6 +232,10 @@ func (g *Generator) GenerateDoublePuppetRegistration(data DoublePuppetRegistrati
 // GenerateBridgeRegistration generates the appservice registration for a bridge
 // Writes to both CONFIG_DIR (for Synapse) and DATA_DIR (for the bridge to use)
 func (g *Generator) GenerateBridgeRegistration(data BridgeRegistrationData) error {
+	if data.ServiceName == "" {
+		data.ServiceName = bridges.ServiceNameFor(data.Name)
+	}
+
 	tmpl, err := template.ParseFS(templateFS, "templates/bridges/registration.yaml.tmpl")
 	if err != nil {
 		return err
@@ -301,6 +323,8 @@ func (g *Generator) GenerateAll(cfg *config.Config) error {
 		RegistrationSecret: regSecret,
 		DoublePuppetSecret: doublePuppetSecret,
 		Bridges:            cfg.EnabledBridges,
+		Metrics:            cfg.Metrics.Synapse,
+		Registration:       cfg.Registration.Enabled,
 	}
 	if err := g.GenerateSynapse(synapseData); err != nil {
 		return err
@@ -331,6 +355,11 @@ func (g *Generator) GenerateAll(cfg *config.Config) error {
 		}
 	}
 
+	bridgeUsers := make([]BridgeUser, 0, len(cfg.Users))
+	for _, u := range cfg.Users {
+		bridgeUsers = append(bridgeUsers, BridgeUser{ID: cfg.UserID(u.Username), Admin: u.BridgeAdmin})
+	}
+
 	// Track if we need to save config (new tokens were generated)
 	configChanged := false
 
@@ -363,6 +392,7 @@ func (g *Generator) GenerateAll(cfg *config.Config) error {
 			HSToken:            tokens.HSToken,
 			BotUsername:        bridge.BotUsername(),
 			DoublePuppetSecret: doublePuppetSecret,
+			Users:              bridgeUsers,
 		}
 
 		// Add telegram-specific credentials if available
@@ -378,6 +408,7 @@ func (g *Generator) GenerateAll(cfg *config.Config) error {
 		// Generate registration (with same tokens)
 		regData := BridgeRegistrationData{
 			Name:            bridgeName,
+			ServiceName:     bridge.ServiceName(),
 			Port:            bridge.Port,
 			ASToken:         tokens.ASToken,
 			HSToken:         tokens.HSToken,
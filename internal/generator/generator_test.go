@@ -8,7 +8,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/bridges"
 	"github.com/tobocop2/muxbee/internal/config"
+	"gopkg.in/yaml.v3"
 )
 
 func setupTestEnv(t *testing.T) string {
@@ -112,6 +114,7 @@ func TestGenerateBridgeConfig(t *testing.T) {
 		ServerName: "localhost",
 		Port:       29318,
 		AdminUser:  "admin",
+		Users:      []BridgeUser{{ID: "@alice:localhost"}, {ID: "@bob:localhost", Admin: true}},
 	}
 
 	bridgeNames := []string{"whatsapp", "telegram", "signal", "gmessages", "discord", "slack", "meta", "twitter", "bluesky", "linkedin", "googlechat", "gvoice", "irc"}
@@ -128,6 +131,11 @@ func TestGenerateBridgeConfig(t *testing.T) {
 
 			assert.Contains(t, string(content), "domain: localhost")
 			assert.Contains(t, string(content), "@admin:localhost")
+
+			var parsed map[string]any
+			require.NoError(t, yaml.Unmarshal(content, &parsed))
+			assert.Contains(t, string(content), `"@alice:localhost": `)
+			assert.Contains(t, string(content), `"@bob:localhost": admin`)
 		})
 	}
 }
@@ -317,3 +325,90 @@ func TestSynapseNoBridges(t *testing.T) {
 	// Should have empty array for app_service_config_files
 	assert.True(t, strings.Contains(string(content), "[]") || !strings.Contains(string(content), "/bridges/"))
 }
+
+func TestSynapseMetrics(t *testing.T) {
+	setupTestEnv(t)
+
+	gen := New()
+	data := SynapseData{
+		ServerName:    "localhost",
+		PublicBaseURL: "http://localhost:8008",
+		Postgres:      config.PostgresConfig{User: "synapse", Password: "pass", Database: "synapse"},
+		Metrics:       true,
+	}
+	require.NoError(t, gen.GenerateSynapse(data))
+
+	content, err := os.ReadFile(filepath.Join(config.ConfigDir(), "synapse", "homeserver.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "  - port: 9000\n    type: metrics\n")
+	assert.Contains(t, string(content), "enable_metrics: true\n")
+
+	var parsed map[string]any
+	require.NoError(t, yaml.Unmarshal(content, &parsed))
+	assert.Len(t, parsed["listeners"], 2)
+}
+
+func TestSynapseRegistration(t *testing.T) {
+	setupTestEnv(t)
+
+	gen := New()
+	data := SynapseData{
+		ServerName:    "localhost",
+		PublicBaseURL: "http://localhost:8008",
+		Postgres:      config.PostgresConfig{User: "synapse", Password: "pass", Database: "synapse"},
+	}
+	require.NoError(t, gen.GenerateSynapse(data))
+	content, err := os.ReadFile(filepath.Join(config.ConfigDir(), "synapse", "homeserver.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "enable_registration: false\n")
+	assert.NotContains(t, string(content), "registration_requires_token")
+
+	data.Registration = true
+	require.NoError(t, gen.GenerateSynapse(data))
+	content, err = os.ReadFile(filepath.Join(config.ConfigDir(), "synapse", "homeserver.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "enable_registration: true\nregistration_requires_token: true\n")
+}
+
+func TestGenerateAllWithUserBridge(t *testing.T) {
+	tmpDir := setupTestEnv(t)
+
+	bridgesDir := filepath.Join(tmpDir, "bridges.d")
+	require.NoError(t, os.MkdirAll(bridgesDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bridgesDir, "custom.yaml"), []byte(`
+mybridge:
+  description: Internal bridge
+  port: 29400
+  image: registry.example.com/my-bridge:latest
+  service_name: my-bridge
+  bot_username: mybot
+  namespace: mine_
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(bridgesDir, "mybridge.yaml.tmpl"),
+		[]byte("domain: {{.ServerName}}\nbot: {{.BotUsername}}\nport: {{.Port}}\nadmin: \"@{{.AdminUser}}:{{.ServerName}}\"\n"), 0644))
+
+	require.NoError(t, bridges.LoadUserBridges(bridgesDir))
+	t.Cleanup(func() { require.NoError(t, bridges.LoadUserBridges(t.TempDir())) })
+
+	cfg := &config.Config{
+		ServerName:     "test.local",
+		Postgres:       config.PostgresConfig{User: "synapse", Password: "pass", Database: "synapse"},
+		Admin:          config.AdminConfig{Username: "admin", Password: "adminpass"},
+		EnabledBridges: []string{"mybridge"},
+	}
+
+	gen := New()
+	require.NoError(t, gen.GenerateAll(cfg))
+
+	content, err := os.ReadFile(filepath.Join(config.DataDir(), "bridges", "mybridge", "config.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "domain: test.local")
+	assert.Contains(t, string(content), "bot: mybot")
+	assert.Contains(t, string(content), "port: 29400")
+
+	reg, err := os.ReadFile(filepath.Join(config.ConfigDir(), "bridges", "mybridge", "registration.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reg), "url: http://my-bridge:29400")
+	assert.Contains(t, string(reg), "sender_localpart: mybot")
+	assert.Contains(t, string(reg), "@mine_.*:test.local")
+}
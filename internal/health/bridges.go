@@ -0,0 +1,191 @@
+// Package health probes bridges beyond their container state: whether the
+// appservice listener answers, and whether the bridge is still logged in to
+// the remote network.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+// State summarizes a bridge, from its container up to its remote login
+type State string
+
+const (
+	StateStopped   State = "stopped"    // Container not running
+	StateRunning   State = "running"    // Container running, appservice listener not answering
+	StateReachable State = "reachable"  // Listener answers, login state unknown
+	StateLoggedIn  State = "logged in"  // Bot reports an active login
+	StateLoggedOut State = "logged out" // Bot reports no login (or an expired one)
+)
+
+// DefaultPingTimeout is how long to wait for a bridge bot to answer "ping"
+const DefaultPingTimeout = 10 * time.Second
+
+// Bridge is the probed state of one enabled bridge
+type Bridge struct {
+	Name      string            `json:"name" yaml:"name"`
+	Service   string            `json:"service" yaml:"service"`
+	State     State             `json:"state" yaml:"state"`
+	Running   bool              `json:"running" yaml:"running"`
+	Reachable bool              `json:"reachable" yaml:"reachable"`
+	Login     matrix.LoginState `json:"login" yaml:"login"`
+	Detail    string            `json:"detail,omitempty" yaml:"detail,omitempty"`
+
+	// ProbeErr is why the listener probe failed. The probe runs inside the
+	// synapse container, so docker.ErrNotRunning means synapse is down, not
+	// the bridge.
+	ProbeErr error `json:"-" yaml:"-"`
+}
+
+// Healthy reports whether the bridge is working as far as the probes can tell
+func (b Bridge) Healthy() bool {
+	return b.State == StateLoggedIn || b.State == StateReachable
+}
+
+// Options controls CheckBridges
+type Options struct {
+	// Login pings each bridge bot for its login state, at most once per
+	// LoginCheckInterval; more recent answers are reused
+	Login       bool
+	PingTimeout time.Duration // Zero means DefaultPingTimeout
+}
+
+// probes are the checks run against a bridge, swappable for tests
+type probes struct {
+	reachable func(b bridges.BridgeInfo) error
+	login     func(b bridges.BridgeInfo) loginResult // nil skips the login check
+}
+
+// CheckBridges probes every enabled bridge in parallel. statuses is the
+// current compose status, so callers that already have it don't query twice.
+func CheckBridges(cfg *config.Config, compose *docker.Compose, statuses []docker.ServiceStatus, opts Options) []Bridge {
+	p := probes{reachable: compose.ProbeAppservice}
+
+	if opts.Login {
+		timeout := opts.PingTimeout
+		if timeout == 0 {
+			timeout = DefaultPingTimeout
+		}
+
+		// Only log in as the admin if some bot is due a ping
+		var once sync.Once
+		var client *matrix.Client
+		var clientErr error
+		p.login = func(b bridges.BridgeInfo) loginResult {
+			return logins.get(b.Name, func() (loginResult, bool) {
+				once.Do(func() { client, clientErr = matrix.AdminClient(cfg) })
+				if clientErr != nil {
+					// Says nothing about the bridge, so it isn't kept
+					return loginResult{Login: matrix.LoginUnknown, Detail: "login not checked: could not log in as admin"}, false
+				}
+				return pingLogin(func() (string, error) {
+					return client.PingBot(fmt.Sprintf("@%s:%s", b.BotUsername(), cfg.ServerName), timeout)
+				}), true
+			})
+		}
+	}
+
+	running := make(map[string]bool)
+	for _, s := range statuses {
+		if s.Running {
+			running[docker.ParseServiceName(s.Name)] = true
+		}
+	}
+
+	var infos []bridges.BridgeInfo
+	for _, name := range cfg.EnabledBridges {
+		if b := bridges.Get(name); b != nil {
+			infos = append(infos, *b)
+		}
+	}
+
+	return check(infos, running, p)
+}
+
+// check runs the probes for each bridge in parallel
+func check(infos []bridges.BridgeInfo, running map[string]bool, p probes) []Bridge {
+	results := make([]Bridge, len(infos))
+
+	var wg sync.WaitGroup
+	for i, b := range infos {
+		wg.Add(1)
+		go func(i int, b bridges.BridgeInfo) {
+			defer wg.Done()
+			results[i] = checkOne(b, running[b.ServiceName()], p)
+		}(i, b)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func checkOne(b bridges.BridgeInfo, running bool, p probes) Bridge {
+	result := Bridge{
+		Name:    b.Name,
+		Service: b.ServiceName(),
+		State:   StateStopped,
+		Running: running,
+		Login:   matrix.LoginUnknown,
+	}
+	if !running {
+		return result
+	}
+
+	result.State = StateRunning
+	if err := p.reachable(b); err != nil {
+		result.Detail = err.Error()
+		result.ProbeErr = err
+		return result
+	}
+	result.Reachable = true
+	result.State = StateReachable
+
+	if p.login == nil {
+		return result
+	}
+
+	login := p.login(b)
+	result.Login, result.Detail = login.Login, login.Detail
+	switch result.Login {
+	case matrix.LoggedIn:
+		result.State = StateLoggedIn
+	case matrix.LoggedOut:
+		result.State = StateLoggedOut
+	}
+	return result
+}
+
+// pingLogin pings a bridge bot and reads its login state from the reply
+func pingLogin(ping func() (string, error)) loginResult {
+	reply, err := ping()
+	switch {
+	case errors.Is(err, matrix.ErrNoBotRoom):
+		return loginResult{Login: matrix.LoginUnknown, Detail: "no chat with the bot, run 'muxbee setup-bots'"}
+	case err != nil:
+		return loginResult{Login: matrix.LoginUnknown, Detail: err.Error()}
+	}
+
+	result := loginResult{Login: matrix.ParseLoginState(reply)}
+	if result.Login == matrix.LoginUnknown {
+		result.Detail = "unrecognized reply to ping: " + firstLine(reply)
+	}
+	return result
+}
+
+// firstLine returns the first line of a bot reply, for display
+func firstLine(s string) string {
+	for i, r := range s {
+		if r == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+// pinger turns a fake bot into a login probe, without the shared cache
+func pinger(ping func(b bridges.BridgeInfo) (string, error)) func(b bridges.BridgeInfo) loginResult {
+	return func(b bridges.BridgeInfo) loginResult {
+		return pingLogin(func() (string, error) { return ping(b) })
+	}
+}
+
+func TestCheck(t *testing.T) {
+	infos := []bridges.BridgeInfo{
+		{Name: "whatsapp", Port: 29318},
+		{Name: "signal", Port: 29328},
+		{Name: "telegram", Port: 29317},
+		{Name: "discord", Port: 29334},
+		{Name: "gmessages", Port: 29336},
+	}
+	running := map[string]bool{
+		"mautrix-whatsapp":  true,
+		"mautrix-signal":    true,
+		"mautrix-telegram":  true,
+		"mautrix-gmessages": true,
+	}
+
+	p := probes{
+		reachable: func(b bridges.BridgeInfo) error {
+			if b.Name == "telegram" {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		login: pinger(func(b bridges.BridgeInfo) (string, error) {
+			switch b.Name {
+			case "whatsapp":
+				return "You're logged in as +15551234567", nil
+			case "signal":
+				return "You're not logged in.", nil
+			}
+			return "", matrix.ErrNoBotRoom
+		}),
+	}
+
+	results := check(infos, running, p)
+	states := make(map[string]State)
+	for _, r := range results {
+		states[r.Name] = r.State
+	}
+
+	assert.Equal(t, map[string]State{
+		"whatsapp":  StateLoggedIn,
+		"signal":    StateLoggedOut,
+		"telegram":  StateRunning,
+		"discord":   StateStopped,
+		"gmessages": StateReachable,
+	}, states)
+
+	assert.True(t, results[0].Healthy())
+	assert.False(t, results[1].Healthy())
+	assert.Contains(t, results[2].Detail, "connection refused")
+	assert.EqualError(t, results[2].ProbeErr, "connection refused")
+	assert.Contains(t, results[4].Detail, "setup-bots")
+}
+
+func TestCheck_WithoutLogin(t *testing.T) {
+	infos := []bridges.BridgeInfo{{Name: "whatsapp", Port: 29318}}
+	p := probes{reachable: func(bridges.BridgeInfo) error { return nil }}
+
+	results := check(infos, map[string]bool{"mautrix-whatsapp": true}, p)
+	assert.Equal(t, StateReachable, results[0].State)
+	assert.Equal(t, matrix.LoginUnknown, results[0].Login)
+}
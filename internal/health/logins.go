@@ -0,0 +1,112 @@
+package health
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+// LoginCheckInterval is how often a bridge bot is pinged at most. The last
+// answer is shared through a file in the data directory, so 'muxbee status',
+// the dashboard, alerts and metrics don't ping more than that between them.
+const LoginCheckInterval = 5 * time.Minute
+
+// loginResult is what one ping told about a bridge's login
+type loginResult struct {
+	Login     matrix.LoginState `json:"login"`
+	Detail    string            `json:"detail,omitempty"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// loginCache keeps the last login check of each bridge
+type loginCache struct {
+	path func() string
+	now  func() time.Time
+
+	mu      sync.Mutex             // Guards bridges and the file
+	bridges map[string]*sync.Mutex // Held while a bridge is checked
+}
+
+var logins = &loginCache{
+	path:    func() string { return filepath.Join(config.DataDir(), "bridge_logins.json") },
+	now:     time.Now,
+	bridges: make(map[string]*sync.Mutex),
+}
+
+// get returns the last result for a bridge if it is recent, and otherwise
+// runs check. Its result is shared with later callers if keep is set.
+// Concurrent calls for the same bridge wait for one check.
+func (c *loginCache) get(name string, check func() (result loginResult, keep bool)) loginResult {
+	lock := c.bridgeLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := c.now()
+	c.mu.Lock()
+	cached, ok := c.read()[name]
+	c.mu.Unlock()
+	if ok && !cached.CheckedAt.After(now) && now.Sub(cached.CheckedAt) < LoginCheckInterval {
+		return cached
+	}
+
+	result, keep := check()
+	result.CheckedAt = now
+	if keep {
+		c.store(name, result)
+	}
+	return result
+}
+
+func (c *loginCache) bridgeLock(name string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, ok := c.bridges[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.bridges[name] = lock
+	}
+	return lock
+}
+
+// read loads the file; a missing or damaged one only means pinging again
+func (c *loginCache) read() map[string]loginResult {
+	results := make(map[string]loginResult)
+	if data, err := os.ReadFile(c.path()); err == nil {
+		json.Unmarshal(data, &results)
+	}
+	return results
+}
+
+// store adds a result to the file, replacing it in one step so other
+// muxbee processes never read half of it. Failing to is harmless.
+func (c *loginCache) store(name string, result loginResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := c.read()
+	results[name] = result
+	data, err := json.Marshal(results)
+	if err != nil {
+		return
+	}
+
+	path := c.path()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bridge_logins-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err != nil || closeErr != nil {
+		return
+	}
+	os.Rename(tmp.Name(), path)
+}
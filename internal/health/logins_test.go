@@ -0,0 +1,92 @@
+package health
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobocop2/muxbee/internal/matrix"
+)
+
+// newTestCache returns a cache in a temp dir with a clock the test moves
+func newTestCache(t *testing.T, path string, now *time.Time) *loginCache {
+	t.Helper()
+	return &loginCache{
+		path:    func() string { return path },
+		now:     func() time.Time { return *now },
+		bridges: make(map[string]*sync.Mutex),
+	}
+}
+
+func TestLoginCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge_logins.json")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := newTestCache(t, path, &now)
+
+	var pings int
+	check := func() (loginResult, bool) {
+		pings++
+		return loginResult{Login: matrix.LoggedIn}, true
+	}
+
+	assert.Equal(t, matrix.LoggedIn, cache.get("signal", check).Login)
+	now = now.Add(LoginCheckInterval - time.Second)
+	assert.Equal(t, matrix.LoggedIn, cache.get("signal", check).Login)
+	assert.Equal(t, 1, pings, "a recent answer should be reused")
+
+	// Another process reads the same file
+	other := newTestCache(t, path, &now)
+	other.get("signal", check)
+	assert.Equal(t, 1, pings)
+
+	now = now.Add(time.Second)
+	cache.get("signal", check)
+	assert.Equal(t, 2, pings, "an old answer should be checked again")
+
+	// Other bridges are checked on their own
+	cache.get("whatsapp", check)
+	assert.Equal(t, 3, pings)
+}
+
+func TestLoginCache_NotKept(t *testing.T) {
+	now := time.Now()
+	cache := newTestCache(t, filepath.Join(t.TempDir(), "bridge_logins.json"), &now)
+
+	var pings int
+	check := func() (loginResult, bool) {
+		pings++
+		return loginResult{Login: matrix.LoginUnknown, Detail: "login not checked"}, false
+	}
+	result := cache.get("signal", check)
+	cache.get("signal", check)
+	assert.Equal(t, "login not checked", result.Detail)
+	assert.Equal(t, 2, pings)
+}
+
+func TestLoginCache_Concurrent(t *testing.T) {
+	now := time.Now()
+	cache := newTestCache(t, filepath.Join(t.TempDir(), "bridge_logins.json"), &now)
+
+	var mu sync.Mutex
+	var pings int
+	check := func() (loginResult, bool) {
+		mu.Lock()
+		pings++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return loginResult{Login: matrix.LoggedOut}, true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, matrix.LoggedOut, cache.get("signal", check).Login)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, pings, "callers at the same time should share one ping")
+}
@@ -0,0 +1,511 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Synapse Admin API. The client must be logged in as a server admin.
+const adminAPI = "/_synapse/admin"
+
+// adminPageSize is how many users or rooms each list request asks for
+const adminPageSize = 100
+
+// adminJobPoll is how often DeleteRoom and PurgeHistory check on the
+// homeserver's background job
+var adminJobPoll = time.Second
+
+// userLoginTTL is how long a LoginAsUser token lasts if it isn't logged out
+const userLoginTTL = time.Hour
+
+// ErrUserExists means CreateUser was asked for a user that already exists
+var ErrUserExists = errors.New("user already exists")
+
+// doJSON makes a request as the user, sending payload and decoding the
+// response into out when they aren't nil
+func (c *Client) doJSON(op, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.homeserverURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(op, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ServerVersion returns the version of Synapse
+func (c *Client) ServerVersion() (string, error) {
+	var result struct {
+		ServerVersion string `json:"server_version"`
+	}
+	if err := c.doJSON("server version", "GET", adminAPI+"/v1/server_version", nil, &result); err != nil {
+		return "", err
+	}
+	return result.ServerVersion, nil
+}
+
+// ResolveRoom returns the ID of a room given its ID or an #alias
+func (c *Client) ResolveRoom(room string) (string, error) {
+	if !strings.HasPrefix(room, "#") {
+		return room, nil
+	}
+	var result struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := c.doJSON("resolve room alias", "GET", "/_matrix/client/v3/directory/room/"+url.PathEscape(room), nil, &result); err != nil {
+		return "", err
+	}
+	return result.RoomID, nil
+}
+
+// Users
+
+// AdminUser is a user account as the Admin API describes it
+type AdminUser struct {
+	Name        string `json:"name" yaml:"name"`
+	DisplayName string `json:"displayname,omitempty" yaml:"displayname,omitempty"`
+	Admin       bool   `json:"admin" yaml:"admin"`
+	Deactivated bool   `json:"deactivated" yaml:"deactivated"`
+	UserType    string `json:"user_type,omitempty" yaml:"user_type,omitempty"`
+}
+
+// UnmarshalJSON accepts admin and deactivated as 0 and 1, which older
+// Synapse versions return, as well as booleans
+func (u *AdminUser) UnmarshalJSON(data []byte) error {
+	type plain AdminUser
+	var raw struct {
+		plain
+		Admin       json.RawMessage `json:"admin"`
+		Deactivated json.RawMessage `json:"deactivated"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*u = AdminUser(raw.plain)
+	u.Admin = isTrue(raw.Admin)
+	u.Deactivated = isTrue(raw.Deactivated)
+	return nil
+}
+
+func isTrue(v json.RawMessage) bool {
+	s := string(v)
+	return s == "true" || s == "1"
+}
+
+// UserFilter narrows down Users
+type UserFilter struct {
+	Name        string // Part of the user ID or display name
+	Guests      bool   // Include guest users
+	Deactivated bool   // Include deactivated users
+}
+
+// Users lists the homeserver's local users
+func (c *Client) Users(filter UserFilter) ([]AdminUser, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(adminPageSize))
+	query.Set("guests", strconv.FormatBool(filter.Guests))
+	query.Set("deactivated", strconv.FormatBool(filter.Deactivated))
+	if filter.Name != "" {
+		query.Set("name", filter.Name)
+	}
+
+	var users []AdminUser
+	for {
+		var page struct {
+			Users     []AdminUser `json:"users"`
+			NextToken string      `json:"next_token"`
+		}
+		if err := c.doJSON("list users", "GET", adminAPI+"/v2/users?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		users = append(users, page.Users...)
+		if page.NextToken == "" {
+			return users, nil
+		}
+		query.Set("from", page.NextToken)
+	}
+}
+
+// User looks up one user. It returns ErrNotFound if there's no such user.
+func (c *Client) User(userID string) (*AdminUser, error) {
+	var user AdminUser
+	if err := c.doJSON("get user", "GET", adminAPI+"/v2/users/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// NewUser describes an account for CreateUser
+type NewUser struct {
+	Password    string
+	DisplayName string
+	Admin       bool
+}
+
+// CreateUser creates a local user. It returns ErrUserExists rather than
+// changing a user that already exists.
+func (c *Client) CreateUser(userID string, user NewUser) error {
+	if _, err := c.User(userID); err == nil {
+		return fmt.Errorf("%s: %w", userID, ErrUserExists)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"password": user.Password,
+		"admin":    user.Admin,
+	}
+	if user.DisplayName != "" {
+		payload["displayname"] = user.DisplayName
+	}
+	return c.doJSON("create user", "PUT", adminAPI+"/v2/users/"+url.PathEscape(userID), payload, nil)
+}
+
+// LoginAsUser returns a client acting as another local user. It adds no
+// device to their account; call Logout when done. The token expires on
+// its own after userLoginTTL.
+func (c *Client) LoginAsUser(userID string) (*Client, error) {
+	payload := map[string]interface{}{
+		"valid_until_ms": time.Now().Add(userLoginTTL).UnixMilli(),
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.doJSON("login as user", "POST", adminAPI+"/v1/users/"+url.PathEscape(userID)+"/login", payload, &result); err != nil {
+		return nil, err
+	}
+
+	client := NewClient(c.homeserverURL)
+	client.UseSession(Session{UserID: userID, AccessToken: result.AccessToken})
+	return client, nil
+}
+
+// DeactivateUser logs a user out everywhere and locks the account for good.
+// With erase, their messages are hidden from users who join rooms later.
+func (c *Client) DeactivateUser(userID string, erase bool) error {
+	payload := map[string]interface{}{"erase": erase}
+	return c.doJSON("deactivate user", "POST", adminAPI+"/v1/deactivate/"+url.PathEscape(userID), payload, nil)
+}
+
+// ResetPassword sets a user's password, logging out all their devices
+// unless keepDevices is set
+func (c *Client) ResetPassword(userID, password string, keepDevices bool) error {
+	payload := map[string]interface{}{
+		"new_password":   password,
+		"logout_devices": !keepDevices,
+	}
+	return c.doJSON("reset password", "POST", adminAPI+"/v1/reset_password/"+url.PathEscape(userID), payload, nil)
+}
+
+// Rooms
+
+// AdminRoom is a room as the Admin API describes it
+type AdminRoom struct {
+	RoomID             string `json:"room_id" yaml:"room_id"`
+	Name               string `json:"name,omitempty" yaml:"name,omitempty"`
+	CanonicalAlias     string `json:"canonical_alias,omitempty" yaml:"canonical_alias,omitempty"`
+	JoinedMembers      int    `json:"joined_members" yaml:"joined_members"`
+	JoinedLocalMembers int    `json:"joined_local_members" yaml:"joined_local_members"`
+	Creator            string `json:"creator,omitempty" yaml:"creator,omitempty"`
+	Encryption         string `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	Public             bool   `json:"public" yaml:"public"`
+	StateEvents        int    `json:"state_events" yaml:"state_events"`
+}
+
+// Rooms lists the rooms the homeserver knows about, biggest first. A
+// non-empty search keeps the rooms whose name, alias or ID contains it.
+func (c *Client) Rooms(search string) ([]AdminRoom, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(adminPageSize))
+	query.Set("order_by", "joined_members")
+	if search != "" {
+		query.Set("search_term", search)
+	}
+
+	var rooms []AdminRoom
+	for {
+		var page struct {
+			Rooms     []AdminRoom `json:"rooms"`
+			NextBatch *int        `json:"next_batch"`
+		}
+		if err := c.doJSON("list rooms", "GET", adminAPI+"/v1/rooms?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, page.Rooms...)
+		if page.NextBatch == nil {
+			return rooms, nil
+		}
+		query.Set("from", strconv.Itoa(*page.NextBatch))
+	}
+}
+
+// DeleteRoomOptions tunes DeleteRoom
+type DeleteRoomOptions struct {
+	Block   bool   // Stop anyone from joining the room again
+	Message string // Told to the members as they are removed
+}
+
+// RoomDeletion is what DeleteRoom did
+type RoomDeletion struct {
+	KickedUsers       []string `json:"kicked_users" yaml:"kicked_users"`
+	FailedToKickUsers []string `json:"failed_to_kick_users" yaml:"failed_to_kick_users"`
+	LocalAliases      []string `json:"local_aliases" yaml:"local_aliases"`
+}
+
+// DeleteRoom removes every local member from a room and purges it from the
+// database. It waits for the homeserver to finish.
+func (c *Client) DeleteRoom(ctx context.Context, roomID string, opts DeleteRoomOptions) (*RoomDeletion, error) {
+	payload := map[string]interface{}{
+		"block": opts.Block,
+		"purge": true,
+	}
+	if opts.Message != "" {
+		payload["message"] = opts.Message
+	}
+
+	var started struct {
+		DeleteID string `json:"delete_id"`
+	}
+	if err := c.doJSON("delete room", "DELETE", adminAPI+"/v2/rooms/"+url.PathEscape(roomID), payload, &started); err != nil {
+		return nil, err
+	}
+
+	var status struct {
+		Status       string       `json:"status"`
+		Error        string       `json:"error"`
+		ShutdownRoom RoomDeletion `json:"shutdown_room"`
+	}
+	err := c.waitForJob(ctx, func() (bool, error) {
+		if err := c.doJSON("delete room", "GET", adminAPI+"/v2/rooms/delete_status/"+url.PathEscape(started.DeleteID), nil, &status); err != nil {
+			return false, err
+		}
+		return jobDone("delete room", status.Status, status.Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &status.ShutdownRoom, nil
+}
+
+// PurgeHistory deletes a room's events from before a time, keeping the
+// latest one. Events sent by local users are kept unless deleteLocal is
+// set. It waits for the homeserver to finish.
+func (c *Client) PurgeHistory(ctx context.Context, roomID string, before time.Time, deleteLocal bool) error {
+	payload := map[string]interface{}{
+		"purge_up_to_ts":      before.UnixMilli(),
+		"delete_local_events": deleteLocal,
+	}
+
+	var started struct {
+		PurgeID string `json:"purge_id"`
+	}
+	if err := c.doJSON("purge history", "POST", adminAPI+"/v1/purge_history/"+url.PathEscape(roomID), payload, &started); err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, func() (bool, error) {
+		var status struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := c.doJSON("purge history", "GET", adminAPI+"/v1/purge_history_status/"+url.PathEscape(started.PurgeID), nil, &status); err != nil {
+			return false, err
+		}
+		return jobDone("purge history", status.Status, status.Error)
+	})
+}
+
+// waitForJob calls check until it reports the job done or fails
+func (c *Client) waitForJob(ctx context.Context, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(adminJobPoll):
+		}
+	}
+}
+
+// jobDone reads the status of a background job
+func jobDone(op, status, message string) (bool, error) {
+	switch status {
+	case "complete":
+		return true, nil
+	case "failed":
+		return false, fmt.Errorf("%s failed: %s", op, message)
+	}
+	return false, nil
+}
+
+// Media
+
+// QuarantineMedia stops a piece of media from being served. mxcURL is an
+// mxc://server/media-id URL.
+func (c *Client) QuarantineMedia(mxcURL string) error {
+	server, mediaID, err := ParseMXC(mxcURL)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/v1/media/quarantine/%s/%s", adminAPI, url.PathEscape(server), url.PathEscape(mediaID))
+	return c.doJSON("quarantine media", "POST", path, map[string]interface{}{}, nil)
+}
+
+// QuarantineRoomMedia quarantines all media sent to a room and returns how
+// many were quarantined
+func (c *Client) QuarantineRoomMedia(roomID string) (int, error) {
+	return c.quarantine(fmt.Sprintf("%s/v1/room/%s/media/quarantine", adminAPI, url.PathEscape(roomID)))
+}
+
+// QuarantineUserMedia quarantines all media a local user uploaded and
+// returns how many were quarantined
+func (c *Client) QuarantineUserMedia(userID string) (int, error) {
+	return c.quarantine(fmt.Sprintf("%s/v1/user/%s/media/quarantine", adminAPI, url.PathEscape(userID)))
+}
+
+func (c *Client) quarantine(path string) (int, error) {
+	var result struct {
+		NumQuarantined int `json:"num_quarantined"`
+	}
+	if err := c.doJSON("quarantine media", "POST", path, map[string]interface{}{}, &result); err != nil {
+		return 0, err
+	}
+	return result.NumQuarantined, nil
+}
+
+// DeleteMedia deletes a piece of media uploaded to this homeserver
+func (c *Client) DeleteMedia(mxcURL string) error {
+	server, mediaID, err := ParseMXC(mxcURL)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/v1/media/%s/%s", adminAPI, url.PathEscape(server), url.PathEscape(mediaID))
+	return c.doJSON("delete media", "DELETE", path, nil, nil)
+}
+
+// PurgeRemoteMedia deletes the cached copies of other homeservers' media
+// last used before a time and returns how many were deleted. They are
+// fetched again if someone asks for them.
+func (c *Client) PurgeRemoteMedia(before time.Time) (int, error) {
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	path := fmt.Sprintf("%s/v1/purge_media_cache?before_ts=%d", adminAPI, before.UnixMilli())
+	if err := c.doJSON("purge remote media", "POST", path, map[string]interface{}{}, &result); err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
+}
+
+// PurgeLocalMedia deletes media uploaded to this homeserver and last used
+// before a time, and returns how many were deleted. Avatars are kept.
+func (c *Client) PurgeLocalMedia(before time.Time) (int, error) {
+	var result struct {
+		Total int `json:"total"`
+	}
+	path := fmt.Sprintf("%s/v1/media/delete?before_ts=%d&keep_profiles=true", adminAPI, before.UnixMilli())
+	if err := c.doJSON("purge local media", "POST", path, map[string]interface{}{}, &result); err != nil {
+		return 0, err
+	}
+	return result.Total, nil
+}
+
+// Registration tokens
+
+// RegistrationToken lets someone sign up while registration requires one
+type RegistrationToken struct {
+	Token       string `json:"token" yaml:"token"`
+	UsesAllowed *int   `json:"uses_allowed" yaml:"uses_allowed"` // nil for unlimited
+	Pending     int    `json:"pending" yaml:"pending"`
+	Completed   int    `json:"completed" yaml:"completed"`
+	ExpiryTime  *int64 `json:"expiry_time" yaml:"expiry_time"` // Unix ms, nil for never
+}
+
+// Expires returns when the token stops working, or the zero time if never
+func (t RegistrationToken) Expires() time.Time {
+	if t.ExpiryTime == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(*t.ExpiryTime)
+}
+
+// RegistrationTokens lists the registration tokens
+func (c *Client) RegistrationTokens() ([]RegistrationToken, error) {
+	var result struct {
+		RegistrationTokens []RegistrationToken `json:"registration_tokens"`
+	}
+	if err := c.doJSON("list registration tokens", "GET", adminAPI+"/v1/registration_tokens", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.RegistrationTokens, nil
+}
+
+// NewRegistrationToken describes a token for CreateRegistrationToken. The
+// zero value is a random token that can be used any number of times and
+// never expires.
+type NewRegistrationToken struct {
+	Token       string // Random if empty
+	UsesAllowed int    // Unlimited if 0
+	Expires     time.Time
+}
+
+// CreateRegistrationToken creates a registration token
+func (c *Client) CreateRegistrationToken(opts NewRegistrationToken) (*RegistrationToken, error) {
+	payload := map[string]interface{}{}
+	if opts.Token != "" {
+		payload["token"] = opts.Token
+	}
+	if opts.UsesAllowed > 0 {
+		payload["uses_allowed"] = opts.UsesAllowed
+	}
+	if !opts.Expires.IsZero() {
+		payload["expiry_time"] = opts.Expires.UnixMilli()
+	}
+
+	var token RegistrationToken
+	if err := c.doJSON("create registration token", "POST", adminAPI+"/v1/registration_tokens/new", payload, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteRegistrationToken deletes a registration token
+func (c *Client) DeleteRegistrationToken(token string) error {
+	return c.doJSON("delete registration token", "DELETE", adminAPI+"/v1/registration_tokens/"+url.PathEscape(token), nil, nil)
+}
@@ -0,0 +1,253 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newAdminServer serves the given handlers, keyed by method and path
+func newAdminServer(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test_token" {
+			t.Errorf("expected Bearer test_token, got %s", r.Header.Get("Authorization"))
+		}
+		handler, ok := handlers[r.Method+" "+r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}))
+}
+
+func TestAdmin_Users(t *testing.T) {
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"GET /_synapse/admin/v2/users": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("deactivated") != "true" {
+				t.Errorf("expected deactivated=true, got %s", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("from") == "" {
+				w.Write([]byte(`{"users": [{"name": "@admin:localhost", "admin": 1, "deactivated": 0}], "next_token": "1", "total": 2}`))
+				return
+			}
+			w.Write([]byte(`{"users": [{"name": "@alice:localhost", "displayname": "Alice", "admin": false, "deactivated": true}], "total": 2}`))
+		},
+	})
+	defer server.Close()
+
+	users, err := newTestClient(server.URL).Users(UserFilter{Deactivated: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("expected both pages, got %+v", users)
+	}
+	if !users[0].Admin || users[0].Deactivated {
+		t.Errorf("expected 0 and 1 to read as booleans, got %+v", users[0])
+	}
+	if users[1].Admin || !users[1].Deactivated || users[1].DisplayName != "Alice" {
+		t.Errorf("unexpected user: %+v", users[1])
+	}
+}
+
+func TestAdmin_CreateUser(t *testing.T) {
+	var created map[string]interface{}
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"GET /_synapse/admin/v2/users/@alice:localhost": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"name": "@alice:localhost"}`))
+		},
+		"GET /_synapse/admin/v2/users/@bob:localhost": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode": "M_NOT_FOUND", "error": "User not found"}`))
+		},
+		"PUT /_synapse/admin/v2/users/@bob:localhost": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"name": "@bob:localhost"}`))
+		},
+	})
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	if err := client.CreateUser("@alice:localhost", NewUser{Password: "pw"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+	if err := client.CreateUser("@bob:localhost", NewUser{Password: "pw", DisplayName: "Bob"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created["password"] != "pw" || created["displayname"] != "Bob" || created["admin"] != false {
+		t.Errorf("unexpected payload: %v", created)
+	}
+}
+
+func TestAdmin_DeleteRoom(t *testing.T) {
+	adminJobPoll = time.Millisecond
+	defer func() { adminJobPoll = time.Second }()
+
+	var polls int
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"GET /_matrix/client/v3/directory/room/#old:localhost": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"room_id": "!old:localhost"}`))
+		},
+		"DELETE /_synapse/admin/v2/rooms/!old:localhost": func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["purge"] != true || payload["block"] != true {
+				t.Errorf("unexpected payload: %v", payload)
+			}
+			w.Write([]byte(`{"delete_id": "d1"}`))
+		},
+		"GET /_synapse/admin/v2/rooms/delete_status/d1": func(w http.ResponseWriter, r *http.Request) {
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"status": "active"}`))
+				return
+			}
+			w.Write([]byte(`{"status": "complete", "shutdown_room": {"kicked_users": ["@alice:localhost"]}}`))
+		},
+	})
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	roomID, err := client.ResolveRoom("#old:localhost")
+	if err != nil || roomID != "!old:localhost" {
+		t.Fatalf("expected the alias to resolve, got %q (%v)", roomID, err)
+	}
+	result, err := client.DeleteRoom(context.Background(), roomID, DeleteRoomOptions{Block: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("expected to wait for the job, got %d polls", polls)
+	}
+	if len(result.KickedUsers) != 1 || result.KickedUsers[0] != "@alice:localhost" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestAdmin_PurgeHistoryFailed(t *testing.T) {
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"POST /_synapse/admin/v1/purge_history/!room:localhost": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"purge_id": "p1"}`))
+		},
+		"GET /_synapse/admin/v1/purge_history_status/p1": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status": "failed", "error": "database is locked"}`))
+		},
+	})
+	defer server.Close()
+
+	err := newTestClient(server.URL).PurgeHistory(context.Background(), "!room:localhost", time.Now(), false)
+	if err == nil || err.Error() != "purge history failed: database is locked" {
+		t.Errorf("expected the job's error, got %v", err)
+	}
+}
+
+func TestAdmin_Media(t *testing.T) {
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"POST /_synapse/admin/v1/media/quarantine/localhost/abc": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		},
+		"POST /_synapse/admin/v1/user/@spam:localhost/media/quarantine": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"num_quarantined": 4}`))
+		},
+		"POST /_synapse/admin/v1/purge_media_cache": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("before_ts") != "1700000000000" {
+				t.Errorf("unexpected before_ts: %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"deleted": 7}`))
+		},
+	})
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	if err := client.QuarantineMedia("mxc://localhost/abc"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := client.QuarantineMedia("https://localhost/abc"); err == nil {
+		t.Error("expected an error for a URL that isn't mxc://")
+	}
+	if n, err := client.QuarantineUserMedia("@spam:localhost"); err != nil || n != 4 {
+		t.Errorf("expected 4 quarantined, got %d (%v)", n, err)
+	}
+	if n, err := client.PurgeRemoteMedia(time.UnixMilli(1700000000000)); err != nil || n != 7 {
+		t.Errorf("expected 7 deleted, got %d (%v)", n, err)
+	}
+}
+
+func TestAdmin_RegistrationTokens(t *testing.T) {
+	server := newAdminServer(t, map[string]http.HandlerFunc{
+		"POST /_synapse/admin/v1/registration_tokens/new": func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if _, ok := payload["uses_allowed"]; ok {
+				t.Errorf("expected unlimited uses to be left out, got %v", payload)
+			}
+			if payload["expiry_time"] != float64(1700000000000) {
+				t.Errorf("unexpected expiry_time: %v", payload["expiry_time"])
+			}
+			w.Write([]byte(`{"token": "abcd", "uses_allowed": null, "pending": 0, "completed": 0, "expiry_time": 1700000000000}`))
+		},
+		"DELETE /_synapse/admin/v1/registration_tokens/abcd": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		},
+	})
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	token, err := client.CreateRegistrationToken(NewRegistrationToken{Expires: time.UnixMilli(1700000000000)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.Token != "abcd" || token.UsesAllowed != nil || !token.Expires().Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if err := client.DeleteRegistrationToken("abcd"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAdmin_LoginAsUser(t *testing.T) {
+	var loggedOut bool
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "POST /_synapse/admin/v1/users/@alice:localhost/login":
+			if r.Header.Get("Authorization") != "Bearer test_token" {
+				t.Errorf("expected the admin's token, got %s", r.Header.Get("Authorization"))
+			}
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if _, ok := payload["valid_until_ms"]; !ok {
+				t.Errorf("expected the token to expire, got %v", payload)
+			}
+			w.Write([]byte(`{"access_token": "alice_token"}`))
+		case "POST /_matrix/client/v3/logout":
+			if r.Header.Get("Authorization") != "Bearer alice_token" {
+				t.Errorf("expected alice's token, got %s", r.Header.Get("Authorization"))
+			}
+			loggedOut = true
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer admin.Close()
+
+	client, err := newTestClient(admin.URL).LoginAsUser("@alice:localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.Session().UserID != "@alice:localhost" || client.DeviceID() != "" {
+		t.Errorf("unexpected session: %+v", client.Session())
+	}
+	if err := client.Logout(); err != nil || !loggedOut {
+		t.Errorf("expected to log out, got %v", err)
+	}
+}
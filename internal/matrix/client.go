@@ -3,6 +3,7 @@ package matrix
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,13 +11,69 @@ import (
 	"time"
 )
 
+// DeviceDisplayName names the devices muxbee logs in as
+const DeviceDisplayName = "muxbee"
+
 // Client is a simple Matrix client for admin operations
 type Client struct {
 	homeserverURL string
 	accessToken   string
+	userID        string
+	deviceID      string
 	httpClient    *http.Client
 }
 
+// ErrUnknownToken means the homeserver no longer accepts the access token,
+// such as after its device was deleted
+var ErrUnknownToken = errors.New("unknown access token")
+
+// ErrNotFound means the user, room or other resource doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// APIError is an error response from the homeserver
+type APIError struct {
+	Op         string // What failed, like "sync"
+	StatusCode int
+	ErrCode    string        // Matrix error code, like M_FORBIDDEN
+	Message    string        // Human-readable error
+	RetryAfter time.Duration // Set on M_LIMIT_EXCEEDED
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Op, e.Body)
+}
+
+// Is makes errors.Is(err, ErrUnknownToken) true for M_UNKNOWN_TOKEN and
+// errors.Is(err, ErrNotFound) true for M_NOT_FOUND
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnknownToken:
+		return e.ErrCode == "M_UNKNOWN_TOKEN"
+	case ErrNotFound:
+		return e.ErrCode == "M_NOT_FOUND"
+	}
+	return false
+}
+
+// newAPIError reads an error response
+func newAPIError(op string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{Op: op, StatusCode: resp.StatusCode, Body: string(body)}
+
+	var result struct {
+		ErrCode      string `json:"errcode"`
+		Error        string `json:"error"`
+		RetryAfterMs int64  `json:"retry_after_ms"`
+	}
+	if json.Unmarshal(body, &result) == nil {
+		apiErr.ErrCode = result.ErrCode
+		apiErr.Message = result.Error
+		apiErr.RetryAfter = time.Duration(result.RetryAfterMs) * time.Millisecond
+	}
+	return apiErr
+}
+
 // NewClient creates a new Matrix client
 func NewClient(homeserverURL string) *Client {
 	return &Client{
@@ -27,15 +84,25 @@ func NewClient(homeserverURL string) *Client {
 	}
 }
 
-// Login authenticates with the homeserver
+// Login authenticates with the homeserver as a new device
 func (c *Client) Login(username, password string) error {
+	return c.LoginDevice(username, password, "")
+}
+
+// LoginDevice authenticates as an existing device, replacing its access
+// token, or as a new device when deviceID is empty
+func (c *Client) LoginDevice(username, password, deviceID string) error {
 	payload := map[string]interface{}{
 		"type": "m.login.password",
 		"identifier": map[string]string{
 			"type": "m.id.user",
 			"user": username,
 		},
-		"password": password,
+		"password":                    password,
+		"initial_device_display_name": DeviceDisplayName,
+	}
+	if deviceID != "" {
+		payload["device_id"] = deviceID
 	}
 
 	body, err := json.Marshal(payload)
@@ -60,15 +127,52 @@ func (c *Client) Login(username, password string) error {
 
 	var result struct {
 		AccessToken string `json:"access_token"`
+		UserID      string `json:"user_id"`
+		DeviceID    string `json:"device_id"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return err
 	}
 
 	c.accessToken = result.AccessToken
+	c.userID = result.UserID
+	c.deviceID = result.DeviceID
 	return nil
 }
 
+// Logout invalidates the client's access token
+func (c *Client) Logout() error {
+	return c.doJSON("logout", "POST", "/_matrix/client/v3/logout", map[string]interface{}{}, nil)
+}
+
+// WhoAmI returns the user and device the access token belongs to
+func (c *Client) WhoAmI() (userID, deviceID string, err error) {
+	req, err := http.NewRequest("GET", c.homeserverURL+"/_matrix/client/v3/account/whoami", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", "", newAPIError("whoami", resp)
+	}
+
+	var result struct {
+		UserID   string `json:"user_id"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", "", err
+	}
+	return result.UserID, result.DeviceID, nil
+}
+
 // CreateDirectMessage creates a DM room with a user
 func (c *Client) CreateDirectMessage(userID string) (string, error) {
 	payload := map[string]interface{}{
@@ -110,8 +214,55 @@ func (c *Client) CreateDirectMessage(userID string) (string, error) {
 	return result.RoomID, nil
 }
 
+// CreateRoom creates a private room with only the logged-in user in it
+func (c *Client) CreateRoom(name, topic string) (string, error) {
+	payload := map[string]interface{}{
+		"preset": "private_chat",
+		"name":   name,
+		"topic":  topic,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", c.homeserverURL+"/_matrix/client/v3/createRoom", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("create room failed: %s", string(respBody))
+	}
+
+	var result struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.RoomID, nil
+}
+
 // SendMessage sends a text message to a room
 func (c *Client) SendMessage(roomID, message string) error {
+	_, err := c.sendText(roomID, message)
+	return err
+}
+
+// sendText sends a text message and returns its event ID
+func (c *Client) sendText(roomID, message string) (string, error) {
 	txnID := fmt.Sprintf("%d", time.Now().UnixNano())
 
 	payload := map[string]interface{}{
@@ -121,7 +272,7 @@ func (c *Client) SendMessage(roomID, message string) error {
 
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	url := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
@@ -129,23 +280,60 @@ func (c *Client) SendMessage(roomID, message string) error {
 
 	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Set("Authorization", "Bearer "+c.accessToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("send message failed: %s", string(respBody))
+		return "", fmt.Errorf("send message failed: %s", string(respBody))
 	}
 
-	return nil
+	var result struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.EventID, nil
+}
+
+// RoomMessages returns up to limit of the most recent events in a room, newest first
+func (c *Client) RoomMessages(roomID string, limit int) ([]Event, error) {
+	url := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/messages?dir=b&limit=%d", c.homeserverURL, roomID, limit)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get room messages failed: %s", string(respBody))
+	}
+
+	var result struct {
+		Chunk []Event `json:"chunk"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Chunk, nil
 }
 
 // GetJoinedRooms returns list of rooms the user has joined
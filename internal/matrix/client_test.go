@@ -126,6 +126,43 @@ func TestCreateDirectMessage_Failure(t *testing.T) {
 	}
 }
 
+func TestCreateRoom_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/createRoom" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["preset"] != "private_chat" {
+			t.Errorf("expected preset private_chat, got %v", payload["preset"])
+		}
+		if payload["name"] != "muxbee alerts" {
+			t.Errorf("expected name muxbee alerts, got %v", payload["name"])
+		}
+		if _, ok := payload["invite"]; ok {
+			t.Error("expected no invites")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"room_id": "!alerts:localhost",
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.accessToken = "test_token"
+
+	roomID, err := client.CreateRoom("muxbee alerts", "Bridge alerts")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if roomID != "!alerts:localhost" {
+		t.Errorf("expected room_id !alerts:localhost, got %s", roomID)
+	}
+}
+
 func TestSendMessage_Success(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/") {
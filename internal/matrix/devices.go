@@ -0,0 +1,153 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// deleteDevicesBatch is how many devices DeleteDevices removes per request
+const deleteDevicesBatch = 100
+
+// Device is one of the user's logged-in devices
+type Device struct {
+	DeviceID    string `json:"device_id" yaml:"device_id"`
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	LastSeenIP  string `json:"last_seen_ip,omitempty" yaml:"last_seen_ip,omitempty"`
+	LastSeenTS  int64  `json:"last_seen_ts,omitempty" yaml:"last_seen_ts,omitempty"`
+}
+
+// LastSeen returns when the device was last used, or the zero time if
+// the homeserver doesn't know
+func (d Device) LastSeen() time.Time {
+	if d.LastSeenTS == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(d.LastSeenTS)
+}
+
+// Devices lists the user's devices
+func (c *Client) Devices() ([]Device, error) {
+	req, err := http.NewRequest("GET", c.homeserverURL+"/_matrix/client/v3/devices", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError("list devices", resp)
+	}
+
+	var result struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Devices, nil
+}
+
+// DeleteDevices logs out and removes devices. The homeserver asks for the
+// user's password to confirm.
+func (c *Client) DeleteDevices(deviceIDs []string, password string) error {
+	for start := 0; start < len(deviceIDs); start += deleteDevicesBatch {
+		end := min(start+deleteDevicesBatch, len(deviceIDs))
+		if err := c.deleteDevices(deviceIDs[start:end], password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDevices makes one delete_devices request, answering the
+// homeserver's request for the password
+func (c *Client) deleteDevices(deviceIDs []string, password string) error {
+	payload := map[string]interface{}{"devices": deviceIDs}
+	resp, err := c.postJSON("/_matrix/client/v3/delete_devices", payload)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == 200 {
+		resp.Body.Close()
+		return nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		defer resp.Body.Close()
+		return newAPIError("delete devices", resp)
+	}
+
+	// User-interactive auth: repeat the request with the password
+	var challenge struct {
+		Session string `json:"session"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&challenge)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read auth challenge: %w", err)
+	}
+
+	payload["auth"] = map[string]interface{}{
+		"type": "m.login.password",
+		"identifier": map[string]string{
+			"type": "m.id.user",
+			"user": c.userID,
+		},
+		"password": password,
+		"session":  challenge.Session,
+	}
+	resp, err = c.postJSON("/_matrix/client/v3/delete_devices", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return newAPIError("delete devices", resp)
+	}
+	return nil
+}
+
+// postJSON POSTs payload as the user. The caller closes the response body.
+func (c *Client) postJSON(path string, payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.homeserverURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.httpClient.Do(req)
+}
+
+// StaleDevices picks the devices to prune, never the one the client uses.
+// Unless all is set, only devices muxbee logged in as are picked: those
+// named after it, and the unnamed ones older muxbee versions created.
+// Devices seen since the cutoff are kept; a zero cutoff keeps none.
+func StaleDevices(devices []Device, currentID string, all bool, seenAfter time.Time) []Device {
+	var stale []Device
+	for _, d := range devices {
+		if d.DeviceID == currentID {
+			continue
+		}
+		if !all && d.DisplayName != "" && d.DisplayName != DeviceDisplayName {
+			continue
+		}
+		if !seenAfter.IsZero() && d.LastSeen().After(seenAfter) {
+			continue
+		}
+		stale = append(stale, d)
+	}
+	return stale
+}
@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a room event, or an account data or to-device event from /sync
+type Event struct {
+	EventID   string         `json:"event_id"`
+	Type      string         `json:"type"`
+	Sender    string         `json:"sender"`
+	RoomID    string         `json:"room_id,omitempty"`   // Filled in by Syncer.Events for room events
+	StateKey  *string        `json:"state_key,omitempty"` // Set for state events
+	Timestamp int64          `json:"origin_server_ts,omitempty"`
+	Content   MessageContent `json:"content"`
+
+	// RawContent is the content as sent, for events other than messages
+	RawContent json.RawMessage `json:"-"`
+}
+
+// MessageContent is the content of an m.room.message event
+type MessageContent struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+	URL     string `json:"url,omitempty"` // mxc:// URL of an image or file
+
+	// Set on edits: the replacement content and the event it replaces
+	NewContent *MessageContent `json:"m.new_content,omitempty"`
+	RelatesTo  *struct {
+		RelType string `json:"rel_type"`
+		EventID string `json:"event_id"`
+	} `json:"m.relates_to,omitempty"`
+}
+
+// MemberContent is the content of an m.room.member event
+type MemberContent struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname,omitempty"`
+}
+
+// UnmarshalJSON keeps the raw content next to the message fields. Content
+// that doesn't fit MessageContent leaves it empty rather than failing.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	var raw struct {
+		event
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*e = Event(raw.event)
+	e.RawContent = raw.Content
+	if len(raw.Content) > 0 {
+		json.Unmarshal(raw.Content, &e.Content)
+	}
+	return nil
+}
+
+// DecodeContent decodes the event's content into v
+func (e Event) DecodeContent(v any) error {
+	if len(e.RawContent) == 0 {
+		return json.Unmarshal([]byte("{}"), v)
+	}
+	return json.Unmarshal(e.RawContent, v)
+}
+
+// IsState reports whether the event is a state event
+func (e Event) IsState() bool {
+	return e.StateKey != nil
+}
+
+// Time returns when the event was sent, by the sender's homeserver clock
+func (e Event) Time() time.Time {
+	return time.UnixMilli(e.Timestamp)
+}
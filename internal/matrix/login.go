@@ -0,0 +1,188 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// LoginResult is where a login conversation with a bridge bot stands
+type LoginResult int
+
+const (
+	LoginPending LoginResult = iota
+	LoginSucceeded
+	LoginFailed
+)
+
+// loginSyncTimeout is how long each /sync waits for the bot
+const loginSyncTimeout = 20 * time.Second
+
+// loginFailedPhrases and loginSucceededPhrases match what mautrix bridge
+// bots reply when a login ends. Failures are checked first, as they can
+// mention the login they failed at.
+var (
+	loginFailedPhrases    = []string{"login failed", "failed to log in", "failed to login", "login cancelled", "login canceled", "login timed out"}
+	loginSucceededPhrases = []string{"successfully logged in", "already logged in", "login successful"}
+)
+
+// promptPhrases mark a bot message that waits for an answer, like a phone
+// number or a verification code
+var promptPhrases = []string{"please enter", "enter your", "please send", "send your", "send the code", "reply with"}
+
+// BotMessage is a message from a bridge bot
+type BotMessage struct {
+	EventID  string
+	Body     string
+	Image    []byte // Set for images, like the bot's QR codes
+	Replaces string // Event ID this message edits, such as a refreshed QR code
+}
+
+// Result reports whether the message ends the login
+func (m BotMessage) Result() LoginResult {
+	text := strings.ToLower(m.Body)
+	for _, phrase := range loginFailedPhrases {
+		if strings.Contains(text, phrase) {
+			return LoginFailed
+		}
+	}
+	for _, phrase := range loginSucceededPhrases {
+		if strings.Contains(text, phrase) {
+			return LoginSucceeded
+		}
+	}
+	return LoginPending
+}
+
+// IsPrompt reports whether the bot waits for the user to answer
+func (m BotMessage) IsPrompt() bool {
+	if m.Image != nil || m.Result() != LoginPending {
+		return false
+	}
+	text := strings.ToLower(m.Body)
+	for _, phrase := range promptPhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	text = strings.TrimSpace(text)
+	return strings.HasSuffix(text, "?") || strings.HasSuffix(text, ":")
+}
+
+// IsSecret reports whether the bot asks for something that shouldn't be
+// echoed, like a password
+func (m BotMessage) IsSecret() bool {
+	return m.IsPrompt() && strings.Contains(strings.ToLower(m.Body), "password")
+}
+
+// errBotAnswered stops the sync loop once the bot has said something
+var errBotAnswered = errors.New("bot answered")
+
+// BridgeLogin is a login conversation with a bridge bot in its chat
+type BridgeLogin struct {
+	client    *Client
+	botUserID string
+	roomID    string
+	syncer    *Syncer
+}
+
+// StartBridgeLogin logs in as the admin, opens the chat with a bridge's
+// bot and sends it the login command
+func StartBridgeLogin(ctx context.Context, cfg *config.Config, bridge *bridges.BridgeInfo, command string) (*BridgeLogin, error) {
+	client, err := AdminClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login as admin: %w", err)
+	}
+
+	botUserID := fmt.Sprintf("@%s:%s", bridge.BotUsername(), cfg.ServerName)
+	return client.StartBotConversation(ctx, botUserID, command)
+}
+
+// StartBotConversation opens the chat with a bot, creating it if needed,
+// and sends it the first message. Only the bot's messages after that are
+// returned by Next.
+func (c *Client) StartBotConversation(ctx context.Context, botUserID, message string) (*BridgeLogin, error) {
+	roomID, _, err := c.GetOrCreateDirectMessage(botUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat with %s: %w", botUserID, err)
+	}
+
+	// Start following the room from here, so older replies aren't mistaken
+	// for answers
+	resp, err := c.Sync(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	syncer := NewSyncer(c, resp.NextBatch)
+	syncer.Timeout = loginSyncTimeout
+	l := &BridgeLogin{client: c, botUserID: botUserID, roomID: roomID, syncer: syncer}
+	if err := l.Send(message); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// BotUserID returns the bot's Matrix ID
+func (l *BridgeLogin) BotUserID() string {
+	return l.botUserID
+}
+
+// Send sends a message to the bot
+func (l *BridgeLogin) Send(text string) error {
+	return l.client.SendMessage(l.roomID, text)
+}
+
+// Next waits for the bot's next messages, with images downloaded
+func (l *BridgeLogin) Next(ctx context.Context) ([]BotMessage, error) {
+	var messages []BotMessage
+	err := l.syncer.Run(ctx, func(resp *SyncResponse) error {
+		for _, e := range resp.Rooms.Join[l.roomID].Timeline.Events {
+			if e.Sender != l.botUserID || e.Type != "m.room.message" {
+				continue
+			}
+			msg, err := l.botMessage(e)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+		}
+		if len(messages) > 0 {
+			return errBotAnswered
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errBotAnswered):
+		return messages, nil
+	case err != nil:
+		return nil, err
+	}
+	return nil, ctx.Err()
+}
+
+// botMessage converts an event from the bot, using the new content of edits
+func (l *BridgeLogin) botMessage(e Event) (BotMessage, error) {
+	content := e.Content
+	msg := BotMessage{EventID: e.EventID}
+	if content.NewContent != nil && content.RelatesTo != nil && content.RelatesTo.RelType == "m.replace" {
+		msg.Replaces = content.RelatesTo.EventID
+		content = *content.NewContent
+	}
+	msg.Body = content.Body
+
+	if content.MsgType == "m.image" && content.URL != "" {
+		image, err := l.client.DownloadMedia(content.URL)
+		if err != nil {
+			return msg, err
+		}
+		msg.Image = image
+	}
+	return msg, nil
+}
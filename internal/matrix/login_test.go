@@ -0,0 +1,177 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBotMessage_Result(t *testing.T) {
+	tests := []struct {
+		body string
+		want LoginResult
+	}{
+		{"Successfully logged in as +15551234567", LoginSucceeded},
+		{"You're already logged in", LoginSucceeded},
+		{"Login failed: entering code or scanning QR timed out", LoginFailed},
+		{"Failed to log in: invalid password", LoginFailed},
+		{"Login cancelled.", LoginFailed},
+		{"Scan the QR code on your phone to log in", LoginPending},
+	}
+
+	for _, tt := range tests {
+		if got := (BotMessage{Body: tt.body}).Result(); got != tt.want {
+			t.Errorf("Result(%q) = %d, want %d", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestBotMessage_IsPrompt(t *testing.T) {
+	tests := []struct {
+		msg    BotMessage
+		prompt bool
+		secret bool
+	}{
+		{BotMessage{Body: "Please enter your phone number"}, true, false},
+		{BotMessage{Body: "Login code sent to +1555. Please send the code here."}, true, false},
+		{BotMessage{Body: "Your account has two-factor authentication. Please send your password here."}, true, true},
+		{BotMessage{Body: "Phone number:"}, true, false},
+		{BotMessage{Body: "Scan the QR code on your phone to log in"}, false, false},
+		{BotMessage{Body: "Please enter your code", Image: []byte{1}}, false, false},
+		{BotMessage{Body: "Successfully logged in. Enter your next command"}, false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.msg.IsPrompt(); got != tt.prompt {
+			t.Errorf("IsPrompt(%q) = %v, want %v", tt.msg.Body, got, tt.prompt)
+		}
+		if got := tt.msg.IsSecret(); got != tt.secret {
+			t.Errorf("IsSecret(%q) = %v, want %v", tt.msg.Body, got, tt.secret)
+		}
+	}
+}
+
+func TestBridgeLogin(t *testing.T) {
+	bot := "@whatsappbot:localhost"
+	var sent []string
+	syncs := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/joined_rooms":
+			json.NewEncoder(w).Encode(map[string][]string{"joined_rooms": {"!dm:localhost"}})
+		case strings.HasSuffix(r.URL.Path, "/members"):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"chunk": []map[string]string{{"state_key": "@admin:localhost"}, {"state_key": bot}},
+			})
+		case strings.Contains(r.URL.Path, "/send/m.room.message/"):
+			var content MessageContent
+			json.NewDecoder(r.Body).Decode(&content)
+			sent = append(sent, content.Body)
+			json.NewEncoder(w).Encode(map[string]string{"event_id": "$sent"})
+		case r.URL.Path == "/_matrix/client/v1/media/download/localhost/qr1":
+			w.Write([]byte("png"))
+		case r.URL.Path == "/_matrix/client/v3/sync":
+			syncs++
+			since := r.URL.Query().Get("since")
+			var events []map[string]interface{}
+			switch syncs {
+			case 1:
+				if since != "" || r.URL.Query().Get("filter") == "" {
+					t.Errorf("expected a filtered initial sync, got %s", r.URL.RawQuery)
+				}
+			case 2:
+				if since != "s1" {
+					t.Errorf("expected since=s1, got %q", since)
+				}
+				// Nothing from the bot yet
+				events = []map[string]interface{}{
+					{"event_id": "$mine", "type": "m.room.message", "sender": "@admin:localhost", "content": map[string]string{"msgtype": "m.text", "body": "login qr"}},
+				}
+			case 3:
+				events = []map[string]interface{}{
+					{"event_id": "$qr", "type": "m.room.message", "sender": bot, "content": map[string]interface{}{
+						"msgtype": "m.notice",
+						"body":    "* qr data",
+						"m.new_content": map[string]string{
+							"msgtype": "m.image", "body": "qr data", "url": "mxc://localhost/qr1",
+						},
+						"m.relates_to": map[string]string{"rel_type": "m.replace", "event_id": "$first"},
+					}},
+				}
+			default:
+				events = []map[string]interface{}{
+					{"event_id": "$ok", "type": "m.room.message", "sender": bot, "content": map[string]string{"msgtype": "m.notice", "body": "Successfully logged in as +1555"}},
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"next_batch": "s" + string(rune('0'+syncs)),
+				"rooms": map[string]interface{}{"join": map[string]interface{}{
+					"!dm:localhost": map[string]interface{}{"timeline": map[string]interface{}{"events": events}},
+				}},
+			})
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	ctx := context.Background()
+	login, err := client.StartBotConversation(ctx, bot, "login qr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 1 || sent[0] != "login qr" {
+		t.Errorf("expected the login command to be sent, got %v", sent)
+	}
+
+	messages, err := login.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	qr := messages[0]
+	if qr.Replaces != "$first" || qr.Body != "qr data" || string(qr.Image) != "png" {
+		t.Errorf("expected the edited QR code, got %+v", qr)
+	}
+
+	messages, err = login.Next(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Result() != LoginSucceeded {
+		t.Errorf("expected the login to succeed, got %+v", messages)
+	}
+}
+
+func TestDownloadMedia_Fallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/media/v3/download/localhost/abc":
+			w.Write([]byte("image"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode": "M_UNRECOGNIZED"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	data, err := client.DownloadMedia("mxc://localhost/abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "image" {
+		t.Errorf("expected the legacy media API to be used, got %q", data)
+	}
+
+	if _, err := client.DownloadMedia("https://example.com/abc"); err == nil {
+		t.Error("expected an error for a URL that is not mxc://")
+	}
+}
@@ -0,0 +1,62 @@
+package matrix
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxMediaSize caps what DownloadMedia reads. Bridge bots only send small
+// images such as QR codes.
+const maxMediaSize = 10 << 20
+
+// DownloadMedia fetches the content of an mxc:// URL. It uses the
+// authenticated media API and falls back to the legacy one for homeservers
+// that predate it.
+func (c *Client) DownloadMedia(mxcURL string) ([]byte, error) {
+	server, mediaID, err := ParseMXC(mxcURL)
+	if err != nil {
+		return nil, err
+	}
+
+	serverAndID := server + "/" + mediaID
+	data, status, err := c.download("/_matrix/client/v1/media/download/" + serverAndID)
+	if status == http.StatusNotFound {
+		data, _, err = c.download("/_matrix/media/v3/download/" + serverAndID)
+	}
+	return data, err
+}
+
+// ParseMXC splits an mxc://server/media-id URL
+func ParseMXC(mxcURL string) (server, mediaID string, err error) {
+	serverAndID, ok := strings.CutPrefix(mxcURL, "mxc://")
+	server, mediaID, found := strings.Cut(serverAndID, "/")
+	if !ok || !found || server == "" || mediaID == "" {
+		return "", "", fmt.Errorf("invalid media URL: %s", mxcURL)
+	}
+	return server, mediaID, nil
+}
+
+// download fetches media from path and returns the response status
+func (c *Client) download(path string) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", c.homeserverURL+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode, fmt.Errorf("download media failed: %s", string(respBody))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize))
+	return data, resp.StatusCode, err
+}
@@ -0,0 +1,95 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LoginState is a bridge's remote login as reported by its bot
+type LoginState string
+
+const (
+	LoginUnknown LoginState = "unknown"
+	LoggedIn     LoginState = "logged_in"
+	LoggedOut    LoginState = "logged_out"
+)
+
+// ErrNoBotRoom is returned when there is no chat with a bridge bot to ping in
+var ErrNoBotRoom = errors.New("no chat with the bridge bot")
+
+// pingPollInterval is how often PingBot checks for the bot's reply
+const pingPollInterval = 500 * time.Millisecond
+
+// loggedOutPhrases and loggedInPhrases match the replies mautrix bridges
+// give to "ping". Logged-out phrases are checked first because they often
+// contain the logged-in ones ("not logged in").
+var (
+	loggedOutPhrases = []string{"not logged in", "no logins", "logged out", "not connected", "disconnected"}
+	loggedInPhrases  = []string{"logged in", "connected"}
+)
+
+// ParseLoginState interprets a bridge bot's reply to "ping"
+func ParseLoginState(reply string) LoginState {
+	text := strings.ToLower(reply)
+	for _, phrase := range loggedOutPhrases {
+		if strings.Contains(text, phrase) {
+			return LoggedOut
+		}
+	}
+	for _, phrase := range loggedInPhrases {
+		if strings.Contains(text, phrase) {
+			return LoggedIn
+		}
+	}
+	return LoginUnknown
+}
+
+// PingBot sends "ping" to a bridge bot in the existing chat with it and
+// returns the bot's reply. No room is created; without one, ErrNoBotRoom
+// is returned.
+func (c *Client) PingBot(botUserID string, timeout time.Duration) (string, error) {
+	roomID, err := c.FindDirectMessageRoom(botUserID)
+	if err != nil {
+		return "", err
+	}
+	if roomID == "" {
+		return "", ErrNoBotRoom
+	}
+
+	pingID, err := c.sendText(roomID, "ping")
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(pingPollInterval)
+
+		events, err := c.RoomMessages(roomID, 20)
+		if err != nil {
+			return "", err
+		}
+		if reply, ok := replyAfter(events, pingID, botUserID); ok {
+			return reply, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s did not answer within %s", botUserID, timeout)
+}
+
+// replyAfter finds the first message from sender that is newer than the
+// event with the given ID. Events are ordered newest first.
+func replyAfter(events []Event, eventID, sender string) (string, bool) {
+	reply, found := "", false
+	for _, e := range events {
+		if e.EventID == eventID {
+			return reply, found
+		}
+		if e.Sender == sender && e.Type == "m.room.message" {
+			reply, found = e.Content.Body, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLoginState(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  LoginState
+	}{
+		{"You're logged in as +15551234567", LoggedIn},
+		{"Logged in as @alice, connection to WhatsApp OK", LoggedIn},
+		{"You're not logged in.", LoggedOut},
+		{"You're not logged into Telegram", LoggedOut},
+		{"Disconnected from Signal", LoggedOut},
+		{"Unknown command, use the `help` command for help.", LoginUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := ParseLoginState(tt.reply); got != tt.want {
+			t.Errorf("ParseLoginState(%q) = %s, want %s", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestReplyAfter(t *testing.T) {
+	bot := "@whatsappbot:localhost"
+	msg := func(id, sender, body string) Event {
+		e := Event{EventID: id, Type: "m.room.message", Sender: sender}
+		e.Content.Body = body
+		return e
+	}
+
+	// Newest first: the old reply before our ping must not count
+	events := []Event{
+		msg("$3", bot, "You're logged in"),
+		msg("$2", "@admin:localhost", "ping"),
+		msg("$1", bot, "You're not logged in"),
+	}
+	reply, ok := replyAfter(events, "$2", bot)
+	if !ok || reply != "You're logged in" {
+		t.Errorf("expected the reply after the ping, got %q (found=%v)", reply, ok)
+	}
+
+	if _, ok := replyAfter(events[1:], "$2", bot); ok {
+		t.Error("expected no reply when the bot has not answered yet")
+	}
+}
+
+func TestPingBot(t *testing.T) {
+	bot := "@signalbot:localhost"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/joined_rooms":
+			json.NewEncoder(w).Encode(map[string][]string{"joined_rooms": {"!dm:localhost"}})
+		case strings.HasSuffix(r.URL.Path, "/members"):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"chunk": []map[string]string{{"state_key": "@admin:localhost"}, {"state_key": bot}},
+			})
+		case strings.Contains(r.URL.Path, "/send/m.room.message/"):
+			json.NewEncoder(w).Encode(map[string]string{"event_id": "$ping"})
+		case strings.HasSuffix(r.URL.Path, "/messages"):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"chunk": []map[string]interface{}{
+					{"event_id": "$pong", "type": "m.room.message", "sender": bot, "content": map[string]string{"msgtype": "m.notice", "body": "You're logged in as +1555"}},
+					{"event_id": "$ping", "type": "m.room.message", "sender": "@admin:localhost", "content": map[string]string{"msgtype": "m.text", "body": "ping"}},
+				},
+			})
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.accessToken = "test_token"
+
+	reply, err := client.PingBot(bot, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ParseLoginState(reply) != LoggedIn {
+		t.Errorf("expected a logged-in reply, got %q", reply)
+	}
+}
+
+func TestPingBot_NoRoom(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"joined_rooms": {}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.accessToken = "test_token"
+
+	if _, err := client.PingBot("@signalbot:localhost", time.Second); err != ErrNoBotRoom {
+		t.Errorf("expected ErrNoBotRoom, got %v", err)
+	}
+}
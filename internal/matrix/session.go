@@ -0,0 +1,121 @@
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tobocop2/muxbee/internal/config"
+)
+
+// Session is a saved login: the access token of one device
+type Session struct {
+	UserID      string `json:"user_id"`
+	DeviceID    string `json:"device_id"`
+	AccessToken string `json:"access_token"`
+}
+
+// LoadSession reads a saved session
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the session, readable only by the user. It replaces the file
+// in one step so other muxbee processes never read half of it.
+func (s Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".admin_session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Session returns the client's login, to save and reuse later
+func (c *Client) Session() Session {
+	return Session{UserID: c.userID, DeviceID: c.deviceID, AccessToken: c.accessToken}
+}
+
+// UseSession makes the client act with a saved login
+func (c *Client) UseSession(s Session) {
+	c.userID = s.UserID
+	c.deviceID = s.DeviceID
+	c.accessToken = s.AccessToken
+}
+
+// DeviceID returns the device the client is logged in as
+func (c *Client) DeviceID() string {
+	return c.deviceID
+}
+
+// AdminClient returns a client logged in as the admin user. It reuses the
+// session saved in the data directory and logs in again, as the same
+// device, only when the homeserver no longer accepts its token, so muxbee
+// doesn't leave a new device behind on every action.
+func AdminClient(cfg *config.Config) (*Client, error) {
+	return AdminClientAt(fmt.Sprintf("http://localhost:%d", cfg.SynapsePort()), cfg)
+}
+
+// AdminClientAt is AdminClient for a homeserver at another address
+func AdminClientAt(homeserverURL string, cfg *config.Config) (*Client, error) {
+	client := NewClient(homeserverURL)
+	if err := client.resumeSession(config.AdminSessionPath(), cfg.Admin.Username, cfg.ServerName, cfg.Admin.Password); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// resumeSession uses the session saved at path if it still works, and
+// logs in otherwise
+func (c *Client) resumeSession(path, username, serverName, password string) error {
+	deviceID := ""
+	saved, err := LoadSession(path)
+	if err == nil && saved.UserID == fmt.Sprintf("@%s:%s", username, serverName) {
+		c.UseSession(*saved)
+		_, _, err := c.WhoAmI()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrUnknownToken) {
+			return err
+		}
+		deviceID = saved.DeviceID
+	}
+
+	if err := c.LoginDevice(username, password, deviceID); err != nil {
+		return err
+	}
+	if err := c.Session().Save(path); err != nil {
+		return fmt.Errorf("failed to save admin session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sessionServer is a homeserver with one valid access token
+type sessionServer struct {
+	*httptest.Server
+	token   string
+	logins  []map[string]interface{}
+	deleted [][]string
+}
+
+func newSessionServer(t *testing.T, token string) *sessionServer {
+	s := &sessionServer{token: token}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/_matrix/client/v3/login" {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			s.logins = append(s.logins, payload)
+			deviceID, _ := payload["device_id"].(string)
+			if deviceID == "" {
+				deviceID = "NEWDEVICE"
+			}
+			s.token = "fresh_token"
+			json.NewEncoder(w).Encode(map[string]string{
+				"access_token": s.token, "user_id": "@admin:localhost", "device_id": deviceID,
+			})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token passed."}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/_matrix/client/v3/account/whoami":
+			json.NewEncoder(w).Encode(map[string]string{"user_id": "@admin:localhost", "device_id": "MUXBEE"})
+		case "/_matrix/client/v3/devices":
+			json.NewEncoder(w).Encode(map[string]interface{}{"devices": []map[string]interface{}{
+				{"device_id": "MUXBEE", "display_name": "muxbee"},
+				{"device_id": "OLD1", "last_seen_ts": 1600000000000},
+				{"device_id": "WEB", "display_name": "app.element.io: Firefox on Linux"},
+			}})
+		case "/_matrix/client/v3/delete_devices":
+			var payload struct {
+				Devices []string `json:"devices"`
+				Auth    *struct {
+					Type     string `json:"type"`
+					Password string `json:"password"`
+					Session  string `json:"session"`
+				} `json:"auth"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload.Auth == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"session": "uia1", "flows": [{"stages": ["m.login.password"]}]}`))
+				return
+			}
+			if payload.Auth.Session != "uia1" || payload.Auth.Password != "secret" {
+				t.Errorf("unexpected auth: %+v", payload.Auth)
+			}
+			s.deleted = append(s.deleted, payload.Devices)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	return s
+}
+
+func TestSession_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "muxbee", "admin_session.json")
+	s := Session{UserID: "@admin:localhost", DeviceID: "MUXBEE", AccessToken: "token"}
+	if err := s.Save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	loaded, err := LoadSession(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *loaded != s {
+		t.Errorf("expected %+v, got %+v", s, *loaded)
+	}
+}
+
+func TestResumeSession_ReusesToken(t *testing.T) {
+	server := newSessionServer(t, "saved_token")
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "admin_session.json")
+	Session{UserID: "@admin:localhost", DeviceID: "MUXBEE", AccessToken: "saved_token"}.Save(path)
+
+	client := NewClient(server.URL)
+	if err := client.resumeSession(path, "admin", "localhost", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.logins) != 0 {
+		t.Errorf("expected no login with a working token, got %d", len(server.logins))
+	}
+	if client.DeviceID() != "MUXBEE" {
+		t.Errorf("expected device MUXBEE, got %s", client.DeviceID())
+	}
+}
+
+func TestResumeSession_UnknownToken(t *testing.T) {
+	server := newSessionServer(t, "other_token")
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "admin_session.json")
+	Session{UserID: "@admin:localhost", DeviceID: "MUXBEE", AccessToken: "revoked_token"}.Save(path)
+
+	client := NewClient(server.URL)
+	if err := client.resumeSession(path, "admin", "localhost", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.logins) != 1 || server.logins[0]["device_id"] != "MUXBEE" {
+		t.Fatalf("expected one login as the saved device, got %v", server.logins)
+	}
+	if server.logins[0]["initial_device_display_name"] != DeviceDisplayName {
+		t.Errorf("expected the device to be named %s, got %v", DeviceDisplayName, server.logins[0]["initial_device_display_name"])
+	}
+
+	saved, err := LoadSession(path)
+	if err != nil || saved.AccessToken != "fresh_token" || saved.DeviceID != "MUXBEE" {
+		t.Errorf("expected the new token to be saved, got %+v (%v)", saved, err)
+	}
+}
+
+func TestResumeSession_NoSession(t *testing.T) {
+	server := newSessionServer(t, "")
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "admin_session.json")
+
+	client := NewClient(server.URL)
+	if err := client.resumeSession(path, "admin", "localhost", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.logins) != 1 || server.logins[0]["device_id"] != nil {
+		t.Fatalf("expected a login as a new device, got %v", server.logins)
+	}
+	if saved, err := LoadSession(path); err != nil || saved.DeviceID != "NEWDEVICE" {
+		t.Errorf("expected the new device to be saved, got %+v (%v)", saved, err)
+	}
+
+	// A session for another user is not reused
+	Session{UserID: "@someone:localhost", DeviceID: "X", AccessToken: "fresh_token"}.Save(path)
+	if err := client.resumeSession(path, "admin", "localhost", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.logins) != 2 {
+		t.Errorf("expected a second login, got %d", len(server.logins))
+	}
+}
+
+func TestDevices_Prune(t *testing.T) {
+	server := newSessionServer(t, "token")
+	defer server.Close()
+	client := NewClient(server.URL)
+	client.UseSession(Session{UserID: "@admin:localhost", DeviceID: "MUXBEE", AccessToken: "token"})
+
+	devices, err := client.Devices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices, got %d", len(devices))
+	}
+
+	stale := StaleDevices(devices, client.DeviceID(), false, time.Time{})
+	if len(stale) != 1 || stale[0].DeviceID != "OLD1" {
+		t.Errorf("expected only the unnamed device, got %+v", stale)
+	}
+	if all := StaleDevices(devices, client.DeviceID(), true, time.Time{}); len(all) != 2 {
+		t.Errorf("expected every other device with all, got %+v", all)
+	}
+	if recent := StaleDevices(devices, client.DeviceID(), true, time.UnixMilli(1500000000000)); len(recent) != 1 || recent[0].DeviceID != "WEB" {
+		t.Errorf("expected devices seen since the cutoff to be kept, got %+v", recent)
+	}
+
+	if err := client.DeleteDevices([]string{"OLD1"}, "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.deleted) != 1 || server.deleted[0][0] != "OLD1" {
+		t.Errorf("expected OLD1 to be deleted, got %v", server.deleted)
+	}
+}
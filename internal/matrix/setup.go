@@ -1,6 +1,7 @@
 package matrix
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/tobocop2/muxbee/internal/bridges"
@@ -217,53 +218,54 @@ Commands:
 📖 Docs: https://github.com/mautrix/linkedin`,
 }
 
-// SetupBotsForUser creates DM rooms with all enabled bridge bots and sends welcome messages
-func SetupBotsForUser(cfg *config.Config) error {
+// SetupBotsForUser creates DM rooms between a local user and all enabled
+// bridge bots and sends welcome messages
+func SetupBotsForUser(cfg *config.Config, username string) error {
 	if len(cfg.EnabledBridges) == 0 {
 		return nil
 	}
 
-	// Connect to homeserver
-	client := NewClient(fmt.Sprintf("http://localhost:%d", cfg.SynapsePort()))
-
-	// Login as admin
-	if err := client.Login(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+	// Connect to homeserver as admin
+	admin, err := AdminClient(cfg)
+	if err != nil {
 		return fmt.Errorf("failed to login as admin: %w", err)
 	}
 
-	// Create DM with each enabled bot
-	for _, bridgeName := range cfg.EnabledBridges {
-		bridge := bridges.Get(bridgeName)
-		if bridge == nil {
-			continue
-		}
+	return asUser(admin, cfg, username, func(client *Client) error {
+		// Create DM with each enabled bot
+		for _, bridgeName := range cfg.EnabledBridges {
+			bridge := bridges.Get(bridgeName)
+			if bridge == nil {
+				continue
+			}
 
-		botUserID := fmt.Sprintf("@%s:%s", bridge.BotUsername(), cfg.ServerName)
+			botUserID := fmt.Sprintf("@%s:%s", bridge.BotUsername(), cfg.ServerName)
 
-		// Get or create DM room
-		roomID, isNew, err := client.GetOrCreateDirectMessage(botUserID)
-		if err != nil {
-			fmt.Printf("  Note: Could not create room with %s: %v\n", bridge.BotUsername(), err)
-			continue
-		}
+			// Get or create DM room
+			roomID, isNew, err := client.GetOrCreateDirectMessage(botUserID)
+			if err != nil {
+				fmt.Printf("  Note: Could not create room with %s: %v\n", bridge.BotUsername(), err)
+				continue
+			}
 
-		// Send welcome message if we have one (only for new rooms to avoid spam)
-		if isNew {
-			if welcomeMsg, ok := BotWelcomeMessages[bridgeName]; ok {
-				if err := client.SendMessage(roomID, welcomeMsg); err != nil {
-					fmt.Printf("  Note: Could not send welcome message to %s\n", bridgeName)
+			// Send welcome message if we have one (only for new rooms to avoid spam)
+			if isNew {
+				if welcomeMsg, ok := BotWelcomeMessages[bridgeName]; ok {
+					if err := client.SendMessage(roomID, welcomeMsg); err != nil {
+						fmt.Printf("  Note: Could not send welcome message to %s\n", bridgeName)
+					}
 				}
+				fmt.Printf("  ✓ Created chat with %s bot\n", bridgeName)
+			} else {
+				fmt.Printf("  ✓ Found existing chat with %s bot\n", bridgeName)
 			}
-			fmt.Printf("  ✓ Created chat with %s bot\n", bridgeName)
-		} else {
-			fmt.Printf("  ✓ Found existing chat with %s bot\n", bridgeName)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
-// SetupBotForBridge creates a DM room with a specific bridge bot and sends a welcome message.
+// SetupBotForBridge creates a DM room between each local user and a specific
+// bridge bot and sends a welcome message.
 // This is called automatically when a bridge is enabled via the TUI.
 // Returns nil on success or error. Errors are non-fatal - the bridge still works,
 // the user just needs to find the bot manually.
@@ -273,34 +275,41 @@ func SetupBotForBridge(cfg *config.Config, bridgeName string) error {
 		return fmt.Errorf("unknown bridge: %s", bridgeName)
 	}
 
-	// Connect to homeserver
-	client := NewClient(fmt.Sprintf("http://localhost:%d", cfg.SynapsePort()))
-
-	// Login as admin
-	if err := client.Login(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+	// Connect to homeserver as admin
+	admin, err := AdminClient(cfg)
+	if err != nil {
 		return fmt.Errorf("failed to login: %w", err)
 	}
 
 	botUserID := fmt.Sprintf("@%s:%s", bridge.BotUsername(), cfg.ServerName)
 
-	// Get or create DM room
-	roomID, isNew, err := client.GetOrCreateDirectMessage(botUserID)
-	if err != nil {
-		return fmt.Errorf("could not create room with %s: %w", bridge.BotUsername(), err)
-	}
+	var errs []error
+	for _, username := range cfg.Usernames() {
+		err := asUser(admin, cfg, username, func(client *Client) error {
+			// Get or create DM room
+			roomID, isNew, err := client.GetOrCreateDirectMessage(botUserID)
+			if err != nil {
+				return fmt.Errorf("could not create room with %s: %w", bridge.BotUsername(), err)
+			}
 
-	// Send welcome message if we have one (only for new rooms to avoid spam)
-	if isNew {
-		if welcomeMsg, ok := BotWelcomeMessages[bridgeName]; ok {
-			// Ignore error - welcome message is nice-to-have
-			client.SendMessage(roomID, welcomeMsg)
+			// Send welcome message if we have one (only for new rooms to avoid spam)
+			if isNew {
+				if welcomeMsg, ok := BotWelcomeMessages[bridgeName]; ok {
+					// Ignore error - welcome message is nice-to-have
+					client.SendMessage(roomID, welcomeMsg)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", username, err))
 		}
 	}
-
-	return nil
+	return errors.Join(errs...)
 }
 
-// CleanupBotForBridge leaves and forgets the DM room with a bridge bot.
+// CleanupBotForBridge makes each local user leave and forget their DM room
+// with a bridge bot.
 // This is called automatically when a bridge is disabled via the TUI.
 // Returns nil on success or error. Errors are non-fatal.
 func CleanupBotForBridge(cfg *config.Config, bridgeName string) error {
@@ -309,23 +318,46 @@ func CleanupBotForBridge(cfg *config.Config, bridgeName string) error {
 		return fmt.Errorf("unknown bridge: %s", bridgeName)
 	}
 
-	// Connect to homeserver
-	client := NewClient(fmt.Sprintf("http://localhost:%d", cfg.SynapsePort()))
-
-	// Login as admin
-	if err := client.Login(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+	// Connect to homeserver as admin
+	admin, err := AdminClient(cfg)
+	if err != nil {
 		return fmt.Errorf("failed to login: %w", err)
 	}
 
 	botUserID := fmt.Sprintf("@%s:%s", bridge.BotUsername(), cfg.ServerName)
 
-	// Find the DM room
-	roomID, err := client.FindDirectMessageRoom(botUserID)
-	if err != nil || roomID == "" {
-		// No room to leave
-		return nil
+	var errs []error
+	for _, username := range cfg.Usernames() {
+		err := asUser(admin, cfg, username, func(client *Client) error {
+			// Find the DM room
+			roomID, err := client.FindDirectMessageRoom(botUserID)
+			if err != nil || roomID == "" {
+				// No room to leave
+				return nil
+			}
+
+			// Leave and forget the room
+			return client.LeaveRoom(roomID)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", username, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Leave and forget the room
-	return client.LeaveRoom(roomID)
+// asUser runs fn with a client acting as a local user. The admin uses its
+// own session; other users are logged in through the Admin API and logged
+// out again afterwards.
+func asUser(admin *Client, cfg *config.Config, username string, fn func(*Client) error) error {
+	if username == cfg.Admin.Username {
+		return fn(admin)
+	}
+
+	client, err := admin.LoginAsUser(cfg.UserID(username))
+	if err != nil {
+		return fmt.Errorf("failed to login as %s: %w", username, err)
+	}
+	defer client.Logout()
+	return fn(client)
 }
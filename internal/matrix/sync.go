@@ -0,0 +1,283 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// initialSyncFilter keeps a sync without a since token small: muxbee only
+// needs its batch token to follow new events from there
+const initialSyncFilter = `{"room":{"timeline":{"limit":1}},"presence":{"not_types":["*"]}}`
+
+// Sync loop defaults
+const (
+	defaultSyncTimeout = 20 * time.Second
+	syncBackoffMin     = time.Second
+	syncBackoffMax     = time.Minute
+)
+
+// SyncResponse is a /sync response
+type SyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join   map[string]JoinedRoom  `json:"join"`
+		Invite map[string]InvitedRoom `json:"invite"`
+		Leave  map[string]LeftRoom    `json:"leave"`
+	} `json:"rooms"`
+	AccountData EventList `json:"account_data"`
+	ToDevice    EventList `json:"to_device"`
+}
+
+// EventList is a list of events in a /sync response
+type EventList struct {
+	Events []Event `json:"events"`
+}
+
+// Timeline is the new events of a room, oldest first. Limited means there
+// were more than the sync returned; PrevBatch pages back to them.
+type Timeline struct {
+	Events    []Event `json:"events"`
+	Limited   bool    `json:"limited"`
+	PrevBatch string  `json:"prev_batch"`
+}
+
+// JoinedRoom is what changed in a room the user is in
+type JoinedRoom struct {
+	State       EventList `json:"state"`
+	Timeline    Timeline  `json:"timeline"`
+	Ephemeral   EventList `json:"ephemeral"`
+	AccountData EventList `json:"account_data"`
+}
+
+// InvitedRoom is a room the user is invited to, with the state the
+// inviter shared
+type InvitedRoom struct {
+	InviteState EventList `json:"invite_state"`
+}
+
+// LeftRoom is a room the user left or was removed from
+type LeftRoom struct {
+	State    EventList `json:"state"`
+	Timeline Timeline  `json:"timeline"`
+}
+
+// Sync returns the events since the given batch token, waiting up to
+// timeout for some to arrive. With an empty token it returns right away,
+// with only the latest event of each room. timeout must stay below the
+// client's 30 second request timeout.
+func (c *Client) Sync(ctx context.Context, since string, timeout time.Duration) (*SyncResponse, error) {
+	filter := ""
+	if since == "" {
+		filter = initialSyncFilter
+	}
+	return c.sync(ctx, since, filter, timeout)
+}
+
+func (c *Client) sync(ctx context.Context, since, filter string, timeout time.Duration) (*SyncResponse, error) {
+	query := url.Values{}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+	if since != "" {
+		query.Set("since", since)
+		query.Set("timeout", fmt.Sprintf("%d", timeout.Milliseconds()))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.homeserverURL+"/_matrix/client/v3/sync?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError("sync", resp)
+	}
+
+	var result SyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to read sync response: %w", err)
+	}
+	return &result, nil
+}
+
+// Syncer follows the user's events with a /sync loop. It keeps the since
+// token between syncs and retries failed syncs with backoff.
+type Syncer struct {
+	client *Client
+	since  string
+
+	// Filter is a JSON filter or the ID of an uploaded one. Without one,
+	// the first sync returns the full state of every room.
+	Filter string
+	// Timeout is how long each sync waits for new events
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait after a failed sync, which
+	// doubles with each failure in a row
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Logf, if set, is told about failed syncs that will be retried
+	Logf func(format string, args ...any)
+}
+
+// NewSyncer creates a sync loop that starts after the given batch token,
+// or from scratch when it is empty
+func NewSyncer(client *Client, since string) *Syncer {
+	return &Syncer{
+		client:     client,
+		since:      since,
+		Timeout:    defaultSyncTimeout,
+		MinBackoff: syncBackoffMin,
+		MaxBackoff: syncBackoffMax,
+	}
+}
+
+// Since returns the batch token the next sync starts from. Save it to
+// resume later without seeing events twice.
+func (s *Syncer) Since() string {
+	return s.since
+}
+
+// SyncOnce makes one sync and moves the since token past it
+func (s *Syncer) SyncOnce(ctx context.Context) (*SyncResponse, error) {
+	resp, err := s.client.sync(ctx, s.since, s.Filter, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	s.since = resp.NextBatch
+	return resp, nil
+}
+
+// Run syncs until ctx is done, passing every response to handle. It
+// returns nil when ctx is done, the error handle returns, or an error that
+// retrying won't fix, like an access token the homeserver no longer
+// accepts (errors.Is(err, ErrUnknownToken)).
+func (s *Syncer) Run(ctx context.Context, handle func(*SyncResponse) error) error {
+	backoff := time.Duration(0)
+	for {
+		resp, err := s.SyncOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if permanentSyncError(err) {
+				return err
+			}
+
+			backoff = s.nextBackoff(backoff, err)
+			if s.Logf != nil {
+				s.Logf("Sync failed, retrying in %s: %v", backoff, err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			continue
+		}
+
+		backoff = 0
+		if err := handle(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// Events runs the sync loop and streams its events until ctx is done:
+// to-device and account data events first, then for each room its state,
+// timeline and account data, with RoomID set. The error channel receives
+// at most one error, then both close.
+func (s *Syncer) Events(ctx context.Context) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(events)
+
+		err := s.Run(ctx, func(resp *SyncResponse) error {
+			for _, e := range resp.Flatten() {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs
+}
+
+// Flatten returns the response's events in the order Syncer.Events sends
+// them, with RoomID set on room events
+func (r *SyncResponse) Flatten() []Event {
+	var out []Event
+	out = append(out, r.ToDevice.Events...)
+	out = append(out, r.AccountData.Events...)
+
+	add := func(roomID string, lists ...[]Event) {
+		for _, list := range lists {
+			for _, e := range list {
+				e.RoomID = roomID
+				out = append(out, e)
+			}
+		}
+	}
+	for roomID, room := range r.Rooms.Join {
+		add(roomID, room.State.Events, room.Timeline.Events, room.Ephemeral.Events, room.AccountData.Events)
+	}
+	for roomID, room := range r.Rooms.Invite {
+		add(roomID, room.InviteState.Events)
+	}
+	for roomID, room := range r.Rooms.Leave {
+		add(roomID, room.State.Events, room.Timeline.Events)
+	}
+	return out
+}
+
+// nextBackoff doubles the wait after each failure, honouring the wait a
+// rate limited homeserver asks for
+func (s *Syncer) nextBackoff(current time.Duration, err error) time.Duration {
+	next := current * 2
+	if next < s.MinBackoff {
+		next = s.MinBackoff
+	}
+	if next > s.MaxBackoff {
+		next = s.MaxBackoff
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > next {
+		next = apiErr.RetryAfter
+	}
+	return next
+}
+
+// permanentSyncError reports whether retrying a sync can't help: the
+// access token is gone or the request itself is wrong
+func permanentSyncError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return apiErr.ErrCode == "M_BAD_JSON" || apiErr.ErrCode == "M_INVALID_PARAM"
+}
@@ -0,0 +1,257 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncServer is a Synapse stand-in that answers /sync with one canned
+// response per request, and records the since tokens it was asked for
+type syncServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	since     []string
+	filters   []string
+}
+
+func newSyncServer(t *testing.T, responses ...func(w http.ResponseWriter)) *syncServer {
+	s := &syncServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/sync" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer test_token" {
+			t.Errorf("expected Bearer test_token, got %s", r.Header.Get("Authorization"))
+		}
+
+		s.mu.Lock()
+		s.since = append(s.since, r.URL.Query().Get("since"))
+		s.filters = append(s.filters, r.URL.Query().Get("filter"))
+		if len(s.responses) == 0 {
+			s.mu.Unlock()
+			// Long-poll with nothing new until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		respond := s.responses[0]
+		s.responses = s.responses[1:]
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		respond(w)
+	}))
+	return s
+}
+
+func (s *syncServer) sinceTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.since...)
+}
+
+func syncBody(body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Write([]byte(body))
+	}
+}
+
+func syncError(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+const firstSync = `{
+	"next_batch": "b1",
+	"account_data": {"events": [{"type": "m.direct", "content": {"@signalbot:localhost": ["!dm:localhost"]}}]},
+	"to_device": {"events": [{"type": "m.room_key_request", "sender": "@admin:localhost", "content": {}}]},
+	"rooms": {
+		"join": {"!dm:localhost": {
+			"state": {"events": [{"event_id": "$m", "type": "m.room.member", "sender": "@signalbot:localhost", "state_key": "@signalbot:localhost", "content": {"membership": "join", "displayname": "Signal bridge bot"}}]},
+			"timeline": {"limited": true, "prev_batch": "p0", "events": [{"event_id": "$1", "type": "m.room.message", "sender": "@signalbot:localhost", "origin_server_ts": 1700000000000, "content": {"msgtype": "m.notice", "body": "Successfully logged in"}}]},
+			"account_data": {"events": [{"type": "m.fully_read", "content": {"event_id": "$1"}}]}
+		}},
+		"invite": {"!new:localhost": {"invite_state": {"events": [{"type": "m.room.name", "sender": "@whatsappbot:localhost", "state_key": "", "content": {"name": "WhatsApp"}}]}}}
+	}
+}`
+
+func newTestClient(url string) *Client {
+	client := NewClient(url)
+	client.accessToken = "test_token"
+	return client
+}
+
+func TestSyncer_Run(t *testing.T) {
+	server := newSyncServer(t,
+		syncBody(firstSync),
+		syncError(http.StatusBadGateway, "bad gateway"),
+		syncError(http.StatusTooManyRequests, `{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": 5}`),
+		syncBody(`{"next_batch": "b2"}`),
+	)
+	defer server.Close()
+
+	syncer := NewSyncer(newTestClient(server.URL), "")
+	syncer.Filter = `{"room":{"timeline":{"limit":10}}}`
+	syncer.MinBackoff = time.Millisecond
+	var retries int
+	syncer.Logf = func(format string, args ...any) { retries++ }
+
+	var responses []*SyncResponse
+	stop := errors.New("stop")
+	err := syncer.Run(context.Background(), func(resp *SyncResponse) error {
+		responses = append(responses, resp)
+		if len(responses) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+	if retries != 2 {
+		t.Errorf("expected 2 retried syncs, got %d", retries)
+	}
+
+	since := server.sinceTokens()
+	want := []string{"", "b1", "b1", "b1"}
+	if len(since) != len(want) {
+		t.Fatalf("expected since tokens %v, got %v", want, since)
+	}
+	for i := range want {
+		if since[i] != want[i] {
+			t.Errorf("sync %d: expected since %q, got %q", i, want[i], since[i])
+		}
+	}
+	if server.filters[0] != syncer.Filter {
+		t.Errorf("expected the filter to be sent, got %q", server.filters[0])
+	}
+	if syncer.Since() != "b2" {
+		t.Errorf("expected since to move to b2, got %q", syncer.Since())
+	}
+
+	resp := responses[0]
+	room := resp.Rooms.Join["!dm:localhost"]
+	if !room.Timeline.Limited || room.Timeline.PrevBatch != "p0" {
+		t.Errorf("expected a limited timeline, got %+v", room.Timeline)
+	}
+	if len(room.Timeline.Events) != 1 || room.Timeline.Events[0].Content.Body != "Successfully logged in" {
+		t.Errorf("unexpected timeline: %+v", room.Timeline.Events)
+	}
+	if got := room.Timeline.Events[0].Time(); !got.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("unexpected event time: %s", got)
+	}
+	if len(room.State.Events) != 1 || !room.State.Events[0].IsState() {
+		t.Errorf("expected a state event, got %+v", room.State.Events)
+	}
+	if len(room.AccountData.Events) != 1 || room.AccountData.Events[0].Type != "m.fully_read" {
+		t.Errorf("unexpected room account data: %+v", room.AccountData.Events)
+	}
+	if len(resp.AccountData.Events) != 1 || resp.AccountData.Events[0].Type != "m.direct" {
+		t.Errorf("unexpected account data: %+v", resp.AccountData.Events)
+	}
+	if len(resp.ToDevice.Events) != 1 || resp.ToDevice.Events[0].Sender != "@admin:localhost" {
+		t.Errorf("unexpected to-device events: %+v", resp.ToDevice.Events)
+	}
+	if len(resp.Rooms.Invite["!new:localhost"].InviteState.Events) != 1 {
+		t.Errorf("expected the invite's state, got %+v", resp.Rooms.Invite)
+	}
+}
+
+func TestSyncer_UnknownToken(t *testing.T) {
+	server := newSyncServer(t,
+		syncError(http.StatusUnauthorized, `{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token passed."}`),
+	)
+	defer server.Close()
+
+	syncer := NewSyncer(newTestClient(server.URL), "b1")
+	syncer.MinBackoff = time.Millisecond
+	err := syncer.Run(context.Background(), func(*SyncResponse) error { return nil })
+	if !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("expected ErrUnknownToken, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Invalid access token passed." {
+		t.Errorf("expected the homeserver's error, got %#v", err)
+	}
+}
+
+func TestSyncer_Events(t *testing.T) {
+	server := newSyncServer(t, syncBody(firstSync))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, errs := NewSyncer(newTestClient(server.URL), "").Events(ctx)
+
+	var got []Event
+	for len(got) < 6 {
+		got = append(got, <-events)
+	}
+	cancel()
+
+	// Drain: both channels close once the loop stops
+	for range events {
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("expected no error after cancel, got %v", err)
+	}
+
+	if got[0].Type != "m.room_key_request" || got[1].Type != "m.direct" {
+		t.Errorf("expected to-device and account data events first, got %s and %s", got[0].Type, got[1].Type)
+	}
+	rooms := map[string]int{}
+	for _, e := range got[2:] {
+		rooms[e.RoomID]++
+	}
+	if rooms["!dm:localhost"] != 3 || rooms["!new:localhost"] != 1 {
+		t.Errorf("expected room events to carry their room, got %v", rooms)
+	}
+}
+
+func TestEvent_Content(t *testing.T) {
+	var member Event
+	if err := json.Unmarshal([]byte(`{"type": "m.room.member", "state_key": "@a:localhost", "content": {"membership": "leave"}}`), &member); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var content MemberContent
+	if err := member.DecodeContent(&content); err != nil || content.Membership != "leave" {
+		t.Errorf("expected membership leave, got %+v (%v)", content, err)
+	}
+
+	// Content that doesn't fit a message still parses
+	var odd Event
+	if err := json.Unmarshal([]byte(`{"type": "m.custom", "content": {"body": 42}}`), &odd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(odd.RawContent) != `{"body": 42}` {
+		t.Errorf("expected the raw content to be kept, got %s", odd.RawContent)
+	}
+}
+
+func TestSyncer_NextBackoff(t *testing.T) {
+	s := NewSyncer(nil, "")
+	plain := errors.New("connection refused")
+
+	if got := s.nextBackoff(0, plain); got != syncBackoffMin {
+		t.Errorf("expected the first wait to be %s, got %s", syncBackoffMin, got)
+	}
+	if got := s.nextBackoff(4*time.Second, plain); got != 8*time.Second {
+		t.Errorf("expected the wait to double, got %s", got)
+	}
+	if got := s.nextBackoff(syncBackoffMax, plain); got != syncBackoffMax {
+		t.Errorf("expected the wait to stop at %s, got %s", syncBackoffMax, got)
+	}
+	limited := &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Minute}
+	if got := s.nextBackoff(0, limited); got != 3*time.Minute {
+		t.Errorf("expected the homeserver's retry_after_ms, got %s", got)
+	}
+}
@@ -0,0 +1,375 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tobocop2/muxbee/internal/backup"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+)
+
+// Default intervals for Exporter
+const (
+	DefaultInterval      = 30 * time.Second
+	DefaultProbeInterval = 5 * time.Minute
+)
+
+// synapseHealthTimeout bounds the Synapse /health request
+const synapseHealthTimeout = 5 * time.Second
+
+// Exporter collects metrics in the background and serves the latest on
+// /metrics, so a scrape never waits on the container runtime or a bridge
+type Exporter struct {
+	// Source returns the install to collect from. The daemon passes its
+	// current config, which changes when settings.yaml does.
+	Source func() (*config.Config, *docker.Compose)
+	// BackupDir is where scheduled backups go, or "" to leave out backup
+	// metrics
+	BackupDir string
+	// Interval is how often services, resource use and Synapse are measured
+	Interval time.Duration
+	// ProbeInterval is how often bridge logins and storage sizes are
+	// measured, which is slower
+	ProbeInterval time.Duration
+	// Logf reports collection errors
+	Logf func(format string, args ...any)
+
+	mu     sync.Mutex
+	page   []byte
+	probed time.Time
+	probes []*Family // From the last probe
+
+	httpGet func(url string) (*http.Response, error)
+	dataDir string
+}
+
+// NewExporter creates an exporter for the install source returns
+func NewExporter(source func() (*config.Config, *docker.Compose)) *Exporter {
+	client := &http.Client{Timeout: synapseHealthTimeout}
+	return &Exporter{
+		Source:        source,
+		Interval:      DefaultInterval,
+		ProbeInterval: DefaultProbeInterval,
+		Logf:          func(string, ...any) {},
+		httpGet:       client.Get,
+		dataDir:       config.DataDir(),
+	}
+}
+
+// Serve collects metrics and serves them on ln until ctx is done
+func (e *Exporter) Serve(ctx context.Context, ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "muxbee metrics exporter: see /metrics")
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go e.run(ctx)
+
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP serves the latest metrics
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	page := e.page
+	e.mu.Unlock()
+
+	if page == nil {
+		http.Error(w, "metrics are still being collected", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(page)
+}
+
+// run collects every Interval until ctx is done
+func (e *Exporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		e.Collect(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect measures everything and stores the page ServeHTTP serves. Bridges
+// and storage are probed only when ProbeInterval has passed since last time.
+func (e *Exporter) Collect(now time.Time) {
+	cfg, compose := e.Source()
+	start := time.Now()
+
+	statuses, err := compose.DetailedStatus()
+	runtimeUp := NewFamily("muxbee_runtime_up", Gauge, "Whether the container runtime answers")
+	if err != nil {
+		e.Logf("Failed to get service status: %v", err)
+		runtimeUp.Add(0)
+	} else {
+		runtimeUp.Add(1)
+	}
+
+	families := []*Family{runtimeUp}
+	families = append(families, serviceFamilies(statuses)...)
+	families = append(families, statsFamilies(statuses, e.stats(compose, statuses))...)
+	families = append(families, e.synapseFamilies(cfg)...)
+
+	e.mu.Lock()
+	due := e.probed.IsZero() || now.Sub(e.probed) >= e.ProbeInterval
+	probes := e.probes
+	e.mu.Unlock()
+	if due {
+		probes = e.probe(cfg, compose, statuses)
+		e.mu.Lock()
+		e.probed, e.probes = now, probes
+		e.mu.Unlock()
+	}
+	families = append(families, probes...)
+
+	if e.BackupDir != "" {
+		families = append(families, backupFamilies(e.BackupDir, now)...)
+	}
+
+	duration := NewFamily("muxbee_collect_duration_seconds", Gauge, "How long the last collection took")
+	duration.Add(time.Since(start).Seconds())
+	families = append(families, duration)
+
+	var buf bytes.Buffer
+	Write(&buf, families)
+	e.mu.Lock()
+	e.page = buf.Bytes()
+	e.mu.Unlock()
+}
+
+// probe measures the slow things: bridge logins and storage sizes
+func (e *Exporter) probe(cfg *config.Config, compose *docker.Compose, statuses []docker.ServiceStatus) []*Family {
+	var families []*Family
+	if len(statuses) > 0 {
+		families = append(families, bridgeFamilies(health.CheckBridges(cfg, compose, statuses, health.Options{Login: true}))...)
+	}
+
+	dbSize := NewFamily("muxbee_database_size_bytes", Gauge, "Size of each Postgres database")
+	if isRunning(statuses, "postgres") {
+		sizes, err := compose.DatabaseSizes()
+		if err != nil {
+			e.Logf("Failed to get database sizes: %v", err)
+		}
+		for _, name := range sortedKeys(sizes) {
+			dbSize.Add(float64(sizes[name]), "database", name)
+		}
+	}
+
+	mediaSize := NewFamily("muxbee_media_store_size_bytes", Gauge, "Size of Synapse's media store")
+	if size, err := dirSize(filepath.Join(e.dataDir, "synapse", "media_store")); err == nil {
+		mediaSize.Add(float64(size))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		e.Logf("Failed to measure the media store: %v", err)
+	}
+
+	return append(families, dbSize, mediaSize)
+}
+
+// stats samples the running containers in parallel, since each sample
+// takes the runtime a second
+func (e *Exporter) stats(compose *docker.Compose, statuses []docker.ServiceStatus) map[string]*docker.Stats {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result = make(map[string]*docker.Stats)
+	)
+	for _, s := range statuses {
+		if !s.Running {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			stats, err := compose.Stats(name)
+			if err != nil {
+				return // Stopped in the meantime
+			}
+			mu.Lock()
+			result[name] = stats
+			mu.Unlock()
+		}(s.Name)
+	}
+	wg.Wait()
+	return result
+}
+
+// synapseFamilies times Synapse's /health endpoint
+func (e *Exporter) synapseFamilies(cfg *config.Config) []*Family {
+	up := NewFamily("muxbee_synapse_health_up", Gauge, "Whether Synapse's /health endpoint answers OK")
+	latency := NewFamily("muxbee_synapse_health_duration_seconds", Gauge, "How long Synapse's /health endpoint took to answer")
+
+	start := time.Now()
+	resp, err := e.httpGet(fmt.Sprintf("http://localhost:%d/health", cfg.SynapsePort()))
+	if err != nil {
+		up.Add(0)
+		return []*Family{up}
+	}
+	resp.Body.Close()
+	latency.Add(time.Since(start).Seconds())
+
+	if resp.StatusCode == http.StatusOK {
+		up.Add(1)
+	} else {
+		up.Add(0)
+	}
+	return []*Family{up, latency}
+}
+
+// serviceFamilies describes each container's state
+func serviceFamilies(statuses []docker.ServiceStatus) []*Family {
+	up := NewFamily("muxbee_service_up", Gauge, "Whether the service's container is running")
+	healthy := NewFamily("muxbee_service_healthy", Gauge, "Whether the service's healthcheck passes, for services with one")
+	restarts := NewFamily("muxbee_service_restarts_total", Counter, "Restarts of the service's container by its restart policy")
+	exitCode := NewFamily("muxbee_service_exit_code", Gauge, "Exit code of the last run of the service's container")
+
+	for _, s := range statuses {
+		up.Add(boolValue(s.Running), "service", s.Service)
+		if s.Health != "" {
+			healthy.Add(boolValue(s.Health == "healthy"), "service", s.Service)
+		}
+		restarts.Add(float64(s.RestartCount), "service", s.Service)
+		exitCode.Add(float64(s.ExitCode), "service", s.Service)
+	}
+	return []*Family{up, healthy, restarts, exitCode}
+}
+
+// statsFamilies describes the resource use of running containers
+func statsFamilies(statuses []docker.ServiceStatus, stats map[string]*docker.Stats) []*Family {
+	cpu := NewFamily("muxbee_container_cpu_percent", Gauge, "CPU use of the service's container, in percent of one CPU")
+	memory := NewFamily("muxbee_container_memory_bytes", Gauge, "Memory use of the service's container, without the page cache")
+	limit := NewFamily("muxbee_container_memory_limit_bytes", Gauge, "Memory the service's container may use")
+
+	for _, s := range statuses {
+		st, ok := stats[s.Name]
+		if !ok {
+			continue
+		}
+		cpu.Add(st.CPUPercent, "service", s.Service)
+		memory.Add(float64(st.MemoryBytes), "service", s.Service)
+		if st.MemoryLimit > 0 {
+			limit.Add(float64(st.MemoryLimit), "service", s.Service)
+		}
+	}
+	return []*Family{cpu, memory, limit}
+}
+
+// bridgeStates are the values of muxbee_bridge_state, one series each
+var bridgeStates = []health.State{
+	health.StateStopped,
+	health.StateRunning,
+	health.StateReachable,
+	health.StateLoggedIn,
+	health.StateLoggedOut,
+}
+
+// bridgeFamilies describes each bridge's state and login
+func bridgeFamilies(results []health.Bridge) []*Family {
+	state := NewFamily("muxbee_bridge_state", Gauge, "State of the bridge: 1 for the state it is in, 0 for the others")
+	loggedIn := NewFamily("muxbee_bridge_logged_in", Gauge, "Whether the bridge reports an active login, for bridges whose login could be checked")
+
+	for _, b := range results {
+		for _, s := range bridgeStates {
+			state.Add(boolValue(b.State == s), "bridge", b.Name, "state", string(s))
+		}
+		switch b.State {
+		case health.StateLoggedIn:
+			loggedIn.Add(1, "bridge", b.Name)
+		case health.StateLoggedOut:
+			loggedIn.Add(0, "bridge", b.Name)
+		}
+	}
+	return []*Family{state, loggedIn}
+}
+
+// backupFamilies describes the newest backup in dir
+func backupFamilies(dir string, now time.Time) []*Family {
+	count := NewFamily("muxbee_backups", Gauge, "Number of backups in the backup directory")
+	last := NewFamily("muxbee_backup_last_timestamp_seconds", Gauge, "When the newest backup was taken")
+	age := NewFamily("muxbee_backup_age_seconds", Gauge, "Age of the newest backup")
+
+	snapshots, err := backup.ListSnapshots(dir)
+	if err != nil {
+		return nil
+	}
+	count.Add(float64(len(snapshots)))
+	if len(snapshots) > 0 {
+		last.Add(float64(snapshots[0].Time.Unix()))
+		age.Add(now.Sub(snapshots[0].Time).Seconds())
+	}
+	return []*Family{count, last, age}
+}
+
+// dirSize adds up the size of the files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return nil // Removed during the walk
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func isRunning(statuses []docker.ServiceStatus, service string) bool {
+	for _, s := range statuses {
+		if s.Service == service && s.Running {
+			return true
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,93 @@
+// Package metrics exports the state of a muxbee install in the Prometheus
+// text format: services, bridges, resource use, storage, backups and how
+// fast Synapse answers.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metric types
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Family is a metric and its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is one value of a metric
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Label is a label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// NewFamily creates an empty metric family
+func NewFamily(name, typ, help string) *Family {
+	return &Family{Name: name, Help: help, Type: typ}
+}
+
+// Add appends a sample. labels are name, value pairs.
+func (f *Family) Add(value float64, labels ...string) {
+	s := Sample{Value: value}
+	for i := 0; i+1 < len(labels); i += 2 {
+		s.Labels = append(s.Labels, Label{Name: labels[i], Value: labels[i+1]})
+	}
+	f.Samples = append(f.Samples, s)
+}
+
+// Write writes families in the Prometheus text exposition format,
+// skipping those without samples
+func Write(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// formatValue formats a sample value. FormatFloat spells infinities and
+// NaN the way Prometheus reads them.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
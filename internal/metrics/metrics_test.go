@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/backup"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+)
+
+func TestWrite(t *testing.T) {
+	up := NewFamily("muxbee_service_up", Gauge, "Whether the service runs")
+	up.Add(1, "service", "synapse")
+	up.Add(0, "service", `odd "name"`)
+	empty := NewFamily("muxbee_unused", Gauge, "Never set")
+	size := NewFamily("muxbee_size_bytes", Gauge, "A size\nover two lines")
+	size.Add(1.5e9)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []*Family{up, empty, size}))
+	assert.Equal(t, `# HELP muxbee_service_up Whether the service runs
+# TYPE muxbee_service_up gauge
+muxbee_service_up{service="synapse"} 1
+muxbee_service_up{service="odd \"name\""} 0
+# HELP muxbee_size_bytes A size\nover two lines
+# TYPE muxbee_size_bytes gauge
+muxbee_size_bytes 1.5e+09
+`, buf.String())
+}
+
+func TestServiceFamilies(t *testing.T) {
+	families := serviceFamilies([]docker.ServiceStatus{
+		{Name: "muxbee-postgres-1", Service: "postgres", Running: true, Health: "healthy"},
+		{Name: "muxbee-mautrix-signal-1", Service: "mautrix-signal", RestartCount: 4, ExitCode: 1},
+	})
+
+	out := render(t, families)
+	assert.Contains(t, out, `muxbee_service_up{service="postgres"} 1`)
+	assert.Contains(t, out, `muxbee_service_up{service="mautrix-signal"} 0`)
+	assert.Contains(t, out, `muxbee_service_healthy{service="postgres"} 1`)
+	assert.NotContains(t, out, `muxbee_service_healthy{service="mautrix-signal"}`)
+	assert.Contains(t, out, `muxbee_service_restarts_total{service="mautrix-signal"} 4`)
+	assert.Contains(t, out, "# TYPE muxbee_service_restarts_total counter")
+	assert.Contains(t, out, `muxbee_service_exit_code{service="mautrix-signal"} 1`)
+}
+
+func TestBridgeFamilies(t *testing.T) {
+	out := render(t, bridgeFamilies([]health.Bridge{
+		{Name: "signal", State: health.StateLoggedIn},
+		{Name: "whatsapp", State: health.StateLoggedOut},
+		{Name: "telegram", State: health.StateStopped},
+	}))
+
+	assert.Contains(t, out, `muxbee_bridge_state{bridge="signal",state="logged in"} 1`)
+	assert.Contains(t, out, `muxbee_bridge_state{bridge="signal",state="stopped"} 0`)
+	assert.Contains(t, out, `muxbee_bridge_state{bridge="telegram",state="stopped"} 1`)
+	assert.Contains(t, out, `muxbee_bridge_logged_in{bridge="signal"} 1`)
+	assert.Contains(t, out, `muxbee_bridge_logged_in{bridge="whatsapp"} 0`)
+	// A stopped bridge's login is unknown
+	assert.NotContains(t, out, `muxbee_bridge_logged_in{bridge="telegram"}`)
+}
+
+func TestBackupFamilies(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, taken := range []time.Time{now.Add(-50 * time.Hour), now.Add(-2 * time.Hour)} {
+		name := backup.SnapshotName(taken, false, false)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	out := render(t, backupFamilies(dir, now))
+	assert.Contains(t, out, "muxbee_backups 2\n")
+	assert.Contains(t, out, "muxbee_backup_age_seconds 72")
+
+	// No backups yet: no age to report
+	out = render(t, backupFamilies(t.TempDir(), now))
+	assert.Contains(t, out, "muxbee_backups 0\n")
+	assert.NotContains(t, out, "muxbee_backup_age_seconds")
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "local_content", "ab"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "local_content", "ab", "one"), make([]byte, 1000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "two"), make([]byte, 24), 0644))
+
+	size, err := dirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+
+	_, err = dirSize(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// fakeBackend serves canned containers, stats and psql output
+type fakeBackend struct {
+	docker.Backend
+	containers []docker.Container
+}
+
+func (f *fakeBackend) Containers(project string) ([]docker.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeBackend) Inspect(container string) (*docker.Container, error) {
+	for _, ctr := range f.containers {
+		if ctr.Name == container {
+			ctr.RestartCount = 2
+			return &ctr, nil
+		}
+	}
+	return nil, docker.ErrNotFound
+}
+
+func (f *fakeBackend) Stats(container string) (*docker.Stats, error) {
+	return &docker.Stats{CPUPercent: 12.5, MemoryBytes: 64 << 20, MemoryLimit: 1 << 30}, nil
+}
+
+func (f *fakeBackend) Exec(container string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if cmd[0] != "psql" {
+		return errors.New("not found")
+	}
+	io.WriteString(stdout, "synapse\t52109859\n")
+	return nil
+}
+
+func TestExporter(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	cfg := &config.Config{}
+	compose := docker.NewWithBackend(cfg, &fakeBackend{containers: []docker.Container{
+		{ID: "p", Name: "muxbee-postgres-1", Service: "postgres", State: "running", Health: "healthy"},
+		{ID: "s", Name: "muxbee-synapse-1", Service: "synapse", State: "running", Health: "healthy"},
+	}})
+
+	e := NewExporter(func() (*config.Config, *docker.Compose) { return cfg, compose })
+	e.httpGet = func(url string) (*http.Response, error) {
+		assert.Equal(t, "http://localhost:8008/health", url)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("OK"))}, nil
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(e.dataDir, "synapse", "media_store"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(e.dataDir, "synapse", "media_store", "f"), make([]byte, 10), 0644))
+
+	// Nothing to serve before the first collection
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	e.Collect(time.Now())
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+
+	out := rec.Body.String()
+	assert.Contains(t, out, "muxbee_runtime_up 1\n")
+	assert.Contains(t, out, `muxbee_service_up{service="synapse"} 1`)
+	assert.Contains(t, out, `muxbee_service_restarts_total{service="synapse"} 2`)
+	assert.Contains(t, out, `muxbee_container_cpu_percent{service="postgres"} 12.5`)
+	assert.Contains(t, out, `muxbee_container_memory_bytes{service="postgres"} 6.7108864e+07`)
+	assert.Contains(t, out, `muxbee_database_size_bytes{database="synapse"} 5.2109859e+07`)
+	assert.Contains(t, out, "muxbee_media_store_size_bytes 10\n")
+	assert.Contains(t, out, "muxbee_synapse_health_up 1\n")
+	assert.Contains(t, out, "muxbee_synapse_health_duration_seconds ")
+	assert.NotContains(t, out, "muxbee_backups")
+}
+
+func render(t *testing.T, families []*Family) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, families))
+	return buf.String()
+}
@@ -0,0 +1,134 @@
+// Package qrcode draws QR code images in a terminal with block characters,
+// so bridge logins can be scanned without a Matrix client.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"  // Bots may send GIF QR codes
+	_ "image/jpeg" // Bots may send JPEG QR codes
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+// quietZone is the light border drawn around the code, in modules. The
+// standard asks for 4; 2 scans fine off a screen and saves space.
+const quietZone = 2
+
+// minModules is the size of the smallest QR code, version 1
+const minModules = 21
+
+// finderModules is the width of the finder pattern in the top left corner
+const finderModules = 7
+
+// Colors for the code: bright white light modules on a black background,
+// so it scans whatever the terminal's own colors are
+const (
+	colorOn  = "\x1b[97;40m"
+	colorOff = "\x1b[0m"
+)
+
+// ErrNoCode is returned for images that don't look like a QR code
+var ErrNoCode = errors.New("no QR code found in image")
+
+// RenderImage decodes a QR code image and draws it for the terminal
+func RenderImage(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	modules, err := Modules(img)
+	if err != nil {
+		return "", err
+	}
+	return Render(modules), nil
+}
+
+// Modules reads the grid of a QR code image, true for dark modules. The
+// module size comes from the finder pattern in the top left corner.
+func Modules(img image.Image) ([][]bool, error) {
+	bounds := img.Bounds()
+	minX, minY, maxX, maxY := bounds.Max.X, bounds.Max.Y, bounds.Min.X-1, bounds.Min.Y-1
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if dark(img.At(x, y)) {
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+	}
+	if maxX < minX {
+		return nil, ErrNoCode
+	}
+
+	// The top edge of the finder pattern is a dark run 7 modules long
+	run := 0
+	for x := minX; x <= maxX && dark(img.At(x, minY)); x++ {
+		run++
+	}
+	width := float64(maxX - minX + 1)
+	n := int(math.Round(width / (float64(run) / finderModules)))
+	if n < minModules || float64(run) < finderModules {
+		return nil, ErrNoCode
+	}
+
+	size := width / float64(n)
+	modules := make([][]bool, n)
+	for row := range modules {
+		modules[row] = make([]bool, n)
+		y := minY + int((float64(row)+0.5)*size)
+		for col := range modules[row] {
+			x := minX + int((float64(col)+0.5)*size)
+			modules[row][col] = dark(img.At(x, y))
+		}
+	}
+	return modules, nil
+}
+
+// dark reports whether a pixel is a dark module. Transparent pixels are
+// light.
+func dark(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	if a < 0x8000 {
+		return false
+	}
+	return color.GrayModel.Convert(c).(color.Gray).Y < 128
+}
+
+// Render draws a grid of modules with half blocks, two rows to a line,
+// inside a quiet zone
+func Render(modules [][]bool) string {
+	n := len(modules)
+	light := func(row, col int) bool {
+		row, col = row-quietZone, col-quietZone
+		if row < 0 || row >= n || col < 0 || col >= len(modules[row]) {
+			return true
+		}
+		return !modules[row][col]
+	}
+
+	var b strings.Builder
+	size := n + 2*quietZone
+	for row := 0; row < size; row += 2 {
+		b.WriteString(colorOn)
+		for col := 0; col < size; col++ {
+			top := light(row, col)
+			bottom := row+1 < size && light(row+1, col)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(colorOff + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGrid returns a version 2 sized grid with finder patterns in three
+// corners and random modules elsewhere
+func testGrid() [][]bool {
+	const n = 25
+	rng := rand.New(rand.NewSource(1))
+	grid := make([][]bool, n)
+	for row := range grid {
+		grid[row] = make([]bool, n)
+		for col := range grid[row] {
+			grid[row][col] = rng.Intn(2) == 0
+		}
+	}
+	for _, corner := range [][2]int{{0, 0}, {0, n - 7}, {n - 7, 0}} {
+		for r := -1; r <= 7; r++ {
+			for c := -1; c <= 7; c++ {
+				row, col := corner[0]+r, corner[1]+c
+				if row < 0 || row >= n || col < 0 || col >= n {
+					continue
+				}
+				ring := max(abs(r-3), abs(c-3))
+				grid[row][col] = ring != 2 && ring != 4
+			}
+		}
+	}
+	return grid
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// drawPNG draws a grid the way bridges do: scaled up, off center, in a
+// white border
+func drawPNG(t *testing.T, grid [][]bool, scale, border int) []byte {
+	t.Helper()
+	side := len(grid)*scale + 2*border + 3
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for row := range grid {
+		for col := range grid[row] {
+			if !grid[row][col] {
+				continue
+			}
+			for y := 0; y < scale; y++ {
+				for x := 0; x < scale; x++ {
+					img.SetGray(border+col*scale+x, border+row*scale+y, color.Gray{})
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestModules(t *testing.T) {
+	grid := testGrid()
+	for _, scale := range []int{1, 3, 8} {
+		data := drawPNG(t, grid, scale, 4*scale+1)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		modules, err := Modules(img)
+		require.NoError(t, err, "scale %d", scale)
+		assert.Equal(t, grid, modules, "scale %d", scale)
+	}
+}
+
+func TestModules_NoCode(t *testing.T) {
+	blank := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range blank.Pix {
+		blank.Pix[i] = 0xff
+	}
+	_, err := Modules(blank)
+	assert.ErrorIs(t, err, ErrNoCode)
+
+	// A dark blob is no QR code either
+	_, err = Modules(image.NewGray(image.Rect(0, 0, 50, 50)))
+	assert.ErrorIs(t, err, ErrNoCode)
+}
+
+func TestRender(t *testing.T) {
+	out := Render([][]bool{{true, false}, {false, true}})
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	// 2 modules and a quiet zone of 2 on each side is 6 rows, 3 lines
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, colorOn))
+		assert.True(t, strings.HasSuffix(line, colorOff))
+	}
+	strip := func(s string) string {
+		return strings.TrimSuffix(strings.TrimPrefix(s, colorOn), colorOff)
+	}
+	assert.Equal(t, "██████", strip(lines[0]))
+	// Dark top left over light, light top right over dark
+	assert.Equal(t, "██▄▀██", strip(lines[1]))
+	assert.Equal(t, "██████", strip(lines[2]))
+}
+
+func TestRenderImage(t *testing.T) {
+	grid := testGrid()
+	out, err := RenderImage(drawPNG(t, grid, 4, 10))
+	require.NoError(t, err)
+	assert.Equal(t, Render(grid), out)
+
+	_, err = RenderImage([]byte("not an image"))
+	assert.Error(t, err)
+}
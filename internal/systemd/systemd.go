@@ -0,0 +1,281 @@
+// Package systemd installs muxbee as a systemd service, so the stack and
+// its bot rooms come back after a reboot without anyone running 'muxbee up'.
+package systemd
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// UnitName is the name of the installed unit
+const UnitName = "muxbee.service"
+
+// SystemUnitDir is where system units are installed
+const SystemUnitDir = "/etc/systemd/system"
+
+// Seam for tests
+var runCommand = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Unit describes the muxbee unit file
+type Unit struct {
+	System     bool   // A system unit rather than a user unit
+	Daemon     bool   // Run 'muxbee daemon' rather than 'muxbee up' and 'muxbee down'
+	Exe        string // Absolute path of the muxbee binary
+	User       string // Who muxbee runs as
+	UID        int    // Of User
+	Home       string // Of User
+	ConfigHome string // XDG_CONFIG_HOME, without the muxbee directory
+	DataHome   string // XDG_DATA_HOME, without the muxbee directory
+	Runtime    string // docker.RuntimeDocker or docker.RuntimePodman
+}
+
+// unitData is the template data for a unit file
+type unitData struct {
+	Unit
+	RunAs      string // User= of a system unit
+	Wants      []string
+	RuntimeDir string
+}
+
+// NewUnit describes the unit for whoever runs muxbee: the user who ran
+// sudo for a system unit, and the current user otherwise. The XDG
+// directories are that user's, so the service finds the same install.
+func NewUnit(system, daemon bool) (Unit, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return Unit{}, fmt.Errorf("failed to find the muxbee binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	u, err := user.Current()
+	sudo := system && os.Geteuid() == 0 && os.Getenv("SUDO_USER") != ""
+	if sudo {
+		u, err = user.Lookup(os.Getenv("SUDO_USER"))
+	}
+	if err != nil {
+		return Unit{}, fmt.Errorf("failed to look up user: %w", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return Unit{}, fmt.Errorf("failed to look up user: unexpected uid %q", u.Uid)
+	}
+
+	unit := Unit{
+		System:     system,
+		Daemon:     daemon,
+		Exe:        exe,
+		User:       u.Username,
+		UID:        uid,
+		Home:       u.HomeDir,
+		ConfigHome: filepath.Join(u.HomeDir, ".config"),
+		DataHome:   filepath.Join(u.HomeDir, ".local", "share"),
+	}
+	// sudo resets the environment, so root's XDG variables aren't the user's
+	if !sudo {
+		if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+			unit.ConfigHome = dir
+		}
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			unit.DataHome = dir
+		}
+	}
+	return unit, nil
+}
+
+// Path returns where the unit file is installed
+func (u Unit) Path() string {
+	if u.System {
+		return filepath.Join(SystemUnitDir, UnitName)
+	}
+	return filepath.Join(u.Home, ".config", "systemd", "user", UnitName)
+}
+
+// NeedsLinger reports whether the unit only runs at boot with lingering
+// on for its user. User units need the user's service manager, and
+// rootless Podman needs it for its socket and runtime directory.
+func (u Unit) NeedsLinger() bool {
+	return !u.System || u.rootlessPodman()
+}
+
+// rootlessPodman reports whether a system unit talks to a user's Podman
+func (u Unit) rootlessPodman() bool {
+	return u.System && u.Runtime == docker.RuntimePodman && u.UID != 0
+}
+
+// Render renders the unit file
+func (u Unit) Render() ([]byte, error) {
+	tmpl, err := template.New("muxbee.service.tmpl").
+		Funcs(template.FuncMap{"quote": quote}).
+		ParseFS(templateFS, "templates/muxbee.service.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	data := unitData{Unit: u}
+	if u.System && u.UID != 0 {
+		data.RunAs = u.User
+	}
+	switch {
+	case u.rootlessPodman():
+		data.Wants = []string{fmt.Sprintf("user@%d.service", u.UID)}
+		data.RuntimeDir = fmt.Sprintf("/run/user/%d", u.UID)
+	case u.Runtime == docker.RuntimePodman:
+		data.Wants = []string{"podman.socket"}
+	case u.System:
+		// A user unit can't depend on a system unit; Docker is up by the
+		// time a user's service manager is
+		data.Wants = []string{"docker.service"}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quote quotes a word of a unit file line if it needs it, and escapes the
+// % that systemd would read as a specifier
+func quote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// Install writes the unit file, enables it and, if start is set, starts it
+func Install(u Unit, start bool) error {
+	content, err := u.Render()
+	if err != nil {
+		return fmt.Errorf("failed to render unit: %w", err)
+	}
+
+	path := u.Path()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write unit: %w", err)
+	}
+
+	if err := systemctl(u.System, "daemon-reload"); err != nil {
+		return err
+	}
+	args := []string{"enable"}
+	if start {
+		args = append(args, "--now")
+	}
+	return systemctl(u.System, append(args, UnitName)...)
+}
+
+// Uninstall stops and disables the unit and removes its file
+func Uninstall(u Unit) error {
+	path := u.Path()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("%s is not installed", path)
+	}
+
+	if err := systemctl(u.System, "disable", "--now", UnitName); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unit: %w", err)
+	}
+	return systemctl(u.System, "daemon-reload")
+}
+
+// Status is the state of the installed unit
+type Status struct {
+	Path      string `json:"path" yaml:"path"`
+	Installed bool   `json:"installed" yaml:"installed"`
+	Enabled   string `json:"enabled,omitempty" yaml:"enabled,omitempty"` // From 'systemctl is-enabled'
+	Active    string `json:"active,omitempty" yaml:"active,omitempty"`   // From 'systemctl is-active'
+	Linger    *bool  `json:"linger,omitempty" yaml:"linger,omitempty"`   // When the unit needs it
+}
+
+// GetStatus asks systemd about the unit
+func GetStatus(u Unit) Status {
+	s := Status{Path: u.Path()}
+	if _, err := os.Stat(s.Path); err != nil {
+		return s
+	}
+	s.Installed = true
+
+	// Both exit non-zero for a disabled or inactive unit, printing its state
+	s.Enabled, _ = runCommand("systemctl", scopeArgs(u.System, "is-enabled", UnitName)...)
+	s.Active, _ = runCommand("systemctl", scopeArgs(u.System, "is-active", UnitName)...)
+
+	if u.NeedsLinger() {
+		if linger, err := Lingering(u.User); err == nil {
+			s.Linger = &linger
+		}
+	}
+	return s
+}
+
+// Lingering reports whether user's service manager runs without a login
+func Lingering(user string) (bool, error) {
+	out, err := runCommand("loginctl", "show-user", user, "--property=Linger", "--value")
+	if err != nil {
+		// loginctl fails for users without a session and lingering off
+		if out == "" || strings.Contains(out, "not logged in") {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check lingering: %s", out)
+	}
+	return out == "yes", nil
+}
+
+// EnableLinger starts the unit's user service manager at boot, if it
+// needs lingering and it's not on already
+func EnableLinger(u Unit) error {
+	if !u.NeedsLinger() {
+		return nil
+	}
+	if on, err := Lingering(u.User); err == nil && on {
+		return nil
+	}
+	if out, err := runCommand("loginctl", "enable-linger", u.User); err != nil {
+		return fmt.Errorf("failed to enable lingering for %s: %s\nRun 'sudo loginctl enable-linger %s'", u.User, out, u.User)
+	}
+	return nil
+}
+
+// systemctl runs a systemctl command for the system or user manager
+func systemctl(system bool, args ...string) error {
+	if out, err := runCommand("systemctl", scopeArgs(system, args...)...); err != nil {
+		if out != "" {
+			return fmt.Errorf("systemctl %s failed: %s", strings.Join(args, " "), out)
+		}
+		return fmt.Errorf("systemctl %s failed: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func scopeArgs(system bool, args ...string) []string {
+	if system {
+		return args
+	}
+	return append([]string{"--user"}, args...)
+}
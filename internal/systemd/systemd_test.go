@@ -0,0 +1,184 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+func testUnit() Unit {
+	return Unit{
+		Exe:        "/usr/local/bin/muxbee",
+		User:       "alice",
+		UID:        1000,
+		Home:       "/home/alice",
+		ConfigHome: "/home/alice/.config",
+		DataHome:   "/srv/muxbee data",
+		Runtime:    docker.RuntimeDocker,
+	}
+}
+
+// fakeCommands records the commands run and answers them from replies,
+// keyed by the command line
+func fakeCommands(t *testing.T, replies map[string]string) *[]string {
+	t.Helper()
+	var ran []string
+	orig := runCommand
+	t.Cleanup(func() { runCommand = orig })
+	runCommand = func(name string, args ...string) (string, error) {
+		line := strings.Join(append([]string{name}, args...), " ")
+		ran = append(ran, line)
+		return replies[line], nil
+	}
+	return &ran
+}
+
+func TestRender_UserUnit(t *testing.T) {
+	content, err := testUnit().Render()
+	require.NoError(t, err)
+
+	unit := string(content)
+	assert.Contains(t, unit, "Type=oneshot\nRemainAfterExit=yes\n")
+	assert.Contains(t, unit, "ExecStart=/usr/local/bin/muxbee up\n")
+	assert.Contains(t, unit, "ExecStop=/usr/local/bin/muxbee down\n")
+	assert.Contains(t, unit, "Environment=XDG_CONFIG_HOME=/home/alice/.config\n")
+	assert.Contains(t, unit, `Environment="XDG_DATA_HOME=/srv/muxbee data"`+"\n")
+	assert.Contains(t, unit, "After=network-online.target\n")
+	assert.Contains(t, unit, "WantedBy=default.target\n")
+	assert.Contains(t, unit, "systemctl --user edit muxbee")
+	assert.NotContains(t, unit, "User=")
+	assert.NotContains(t, unit, "docker.service")
+}
+
+func TestRender_SystemUnit(t *testing.T) {
+	u := testUnit()
+	u.System = true
+
+	content, err := u.Render()
+	require.NoError(t, err)
+
+	unit := string(content)
+	assert.Contains(t, unit, "User=alice\n")
+	assert.Contains(t, unit, "Wants=network-online.target docker.service\n")
+	assert.Contains(t, unit, "After=network-online.target docker.service\n")
+	assert.Contains(t, unit, "WantedBy=multi-user.target\n")
+	assert.NotContains(t, unit, "XDG_RUNTIME_DIR")
+
+	// Root's system unit needs no User=
+	u.User, u.UID, u.Home = "root", 0, "/root"
+	content, err = u.Render()
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "User=")
+}
+
+func TestRender_Podman(t *testing.T) {
+	u := testUnit()
+	u.Runtime = docker.RuntimePodman
+
+	content, err := u.Render()
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "After=network-online.target podman.socket\n")
+
+	// A system unit reaches the user's rootless Podman
+	u.System = true
+	content, err = u.Render()
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "After=network-online.target user@1000.service\n")
+	assert.Contains(t, string(content), "Environment=XDG_RUNTIME_DIR=/run/user/1000\n")
+	assert.True(t, u.NeedsLinger())
+}
+
+func TestRender_Daemon(t *testing.T) {
+	u := testUnit()
+	u.Daemon = true
+
+	content, err := u.Render()
+	require.NoError(t, err)
+
+	unit := string(content)
+	assert.Contains(t, unit, "Type=simple\n")
+	assert.Contains(t, unit, "ExecStart=/usr/local/bin/muxbee daemon\n")
+	assert.Contains(t, unit, "Restart=on-failure\n")
+	assert.NotContains(t, unit, "ExecStop=")
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "/usr/bin/muxbee", quote("/usr/bin/muxbee"))
+	assert.Equal(t, `"/opt/my apps/muxbee"`, quote("/opt/my apps/muxbee"))
+	assert.Equal(t, `"a \"b\" \\c"`, quote(`a "b" \c`))
+	assert.Equal(t, "/data/100%%", quote("/data/100%"))
+}
+
+func TestNeedsLinger(t *testing.T) {
+	u := testUnit()
+	assert.True(t, u.NeedsLinger())
+
+	u.System = true
+	assert.False(t, u.NeedsLinger())
+}
+
+func TestInstallUninstall(t *testing.T) {
+	u := testUnit()
+	u.Home = t.TempDir()
+	ran := fakeCommands(t, nil)
+
+	require.NoError(t, Install(u, true))
+	content, err := os.ReadFile(filepath.Join(u.Home, ".config", "systemd", "user", UnitName))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "ExecStart=/usr/local/bin/muxbee up")
+	assert.Equal(t, []string{
+		"systemctl --user daemon-reload",
+		"systemctl --user enable --now muxbee.service",
+	}, *ran)
+
+	*ran = nil
+	require.NoError(t, Uninstall(u))
+	assert.NoFileExists(t, u.Path())
+	assert.Equal(t, []string{
+		"systemctl --user disable --now muxbee.service",
+		"systemctl --user daemon-reload",
+	}, *ran)
+
+	assert.Error(t, Uninstall(u))
+}
+
+func TestEnableLinger(t *testing.T) {
+	u := testUnit()
+
+	ran := fakeCommands(t, map[string]string{"loginctl show-user alice --property=Linger --value": "no"})
+	require.NoError(t, EnableLinger(u))
+	assert.Equal(t, []string{
+		"loginctl show-user alice --property=Linger --value",
+		"loginctl enable-linger alice",
+	}, *ran)
+
+	ran = fakeCommands(t, map[string]string{"loginctl show-user alice --property=Linger --value": "yes"})
+	require.NoError(t, EnableLinger(u))
+	assert.Len(t, *ran, 1)
+}
+
+func TestGetStatus(t *testing.T) {
+	u := testUnit()
+	u.Home = t.TempDir()
+
+	fakeCommands(t, nil)
+	assert.Equal(t, Status{Path: u.Path()}, GetStatus(u))
+
+	require.NoError(t, Install(u, false))
+	fakeCommands(t, map[string]string{
+		"systemctl --user is-enabled muxbee.service":         "enabled",
+		"systemctl --user is-active muxbee.service":          "active",
+		"loginctl show-user alice --property=Linger --value": "yes",
+	})
+	s := GetStatus(u)
+	assert.True(t, s.Installed)
+	assert.Equal(t, "enabled", s.Enabled)
+	assert.Equal(t, "active", s.Active)
+	require.NotNil(t, s.Linger)
+	assert.True(t, *s.Linger)
+}
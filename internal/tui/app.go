@@ -92,10 +92,11 @@ func (m Model) Init() tea.Cmd {
 	}
 
 	// Otherwise, start on dashboard and auto-start services
-	// Also start the ticker for real-time updates
+	// Also follow container events, with the ticker as a fallback
 	return tea.Batch(
 		m.dashboard.Init(),
 		m.autoStartCmd(),
+		m.subscribeEventsCmd(),
 		tickCmd(),
 	)
 }
@@ -109,10 +110,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyMsg:
+		// Text typed into a form on the bridges screen isn't a shortcut
+		if m.screen == ScreenBridges && m.bridges.capturesKeys() && msg.String() != "ctrl+c" {
+			break
+		}
+
 		// Global key handlers
 		switch msg.String() {
 		case "ctrl+c":
 			m.quitting = true
+			m.dashboard.stopEvents()
+			m.bridges.stopLogin()
 			return m, tea.Quit
 		case "q":
 			if m.screen == ScreenSettings {
@@ -122,6 +130,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			if m.screen != ScreenWizard {
 				m.quitting = true
+				m.dashboard.stopEvents()
 				return m, tea.Quit
 			}
 		case "esc":
@@ -160,21 +169,66 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case statusMsg:
 		m.services = msg.services
 		m.err = msg.err
-		m.dashboard.services = msg.services
-		m.dashboard.lastUpdated = time.Now()
+		m.dashboard.setServices(msg.services)
+		m.dashboard.statusErr = msg.err
+		if m.dashboard.probeDue() && len(msg.services) > 0 && m.config != nil {
+			return m, m.dashboard.probeBridgesCmd(m.config, m.compose, msg.services)
+		}
+		return m, nil
+
+	case bridgeHealthMsg:
+		m.dashboard.finishProbe(msg)
 		return m, nil
 
 	case autoStartMsg:
 		m.autoStarting = false
 		m.services = msg.services
 		m.err = msg.err
-		m.dashboard.services = msg.services
+		m.dashboard.setServices(msg.services)
+		m.dashboard.statusErr = msg.err
 		m.dashboard.isLoading = false
-		m.dashboard.lastUpdated = time.Now()
 		return m, tickCmd()
 
+	case eventsStartedMsg:
+		m.dashboard.stopEvents()
+		m.dashboard.events = msg.stream
+		return m, msg.stream.next()
+
+	case containerEventMsg:
+		if msg.stream != m.dashboard.events {
+			return m, nil
+		}
+		cmds := []tea.Cmd{msg.stream.next()}
+		if m.dashboard.applyEvent(msg.event) && m.compose != nil {
+			cmds = append(cmds, inspectServiceCmd(m.compose, msg.event.ID))
+		}
+		m.services = m.dashboard.services
+		return m, tea.Batch(cmds...)
+
+	case serviceInspectedMsg:
+		// The container may be gone already; its destroy event removes it
+		if msg.err == nil {
+			m.dashboard.setService(msg.status)
+			m.services = m.dashboard.services
+		}
+		return m, nil
+
+	case eventsStoppedMsg:
+		if msg.stream != m.dashboard.events {
+			return m, nil
+		}
+		// Fall back to polling until the runtime takes subscribers again
+		m.dashboard.stopEvents()
+		return m, eventsRetryCmd()
+
+	case eventsRetryMsg:
+		if m.quitting || m.dashboard.events != nil {
+			return m, nil
+		}
+		return m, m.subscribeEventsCmd()
+
 	case tickMsg:
-		if m.screen == ScreenDashboard && !m.dashboard.isLoading && m.compose != nil {
+		if m.screen == ScreenDashboard && !m.dashboard.isLoading && m.compose != nil && m.dashboard.statusDue() {
 			return m, tea.Batch(m.fetchStatusCmd(), tickCmd())
 		}
 		return m, tickCmd()
@@ -191,7 +245,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.settings = NewSettingsModel(cfg)
 		}
 		m.screen = ScreenDashboard
-		return m, tea.Batch(m.fetchStatusCmd(), tickCmd())
+		return m, tea.Batch(m.fetchStatusCmd(), m.subscribeEventsCmd(), tickCmd())
 
 	case settingsSavedMsg:
 		// Reload config after settings saved
@@ -212,8 +266,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.dashboard.isLoading = false
 		m.dashboard.loadingOp = ""
 		m.dashboard.loadingStep = ""
-		m.dashboard.services = msg.services
-		m.dashboard.lastUpdated = time.Now()
+		m.dashboard.setServices(msg.services)
+		m.dashboard.actionErr = msg.err
 		m.services = msg.services
 		return m, nil
 
@@ -228,12 +282,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case updateStepMsg:
 		// Handle update progress regardless of active screen
 		if msg.done {
-			m.dashboard.isLoading = false
-			m.dashboard.loadingOp = ""
-			m.dashboard.loadingStep = ""
-			m.dashboard.services = msg.services
-			m.dashboard.lastUpdated = time.Now()
-			m.dashboard.updateChan = nil
+			m.dashboard.finishUpdate(msg)
 			m.services = msg.services
 			return m, nil
 		}
@@ -387,6 +436,7 @@ func (m Model) renderHelp() string {
 		keys = []string{
 			RenderKey("enter", "toggle"),
 			RenderKey("i", "info"),
+			RenderKey("L", "login"),
 		}
 	case ScreenLogs:
 		keys = []string{
@@ -437,7 +487,7 @@ func (m Model) fetchStatusCmd() tea.Cmd {
 		if m.compose == nil {
 			return statusMsg{services: nil, err: fmt.Errorf("not initialized")}
 		}
-		services, err := m.compose.Status()
+		services, err := m.compose.DetailedStatus()
 		return statusMsg{services: services, err: err}
 	}
 }
@@ -460,15 +510,21 @@ func (m Model) autoStartCmd() tea.Cmd {
 
 		// If no services running, auto-start them
 		if !hasRunning {
-			profiles := docker.GetProfiles(m.config)
-			m.compose.UpQuiet(profiles)
-			services, _ = m.compose.Status()
+			startServices(m.config, m.compose)
+			services, _ = m.compose.DetailedStatus()
 		}
 
 		return autoStartMsg{services: services, err: nil}
 	}
 }
 
+func (m Model) subscribeEventsCmd() tea.Cmd {
+	if m.compose == nil {
+		return nil
+	}
+	return subscribeEventsCmd(m.compose)
+}
+
 // Run starts the TUI application
 func Run() error {
 	p := tea.NewProgram(New(), tea.WithAltScreen())
@@ -0,0 +1,270 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tobocop2/muxbee/internal/bridges"
+	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/matrix"
+	"github.com/tobocop2/muxbee/internal/qrcode"
+)
+
+// bridgeLoginTimeout is how long the login view waits for the bot to answer
+const bridgeLoginTimeout = 5 * time.Minute
+
+// bridgeLoginHistory is how many of the bot's messages the login view shows
+const bridgeLoginHistory = 6
+
+// bridgeLogin is a login with a bridge bot, run from the bridges screen
+type bridgeLogin struct {
+	bridge  bridges.BridgeInfo
+	ctx     context.Context
+	cancel  context.CancelFunc
+	conv    *matrix.BridgeLogin
+	lines   []string // The bot's recent messages
+	qr      string   // The latest QR code, drawn for the terminal
+	prompt  bool     // The bot waits for an answer
+	input   textinput.Model
+	waiting bool
+	result  matrix.LoginResult
+	err     error
+}
+
+// bridgeLoginStartedMsg reports that the login command was sent
+type bridgeLoginStartedMsg struct {
+	login *bridgeLogin
+	conv  *matrix.BridgeLogin
+	err   error
+}
+
+// bridgeLoginMessagesMsg carries the bot's next messages
+type bridgeLoginMessagesMsg struct {
+	login    *bridgeLogin
+	messages []matrix.BotMessage
+	err      error
+}
+
+// bridgeLoginSentMsg reports that an answer reached the bot
+type bridgeLoginSentMsg struct {
+	login *bridgeLogin
+	err   error
+}
+
+// startLogin opens the login view and sends the bridge's login command
+func (m *BridgesModel) startLogin(cfg *config.Config, bridge bridges.BridgeInfo) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	input := textinput.New()
+	input.CharLimit = 256
+
+	login := &bridgeLogin{bridge: bridge, ctx: ctx, cancel: cancel, input: input, waiting: true}
+	m.login = login
+	m.lastError = nil
+
+	return func() tea.Msg {
+		conv, err := matrix.StartBridgeLogin(ctx, cfg, &bridge, bridge.LoginCommand)
+		return bridgeLoginStartedMsg{login: login, conv: conv, err: err}
+	}
+}
+
+// stopLogin closes the login view, telling the bot to drop an unfinished
+// login
+func (m *BridgesModel) stopLogin() {
+	login := m.login
+	if login == nil {
+		return
+	}
+	m.login = nil
+	login.cancel()
+	if login.conv != nil && login.result == matrix.LoginPending && login.err == nil {
+		login.conv.Send("cancel")
+	}
+}
+
+// next waits for the bot's next messages
+func (l *bridgeLogin) next() tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(l.ctx, bridgeLoginTimeout)
+		defer cancel()
+		messages, err := l.conv.Next(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%s did not answer within %s", l.conv.BotUserID(), bridgeLoginTimeout)
+		}
+		return bridgeLoginMessagesMsg{login: l, messages: messages, err: err}
+	}
+}
+
+// send sends an answer to the bot
+func (l *bridgeLogin) send(text string) tea.Cmd {
+	return func() tea.Msg {
+		return bridgeLoginSentMsg{login: l, err: l.conv.Send(text)}
+	}
+}
+
+// apply shows the bot's messages and reports whether to keep waiting for
+// more
+func (l *bridgeLogin) apply(messages []matrix.BotMessage) bool {
+	for _, msg := range messages {
+		if msg.Image != nil {
+			code, err := qrcode.RenderImage(msg.Image)
+			if err != nil {
+				l.addLine("The bot sent an image muxbee can't show. Open the chat in Element to see it.")
+				continue
+			}
+			l.qr = code
+			continue
+		}
+
+		l.addLine(msg.Body)
+		if result := msg.Result(); result != matrix.LoginPending {
+			l.result = result
+			l.qr = ""
+			l.prompt = false
+			return false
+		}
+	}
+
+	last := messages[len(messages)-1]
+	l.prompt = last.IsPrompt()
+	if l.prompt {
+		l.input.SetValue("")
+		l.input.EchoMode = textinput.EchoNormal
+		if last.IsSecret() {
+			l.input.EchoMode = textinput.EchoPassword
+		}
+		l.input.Focus()
+	}
+	return true
+}
+
+func (l *bridgeLogin) addLine(line string) {
+	l.lines = append(l.lines, line)
+	if len(l.lines) > bridgeLoginHistory {
+		l.lines = l.lines[len(l.lines)-bridgeLoginHistory:]
+	}
+}
+
+// finished reports whether the login is over, one way or another
+func (l *bridgeLogin) finished() bool {
+	return l.result != matrix.LoginPending || l.err != nil
+}
+
+// updateLogin handles events while the login view is open
+func (m *BridgesModel) updateLogin(msg tea.Msg) (*BridgesModel, tea.Cmd) {
+	login := m.login
+
+	switch msg := msg.(type) {
+	case bridgeLoginStartedMsg:
+		if msg.login != login {
+			return m, nil
+		}
+		if msg.err != nil {
+			login.waiting = false
+			login.err = msg.err
+			return m, nil
+		}
+		login.conv = msg.conv
+		return m, login.next()
+
+	case bridgeLoginMessagesMsg:
+		if msg.login != login {
+			return m, nil
+		}
+		if msg.err != nil {
+			login.waiting = false
+			login.err = msg.err
+			return m, nil
+		}
+		login.waiting = login.apply(msg.messages)
+		if login.waiting {
+			return m, tea.Batch(login.next(), textinput.Blink)
+		}
+		return m, nil
+
+	case bridgeLoginSentMsg:
+		if msg.login == login && msg.err != nil {
+			login.err = msg.err
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc":
+			m.stopLogin()
+			return m, nil
+		case "enter":
+			if login.finished() {
+				m.stopLogin()
+				return m, nil
+			}
+			if !login.prompt || login.conv == nil {
+				return m, nil
+			}
+			answer := strings.TrimSpace(login.input.Value())
+			if answer == "" {
+				return m, nil
+			}
+			login.prompt = false
+			login.input.Blur()
+			login.input.SetValue("")
+			return m, login.send(answer)
+		}
+
+		if login.prompt {
+			var cmd tea.Cmd
+			login.input, cmd = login.input.Update(msg)
+			return m, cmd
+		}
+	}
+	return m, nil
+}
+
+// viewLogin renders the login view
+func (m *BridgesModel) viewLogin() string {
+	login := m.login
+	var s string
+
+	s += TitleStyle.Render("Log in to "+login.bridge.Description) + "\n"
+	if login.conv != nil {
+		s += SubtitleStyle.Render("Talking to "+login.conv.BotUserID()) + "\n"
+	}
+	s += "\n"
+
+	for _, line := range login.lines {
+		s += line + "\n"
+	}
+	if len(login.lines) > 0 {
+		s += "\n"
+	}
+
+	if login.qr != "" {
+		s += login.qr + "\n"
+	}
+
+	switch {
+	case login.err != nil:
+		s += ErrorStyle.Render("Error: "+login.err.Error()) + "\n\n"
+	case login.result == matrix.LoginSucceeded:
+		s += SuccessStyle.Render("✓ Logged in to "+login.bridge.Name) + "\n\n"
+	case login.result == matrix.LoginFailed:
+		s += ErrorStyle.Render("Login failed") + "\n\n"
+	case login.prompt:
+		s += login.input.View() + "\n\n"
+	case login.waiting:
+		s += SubtitleStyle.Render("Waiting for the bot...") + "\n\n"
+	}
+
+	if login.finished() {
+		s += HelpStyle.Render(RenderKey("enter", "close"))
+	} else if login.prompt {
+		s += HelpStyle.Render(RenderKey("enter", "send") + "  " + RenderKey("esc", "cancel"))
+	} else {
+		s += HelpStyle.Render(RenderKey("esc", "cancel"))
+	}
+	return s
+}
@@ -37,6 +37,9 @@ type BridgesModel struct {
 	credentialStep   int // 0 = API ID, 1 = API Hash
 	apiIDInput       textinput.Model
 	apiHashInput     textinput.Model
+
+	// Login with a bridge bot, while the login view is open
+	login *bridgeLogin
 }
 
 var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
@@ -73,6 +76,9 @@ func (m *BridgesModel) Update(msg tea.Msg, cfg *config.Config) (*BridgesModel, t
 	if m.credentialInput {
 		return m.updateCredentialInput(msg, cfg)
 	}
+	if m.login != nil {
+		return m.updateLogin(msg)
+	}
 
 	switch msg := msg.(type) {
 	case bridgeProgressMsg:
@@ -155,6 +161,21 @@ func (m *BridgesModel) Update(msg tea.Msg, cfg *config.Config) (*BridgesModel, t
 					m.infoBridge = nil
 				}
 			}
+		case "L":
+			if cfg != nil && m.cursor < len(m.bridges) {
+				bridge := m.bridges[m.cursor]
+				if !cfg.IsBridgeEnabled(bridge.Name) {
+					m.lastError = fmt.Errorf("enable %s before logging in", bridge.Name)
+					return m, nil
+				}
+				if !bridge.HasInteractiveLogin() {
+					// Show the instructions for bridges that need cookies
+					m.showInfo = true
+					m.infoBridge = &bridge
+					return m, nil
+				}
+				return m, m.startLogin(cfg, bridge)
+			}
 		case "esc":
 			m.showInfo = false
 			m.infoBridge = nil
@@ -163,6 +184,12 @@ func (m *BridgesModel) Update(msg tea.Msg, cfg *config.Config) (*BridgesModel, t
 	return m, nil
 }
 
+// capturesKeys reports whether the screen takes typed text, which the
+// global shortcuts must leave alone
+func (m *BridgesModel) capturesKeys() bool {
+	return m.credentialInput || m.login != nil
+}
+
 // updateCredentialInput handles input when in credential entry mode
 func (m *BridgesModel) updateCredentialInput(msg tea.Msg, cfg *config.Config) (*BridgesModel, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -325,6 +352,9 @@ func (m *BridgesModel) View(cfg *config.Config) string {
 	if m.credentialInput {
 		return m.viewCredentialInput()
 	}
+	if m.login != nil {
+		return m.viewLogin()
+	}
 
 	var s string
 
@@ -369,6 +399,10 @@ func (m *BridgesModel) View(cfg *config.Config) string {
 			status = " (queued)"
 		}
 
+		if bridge.Custom {
+			status += " " + SubtitleStyle.Render("(custom)")
+		}
+
 		line := cursor + " " + enabled + " " + bridge.Name + status
 		if i == m.cursor {
 			s += ListItemSelectedStyle.Render(line) + "\n"
@@ -378,7 +412,7 @@ func (m *BridgesModel) View(cfg *config.Config) string {
 	}
 
 	s += "\n"
-	s += HelpStyle.Render(RenderKey("i", "info") + "  " + RenderKey("enter", "toggle"))
+	s += HelpStyle.Render(RenderKey("i", "info") + "  " + RenderKey("enter", "toggle") + "  " + RenderKey("L", "login"))
 
 	return s
 }
@@ -439,7 +473,11 @@ func (m *BridgesModel) viewBridgeInfo(cfg *config.Config) string {
 	s += TitleStyle.Render("Login Instructions:") + "\n"
 	s += b.LoginInstructions + "\n"
 
-	s += "\n" + HelpStyle.Render(RenderKey("esc", "back"))
+	help := RenderKey("esc", "back")
+	if b.HasInteractiveLogin() {
+		help += "  " + RenderKey("L", "login")
+	}
+	s += "\n" + HelpStyle.Render(help)
 
 	return s
 }
@@ -495,6 +533,11 @@ func (m *BridgesModel) toggleBridgeBackground(cfg *config.Config, bridgeName str
 	d := docker.New(cfg)
 
 	if enabling {
+		if err = d.WriteComposeFile(); err != nil {
+			m.resultChan <- bridgeToggledMsg{err: err}
+			return
+		}
+
 		// IMPORTANT: Restart Synapse FIRST so it loads the new registration file
 		// before the bridge tries to connect
 		if err = d.RestartQuiet("synapse"); err != nil {
@@ -518,7 +561,7 @@ func (m *BridgesModel) toggleBridgeBackground(cfg *config.Config, bridgeName str
 
 		// Verify bridge started successfully
 		time.Sleep(5 * time.Second)
-		serviceName := "mautrix-" + bridgeName
+		serviceName := bridges.ServiceNameFor(bridgeName)
 		if !d.IsServiceRunning(serviceName) {
 			m.resultChan <- bridgeToggledMsg{err: fmt.Errorf("%s failed to start - check logs with 'muxbee logs %s'", bridgeName, serviceName)}
 			return
@@ -533,9 +576,15 @@ func (m *BridgesModel) toggleBridgeBackground(cfg *config.Config, bridgeName str
 		matrix.CleanupBotForBridge(cfg, bridgeName)
 
 		// Stop and remove the bridge container
-		serviceName := "mautrix-" + bridgeName
+		serviceName := bridges.ServiceNameFor(bridgeName)
 		d.StopService(serviceName) // Ignore error, might not be running
 
+		// Drop the service from the compose file once its container is gone
+		if err = d.WriteComposeFile(); err != nil {
+			m.resultChan <- bridgeToggledMsg{err: err}
+			return
+		}
+
 		// Restart Synapse to update bridge registrations
 		if err = d.RestartQuiet("synapse"); err != nil {
 			m.resultChan <- bridgeToggledMsg{err: err}
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"fmt"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -8,22 +9,44 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/tobocop2/muxbee/internal/config"
+	"github.com/tobocop2/muxbee/internal/daemon"
 	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
 )
 
+// bridgeProbeInterval is how often the dashboard probes bridges. Each probe
+// pings every bridge bot, so it runs far less often than the status refresh.
+const bridgeProbeInterval = 5 * time.Minute
+
 var dashboardSpinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 
 // DashboardModel handles the dashboard screen
 type DashboardModel struct {
 	services    []docker.ServiceStatus
+	statusErr   error // Why the last status refresh failed, if it did
+	actionErr   error // Why the last start, stop, restart or toggle failed, if it did
 	isLoading   bool
 	loadingOp   string
 	loadingStep string // Current step within a multi-step operation
 	spinnerIdx  int
-	lastUpdated time.Time
+	lastUpdated time.Time // Last change to services, from a refresh or an event
+
+	// Last time every service was reloaded. Events update lastUpdated
+	// without one, so the periodic resync goes by this.
+	lastFullRefresh time.Time
 
 	// For multi-step operations like update
-	updateChan chan updateStepMsg
+	updateChan   chan updateStepMsg
+	updateResult string // Summary of the last update, shown until the next one
+
+	// Per-bridge probe results, refreshed every bridgeProbeInterval
+	bridgeHealth map[string]health.Bridge
+	probing      bool
+	lastProbe    time.Time
+
+	// Container events that keep services current between refreshes; nil
+	// while the dashboard polls instead
+	events *eventStream
 }
 
 // NewDashboardModel creates a new dashboard model
@@ -45,8 +68,8 @@ func (m *DashboardModel) Update(msg tea.Msg, cfg *config.Config, compose *docker
 		m.isLoading = false
 		m.loadingOp = ""
 		m.loadingStep = ""
-		m.services = msg.services
-		m.lastUpdated = time.Now()
+		m.setServices(msg.services)
+		m.actionErr = msg.err
 		return m, nil
 
 	case dashboardSpinnerMsg:
@@ -58,12 +81,7 @@ func (m *DashboardModel) Update(msg tea.Msg, cfg *config.Config, compose *docker
 
 	case updateStepMsg:
 		if msg.done {
-			m.isLoading = false
-			m.loadingOp = ""
-			m.loadingStep = ""
-			m.services = msg.services
-			m.lastUpdated = time.Now()
-			m.updateChan = nil
+			m.finishUpdate(msg)
 			return m, nil
 		}
 		// Only update step if a new one was provided
@@ -127,7 +145,8 @@ func (m *DashboardModel) Update(msg tea.Msg, cfg *config.Config, compose *docker
 			if cfg != nil && compose != nil {
 				m.isLoading = true
 				m.loadingOp = "Updating"
-				m.loadingStep = "Pulling images"
+				m.loadingStep = "Recording current images"
+				m.updateResult = ""
 				m.spinnerIdx = 0
 				m.updateChan = make(chan updateStepMsg, 1)
 				go m.updateServicesBackground(cfg, compose)
@@ -159,13 +178,25 @@ func (m *DashboardModel) View(cfg *config.Config) string {
 			s += SubtitleStyle.Render(spinner+" "+m.loadingOp+"...") + "\n"
 		}
 	} else if !m.lastUpdated.IsZero() {
-		s += SubtitleStyle.Render("updated "+m.lastUpdated.Format("15:04:05")) + "\n"
+		s += SubtitleStyle.Render("updated " + m.lastUpdated.Format("15:04:05"))
+		if m.events != nil {
+			s += " " + SuccessStyle.Render("● live")
+		}
+		s += "\n"
+	}
+	if m.updateResult != "" && !m.isLoading {
+		s += m.updateResult + "\n"
+	}
+	if m.actionErr != nil && !m.isLoading {
+		s += ErrorStyle.Render(m.actionErr.Error()) + "\n"
 	}
 	s += "\n"
 
 	// Services
 	s += TitleStyle.Render("Services") + "\n"
-	if len(m.services) == 0 {
+	if m.statusErr != nil {
+		s += "  " + ErrorStyle.Render(m.statusErr.Error()) + "\n"
+	} else if len(m.services) == 0 {
 		s += "  " + SubtitleStyle.Render("none running") + "\n"
 	} else {
 		for _, svc := range m.services {
@@ -175,7 +206,10 @@ func (m *DashboardModel) View(cfg *config.Config) string {
 			if svc.Version != "" {
 				version = " " + VersionStyle.Render(svc.Version)
 			}
-			s += "  " + name + version + " " + status + "\n"
+			if pin := cfg.PinnedVersion(name); pin != "" {
+				version += " " + VersionStyle.Render("(pinned "+docker.ShortVersion(pin)+")")
+			}
+			s += "  " + name + version + " " + status + renderRunHistory(svc) + "\n"
 		}
 	}
 	s += "\n"
@@ -185,7 +219,16 @@ func (m *DashboardModel) View(cfg *config.Config) string {
 	if len(cfg.EnabledBridges) == 0 {
 		s += "  " + SubtitleStyle.Render("none") + "\n"
 	} else {
-		s += "  " + strings.Join(cfg.EnabledBridges, ", ") + "\n"
+		for _, name := range cfg.EnabledBridges {
+			state := SubtitleStyle.Render("checking...")
+			if b, ok := m.bridgeHealth[name]; ok {
+				state = RenderBridgeState(b.State)
+				if b.Detail != "" {
+					state += " " + SubtitleStyle.Render("("+b.Detail+")")
+				}
+			}
+			s += "  " + name + " " + state + "\n"
+		}
 	}
 	s += "\n"
 
@@ -208,60 +251,140 @@ func (m *DashboardModel) View(cfg *config.Config) string {
 	return s
 }
 
+// renderRunHistory notes a service's restarts and, once it has stopped,
+// how its last run exited
+func renderRunHistory(svc docker.ServiceStatus) string {
+	var s string
+	if !svc.Running && svc.ExitCode != 0 {
+		s += " " + ErrorStyle.Render(fmt.Sprintf("(exit %d)", svc.ExitCode))
+	}
+	if svc.RestartCount == 1 {
+		s += " " + StatusWarning.Render("restarted once")
+	} else if svc.RestartCount > 1 {
+		s += " " + StatusWarning.Render(fmt.Sprintf("restarted %d times", svc.RestartCount))
+	}
+	return s
+}
+
 type servicesUpdatedMsg struct {
 	services []docker.ServiceStatus
+	err      error // Why the operation failed, if it did
 }
 
 type dashboardSpinnerMsg struct{}
 
+type bridgeHealthMsg struct {
+	bridges []health.Bridge
+}
+
 type updateStepMsg struct {
 	step     string // Current step description
 	done     bool   // Whether the whole update is done
 	services []docker.ServiceStatus
+	report   *docker.UpdateReport
+	err      error
 }
 
 func (m *DashboardModel) startServicesCmd(cfg *config.Config, compose *docker.Compose) tea.Cmd {
 	return func() tea.Msg {
-		profiles := docker.GetProfiles(cfg)
-		compose.UpQuiet(profiles)
-		services, _ := compose.Status()
+		startServices(cfg, compose)
+		services, _ := compose.DetailedStatus()
 		return servicesUpdatedMsg{services: services}
 	}
 }
 
 func (m *DashboardModel) stopServicesCmd(cfg *config.Config, compose *docker.Compose) tea.Cmd {
 	return func() tea.Msg {
-		profiles := docker.GetProfiles(cfg)
-		compose.DownQuiet(profiles)
-		services, _ := compose.Status()
+		if client, err := daemon.Dial(); err == nil {
+			client.Down()
+		} else {
+			daemon.SetStoppedOnPurpose(true)
+			compose.DownQuiet(docker.GetProfiles(cfg))
+		}
+		services, _ := compose.DetailedStatus()
 		return servicesUpdatedMsg{services: services}
 	}
 }
 
 func (m *DashboardModel) restartServicesCmd(cfg *config.Config, compose *docker.Compose) tea.Cmd {
 	return func() tea.Msg {
-		profiles := docker.GetProfiles(cfg)
-		compose.DownQuiet(profiles)
-		compose.UpQuiet(profiles)
-		services, _ := compose.Status()
+		restartServices(cfg, compose)
+		services, _ := compose.DetailedStatus()
 		return servicesUpdatedMsg{services: services}
 	}
 }
 
 func (m *DashboardModel) toggleElementCmd(cfg *config.Config, compose *docker.Compose) tea.Cmd {
 	return func() tea.Msg {
+		previous := cfg.ElementEnabled
 		enabled := !cfg.IsElementEnabled()
 		cfg.ElementEnabled = &enabled
-		cfg.Save()
+		if err := cfg.Save(); err != nil {
+			cfg.ElementEnabled = previous
+			services, _ := compose.DetailedStatus()
+			return servicesUpdatedMsg{services: services, err: fmt.Errorf("failed to save config: %w", err)}
+		}
 
-		profiles := docker.GetProfiles(cfg)
-		compose.DownQuiet(profiles)
-		compose.UpQuiet(profiles)
-		services, _ := compose.Status()
+		if err := compose.WriteComposeFile(); err != nil {
+			// Left as it was, so settings.yaml matches the running stack
+			cfg.ElementEnabled = previous
+			cfg.Save()
+			services, _ := compose.DetailedStatus()
+			return servicesUpdatedMsg{services: services, err: fmt.Errorf("failed to write docker-compose.yml: %w", err)}
+		}
+		restartServices(cfg, compose)
+		services, _ := compose.DetailedStatus()
 		return servicesUpdatedMsg{services: services}
 	}
 }
 
+// startServices starts the stack, through 'muxbee daemon' if it runs so
+// it doesn't keep the stack down
+func startServices(cfg *config.Config, compose *docker.Compose) {
+	if client, err := daemon.Dial(); err == nil {
+		client.Up()
+		return
+	}
+	daemon.SetStoppedOnPurpose(false)
+	compose.UpQuiet(docker.GetProfiles(cfg))
+}
+
+// restartServices restarts the stack, through 'muxbee daemon' if it runs
+// so it doesn't start services again halfway through
+func restartServices(cfg *config.Config, compose *docker.Compose) {
+	if client, err := daemon.Dial(); err == nil {
+		client.Restart("")
+		return
+	}
+	daemon.SetStoppedOnPurpose(false)
+	profiles := docker.GetProfiles(cfg)
+	compose.DownQuiet(profiles)
+	compose.UpQuiet(profiles)
+}
+
+// probeDue reports whether the bridges should be probed again
+func (m *DashboardModel) probeDue() bool {
+	return !m.probing && time.Since(m.lastProbe) >= bridgeProbeInterval
+}
+
+// probeBridgesCmd probes every enabled bridge, including its login state
+func (m *DashboardModel) probeBridgesCmd(cfg *config.Config, compose *docker.Compose, services []docker.ServiceStatus) tea.Cmd {
+	m.probing = true
+	return func() tea.Msg {
+		return bridgeHealthMsg{bridges: health.CheckBridges(cfg, compose, services, health.Options{Login: true})}
+	}
+}
+
+// finishProbe stores probe results
+func (m *DashboardModel) finishProbe(msg bridgeHealthMsg) {
+	m.probing = false
+	m.lastProbe = time.Now()
+	m.bridgeHealth = make(map[string]health.Bridge)
+	for _, b := range msg.bridges {
+		m.bridgeHealth[b.Name] = b
+	}
+}
+
 // spinnerTick returns a command that ticks the spinner
 func (m *DashboardModel) spinnerTick() tea.Cmd {
 	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
@@ -290,21 +413,46 @@ func (m *DashboardModel) checkUpdateProgress() tea.Cmd {
 func (m *DashboardModel) updateServicesBackground(cfg *config.Config, compose *docker.Compose) {
 	profiles := docker.GetProfiles(cfg)
 
-	// Step 1: Pull images
-	m.updateChan <- updateStepMsg{step: "Pulling images", done: false}
-	compose.PullQuiet(profiles)
+	report, err := compose.Update(profiles, docker.UpdateOptions{
+		Quiet: true,
+		Progress: func(step string) {
+			m.updateChan <- updateStepMsg{step: step, done: false}
+		},
+	})
+
+	services, _ := compose.DetailedStatus()
+	m.updateChan <- updateStepMsg{step: "", done: true, services: services, report: report, err: err}
+}
 
-	// Step 2: Stop services
-	m.updateChan <- updateStepMsg{step: "Stopping services", done: false}
-	compose.DownQuiet(profiles)
+// finishUpdate stores the final service list and update summary
+func (m *DashboardModel) finishUpdate(msg updateStepMsg) {
+	m.isLoading = false
+	m.loadingOp = ""
+	m.loadingStep = ""
+	m.setServices(msg.services)
+	m.updateChan = nil
+	m.updateResult = renderUpdateResult(msg.report, msg.err)
+}
 
-	// Step 3: Start services
-	m.updateChan <- updateStepMsg{step: "Starting services", done: false}
-	compose.UpForceRecreateQuiet(profiles)
+// renderUpdateResult formats an update report as a single status line
+func renderUpdateResult(report *docker.UpdateReport, err error) string {
+	if err != nil {
+		return ErrorStyle.Render("Update failed: " + err.Error())
+	}
+	if report == nil {
+		return ""
+	}
 
-	// Done
-	services, _ := compose.Status()
-	m.updateChan <- updateStepMsg{step: "", done: true, services: services}
+	var problems []string
+	for _, svc := range report.Services {
+		if svc.Outcome == docker.UpdateRolledBack || svc.Outcome == docker.UpdateFailed {
+			problems = append(problems, svc.Service+" "+string(svc.Outcome))
+		}
+	}
+	if len(problems) > 0 {
+		return ErrorStyle.Render("Update: " + report.Summary() + " (" + strings.Join(problems, ", ") + ")")
+	}
+	return SuccessStyle.Render("Update: " + report.Summary())
 }
 
 func openBrowser(url string) {
@@ -0,0 +1,195 @@
+package tui
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tobocop2/muxbee/internal/docker"
+)
+
+// statusResyncInterval is how often the dashboard reloads every service
+// while container events keep it current, to catch anything they missed
+const statusResyncInterval = 30 * time.Second
+
+// eventsRetryInterval is how long the dashboard waits to subscribe again
+// after the event stream ends. It polls in the meantime.
+const eventsRetryInterval = 5 * time.Second
+
+// eventStream is a subscription to the stack's container events
+type eventStream struct {
+	events <-chan docker.Event
+	errs   <-chan error
+	cancel context.CancelFunc
+}
+
+// eventsStartedMsg carries a new subscription
+type eventsStartedMsg struct {
+	stream *eventStream
+}
+
+// containerEventMsg is one event from a subscription
+type containerEventMsg struct {
+	stream *eventStream
+	event  docker.Event
+}
+
+// eventsStoppedMsg reports that a subscription ended
+type eventsStoppedMsg struct {
+	stream *eventStream
+	err    error
+}
+
+type eventsRetryMsg struct{}
+
+// serviceInspectedMsg carries a container's status after an event
+type serviceInspectedMsg struct {
+	status docker.ServiceStatus
+	err    error
+}
+
+// subscribeEventsCmd starts following the stack's container events
+func subscribeEventsCmd(compose *docker.Compose) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, errs := compose.Events(ctx)
+		return eventsStartedMsg{stream: &eventStream{events: events, errs: errs, cancel: cancel}}
+	}
+}
+
+// next waits for the subscription's next event
+func (s *eventStream) next() tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := <-s.events
+		if !ok {
+			return eventsStoppedMsg{stream: s, err: <-s.errs}
+		}
+		return containerEventMsg{stream: s, event: ev}
+	}
+}
+
+func eventsRetryCmd() tea.Cmd {
+	return tea.Tick(eventsRetryInterval, func(time.Time) tea.Msg {
+		return eventsRetryMsg{}
+	})
+}
+
+func inspectServiceCmd(compose *docker.Compose, container string) tea.Cmd {
+	return func() tea.Msg {
+		status, err := compose.InspectService(container)
+		return serviceInspectedMsg{status: status, err: err}
+	}
+}
+
+// applyEvent updates the service an event is about right away, and
+// reports whether the container should be inspected for what the event
+// doesn't say, like its restart count or version
+func (m *DashboardModel) applyEvent(ev docker.Event) bool {
+	m.lastUpdated = time.Now()
+
+	if ev.Action == "destroy" {
+		m.removeService(ev.Container)
+		return false
+	}
+
+	idx := m.serviceIndex(ev.Container)
+	if idx < 0 {
+		// A container muxbee hasn't listed yet, such as a new bridge
+		return ev.Action == "create" || ev.Action == "start"
+	}
+	s := &m.services[idx]
+
+	switch {
+	case ev.Action == "start", ev.Action == "restart", ev.Action == "unpause":
+		s.State = "running"
+		s.Running = true
+		if s.Health != "" {
+			s.Health = "starting"
+		}
+		return true
+	case ev.Action == "die", ev.Action == "died":
+		s.State = "exited"
+		s.Running = false
+		s.Health = ""
+		if code, err := strconv.Atoi(eventExitCode(ev)); err == nil {
+			s.ExitCode = code
+		}
+		return true
+	case ev.Action == "pause":
+		s.State = "paused"
+		s.Running = false
+	case strings.HasPrefix(ev.Action, "health_status"):
+		if h := eventHealth(ev); h != "" {
+			s.Health = h
+		}
+	}
+	return false
+}
+
+// eventExitCode returns the exit code of a die event. Docker and Podman
+// name the attribute differently.
+func eventExitCode(ev docker.Event) string {
+	if code, ok := ev.Attributes["exitCode"]; ok {
+		return code
+	}
+	return ev.Attributes["containerExitCode"]
+}
+
+// eventHealth returns the health of a health_status event: Docker puts it
+// in the action ("health_status: healthy"), Podman in an attribute
+func eventHealth(ev docker.Event) string {
+	if h, ok := strings.CutPrefix(ev.Action, "health_status:"); ok {
+		return strings.TrimSpace(h)
+	}
+	return ev.Attributes["health_status"]
+}
+
+// setService replaces a service's status, or adds it in name order
+func (m *DashboardModel) setService(status docker.ServiceStatus) {
+	if idx := m.serviceIndex(status.Name); idx >= 0 {
+		m.services[idx] = status
+		return
+	}
+	m.services = append(m.services, status)
+	sort.Slice(m.services, func(i, j int) bool {
+		return m.services[i].Name < m.services[j].Name
+	})
+}
+
+func (m *DashboardModel) removeService(name string) {
+	if idx := m.serviceIndex(name); idx >= 0 {
+		m.services = append(m.services[:idx], m.services[idx+1:]...)
+	}
+}
+
+func (m *DashboardModel) serviceIndex(name string) int {
+	for i, s := range m.services {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// statusDue reports whether the periodic tick should reload every service
+func (m *DashboardModel) statusDue() bool {
+	return m.events == nil || time.Since(m.lastFullRefresh) >= statusResyncInterval
+}
+
+// setServices stores a freshly loaded list of every service
+func (m *DashboardModel) setServices(services []docker.ServiceStatus) {
+	m.services = services
+	m.lastUpdated = time.Now()
+	m.lastFullRefresh = m.lastUpdated
+}
+
+// stopEvents ends the subscription, if there is one
+func (m *DashboardModel) stopEvents() {
+	if m.events != nil {
+		m.events.cancel()
+		m.events = nil
+	}
+}
@@ -1,6 +1,9 @@
 package tui
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tobocop2/muxbee/internal/health"
+)
 
 // Colors - more subtle palette
 var (
@@ -90,3 +93,13 @@ func RenderStatus(running bool, health string) string {
 	}
 	return StatusStopped.Render("stopped")
 }
+
+func RenderBridgeState(state health.State) string {
+	switch state {
+	case health.StateLoggedIn, health.StateReachable:
+		return StatusRunning.Render(string(state))
+	case health.StateStopped:
+		return StatusStopped.Render(string(state))
+	}
+	return StatusWarning.Render(string(state))
+}
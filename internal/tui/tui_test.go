@@ -1,12 +1,17 @@
 package tui
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/tobocop2/muxbee/internal/config"
 	"github.com/tobocop2/muxbee/internal/docker"
+	"github.com/tobocop2/muxbee/internal/health"
+	"github.com/tobocop2/muxbee/internal/matrix"
 )
 
 // Test Dashboard Model
@@ -64,6 +69,23 @@ func TestDashboardModel_Update_ServicesUpdated(t *testing.T) {
 	}
 }
 
+func TestDashboardModel_Update_ServicesUpdatedError(t *testing.T) {
+	m := NewDashboardModel()
+	m.isLoading = true
+
+	newM, _ := m.Update(servicesUpdatedMsg{err: errors.New("failed to write docker-compose.yml: disk full")}, nil, nil)
+	view := newM.View(&config.Config{ServerName: "example.com"})
+	if !strings.Contains(view, "disk full") {
+		t.Errorf("expected the error to be shown, got:\n%s", view)
+	}
+
+	// The next operation that works clears it
+	newM, _ = newM.Update(servicesUpdatedMsg{}, nil, nil)
+	if newM.actionErr != nil {
+		t.Errorf("expected the error to be cleared, got %v", newM.actionErr)
+	}
+}
+
 func TestDashboardModel_View_NoConfig(t *testing.T) {
 	m := NewDashboardModel()
 	view := m.View(nil)
@@ -327,6 +349,91 @@ func TestBridgesModel_ViewCredentialInput(t *testing.T) {
 	}
 }
 
+// bridgeIndex moves the cursor to a bridge
+func bridgeIndex(t *testing.T, m *BridgesModel, name string) {
+	t.Helper()
+	for i, b := range m.bridges {
+		if b.Name == name {
+			m.cursor = i
+			return
+		}
+	}
+	t.Fatalf("bridge %s not found", name)
+}
+
+func TestBridgesModel_LoginKey(t *testing.T) {
+	m := NewBridgesModel()
+	cfg := &config.Config{EnabledBridges: []string{"whatsapp", "meta"}}
+	key := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'L'}}
+
+	// Not enabled: nothing to log in to
+	bridgeIndex(t, m, "signal")
+	m, _ = m.Update(key, cfg)
+	if m.login != nil || m.lastError == nil {
+		t.Error("expected an error for a disabled bridge")
+	}
+
+	// Cookie bridges show their instructions instead
+	bridgeIndex(t, m, "meta")
+	m, _ = m.Update(key, cfg)
+	if m.login != nil || !m.showInfo {
+		t.Error("expected the instructions for a bridge without interactive login")
+	}
+	m.showInfo = false
+
+	bridgeIndex(t, m, "whatsapp")
+	m, cmd := m.Update(key, cfg)
+	if m.login == nil || cmd == nil {
+		t.Fatal("expected the login to start")
+	}
+	if !m.capturesKeys() {
+		t.Error("expected the login view to take typed text")
+	}
+	if !strings.Contains(m.View(cfg), "Waiting for the bot") {
+		t.Error("expected the view to wait for the bot")
+	}
+}
+
+func TestBridgesModel_LoginConversation(t *testing.T) {
+	m := NewBridgesModel()
+	cfg := &config.Config{EnabledBridges: []string{"telegram"}}
+	bridgeIndex(t, m, "telegram")
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'L'}}, cfg)
+	login := m.login
+
+	m, _ = m.Update(bridgeLoginMessagesMsg{login: login, messages: []matrix.BotMessage{
+		{Body: "Your account has two-factor authentication. Please send your password here."},
+	}}, cfg)
+	if !login.prompt || login.input.EchoMode != textinput.EchoPassword {
+		t.Error("expected a hidden prompt for the password")
+	}
+	if !strings.Contains(m.View(cfg), "two-factor authentication") {
+		t.Error("expected the view to show the bot's message")
+	}
+
+	// A reply to a login that was closed is dropped
+	stale := &bridgeLogin{}
+	m, _ = m.Update(bridgeLoginMessagesMsg{login: stale, messages: []matrix.BotMessage{{Body: "Successfully logged in"}}}, cfg)
+	if login.result != matrix.LoginPending {
+		t.Error("expected a stale reply to be ignored")
+	}
+
+	m, _ = m.Update(bridgeLoginMessagesMsg{login: login, messages: []matrix.BotMessage{
+		{Body: "Successfully logged in as @alice"},
+	}}, cfg)
+	if login.result != matrix.LoginSucceeded || login.waiting {
+		t.Error("expected the login to succeed")
+	}
+	if !strings.Contains(m.View(cfg), "Logged in to telegram") {
+		t.Error("expected the view to report the login")
+	}
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter}, cfg)
+	if m.login != nil {
+		t.Error("expected enter to close a finished login")
+	}
+}
+
 // Test Logs Model
 
 func TestNewLogsModel(t *testing.T) {
@@ -1068,3 +1175,141 @@ func TestPadRight(t *testing.T) {
 		}
 	}
 }
+
+func TestDashboardModel_View_BridgeHealth(t *testing.T) {
+	m := NewDashboardModel()
+	cfg := &config.Config{
+		ServerName:     "test.local",
+		EnabledBridges: []string{"whatsapp", "signal"},
+	}
+
+	m.finishProbe(bridgeHealthMsg{bridges: []health.Bridge{
+		{Name: "whatsapp", State: health.StateLoggedIn},
+	}})
+	view := m.View(cfg)
+
+	if !strings.Contains(view, "logged in") {
+		t.Error("expected view to show the whatsapp login state")
+	}
+	if !strings.Contains(view, "checking...") {
+		t.Error("expected view to show signal as not yet probed")
+	}
+	if m.probeDue() {
+		t.Error("expected no probe to be due right after one finished")
+	}
+}
+
+func TestDashboardModel_ApplyEvent(t *testing.T) {
+	m := NewDashboardModel()
+	m.services = []docker.ServiceStatus{
+		{Name: "muxbee-postgres-1", State: "running", Running: true, Health: "healthy"},
+		{Name: "muxbee-synapse-1", State: "running", Running: true},
+	}
+
+	inspect := m.applyEvent(docker.Event{Action: "die", Container: "muxbee-postgres-1", Attributes: map[string]string{"exitCode": "137"}})
+	if !inspect {
+		t.Error("expected a die event to ask for an inspect")
+	}
+	if m.services[0].Running || m.services[0].ExitCode != 137 || m.services[0].Health != "" {
+		t.Errorf("expected postgres to be stopped with exit code 137, got %+v", m.services[0])
+	}
+
+	m.applyEvent(docker.Event{Action: "died", Container: "muxbee-synapse-1", Attributes: map[string]string{"containerExitCode": "1"}})
+	if m.services[1].ExitCode != 1 {
+		t.Errorf("expected the podman exit code to be read, got %d", m.services[1].ExitCode)
+	}
+
+	m.applyEvent(docker.Event{Action: "start", Container: "muxbee-postgres-1"})
+	if !m.services[0].Running {
+		t.Error("expected postgres to be running after a start event")
+	}
+
+	if m.applyEvent(docker.Event{Action: "health_status: unhealthy", Container: "muxbee-postgres-1"}) {
+		t.Error("expected a health event not to ask for an inspect")
+	}
+	if m.services[0].Health != "unhealthy" {
+		t.Errorf("expected health unhealthy, got %q", m.services[0].Health)
+	}
+	m.applyEvent(docker.Event{Action: "health_status", Container: "muxbee-postgres-1", Attributes: map[string]string{"health_status": "healthy"}})
+	if m.services[0].Health != "healthy" {
+		t.Errorf("expected the podman health to be read, got %q", m.services[0].Health)
+	}
+
+	if !m.applyEvent(docker.Event{Action: "create", Container: "muxbee-mautrix-signal-1"}) {
+		t.Error("expected an unknown container to be inspected")
+	}
+
+	m.applyEvent(docker.Event{Action: "destroy", Container: "muxbee-synapse-1"})
+	if len(m.services) != 1 || m.services[0].Name != "muxbee-postgres-1" {
+		t.Errorf("expected synapse to be removed, got %+v", m.services)
+	}
+}
+
+func TestDashboardModel_StatusDue(t *testing.T) {
+	m := NewDashboardModel()
+	if !m.statusDue() {
+		t.Error("expected a refresh to be due without events")
+	}
+
+	m.events = &eventStream{cancel: func() {}}
+	m.setServices([]docker.ServiceStatus{{Name: "muxbee-synapse-1", Running: true}})
+	if m.statusDue() {
+		t.Error("expected no refresh to be due right after one")
+	}
+
+	m.lastFullRefresh = time.Now().Add(-statusResyncInterval)
+	m.applyEvent(docker.Event{Action: "die", Container: "muxbee-synapse-1"})
+	if !m.statusDue() {
+		t.Error("expected events not to put off the periodic refresh")
+	}
+}
+
+func TestDashboardModel_SetService(t *testing.T) {
+	m := NewDashboardModel()
+	m.setService(docker.ServiceStatus{Name: "muxbee-synapse-1"})
+	m.setService(docker.ServiceStatus{Name: "muxbee-postgres-1"})
+	m.setService(docker.ServiceStatus{Name: "muxbee-synapse-1", RestartCount: 3})
+
+	if len(m.services) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(m.services))
+	}
+	if m.services[0].Name != "muxbee-postgres-1" {
+		t.Error("expected services to stay sorted by name")
+	}
+	if m.services[1].RestartCount != 3 {
+		t.Error("expected synapse to be replaced")
+	}
+}
+
+func TestDashboardModel_View_RunHistory(t *testing.T) {
+	m := NewDashboardModel()
+	m.services = []docker.ServiceStatus{
+		{Name: "muxbee-synapse-1", Running: true, RestartCount: 4},
+		{Name: "muxbee-postgres-1", Running: false, ExitCode: 137},
+	}
+	view := m.View(&config.Config{ServerName: "test.local"})
+
+	if !strings.Contains(view, "restarted 4 times") {
+		t.Error("expected view to show the synapse restart count")
+	}
+	if !strings.Contains(view, "(exit 137)") {
+		t.Error("expected view to show the postgres exit code")
+	}
+}
+
+func TestModel_StaleEventsIgnored(t *testing.T) {
+	m := New()
+	m.dashboard.services = []docker.ServiceStatus{{Name: "muxbee-synapse-1", Running: true}}
+	stale := &eventStream{cancel: func() {}}
+	m.dashboard.events = &eventStream{cancel: func() {}}
+
+	newM, _ := m.Update(containerEventMsg{stream: stale, event: docker.Event{Action: "die", Container: "muxbee-synapse-1"}})
+	if !newM.(Model).dashboard.services[0].Running {
+		t.Error("expected an event from an old subscription to be ignored")
+	}
+
+	newM, _ = m.Update(eventsStoppedMsg{stream: stale})
+	if newM.(Model).dashboard.events == nil {
+		t.Error("expected an old subscription ending to leave the current one")
+	}
+}